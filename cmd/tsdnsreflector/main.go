@@ -81,10 +81,10 @@ func main() {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
+	// Start only returns once the server stops, e.g. when a listener can't bind
+	serveErr := make(chan error, 1)
 	go func() {
-		if err := server.Start(ctx); err != nil {
-			log.Error("DNS server error", "error", err)
-		}
+		serveErr <- server.Start(ctx)
 	}()
 
 	log.Info("tsdnsreflector started", "dnsPort", runtimeCfg.DNSPort)
@@ -92,30 +92,48 @@ func main() {
 		log.Info("Metrics server enabled", "port", runtimeCfg.HTTPPort, "path", runtimeCfg.MetricsPath)
 	}
 
-	for sig := range sigChan {
-		switch sig {
-		case syscall.SIGHUP:
-			log.Info("Received SIGHUP, reloading configuration")
-			// Note: Runtime config (env vars/flags) cannot be reloaded, only zone config
-			if err := reloadConfiguration(server, *configFile); err != nil {
-				log.Error("Configuration reload failed", "error", err)
-			} else {
-				log.Info("Configuration reloaded successfully (zones only)")
-			}
-		case syscall.SIGINT, syscall.SIGTERM:
-			log.Info("Shutting down", "signal", sig.String())
+	shutdown := func() {
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer shutdownCancel()
 
-			shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+		server.Stop()
 
-			server.Stop()
+		if metricsServer != nil {
+			if err := metricsServer.Shutdown(shutdownCtx); err != nil {
+				log.Error("Metrics server shutdown error", "error", err)
+			}
+		}
+	}
 
-			if metricsServer != nil {
-				if err := metricsServer.Shutdown(shutdownCtx); err != nil {
-					log.Error("Metrics server shutdown error", "error", err)
-				}
+	for {
+		select {
+		case err := <-serveErr:
+			shutdown()
+			if err != nil {
+				log.Error("DNS server failed", "error", err)
+				cancel()
+				os.Exit(1)
 			}
-			shutdownCancel()
 			return
+		case sig := <-sigChan:
+			switch sig {
+			case syscall.SIGHUP:
+				log.Info("Received SIGHUP, reloading configuration")
+				// Of the runtime config only the log level and query logging
+				// can change, read again from the environment
+				loggingCfg = config.ReloadLogging(loggingCfg)
+				log.SetLevel(loggingCfg.Level)
+				server.UpdateLogging(loggingCfg)
+				if err := reloadConfiguration(server, *configFile); err != nil {
+					log.Error("Configuration reload failed", "error", err)
+				} else {
+					log.Info("Configuration reloaded successfully")
+				}
+			case syscall.SIGINT, syscall.SIGTERM:
+				log.Info("Shutting down", "signal", sig.String())
+				shutdown()
+				return
+			}
 		}
 	}
 }
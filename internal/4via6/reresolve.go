@@ -0,0 +1,118 @@
+package via6
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rajsingh/tsdnsreflector/internal/logger"
+)
+
+// reresolveIdleIntervals is how many refresh intervals a reflected name may go
+// unqueried before it is no longer refreshed
+const reresolveIdleIntervals = 10
+
+// reresolver keeps the addresses of a zone's reflected names current by
+// resolving them again every interval, so queries are answered from the last
+// refresh instead of waiting on the backends. A name is resolved when first
+// queried and refreshed while it keeps being queried.
+type reresolver struct {
+	interval time.Duration
+	mu       sync.Mutex
+	names    map[string]*reresolvedName // Keyed by lowercased reflected name
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+type reresolvedName struct {
+	name     string
+	ips      []resolvedIP
+	lastUsed time.Time
+}
+
+func newReresolver(interval time.Duration) *reresolver {
+	return &reresolver{
+		interval: interval,
+		names:    make(map[string]*reresolvedName),
+		stop:     make(chan struct{}),
+	}
+}
+
+// get returns the last refreshed addresses of reflectedDomain, with the
+// refresh interval as their TTL
+func (r *reresolver) get(reflectedDomain string) ([]resolvedIP, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.names[strings.ToLower(reflectedDomain)]
+	if !ok {
+		return nil, false
+	}
+	entry.lastUsed = time.Now()
+	return r.withTTL(entry.ips), true
+}
+
+// add starts refreshing reflectedDomain, first resolved to ips
+func (r *reresolver) add(reflectedDomain string, ips []resolvedIP) []resolvedIP {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.names) < maxResolutionCacheSize {
+		r.names[strings.ToLower(reflectedDomain)] = &reresolvedName{name: reflectedDomain, ips: ips, lastUsed: time.Now()}
+	}
+	return r.withTTL(ips)
+}
+
+// withTTL copies ips with their TTL set to the refresh interval, which is how
+// long they are answered before the next refresh. Callers hold r.mu.
+func (r *reresolver) withTTL(ips []resolvedIP) []resolvedIP {
+	ttl := uint32(max(r.interval/time.Second, 1))
+	refreshed := make([]resolvedIP, len(ips))
+	for i, resolved := range ips {
+		refreshed[i] = resolvedIP{ip: resolved.ip, ttl: ttl}
+	}
+	return refreshed
+}
+
+// run refreshes the names of zt every interval until stopped. A failed
+// refresh keeps the previous addresses; names no longer queried are dropped.
+func (r *reresolver) run(zt *ZoneTranslator, log *logger.Logger) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+		}
+
+		idleSince := time.Now().Add(-reresolveIdleIntervals * r.interval)
+		r.mu.Lock()
+		var due []*reresolvedName
+		for key, entry := range r.names {
+			if entry.lastUsed.Before(idleSince) {
+				delete(r.names, key)
+				continue
+			}
+			due = append(due, entry)
+		}
+		r.mu.Unlock()
+
+		for _, entry := range due {
+			ips, err := zt.resolveReflectedIPs(entry.name)
+			if err != nil {
+				log.ZoneWarn(zt.zoneName, "Re-resolving reflected name failed, keeping previous addresses", "reflectedDomain", entry.name, "error", err)
+				continue
+			}
+			r.mu.Lock()
+			entry.ips = ips
+			r.mu.Unlock()
+		}
+	}
+}
+
+// Stop ends background refreshing
+func (r *reresolver) Stop() {
+	r.stopOnce.Do(func() { close(r.stop) })
+}
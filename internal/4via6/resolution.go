@@ -0,0 +1,173 @@
+package via6
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rajsingh/tsdnsreflector/internal/metrics"
+	"golang.org/x/sync/singleflight"
+)
+
+// maxResolutionCacheSize bounds the reflected names cached per translator
+const maxResolutionCacheSize = 1024
+
+// resolutionCache holds the IPv4 addresses reflected names resolved to, until
+// the lowest TTL among their A records runs out. It is shared by all zones of
+// a translator, so zones reflecting onto the same domain through the same
+// backends share answers, and concurrent lookups of a name share one query.
+type resolutionCache struct {
+	mu       sync.Mutex
+	entries  map[string]resolutionEntry
+	inflight singleflight.Group
+}
+
+type resolutionEntry struct {
+	ips     []resolvedIP
+	expires time.Time
+}
+
+func newResolutionCache() *resolutionCache {
+	return &resolutionCache{entries: make(map[string]resolutionEntry)}
+}
+
+// Get returns the addresses cached for name, with their TTLs reduced to the
+// time left
+func (c *resolutionCache) Get(name string) ([]resolvedIP, bool) {
+	c.mu.Lock()
+	entry, ok := c.entries[name]
+	c.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	remaining := time.Until(entry.expires)
+	if remaining < time.Second {
+		return nil, false
+	}
+	ttl := uint32(remaining / time.Second)
+
+	ips := make([]resolvedIP, len(entry.ips))
+	for i, resolved := range entry.ips {
+		ips[i] = resolvedIP{ip: resolved.ip, ttl: min(resolved.ttl, ttl)}
+	}
+	return ips, true
+}
+
+// Set caches ips for name for the lowest of their TTLs. Answers with a zero
+// TTL are not cached.
+func (c *resolutionCache) Set(name string, ips []resolvedIP) {
+	if len(ips) == 0 {
+		return
+	}
+	ttl := ips[0].ttl
+	for _, resolved := range ips[1:] {
+		ttl = min(ttl, resolved.ttl)
+	}
+	if ttl == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if _, exists := c.entries[name]; !exists && len(c.entries) >= maxResolutionCacheSize {
+		for key, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, key)
+			}
+		}
+		if len(c.entries) >= maxResolutionCacheSize {
+			return
+		}
+	}
+	c.entries[name] = resolutionEntry{ips: ips, expires: now.Add(time.Duration(ttl) * time.Second)}
+}
+
+// lookupReflectedIPs returns the IPv4 addresses reflectedDomain resolves to,
+// from the shared cache or from a backend lookup shared with every zone
+// resolving the same name at the same time. Zones re-resolving in the
+// background answer from their last refresh once a name has been resolved.
+func (zt *ZoneTranslator) lookupReflectedIPs(reflectedDomain string) ([]resolvedIP, error) {
+	if zt.reresolve == nil {
+		return zt.lookupSharedIPs(reflectedDomain)
+	}
+	if ips, ok := zt.reresolve.get(reflectedDomain); ok {
+		return ips, nil
+	}
+	ips, err := zt.lookupSharedIPs(reflectedDomain)
+	if err != nil {
+		return nil, err
+	}
+	return zt.reresolve.add(reflectedDomain, ips), nil
+}
+
+// lookupSharedIPs is lookupReflectedIPs through the resolution cache shared by
+// every zone
+func (zt *ZoneTranslator) lookupSharedIPs(reflectedDomain string) ([]resolvedIP, error) {
+	key := zt.resolutionKey(reflectedDomain)
+	if ips, ok := zt.resolutions.Get(key); ok {
+		return ips, nil
+	}
+
+	leader := false
+	v, err, _ := zt.resolutions.inflight.Do(key, func() (any, error) {
+		leader = true
+		// A lookup that finished while this one waited to start has cached its answer
+		if ips, ok := zt.resolutions.Get(key); ok {
+			return ips, nil
+		}
+		ips, err := zt.resolveReflectedIPs(reflectedDomain)
+		if err != nil {
+			return nil, err
+		}
+		zt.resolutions.Set(key, ips)
+		return ips, nil
+	})
+	if !leader {
+		metrics.RecordSingleflightShared(zt.zoneName, "via6")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return v.([]resolvedIP), nil
+}
+
+// resolutionKey identifies a resolution by what determines its answer: the
+// name, the backends asked and how (source address and TLS server name),
+// whether every backend is asked (with expected networks) or only the first
+// that answers, and how many CNAMEs are followed. The zone itself is not part
+// of it.
+func (zt *ZoneTranslator) resolutionKey(reflectedDomain string) string {
+	mode := "first"
+	if len(zt.rule.ExpectedNetworks) > 0 {
+		mode = "all"
+	}
+	source := ""
+	if zt.rule.SourceAddress != nil {
+		source = zt.rule.SourceAddress.String()
+	}
+	return strings.Join([]string{
+		strings.ToLower(reflectedDomain),
+		strings.Join(zt.rule.DNSServers, ","),
+		source,
+		zt.rule.TLSServerName,
+		mode,
+		strconv.Itoa(zt.rule.MaxCNAMEDepth),
+	}, "|")
+}
+
+// Clear drops every cached resolution
+func (c *resolutionCache) Clear() {
+	c.mu.Lock()
+	c.entries = make(map[string]resolutionEntry)
+	c.mu.Unlock()
+}
+
+// ClearResolutionCache drops the cached reflected-domain resolutions of every
+// zone, so the next translation queries the backends again
+func (t *Translator) ClearResolutionCache() {
+	t.resolutions.Clear()
+}
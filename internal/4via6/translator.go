@@ -1,20 +1,39 @@
 package via6
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"math"
 	"net"
+	"net/http"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/miekg/dns"
 	"github.com/rajsingh/tsdnsreflector/internal/config"
+	"github.com/rajsingh/tsdnsreflector/internal/doh"
 	"github.com/rajsingh/tsdnsreflector/internal/logger"
+	"github.com/rajsingh/tsdnsreflector/internal/metrics"
 )
 
 const (
 	Via6PrefixBase = "fd7a:115c:a1e0:b1a:0000:0000:0000:0000"
 )
 
+// ErrNameNotFound is returned when the backends answer NXDOMAIN for a reflected name
+var ErrNameNotFound = errors.New("reflected name does not exist")
+
+// ErrCNAMELoop is returned when a reflected name's CNAME chain leads back to
+// a name already in it
+var ErrCNAMELoop = errors.New("CNAME loop")
+
+// ErrCNAMEChainTooLong is returned when a reflected name's CNAME chain is
+// longer than the zone's maxCnameDepth
+var ErrCNAMEChainTooLong = errors.New("CNAME chain too long")
+
 func parseTimeout(timeoutStr string) time.Duration {
 	if timeoutStr == "" {
 		return 5 * time.Second
@@ -27,9 +46,10 @@ func parseTimeout(timeoutStr string) time.Duration {
 }
 
 type Translator struct {
-	zones  map[string]*ZoneTranslator
-	config *config.Config
-	logger *logger.Logger
+	zones       map[string]*ZoneTranslator
+	config      *config.Config
+	logger      *logger.Logger
+	resolutions *resolutionCache // Shared by every zone
 }
 
 type ZoneTranslator struct {
@@ -37,6 +57,9 @@ type ZoneTranslator struct {
 	zone          *config.Zone
 	rule          *Rule
 	prefixNetwork *net.IPNet
+	resolutions   *resolutionCache
+	dohClient     *http.Client // Client for https:// DNS servers
+	reresolve     *reresolver  // Optional, keeps reflected names resolved in the background
 }
 
 type Rule struct {
@@ -46,10 +69,17 @@ type Rule struct {
 	PrefixNetwork   *net.IPNet
 	DNSServers      []string
 	DNSTimeout      time.Duration
+	SourceAddress   net.IP
+	TLSServerName   string // Certificate name of tls:// DNS servers
+	MaxCNAMEDepth   int    // Most CNAMEs followed resolving the reflected domain
+
+	// Backend answers within these networks are preferred for the reflected domain
+	ExpectedNetworks []*net.IPNet
 }
 
 func NewTranslator(cfg *config.Config, log *logger.Logger) (*Translator, error) {
 	zones := make(map[string]*ZoneTranslator)
+	resolutions := newResolutionCache()
 
 	log.Debug("Creating zone-based 4via6 translator", "zoneCount", len(cfg.Zones))
 
@@ -67,24 +97,28 @@ func NewTranslator(cfg *config.Config, log *logger.Logger) (*Translator, error)
 			"reflectedDomain", zone.ReflectedDomain,
 			"translateID", zone.TranslateID)
 
-		zoneTranslator, err := newZoneTranslator(name, zone)
+		zoneTranslator, err := newZoneTranslator(name, zone, resolutions)
 		if err != nil {
 			return nil, fmt.Errorf("invalid 4via6 zone %s: %w", name, err)
 		}
 
 		zones[name] = zoneTranslator
+		if zoneTranslator.reresolve != nil {
+			go zoneTranslator.reresolve.run(zoneTranslator, log)
+		}
 	}
 
 	log.Info("Zone-based 4via6 translator created successfully", "activeZones", len(zones))
 
 	return &Translator{
-		zones:  zones,
-		config: cfg,
-		logger: log,
+		zones:       zones,
+		config:      cfg,
+		logger:      log,
+		resolutions: resolutions,
 	}, nil
 }
 
-func newZoneTranslator(zoneName string, zone *config.Zone) (*ZoneTranslator, error) {
+func newZoneTranslator(zoneName string, zone *config.Zone, resolutions *resolutionCache) (*ZoneTranslator, error) {
 	if zone.TranslateID == nil || *zone.TranslateID == 0 {
 		return nil, fmt.Errorf("translateID cannot be 0 (reserved)")
 	}
@@ -103,6 +137,15 @@ func newZoneTranslator(zoneName string, zone *config.Zone) (*ZoneTranslator, err
 		return nil, fmt.Errorf("prefix subnet %s is not within 4via6 space (must start with fd7a:115c:a1e0:b1a:)", prefixSubnet)
 	}
 
+	var expected []*net.IPNet
+	for _, cidr := range zone.ExpectedCIDRs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expected CIDR %s: %w", cidr, err)
+		}
+		expected = append(expected, network)
+	}
+
 	rule := &Rule{
 		ReflectedDomain: zone.ReflectedDomain,
 		PrefixSubnet:    prefixSubnet,
@@ -110,14 +153,62 @@ func newZoneTranslator(zoneName string, zone *config.Zone) (*ZoneTranslator, err
 		PrefixNetwork:   prefixNet,
 		DNSServers:      zone.Backend.DNSServers,
 		DNSTimeout:      parseTimeout(zone.Backend.Timeout),
+		SourceAddress:   net.ParseIP(zone.Backend.SourceAddress),
+		TLSServerName:   zone.Backend.TLSServerName,
+		MaxCNAMEDepth:   zone.CNAMEDepth(),
+
+		ExpectedNetworks: expected,
 	}
 
-	return &ZoneTranslator{
+	zt := &ZoneTranslator{
 		zoneName:      zoneName,
 		zone:          zone,
 		rule:          rule,
 		prefixNetwork: prefixNet,
-	}, nil
+		resolutions:   resolutions,
+		dohClient:     newDoHClient(rule),
+	}
+	if interval := zone.ReresolveEvery(); interval > 0 {
+		zt.reresolve = newReresolver(interval)
+	}
+	return zt, nil
+}
+
+// Stop ends the background re-resolution of every zone
+func (t *Translator) Stop() {
+	for _, zt := range t.zones {
+		if zt.reresolve != nil {
+			zt.reresolve.Stop()
+		}
+	}
+}
+
+// WithLogger returns a translator sharing t's zones and resolutions that logs
+// to l, e.g. a logger for one query
+func (t *Translator) WithLogger(l *logger.Logger) *Translator {
+	if t == nil {
+		return nil
+	}
+	scoped := *t
+	scoped.logger = l
+	return &scoped
+}
+
+// newDoHClient returns the HTTP client a rule's DNS over HTTPS servers are
+// queried through, sending from its source address if set
+func newDoHClient(rule *Rule) *http.Client {
+	dialer := &net.Dialer{Timeout: rule.DNSTimeout}
+	if rule.SourceAddress != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: rule.SourceAddress}
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext:       dialer.DialContext,
+			TLSClientConfig:   &tls.Config{MinVersion: tls.VersionTLS12},
+			ForceAttemptHTTP2: true,
+			IdleConnTimeout:   90 * time.Second,
+		},
+	}
 }
 
 func (t *Translator) ShouldTranslate(domain string) bool {
@@ -125,26 +216,53 @@ func (t *Translator) ShouldTranslate(domain string) bool {
 	return zone != nil && zone.Has4via6()
 }
 
+// TranslateToVia6 returns the 4via6 address of the first IPv4 address the
+// domain's reflected name resolves to
 func (t *Translator) TranslateToVia6(domain string) (net.IP, error) {
+	addrs, _, err := t.TranslateToVia6Addrs(domain)
+	if err != nil {
+		return nil, err
+	}
+	return addrs[0], nil
+}
+
+// TranslateToVia6Addrs returns one 4via6 address per IPv4 address the
+// domain's reflected name resolves to, in upstream answer order, and the
+// lowest TTL of their A records. Static reflected IPs never change, so their
+// TTL is StaticTTL.
+func (t *Translator) TranslateToVia6Addrs(domain string) ([]net.IP, uint32, error) {
 	if !strings.HasSuffix(domain, ".") {
 		domain += "."
 	}
 
 	zoneTranslator := t.GetZoneForDomain(domain)
 	if zoneTranslator == nil {
-		return nil, fmt.Errorf("no 4via6 zone found for domain %s", domain)
+		return nil, 0, fmt.Errorf("no 4via6 zone found for domain %s", domain)
 	}
 
-	return zoneTranslator.CreateVia6Address(domain, t)
+	return zoneTranslator.CreateVia6Addresses(domain, t)
 }
 
+// StaticTTL is the TTL reported for reflected domains that are IP addresses
+const StaticTTL = math.MaxUint32
+
 func (t *Translator) TranslateFromVia6(via6IP net.IP) (string, net.IP, error) {
+	zt, ipv4, err := t.zoneForVia6(via6IP)
+	if err != nil {
+		return "", nil, err
+	}
+	return zt.rule.ReflectedDomain, ipv4, nil
+}
+
+// zoneForVia6 returns the zone whose translateID via6IP carries and the IPv4
+// address embedded in it
+func (t *Translator) zoneForVia6(via6IP net.IP) (*ZoneTranslator, net.IP, error) {
 	if len(via6IP) != 16 {
-		return "", nil, fmt.Errorf("invalid IPv6 address length")
+		return nil, nil, fmt.Errorf("invalid IPv6 address length")
 	}
 
 	if !t.isVia6Address(via6IP) {
-		return "", nil, fmt.Errorf("not a 4via6 address")
+		return nil, nil, fmt.Errorf("not a 4via6 address")
 	}
 
 	translateID := (uint16(via6IP[10]) << 8) | uint16(via6IP[11])
@@ -152,10 +270,168 @@ func (t *Translator) TranslateFromVia6(via6IP net.IP) (string, net.IP, error) {
 
 	for _, zoneTranslator := range t.zones {
 		if zoneTranslator.rule.TranslateID == translateID {
-			return zoneTranslator.rule.ReflectedDomain, ipv4, nil
+			return zoneTranslator, ipv4, nil
 		}
 	}
-	return "", nil, fmt.Errorf("no zone found for translate ID %d", translateID)
+	return nil, nil, fmt.Errorf("no zone found for translate ID %d", translateID)
+}
+
+// ReverseVia6 returns the names in via6IP's zone whose 4via6 translation is
+// via6IP, so PTR answers are the inverse of AAAA answers. The reflected name
+// comes from the backends' PTR records for the embedded IPv4 address, and the
+// TTL is the lowest of theirs. Zones reflecting onto a static IP answer with
+// their non-wildcard domains.
+func (t *Translator) ReverseVia6(via6IP net.IP) ([]string, uint32, error) {
+	zt, ipv4, err := t.zoneForVia6(via6IP)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if static := net.ParseIP(zt.rule.ReflectedDomain); static != nil {
+		if !static.Equal(ipv4) {
+			return nil, 0, nil
+		}
+		var names []string
+		for _, domain := range zt.zone.Domains {
+			if !strings.HasPrefix(domain, "*.") {
+				names = append(names, dns.Fqdn(domain))
+			}
+		}
+		return names, StaticTTL, nil
+	}
+
+	reverseName, err := dns.ReverseAddr(ipv4.String())
+	if err != nil {
+		return nil, 0, err
+	}
+	msg := new(dns.Msg)
+	msg.SetQuestion(reverseName, dns.TypePTR)
+
+	var lastErr error
+	for _, backend := range zt.rule.DNSServers {
+		resp, err := zt.exchange(msg, backend)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.Rcode == dns.RcodeNameError {
+			return nil, 0, nil
+		}
+		if resp.Rcode != dns.RcodeSuccess {
+			lastErr = fmt.Errorf("backend returned %s", dns.RcodeToString[resp.Rcode])
+			continue
+		}
+
+		var names []string
+		ttl := uint32(StaticTTL)
+		for _, rr := range resp.Answer {
+			ptr, ok := rr.(*dns.PTR)
+			if !ok {
+				continue
+			}
+			// Only names under the reflected domain map back into the zone
+			for _, name := range zt.zone.UnmapName(ptr.Ptr, zt.rule.ReflectedDomain) {
+				names = append(names, name)
+				ttl = min(ttl, ptr.Hdr.Ttl)
+			}
+		}
+		return names, ttl, nil
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no backends configured")
+	}
+	return nil, 0, lastErr
+}
+
+// ParseReverseIPv6 returns the IPv6 address named by an ip6.arpa name
+func ParseReverseIPv6(name string) (net.IP, bool) {
+	ip, nibbles, ok := parseReverseNibbles(name)
+	if !ok || nibbles != 32 {
+		return nil, false
+	}
+	return ip, true
+}
+
+// parseReverseNibbles returns the address prefix named by an ip6.arpa name,
+// with the bits it doesn't name set to zero, and how many nibbles it names
+func parseReverseNibbles(name string) (net.IP, int, bool) {
+	name = strings.ToLower(dns.Fqdn(name))
+	if !strings.HasSuffix(name, ".ip6.arpa.") {
+		return nil, 0, false
+	}
+	nibbles := strings.Split(strings.TrimSuffix(name, ".ip6.arpa."), ".")
+	if len(nibbles) > 32 {
+		return nil, 0, false
+	}
+
+	ip := make(net.IP, net.IPv6len)
+	for i, nibble := range nibbles {
+		if len(nibble) != 1 {
+			return nil, 0, false
+		}
+		v := strings.IndexByte("0123456789abcdef", nibble[0])
+		if v < 0 {
+			return nil, 0, false
+		}
+		// Nibbles run from the least significant end of the address
+		pos := len(nibbles) - 1 - i
+		if pos%2 == 0 {
+			ip[pos/2] |= byte(v) << 4
+		} else {
+			ip[pos/2] |= byte(v)
+		}
+	}
+	return ip, len(nibbles), true
+}
+
+// Where a reverse name falls relative to the 4via6 range
+type ReverseMatch int
+
+const (
+	ReverseOutside ReverseMatch = iota // Not within the 4via6 range
+	ReverseUnknown                     // Within the range, but under no zone's translateID
+	ReverseKnown                       // Under a zone's translateID
+)
+
+// via6PrefixNibbles is the length of the 4via6 prefix before the translateID
+const via6PrefixNibbles = 20
+
+// via6NetworkNibbles is the length of a zone's 4via6 network, up to and
+// including its translateID
+const via6NetworkNibbles = via6PrefixNibbles + 4
+
+// MatchReverse reports whether an ip6.arpa name lies within the 4via6 range
+// and, if so, whether it could belong to one of the zones' translateIDs.
+// Names above the range, which also cover other addresses, are outside it.
+func (t *Translator) MatchReverse(name string) ReverseMatch {
+	ip, nibbles, ok := parseReverseNibbles(name)
+	if !ok || nibbles < via6PrefixNibbles || !t.isVia6Address(ip) {
+		return ReverseOutside
+	}
+
+	// Compare only the translateID nibbles the name spells out
+	idNibbles := min(nibbles-via6PrefixNibbles, 4)
+	shift := 16 - 4*idNibbles
+	nameID := (uint16(ip[10])<<8 | uint16(ip[11])) >> shift
+	for _, zt := range t.zones {
+		if zt.rule.TranslateID>>shift == nameID {
+			return ReverseKnown
+		}
+	}
+	return ReverseUnknown
+}
+
+// IsVia6Apex reports whether an ip6.arpa name lands exactly on a zone's 4via6
+// network without naming a host in it: the network itself, or its network
+// address with an all-zero embedded IPv4 address
+func (t *Translator) IsVia6Apex(name string) bool {
+	ip, nibbles, ok := parseReverseNibbles(name)
+	if !ok || (nibbles != via6NetworkNibbles && nibbles != 32) || !net.IP(ip[12:]).Equal(net.IPv4zero) {
+		return false
+	}
+	zt, _, err := t.zoneForVia6(ip)
+	return err == nil && zt.prefixNetwork.Contains(ip)
 }
 
 // is4via6Prefix validates that a network prefix is within the 4via6 address space
@@ -208,35 +484,45 @@ func (t *Translator) isVia6Address(ip net.IP) bool {
 	return true
 }
 
-func (zt *ZoneTranslator) CreateVia6Address(domain string, translator *Translator) (net.IP, error) {
-	var ipv4 net.IP
-	var err error
-
-	if zt.rule.ReflectedDomain != "" {
-		translator.logger.ZoneDebug(zt.zoneName, "Resolving reflected domain",
-			"originalDomain", domain,
-			"reflectedDomain", zt.rule.ReflectedDomain,
-			"translateID", zt.rule.TranslateID)
+func (zt *ZoneTranslator) CreateVia6Addresses(domain string, translator *Translator) ([]net.IP, uint32, error) {
+	if zt.rule.ReflectedDomain == "" {
+		return nil, 0, fmt.Errorf("no reflected domain configured for zone %s", zt.zoneName)
+	}
 
-		ipv4, err = zt.resolveReflectedDomain(domain, translator)
-		if err != nil {
-			translator.logger.Warn("Failed to resolve reflected domain",
-				"zone", zt.zoneName,
-				"domain", domain,
-				"reflectedDomain", zt.rule.ReflectedDomain,
-				"error", err)
-			return nil, fmt.Errorf("failed to resolve reflected domain: %w", err)
-		}
+	translator.logger.ZoneDebug(zt.zoneName, "Resolving reflected domain",
+		"originalDomain", domain,
+		"reflectedDomain", zt.rule.ReflectedDomain,
+		"translateID", zt.rule.TranslateID)
 
-		translator.logger.Debug("Resolved reflected domain successfully",
-			"zone", zt.zoneName,
+	resolved, err := zt.resolveReflectedDomain(domain, translator)
+	if err != nil {
+		translator.logger.ZoneWarn(zt.zoneName, "Failed to resolve reflected domain",
 			"domain", domain,
 			"reflectedDomain", zt.rule.ReflectedDomain,
-			"resolvedIP", ipv4.String())
-	} else {
-		return nil, fmt.Errorf("no reflected domain configured for zone %s", zt.zoneName)
+			"error", err)
+		return nil, 0, fmt.Errorf("failed to resolve reflected domain: %w", err)
+	}
+
+	via6Addrs := make([]net.IP, 0, len(resolved))
+	ttl := uint32(StaticTTL)
+	for _, r := range resolved {
+		via6 := zt.embedIPv4(r.ip)
+		via6Addrs = append(via6Addrs, via6)
+		ttl = min(ttl, r.ttl)
+
+		translator.logger.ZoneDebug(zt.zoneName, "Created 4via6 address",
+			"originalDomain", domain,
+			"ipv4", r.ip.String(),
+			"via6", via6.String(),
+			"ttl", r.ttl,
+			"translateID", zt.rule.TranslateID)
 	}
 
+	return via6Addrs, ttl, nil
+}
+
+// embedIPv4 builds the 4via6 address for ipv4 within this zone's prefix
+func (zt *ZoneTranslator) embedIPv4(ipv4 net.IP) net.IP {
 	via6 := make(net.IP, 16)
 	copy(via6, zt.rule.PrefixNetwork.IP)
 
@@ -244,74 +530,441 @@ func (zt *ZoneTranslator) CreateVia6Address(domain string, translator *Translato
 	via6[11] = byte(zt.rule.TranslateID)
 
 	copy(via6[12:], ipv4.To4())
-
-	translator.logger.Debug("Created 4via6 address",
-		"zone", zt.zoneName,
-		"originalDomain", domain,
-		"ipv4", ipv4.String(),
-		"via6", via6.String(),
-		"translateID", zt.rule.TranslateID)
-
-	return via6, nil
+	return via6
 }
 
-func (zt *ZoneTranslator) resolveReflectedDomain(originalDomain string, translator *Translator) (net.IP, error) {
+// resolveReflectedDomain returns the IPv4 addresses to translate for
+// originalDomain. With expected networks only the addresses within them are
+// used, or every address if none is.
+func (zt *ZoneTranslator) resolveReflectedDomain(originalDomain string, translator *Translator) ([]resolvedIP, error) {
 	reflectedDomain := zt.rule.ReflectedDomain
 
 	if ip := net.ParseIP(reflectedDomain); ip != nil {
 		if ipv4 := ip.To4(); ipv4 != nil {
-			return ipv4, nil
+			return []resolvedIP{{ip: ipv4, ttl: StaticTTL}}, nil
 		}
 		return nil, fmt.Errorf("IPv6 addresses not supported")
 	}
 
-	// Handle subdomain mapping
-	for _, zoneDomain := range zt.zone.Domains {
-		if zt.zone.MatchesDomain(originalDomain, zoneDomain) {
-			// Extract subdomain and replace the zone suffix with reflected domain
-			if strings.HasPrefix(zoneDomain, "*.") {
-				// Remove the wildcard prefix to get the base domain
-				baseDomain := strings.TrimPrefix(zoneDomain, "*.")
-				if !strings.HasSuffix(baseDomain, ".") {
-					baseDomain += "."
-				}
-				if !strings.HasSuffix(originalDomain, ".") {
-					originalDomain += "."
-				}
-				// Replace the zone's base domain with the reflected domain
-				if strings.HasSuffix(originalDomain, baseDomain) {
-					prefix := strings.TrimSuffix(originalDomain, baseDomain)
-					if !strings.HasSuffix(reflectedDomain, ".") {
-						reflectedDomain += "."
-					}
-					reflectedDomain = prefix + reflectedDomain
-				}
+	reflectedDomain = zt.reflectedName(originalDomain)
+
+	ips, err := zt.lookupReflectedIPs(reflectedDomain)
+	if err != nil {
+		return nil, err
+	}
+	if len(zt.rule.ExpectedNetworks) == 0 {
+		return ips, nil
+	}
+
+	var expected []resolvedIP
+	for _, resolved := range ips {
+		if zt.isExpected(resolved.ip) {
+			expected = append(expected, resolved)
+		}
+	}
+	if len(expected) > 0 {
+		return expected, nil
+	}
+	translator.logger.ZoneWarn(zt.zoneName, "No backend answer within expected networks, using every answer",
+		"reflectedDomain", reflectedDomain,
+		"ip", ips[0].ip.String())
+	return ips, nil
+}
+
+// resolvedIP is an IPv4 address of a reflected domain and its record TTL
+type resolvedIP struct {
+	ip  net.IP
+	ttl uint32
+}
+
+// resolveReflectedIPs returns the IPv4 addresses of reflectedDomain, asking
+// the backends again for the target of a CNAME chain they answer without A
+// records. Chains that loop or run past the zone's maxCnameDepth fail with
+// ErrCNAMELoop or ErrCNAMEChainTooLong. The time taken, CNAMEs included, is
+// recorded per zone.
+func (zt *ZoneTranslator) resolveReflectedIPs(reflectedDomain string) ([]resolvedIP, error) {
+	defer func(start time.Time) { metrics.RecordVia6Resolution(zt.zoneName, time.Since(start)) }(time.Now())
+
+	chain := []string{strings.ToLower(reflectedDomain)}
+	name := reflectedDomain
+	for {
+		ips, hops, err := zt.queryReflectedIPs(name, chain)
+		if err != nil {
+			return nil, err
+		}
+		if len(ips) > 0 {
+			return ips, nil
+		}
+		chain = append(chain, hops...)
+		name = hops[len(hops)-1]
+	}
+}
+
+// queryReflectedIPs returns the IPv4 addresses of name in answer order, or the
+// CNAMEs a backend answered with instead when none has an address. chain is
+// the names already followed to reach name. Without expected networks the
+// first backend to answer wins. Otherwise every backend is asked so
+// split-horizon upstreams that disagree resolve to the internal address, and
+// addresses returned by several backends are only kept once, with the lowest
+// TTL seen.
+func (zt *ZoneTranslator) queryReflectedIPs(reflectedDomain string, chain []string) ([]resolvedIP, []string, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(reflectedDomain, dns.TypeA)
+
+	var ips []resolvedIP
+	var cnames []string
+	seen := make(map[string]int)
+	err := zt.queryBackends(msg, func(resp *dns.Msg) (bool, error) {
+		hops, err := zt.followCNAMEs(resp.Answer, reflectedDomain, chain)
+		if err != nil {
+			return false, err
+		}
+		found := len(ips)
+		for _, rr := range resp.Answer {
+			a, ok := rr.(*dns.A)
+			if !ok {
+				continue
+			}
+			ipv4 := a.A.To4()
+			if ipv4 == nil {
+				continue
 			}
-			break
+			if i, dup := seen[ipv4.String()]; dup {
+				ips[i].ttl = min(ips[i].ttl, a.Hdr.Ttl)
+				continue
+			}
+			seen[ipv4.String()] = len(ips)
+			ips = append(ips, resolvedIP{ip: ipv4, ttl: a.Hdr.Ttl})
+		}
+		if len(ips) == found && len(hops) > 0 && cnames == nil {
+			cnames = hops
+		}
+		return len(ips) > 0 && len(zt.rule.ExpectedNetworks) == 0, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(ips) == 0 {
+		if cnames != nil {
+			return nil, cnames, nil
+		}
+		return nil, nil, fmt.Errorf("no IPv4 address found for %s", reflectedDomain)
+	}
+	return ips, nil, nil
+}
+
+// queryBackends sends msg to the zone's backends in turn and passes each
+// NOERROR response to handle, until it reports done or fails. Errors from
+// handle are returned as is. Without a response to handle the query fails,
+// with ErrNameNotFound if every backend that answered says the name doesn't
+// exist.
+func (zt *ZoneTranslator) queryBackends(msg *dns.Msg, handle func(resp *dns.Msg) (done bool, err error)) error {
+	question := msg.Question[0]
+	var lastErr error
+	answered, nxdomain, handled := 0, 0, false
+	for _, backend := range zt.rule.DNSServers {
+		resp, err := zt.exchange(msg, backend)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		answered++
+		if resp.Rcode == dns.RcodeNameError {
+			nxdomain++
+		}
+		if resp.Rcode != dns.RcodeSuccess {
+			lastErr = fmt.Errorf("backend returned %s", dns.RcodeToString[resp.Rcode])
+			continue
+		}
+		handled = true
+		if done, err := handle(resp); done || err != nil {
+			return err
+		}
+	}
+	if handled {
+		return nil
+	}
+
+	// The name only doesn't exist if every backend that answered says so
+	if answered > 0 && nxdomain == answered {
+		return fmt.Errorf("%s: %w", question.Name, ErrNameNotFound)
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no backends configured")
+	}
+	return fmt.Errorf("failed to resolve %s %s: %w", dns.TypeToString[question.Qtype], question.Name, lastErr)
+}
+
+// followCNAMEs returns the names the CNAME chain from name leads through in
+// answer, failing if it revisits a name or, with the chain already followed,
+// runs past the zone's maxCnameDepth
+func (zt *ZoneTranslator) followCNAMEs(answer []dns.RR, name string, chain []string) ([]string, error) {
+	var hops []string
+	for {
+		target, ok := cnameTarget(answer, name)
+		if !ok {
+			return hops, nil
+		}
+		target = strings.ToLower(target)
+		if slices.Contains(chain, target) || slices.Contains(hops, target) {
+			return nil, fmt.Errorf("%s: %w at %s", chain[0], ErrCNAMELoop, target)
 		}
+		// chain holds the name queried first plus every CNAME target since
+		if len(chain)+len(hops) > zt.rule.MaxCNAMEDepth {
+			return nil, fmt.Errorf("%s: %w (more than %d)", chain[0], ErrCNAMEChainTooLong, zt.rule.MaxCNAMEDepth)
+		}
+		hops = append(hops, target)
+		name = target
 	}
+}
 
-	if !strings.HasSuffix(reflectedDomain, ".") {
-		reflectedDomain += "."
+// cnameTarget returns the target of the CNAME record owned by name in answer
+func cnameTarget(answer []dns.RR, name string) (string, bool) {
+	for _, rr := range answer {
+		if cname, ok := rr.(*dns.CNAME); ok && strings.EqualFold(cname.Hdr.Name, name) {
+			return cname.Target, true
+		}
+	}
+	return "", false
+}
+
+// isExpected reports whether ip is within one of the zone's expected networks
+func (zt *ZoneTranslator) isExpected(ip net.IP) bool {
+	for _, network := range zt.rule.ExpectedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// reflectedName maps a name in the zone onto the reflected domain
+func (zt *ZoneTranslator) reflectedName(originalDomain string) string {
+	return zt.zone.MapName(originalDomain, zt.rule.ReflectedDomain)
+}
+
+// exchange sends msg to backend, over TLS for tls:// backends and HTTPS for
+// https:// ones
+func (zt *ZoneTranslator) exchange(msg *dns.Msg, backend string) (*dns.Msg, error) {
+	addr, protocol, err := config.ParseBackend(backend)
+	if err != nil {
+		return nil, err
+	}
+	if protocol == config.BackendProtocolHTTPS {
+		ctx, cancel := context.WithTimeout(context.Background(), zt.rule.DNSTimeout)
+		defer cancel()
+		return doh.Exchange(ctx, zt.dohClient, addr, msg)
 	}
 
 	client := &dns.Client{Timeout: zt.rule.DNSTimeout}
+	var localAddr net.Addr
+	if zt.rule.SourceAddress != nil {
+		localAddr = &net.UDPAddr{IP: zt.rule.SourceAddress}
+	}
+	if protocol == config.BackendProtocolTLS {
+		serverName := zt.rule.TLSServerName
+		if serverName == "" {
+			serverName, _, _ = net.SplitHostPort(addr)
+		}
+		client.Net = "tcp-tls"
+		client.TLSConfig = &tls.Config{ServerName: serverName, MinVersion: tls.VersionTLS12}
+		if zt.rule.SourceAddress != nil {
+			localAddr = &net.TCPAddr{IP: zt.rule.SourceAddress}
+		}
+	}
+	if localAddr != nil {
+		client.Dialer = &net.Dialer{Timeout: zt.rule.DNSTimeout, LocalAddr: localAddr}
+	}
+
+	resp, _, err := client.Exchange(msg, addr)
+	return resp, err
+}
+
+// MagicDNSTarget returns the name under the zone's magicDNSName that domain
+// is aliased to, or false if the zone has none
+func (t *Translator) MagicDNSTarget(domain string) (string, bool) {
+	zt := t.GetZoneForDomain(domain)
+	if zt == nil || zt.zone.MagicDNSName == "" {
+		return "", false
+	}
+	return zt.zone.MapName(domain, zt.zone.MagicDNSName), true
+}
+
+// ResolveAAAA resolves the real AAAA records of the reflected name for zones
+// that pass them through alongside their 4via6 answer
+func (t *Translator) ResolveAAAA(domain string) ([]dns.RR, error) {
+	if !strings.HasSuffix(domain, ".") {
+		domain += "."
+	}
+
+	zt := t.GetZoneForDomain(domain)
+	if zt == nil {
+		return nil, fmt.Errorf("no 4via6 zone found for domain %s", domain)
+	}
+	if net.ParseIP(zt.rule.ReflectedDomain) != nil {
+		return nil, nil // Static reflected IPs have no real AAAA
+	}
+
+	reflectedDomain := zt.reflectedName(domain)
 	msg := new(dns.Msg)
-	msg.SetQuestion(reflectedDomain, dns.TypeA)
+	msg.SetQuestion(reflectedDomain, dns.TypeAAAA)
 
+	var lastErr error
 	for _, backend := range zt.rule.DNSServers {
-		resp, _, err := client.Exchange(msg, backend)
+		resp, err := zt.exchange(msg, backend)
 		if err != nil {
+			lastErr = err
 			continue
 		}
 		if resp.Rcode != dns.RcodeSuccess {
+			lastErr = fmt.Errorf("backend returned %s", dns.RcodeToString[resp.Rcode])
 			continue
 		}
+
+		var answers []dns.RR
+		for _, rr := range resp.Answer {
+			if aaaa, ok := rr.(*dns.AAAA); ok {
+				aaaa.Hdr.Name = domain
+				answers = append(answers, aaaa)
+			}
+		}
+		return answers, nil
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no backends configured")
+	}
+	return nil, fmt.Errorf("failed to resolve AAAA %s: %w", reflectedDomain, lastErr)
+}
+
+// ReflectAAAA resolves the reflected name's own AAAA records for zones in
+// direct reflect mode and returns them under domain, with the lowest TTL among
+// them. A static IPv6 reflected address is answered as is. A reflected name
+// every answering backend says doesn't exist fails with ErrNameNotFound, one
+// aliased through a bad CNAME chain with ErrCNAMELoop or ErrCNAMEChainTooLong.
+func (t *Translator) ReflectAAAA(domain string) ([]dns.RR, uint32, error) {
+	if !strings.HasSuffix(domain, ".") {
+		domain += "."
+	}
+
+	zt := t.GetZoneForDomain(domain)
+	if zt == nil {
+		return nil, 0, fmt.Errorf("no 4via6 zone found for domain %s", domain)
+	}
+	if static := net.ParseIP(zt.rule.ReflectedDomain); static != nil {
+		if static.To4() != nil {
+			return nil, StaticTTL, nil // No AAAA for a static IPv4 address
+		}
+		return []dns.RR{&dns.AAAA{
+			Hdr:  dns.RR_Header{Name: domain, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: StaticTTL},
+			AAAA: static,
+		}}, StaticTTL, nil
+	}
+
+	reflectedDomain := zt.reflectedName(domain)
+	msg := new(dns.Msg)
+	msg.SetQuestion(reflectedDomain, dns.TypeAAAA)
+
+	var answers []dns.RR
+	ttl := uint32(StaticTTL)
+	err := zt.queryBackends(msg, func(resp *dns.Msg) (bool, error) {
+		// Records at the end of a CNAME chain are answered for domain too
+		if _, err := zt.followCNAMEs(resp.Answer, reflectedDomain, []string{strings.ToLower(reflectedDomain)}); err != nil {
+			return false, err
+		}
 		for _, rr := range resp.Answer {
-			if a, ok := rr.(*dns.A); ok {
-				return a.A, nil
+			if aaaa, ok := rr.(*dns.AAAA); ok {
+				aaaa.Hdr.Name = domain
+				answers = append(answers, aaaa)
+				ttl = min(ttl, aaaa.Hdr.Ttl)
 			}
 		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return answers, ttl, nil
+}
+
+// TranslateSVCB resolves the HTTPS/SVCB records of the reflected name and
+// replaces their ipv4hint values with the equivalent 4via6 ipv6hint. A
+// reflected name every answering backend says doesn't exist fails with
+// ErrNameNotFound, one aliased through a bad CNAME chain with ErrCNAMELoop
+// or ErrCNAMEChainTooLong.
+func (t *Translator) TranslateSVCB(domain string, qtype uint16) ([]dns.RR, error) {
+	if qtype != dns.TypeHTTPS && qtype != dns.TypeSVCB {
+		return nil, fmt.Errorf("unsupported query type %s", dns.TypeToString[qtype])
+	}
+	if !strings.HasSuffix(domain, ".") {
+		domain += "."
+	}
+
+	zt := t.GetZoneForDomain(domain)
+	if zt == nil {
+		return nil, fmt.Errorf("no 4via6 zone found for domain %s", domain)
+	}
+	if net.ParseIP(zt.rule.ReflectedDomain) != nil {
+		return nil, nil // Static reflected IPs have no service bindings
+	}
+
+	reflectedDomain := zt.reflectedName(domain)
+	msg := new(dns.Msg)
+	msg.SetQuestion(reflectedDomain, qtype)
+
+	var answers []dns.RR
+	err := zt.queryBackends(msg, func(resp *dns.Msg) (bool, error) {
+		for _, rr := range resp.Answer {
+			var svcb *dns.SVCB
+			switch r := rr.(type) {
+			case *dns.HTTPS:
+				svcb = &r.SVCB
+			case *dns.SVCB:
+				svcb = r
+			default:
+				continue
+			}
+			if !strings.EqualFold(svcb.Hdr.Name, reflectedDomain) {
+				continue
+			}
+			svcb.Hdr.Name = domain
+			zt.translateHints(svcb)
+			answers = append(answers, rr)
+		}
+		// An answer without service bindings may still alias the name through
+		// a CNAME chain that loops or runs too deep
+		if len(answers) == 0 {
+			if _, err := zt.followCNAMEs(resp.Answer, reflectedDomain, []string{strings.ToLower(reflectedDomain)}); err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return answers, nil
+}
+
+// translateHints swaps ipv4hint for a 4via6 ipv6hint, since the original IPv4
+// addresses are only reachable through the site's subnet router
+func (zt *ZoneTranslator) translateHints(svcb *dns.SVCB) {
+	var via6Hints []net.IP
+	values := svcb.Value[:0]
+	for _, kv := range svcb.Value {
+		switch v := kv.(type) {
+		case *dns.SVCBIPv4Hint:
+			for _, ipv4 := range v.Hint {
+				via6Hints = append(via6Hints, zt.embedIPv4(ipv4))
+			}
+		case *dns.SVCBIPv6Hint:
+			// Native IPv6 hints are not routable through the 4via6 path
+		default:
+			values = append(values, kv)
+		}
+	}
+	if len(via6Hints) > 0 {
+		values = append(values, &dns.SVCBIPv6Hint{Hint: via6Hints})
 	}
-	return nil, fmt.Errorf("no IPv4 address found for %s", reflectedDomain)
+	svcb.Value = values
 }
@@ -1,11 +1,16 @@
 package via6
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/miekg/dns"
 	"github.com/rajsingh/tsdnsreflector/internal/config"
 	"github.com/rajsingh/tsdnsreflector/internal/logger"
 )
@@ -699,4 +704,840 @@ func TestIs4via6Prefix(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+func TestTranslateSVCB(t *testing.T) {
+	backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		if r.Question[0].Name == "web.cluster.local." && r.Question[0].Qtype == dns.TypeHTTPS {
+			msg.Answer = append(msg.Answer, &dns.HTTPS{SVCB: dns.SVCB{
+				Hdr:      dns.RR_Header{Name: "web.cluster.local.", Rrtype: dns.TypeHTTPS, Class: dns.ClassINET, Ttl: 60},
+				Priority: 1,
+				Target:   ".",
+				Value: []dns.SVCBKeyValue{
+					&dns.SVCBAlpn{Alpn: []string{"h3", "h2"}},
+					&dns.SVCBIPv4Hint{Hint: []net.IP{net.ParseIP("10.0.0.5").To4(), net.ParseIP("10.0.0.6").To4()}},
+				},
+			}})
+		}
+		_ = w.WriteMsg(msg)
+	})
+
+	translateID := uint16(7)
+	cfg := &config.Config{
+		Zones: map[string]*config.Zone{
+			"cluster": {
+				Domains: []string{"*.prod.local"},
+				Backend: config.BackendConfig{
+					DNSServers: []string{backend},
+					Timeout:    "1s",
+				},
+				ReflectedDomain: "cluster.local",
+				PrefixSubnet:    "fd7a:115c:a1e0:b1a::/64",
+				TranslateID:     &translateID,
+			},
+		},
+	}
+	translator, err := NewTranslator(cfg, logger.Default())
+	if err != nil {
+		t.Fatalf("Failed to create translator: %v", err)
+	}
+
+	answers, err := translator.TranslateSVCB("web.prod.local.", dns.TypeHTTPS)
+	if err != nil {
+		t.Fatalf("TranslateSVCB failed: %v", err)
+	}
+	if len(answers) != 1 {
+		t.Fatalf("Expected 1 answer, got %d", len(answers))
+	}
+
+	https, ok := answers[0].(*dns.HTTPS)
+	if !ok {
+		t.Fatalf("Expected HTTPS record, got %T", answers[0])
+	}
+	if https.Hdr.Name != "web.prod.local." {
+		t.Errorf("Expected owner web.prod.local., got %s", https.Hdr.Name)
+	}
+
+	var alpn *dns.SVCBAlpn
+	var v6hint *dns.SVCBIPv6Hint
+	for _, kv := range https.Value {
+		switch v := kv.(type) {
+		case *dns.SVCBAlpn:
+			alpn = v
+		case *dns.SVCBIPv6Hint:
+			v6hint = v
+		case *dns.SVCBIPv4Hint:
+			t.Error("ipv4hint should be replaced by the 4via6 ipv6hint")
+		}
+	}
+	if alpn == nil {
+		t.Error("Expected alpn parameter to be preserved")
+	}
+	if v6hint == nil || len(v6hint.Hint) != 2 {
+		t.Fatalf("Expected ipv6hint with 2 addresses, got %v", v6hint)
+	}
+	Validate4via6Address(t, v6hint.Hint[0], translateID, net.ParseIP("10.0.0.5"))
+	Validate4via6Address(t, v6hint.Hint[1], translateID, net.ParseIP("10.0.0.6"))
+
+	if _, err := translator.TranslateSVCB("web.prod.local.", dns.TypeA); err == nil {
+		t.Error("Expected error for non-SVCB query type")
+	}
+}
+
+func TestReflectAAAA(t *testing.T) {
+	// An IPv6-only reflected domain, reached through a CNAME
+	backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		switch {
+		case r.Question[0].Name == "gone.cluster.local.":
+			msg.Rcode = dns.RcodeNameError
+		case r.Question[0].Qtype == dns.TypeAAAA:
+			msg.Answer = append(msg.Answer,
+				&dns.CNAME{
+					Hdr:    dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 300},
+					Target: "v6.cluster.local.",
+				},
+				&dns.AAAA{
+					Hdr:  dns.RR_Header{Name: "v6.cluster.local.", Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: 60},
+					AAAA: net.ParseIP("fd00::10"),
+				},
+				&dns.AAAA{
+					Hdr:  dns.RR_Header{Name: "v6.cluster.local.", Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: 30},
+					AAAA: net.ParseIP("fd00::11"),
+				})
+		}
+		_ = w.WriteMsg(msg)
+	})
+	translator := newBackendTranslator(t, backend)
+
+	answers, ttl, err := translator.ReflectAAAA("web.prod.local.")
+	if err != nil {
+		t.Fatalf("ReflectAAAA failed: %v", err)
+	}
+	if len(answers) != 2 {
+		t.Fatalf("Expected 2 answers, got %v", answers)
+	}
+	for i, want := range []string{"fd00::10", "fd00::11"} {
+		aaaa, ok := answers[i].(*dns.AAAA)
+		if !ok || aaaa.Hdr.Name != "web.prod.local." || !aaaa.AAAA.Equal(net.ParseIP(want)) {
+			t.Errorf("Expected web.prod.local. AAAA %s, got %v", want, answers[i])
+		}
+	}
+	if ttl != 30 {
+		t.Errorf("Expected the lowest record TTL 30, got %d", ttl)
+	}
+
+	if _, _, err := translator.ReflectAAAA("gone.prod.local."); !errors.Is(err, ErrNameNotFound) {
+		t.Errorf("Expected ErrNameNotFound, got %v", err)
+	}
+
+	// A static IPv6 reflected address is answered without a backend
+	translator.zones["cluster"].rule.ReflectedDomain = "fd00::20"
+	answers, _, err = translator.ReflectAAAA("web.prod.local.")
+	if err != nil || len(answers) != 1 {
+		t.Fatalf("Expected one static answer, got %v, %v", answers, err)
+	}
+	if aaaa := answers[0].(*dns.AAAA); !aaaa.AAAA.Equal(net.ParseIP("fd00::20")) {
+		t.Errorf("Expected static AAAA fd00::20, got %s", aaaa.AAAA)
+	}
+}
+
+func TestResolveReflectedDomainExpectedCIDRs(t *testing.T) {
+	answer := func(ip string) dns.HandlerFunc {
+		return func(w dns.ResponseWriter, r *dns.Msg) {
+			msg := new(dns.Msg)
+			msg.SetReply(r)
+			msg.Answer = append(msg.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   net.ParseIP(ip).To4(),
+			})
+			_ = w.WriteMsg(msg)
+		}
+	}
+	public := startTestBackend(t, answer("203.0.113.10"))
+	internal := startTestBackend(t, answer("10.0.0.10"))
+
+	tests := []struct {
+		name     string
+		expected []string
+		want     string
+	}{
+		{"first answer without expected CIDRs", nil, "203.0.113.10"},
+		{"prefers expected network", []string{"10.0.0.0/8"}, "10.0.0.10"},
+		{"falls back to first answer", []string{"192.168.0.0/16"}, "203.0.113.10"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			translateID := uint16(7)
+			cfg := &config.Config{
+				Zones: map[string]*config.Zone{
+					"cluster": {
+						Domains: []string{"*.prod.local"},
+						Backend: config.BackendConfig{
+							DNSServers: []string{public, internal},
+							Timeout:    "1s",
+						},
+						ReflectedDomain: "cluster.local",
+						TranslateID:     &translateID,
+						ExpectedCIDRs:   tt.expected,
+					},
+				},
+			}
+			translator, err := NewTranslator(cfg, logger.Default())
+			if err != nil {
+				t.Fatalf("Failed to create translator: %v", err)
+			}
+
+			via6IP, err := translator.TranslateToVia6("web.prod.local.")
+			if err != nil {
+				t.Fatalf("TranslateToVia6 failed: %v", err)
+			}
+			Validate4via6Address(t, via6IP, translateID, net.ParseIP(tt.want))
+		})
+	}
+}
+
+func TestResolveReflectedIPsDeduplicates(t *testing.T) {
+	answer := func(ips ...string) dns.HandlerFunc {
+		return func(w dns.ResponseWriter, r *dns.Msg) {
+			msg := new(dns.Msg)
+			msg.SetReply(r)
+			for _, ip := range ips {
+				msg.Answer = append(msg.Answer, &dns.A{
+					Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+					A:   net.ParseIP(ip).To4(),
+				})
+			}
+			_ = w.WriteMsg(msg)
+		}
+	}
+	first := startTestBackend(t, answer("10.0.0.10", "10.0.0.11"))
+	second := startTestBackend(t, answer("10.0.0.11", "10.0.0.12", "10.0.0.10"))
+
+	translateID := uint16(7)
+	cfg := &config.Config{
+		Zones: map[string]*config.Zone{
+			"cluster": {
+				Domains: []string{"*.prod.local"},
+				Backend: config.BackendConfig{
+					DNSServers: []string{first, second},
+					Timeout:    "1s",
+				},
+				ReflectedDomain: "cluster.local",
+				TranslateID:     &translateID,
+				ExpectedCIDRs:   []string{"10.0.0.0/8"},
+			},
+		},
+	}
+	translator, err := NewTranslator(cfg, logger.Default())
+	if err != nil {
+		t.Fatalf("Failed to create translator: %v", err)
+	}
+
+	ips, err := translator.zones["cluster"].resolveReflectedIPs("web.cluster.local.")
+	if err != nil {
+		t.Fatalf("resolveReflectedIPs failed: %v", err)
+	}
+
+	want := []string{"10.0.0.10", "10.0.0.11", "10.0.0.12"}
+	if len(ips) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, ips)
+	}
+	for i, resolved := range ips {
+		if resolved.ip.String() != want[i] {
+			t.Errorf("Expected %s at position %d, got %s", want[i], i, resolved.ip)
+		}
+	}
+}
+
+func TestResolveReflectedIPsNameNotFound(t *testing.T) {
+	nxdomain := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetRcode(r, dns.RcodeNameError)
+		_ = w.WriteMsg(msg)
+	})
+	servfail := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetRcode(r, dns.RcodeServerFailure)
+		_ = w.WriteMsg(msg)
+	})
+
+	_, err := newBackendTranslator(t, nxdomain).zones["cluster"].resolveReflectedIPs("gone.cluster.local.")
+	if !errors.Is(err, ErrNameNotFound) {
+		t.Errorf("Expected ErrNameNotFound, got %v", err)
+	}
+
+	// A failing backend is no proof the name doesn't exist
+	translator := newBackendTranslator(t, nxdomain)
+	translator.zones["cluster"].rule.DNSServers = []string{nxdomain, servfail}
+	_, err = translator.zones["cluster"].resolveReflectedIPs("gone.cluster.local.")
+	if err == nil || errors.Is(err, ErrNameNotFound) {
+		t.Errorf("Expected a lookup error other than ErrNameNotFound, got %v", err)
+	}
+}
+
+func TestResolveReflectedIPsFollowsCNAMEs(t *testing.T) {
+	cname := func(name, target string) dns.RR {
+		return &dns.CNAME{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 60}, Target: target}
+	}
+	backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		name := r.Question[0].Name
+		var hops int
+		_, notChain := fmt.Sscanf(name, "chain%d.cluster.local.", &hops)
+		switch {
+		case name == "loop.cluster.local.":
+			msg.Answer = append(msg.Answer, cname(name, "loop2.cluster.local."))
+		case name == "loop2.cluster.local.":
+			msg.Answer = append(msg.Answer, cname(name, "LOOP.cluster.local."))
+		case name == "inline.cluster.local.":
+			// A whole chain in one answer, as recursive resolvers send
+			msg.Answer = append(msg.Answer,
+				cname(name, "a.cluster.local."),
+				cname("a.cluster.local.", "b.cluster.local."),
+				&dns.A{Hdr: dns.RR_Header{Name: "b.cluster.local.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IPv4(10, 0, 0, 6)})
+		case notChain == nil && hops > 0:
+			// One hop per answer, so every target is asked for again
+			msg.Answer = append(msg.Answer, cname(name, fmt.Sprintf("chain%d.cluster.local.", hops-1)))
+		default:
+			msg.Answer = append(msg.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   net.IPv4(10, 0, 0, 5),
+			})
+		}
+		_ = w.WriteMsg(msg)
+	})
+	zt := newBackendTranslator(t, backend).zones["cluster"]
+
+	tests := []struct {
+		name    string
+		want    string
+		wantErr error
+	}{
+		{"chain2.cluster.local.", "10.0.0.5", nil}, // CNAME -> CNAME -> A
+		{"chain8.cluster.local.", "10.0.0.5", nil},
+		{"inline.cluster.local.", "10.0.0.6", nil},
+		{"chain9.cluster.local.", "", ErrCNAMEChainTooLong},
+		{"loop.cluster.local.", "", ErrCNAMELoop},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ips, err := zt.resolveReflectedIPs(tt.name)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Expected %v, got %v (%v)", tt.wantErr, err, ips)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveReflectedIPs failed: %v", err)
+			}
+			if len(ips) != 1 || ips[0].ip.String() != tt.want {
+				t.Errorf("Expected %s, got %v", tt.want, ips)
+			}
+		})
+	}
+
+	// The limit counts hops across answers as well as within one
+	zt.rule.MaxCNAMEDepth = 1
+	if _, err := zt.resolveReflectedIPs("inline.cluster.local."); !errors.Is(err, ErrCNAMEChainTooLong) {
+		t.Errorf("Expected ErrCNAMEChainTooLong within one answer, got %v", err)
+	}
+	if _, err := zt.resolveReflectedIPs("chain2.cluster.local."); !errors.Is(err, ErrCNAMEChainTooLong) {
+		t.Errorf("Expected ErrCNAMEChainTooLong across answers, got %v", err)
+	}
+}
+
+func TestResolutionCache(t *testing.T) {
+	tests := []struct {
+		name        string
+		ttl         uint32
+		wantQueries int32
+	}{
+		{"cached within TTL", 60, 1},
+		{"zero TTL not cached", 0, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var queries atomic.Int32
+			backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+				queries.Add(1)
+				msg := new(dns.Msg)
+				msg.SetReply(r)
+				msg.Answer = append(msg.Answer, &dns.A{
+					Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: tt.ttl},
+					A:   net.IPv4(10, 0, 0, 10),
+				})
+				_ = w.WriteMsg(msg)
+			})
+			translator := newBackendTranslator(t, backend)
+
+			for i := 0; i < 3; i++ {
+				addrs, ttl, err := translator.TranslateToVia6Addrs("web.prod.local.")
+				if err != nil {
+					t.Fatalf("TranslateToVia6Addrs failed: %v", err)
+				}
+				Validate4via6Address(t, addrs[0], 7, net.IPv4(10, 0, 0, 10))
+				if ttl > tt.ttl {
+					t.Errorf("Expected TTL at most %d, got %d", tt.ttl, ttl)
+				}
+			}
+			if got := queries.Load(); got != tt.wantQueries {
+				t.Errorf("Expected %d backend queries, got %d", tt.wantQueries, got)
+			}
+
+			translator.ClearResolutionCache()
+			if _, err := translator.TranslateToVia6("web.prod.local."); err != nil {
+				t.Fatalf("TranslateToVia6 failed: %v", err)
+			}
+			if got := queries.Load(); got != tt.wantQueries+1 {
+				t.Errorf("Expected a backend query after clearing the cache, got %d queries", got)
+			}
+		})
+	}
+}
+
+func TestParseReverseIPv6(t *testing.T) {
+	for _, addr := range []string{"fd7a:115c:a1e0:b1a:0:7:a00:5", "::1", "2001:db8::ff"} {
+		reverse, err := dns.ReverseAddr(addr)
+		if err != nil {
+			t.Fatalf("ReverseAddr(%s) failed: %v", addr, err)
+		}
+		ip, ok := ParseReverseIPv6(strings.ToUpper(reverse))
+		if !ok || !ip.Equal(net.ParseIP(addr)) {
+			t.Errorf("ParseReverseIPv6(%s) = %s, %v; want %s", reverse, ip, ok, addr)
+		}
+	}
+
+	for _, name := range []string{"5.0.0.10.in-addr.arpa.", "1.0.ip6.arpa.", "example.com.", "g.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.ip6.arpa."} {
+		if ip, ok := ParseReverseIPv6(name); ok {
+			t.Errorf("ParseReverseIPv6(%s) = %s, expected no address", name, ip)
+		}
+	}
+}
+
+func TestMatchReverse(t *testing.T) {
+	translator := newBackendTranslator(t, "127.0.0.1:53") // translateID 7
+
+	tests := []struct {
+		name string
+		want ReverseMatch
+	}{
+		{"5.0.0.0.0.0.a.0.7.0.0.0.0.0.0.0.a.1.b.0.0.e.1.a.c.5.1.1.a.7.d.f.ip6.arpa.", ReverseKnown},
+		{"5.0.0.0.0.0.a.0.8.0.0.0.0.0.0.0.a.1.b.0.0.e.1.a.c.5.1.1.a.7.d.f.ip6.arpa.", ReverseUnknown},
+		{"7.0.0.0.0.0.0.0.a.1.b.0.0.e.1.a.c.5.1.1.a.7.d.f.ip6.arpa.", ReverseKnown},
+		{"0.0.0.0.0.0.0.a.1.b.0.0.e.1.a.c.5.1.1.a.7.d.f.ip6.arpa.", ReverseKnown},     // Parent of 0x0007
+		{"0.0.0.0.0.0.0.0.a.1.b.0.0.e.1.a.c.5.1.1.a.7.d.f.ip6.arpa.", ReverseUnknown}, // translateID 0
+		{"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.", ReverseOutside},
+		{"a.1.b.0.0.e.1.a.c.5.1.1.a.7.d.f.ip6.arpa.", ReverseOutside}, // Above the range
+		{"1.0.0.0.a.1.b.0.0.e.1.a.c.5.1.1.a.7.d.f.ip6.arpa.", ReverseOutside},
+		{"example.com.", ReverseOutside},
+	}
+	for _, tt := range tests {
+		if got := translator.MatchReverse(tt.name); got != tt.want {
+			t.Errorf("MatchReverse(%q) = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIsVia6Apex(t *testing.T) {
+	translator := newBackendTranslator(t, "127.0.0.1:53") // translateID 7
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"7.0.0.0.0.0.0.0.a.1.b.0.0.e.1.a.c.5.1.1.a.7.d.f.ip6.arpa.", true},                  // The zone's /96
+		{"0.0.0.0.0.0.0.0.7.0.0.0.0.0.0.0.a.1.b.0.0.e.1.a.c.5.1.1.a.7.d.f.ip6.arpa.", true},  // Its network address
+		{"5.0.0.0.0.0.a.0.7.0.0.0.0.0.0.0.a.1.b.0.0.e.1.a.c.5.1.1.a.7.d.f.ip6.arpa.", false}, // A host
+		{"0.0.0.0.0.0.0.0.8.0.0.0.0.0.0.0.a.1.b.0.0.e.1.a.c.5.1.1.a.7.d.f.ip6.arpa.", false}, // No zone's translateID
+		{"0.0.0.0.0.0.0.a.1.b.0.0.e.1.a.c.5.1.1.a.7.d.f.ip6.arpa.", false},                   // Above the network
+		{"0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.ip6.arpa.", false},
+		{"example.com.", false},
+	}
+	for _, tt := range tests {
+		if got := translator.IsVia6Apex(tt.name); got != tt.want {
+			t.Errorf("IsVia6Apex(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReverseVia6RoundTrip(t *testing.T) {
+	backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		q := r.Question[0]
+		switch {
+		case q.Qtype == dns.TypeA && q.Name == "web.cluster.local.":
+			msg.Answer = append(msg.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   net.IPv4(10, 0, 0, 5),
+			})
+		case q.Qtype == dns.TypePTR && q.Name == "5.0.0.10.in-addr.arpa.":
+			msg.Answer = append(msg.Answer, &dns.PTR{
+				Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: 30},
+				Ptr: "web.cluster.local.",
+			})
+		case q.Qtype == dns.TypePTR && q.Name == "6.0.0.10.in-addr.arpa.":
+			msg.Answer = append(msg.Answer, &dns.PTR{
+				Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: 30},
+				Ptr: "host.elsewhere.example.",
+			})
+		default:
+			msg.Rcode = dns.RcodeNameError
+		}
+		_ = w.WriteMsg(msg)
+	})
+	translator := newBackendTranslator(t, backend)
+
+	via6IP, err := translator.TranslateToVia6("web.prod.local.")
+	if err != nil {
+		t.Fatalf("TranslateToVia6 failed: %v", err)
+	}
+
+	names, ttl, err := translator.ReverseVia6(via6IP)
+	if err != nil {
+		t.Fatalf("ReverseVia6 failed: %v", err)
+	}
+	if len(names) != 1 || names[0] != "web.prod.local." {
+		t.Fatalf("Expected [web.prod.local.], got %v", names)
+	}
+	if ttl != 30 {
+		t.Errorf("Expected PTR TTL 30, got %d", ttl)
+	}
+
+	again, err := translator.TranslateToVia6(names[0])
+	if err != nil {
+		t.Fatalf("TranslateToVia6(%s) failed: %v", names[0], err)
+	}
+	if !again.Equal(via6IP) {
+		t.Errorf("Forward and reverse are not inverses: %s -> %s -> %s", via6IP, names[0], again)
+	}
+
+	// PTR targets outside the reflected domain and missing PTRs have no name in the zone
+	for _, ipv4 := range []net.IP{net.IPv4(10, 0, 0, 6), net.IPv4(10, 0, 0, 7)} {
+		names, _, err := translator.ReverseVia6(translator.zones["cluster"].embedIPv4(ipv4))
+		if err != nil {
+			t.Fatalf("ReverseVia6 failed: %v", err)
+		}
+		if len(names) != 0 {
+			t.Errorf("Expected no names for %s, got %v", ipv4, names)
+		}
+	}
+
+	if _, _, err := translator.ReverseVia6(net.ParseIP("2001:db8::1")); err == nil {
+		t.Error("Expected error for non-4via6 address")
+	}
+}
+
+func TestEmbedIPv4Concurrent(t *testing.T) {
+	translator := CreateTestTranslator(t, []string{"*.prod.local"}, "cluster.local", 7)
+	zt := translator.zones["test"]
+
+	const goroutines = 64
+	var wg sync.WaitGroup
+	errs := make(chan string, goroutines)
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 256; i++ {
+				ipv4 := net.IPv4(10, byte(g), byte(i), 1)
+				got := zt.embedIPv4(ipv4)
+				// Results must neither share memory with the input nor with each other
+				ipv4[15] = 99
+				if want := Create4via6Address(7, net.IPv4(10, byte(g), byte(i), 1)); !got.Equal(want) {
+					errs <- fmt.Sprintf("embedIPv4(10.%d.%d.1) = %s, want %s", g, i, got, want)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestReresolveInBackground(t *testing.T) {
+	var lastOctet, queries atomic.Int32
+	var failing atomic.Bool
+	lastOctet.Store(1)
+	backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		queries.Add(1)
+		msg := new(dns.Msg)
+		if failing.Load() {
+			msg.SetRcode(r, dns.RcodeServerFailure)
+			_ = w.WriteMsg(msg)
+			return
+		}
+		msg.SetReply(r)
+		msg.Answer = append(msg.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 3600},
+			A:   net.IPv4(10, 0, 0, byte(lastOctet.Load())),
+		})
+		_ = w.WriteMsg(msg)
+	})
+
+	translateID := uint16(7)
+	cfg := &config.Config{
+		Zones: map[string]*config.Zone{
+			"cluster": {
+				Domains:           []string{"*.prod.local"},
+				Backend:           config.BackendConfig{DNSServers: []string{backend}, Timeout: "1s"},
+				ReflectedDomain:   "cluster.local",
+				TranslateID:       &translateID,
+				ReresolveInterval: "50ms",
+			},
+		},
+	}
+	translator, err := NewTranslator(cfg, logger.Default())
+	if err != nil {
+		t.Fatalf("Failed to create translator: %v", err)
+	}
+	defer translator.Stop()
+
+	lookup := func() net.IP {
+		t.Helper()
+		addrs, ttl, err := translator.TranslateToVia6Addrs("web.prod.local.")
+		if err != nil || len(addrs) != 1 {
+			t.Fatalf("Expected one 4via6 address, got %v, %v", addrs, err)
+		}
+		// Answers last until the next refresh, not the record TTL
+		if ttl != 1 {
+			t.Errorf("Expected the refresh interval as TTL, got %d", ttl)
+		}
+		return addrs[0]
+	}
+
+	Validate4via6Address(t, lookup(), translateID, net.ParseIP("10.0.0.1"))
+
+	// The address changes behind the zone's back and is picked up by a refresh
+	lastOctet.Store(2)
+	deadline := time.Now().Add(2 * time.Second)
+	for !lookup().Equal(translator.zones["cluster"].embedIPv4(net.IPv4(10, 0, 0, 2))) {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the refreshed address within the deadline")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Failed refreshes keep answering the last addresses
+	failing.Store(true)
+	before := queries.Load()
+	time.Sleep(150 * time.Millisecond)
+	if queries.Load() == before {
+		t.Error("Expected refreshes to keep querying the backend")
+	}
+	Validate4via6Address(t, lookup(), translateID, net.ParseIP("10.0.0.2"))
+
+	// Stopped translators no longer query the backends
+	translator.Stop()
+	time.Sleep(60 * time.Millisecond)
+	before = queries.Load()
+	time.Sleep(150 * time.Millisecond)
+	if got := queries.Load(); got != before {
+		t.Errorf("Expected no refreshes after Stop, got %d more queries", got-before)
+	}
+}
+
+func TestResolutionSharedAcrossZones(t *testing.T) {
+	for _, ttl := range []uint32{0, 60} {
+		t.Run(fmt.Sprintf("ttl %d", ttl), func(t *testing.T) {
+			var queries atomic.Int32
+			backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+				queries.Add(1)
+				// Slow enough for every concurrent translation to join this lookup
+				time.Sleep(100 * time.Millisecond)
+				msg := new(dns.Msg)
+				msg.SetReply(r)
+				msg.Answer = append(msg.Answer, &dns.A{
+					Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+					A:   net.IPv4(10, 0, 0, 10),
+				})
+				_ = w.WriteMsg(msg)
+			})
+
+			backendCfg := config.BackendConfig{DNSServers: []string{backend}, Timeout: "1s"}
+			east, west := uint16(7), uint16(8)
+			cfg := &config.Config{
+				Zones: map[string]*config.Zone{
+					"east": {Domains: []string{"*.east.local"}, Backend: backendCfg, ReflectedDomain: "cluster.local", TranslateID: &east},
+					"west": {Domains: []string{"*.west.local"}, Backend: backendCfg, ReflectedDomain: "cluster.local", TranslateID: &west},
+				},
+			}
+			translator, err := NewTranslator(cfg, logger.Default())
+			if err != nil {
+				t.Fatalf("Failed to create translator: %v", err)
+			}
+
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				for domain, translateID := range map[string]uint16{"web.east.local.": east, "web.west.local.": west} {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						via6IP, err := translator.TranslateToVia6(domain)
+						if err != nil {
+							t.Errorf("TranslateToVia6(%s) failed: %v", domain, err)
+							return
+						}
+						// Each zone still applies its own translateID
+						if !via6IP.Equal(Create4via6Address(translateID, net.IPv4(10, 0, 0, 10))) {
+							t.Errorf("TranslateToVia6(%s) = %s, wrong translateID or IPv4", domain, via6IP)
+						}
+					}()
+				}
+			}
+			wg.Wait()
+
+			if got := queries.Load(); got != 1 {
+				t.Errorf("Expected one shared backend lookup, got %d", got)
+			}
+		})
+	}
+}
+
+func TestResolutionKeyedBySourceAddress(t *testing.T) {
+	// Split horizon: the backend answers by the address a query comes from
+	backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		client := w.RemoteAddr().(*net.UDPAddr).IP.To4()
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		msg.Answer = append(msg.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.IPv4(10, 0, 0, client[3]),
+		})
+		_ = w.WriteMsg(msg)
+	})
+
+	// Binding 127.0.0.2 needs the whole of 127/8 on loopback, as on Linux
+	probe, err := net.ListenPacket("udp", "127.0.0.2:0")
+	if err != nil {
+		t.Skipf("127.0.0.2 not usable as a source address: %v", err)
+	}
+	_ = probe.Close()
+
+	east, west := uint16(7), uint16(8)
+	cfg := &config.Config{
+		Zones: map[string]*config.Zone{
+			"east": {
+				Domains:         []string{"*.east.local"},
+				Backend:         config.BackendConfig{DNSServers: []string{backend}, Timeout: "1s", SourceAddress: "127.0.0.1"},
+				ReflectedDomain: "cluster.local",
+				TranslateID:     &east,
+			},
+			"west": {
+				Domains:         []string{"*.west.local"},
+				Backend:         config.BackendConfig{DNSServers: []string{backend}, Timeout: "1s", SourceAddress: "127.0.0.2"},
+				ReflectedDomain: "cluster.local",
+				TranslateID:     &west,
+			},
+		},
+	}
+	translator, err := NewTranslator(cfg, logger.Default())
+	if err != nil {
+		t.Fatalf("Failed to create translator: %v", err)
+	}
+
+	for _, tc := range []struct {
+		domain      string
+		translateID uint16
+		ipv4        net.IP
+	}{
+		{"web.east.local.", east, net.IPv4(10, 0, 0, 1)},
+		{"web.west.local.", west, net.IPv4(10, 0, 0, 2)},
+		{"web.east.local.", east, net.IPv4(10, 0, 0, 1)},
+	} {
+		via6IP, err := translator.TranslateToVia6(tc.domain)
+		if err != nil {
+			t.Fatalf("TranslateToVia6(%s) failed: %v", tc.domain, err)
+		}
+		if want := Create4via6Address(tc.translateID, tc.ipv4); !via6IP.Equal(want) {
+			t.Errorf("TranslateToVia6(%s) = %s, want %s from its own source address", tc.domain, via6IP, want)
+		}
+	}
+}
+
+func BenchmarkTranslateToVia6Cached(b *testing.B) {
+	benchmarkTranslateToVia6(b, 300)
+}
+
+func BenchmarkTranslateToVia6Uncached(b *testing.B) {
+	// A zero TTL is never cached, so every translation queries the backend
+	benchmarkTranslateToVia6(b, 0)
+}
+
+func benchmarkTranslateToVia6(b *testing.B, ttl uint32) {
+	backend := startTestBackend(b, func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		msg.Answer = append(msg.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+			A:   net.IPv4(10, 0, 0, 10),
+		})
+		_ = w.WriteMsg(msg)
+	})
+	translator := newBackendTranslator(b, backend)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := translator.TranslateToVia6("web.prod.local."); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// newBackendTranslator returns a translator for *.prod.local reflecting onto
+// cluster.local through backend, with translateID 7
+func newBackendTranslator(t testing.TB, backend string) *Translator {
+	t.Helper()
+
+	translateID := uint16(7)
+	cfg := &config.Config{
+		Zones: map[string]*config.Zone{
+			"cluster": {
+				Domains: []string{"*.prod.local"},
+				Backend: config.BackendConfig{
+					DNSServers: []string{backend},
+					Timeout:    "1s",
+				},
+				ReflectedDomain: "cluster.local",
+				TranslateID:     &translateID,
+			},
+		},
+	}
+	translator, err := NewTranslator(cfg, logger.Default())
+	if err != nil {
+		t.Fatalf("Failed to create translator: %v", err)
+	}
+	return translator
+}
+
+// startTestBackend runs a UDP DNS server on localhost and returns its address
+func startTestBackend(t testing.TB, handler dns.HandlerFunc) string {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	started := make(chan struct{})
+	server := &dns.Server{
+		PacketConn:        pc,
+		Handler:           handler,
+		NotifyStartedFunc: func() { close(started) },
+	}
+	go func() { _ = server.ActivateAndServe() }()
+	<-started
+	t.Cleanup(func() { _ = server.Shutdown() })
+
+	return pc.LocalAddr().String()
+}
@@ -1,7 +1,9 @@
 package cache
 
 import (
+	"container/list"
 	"net"
+	"slices"
 	"sync"
 	"time"
 	"unsafe"
@@ -12,17 +14,38 @@ import (
 
 type CacheEntry struct {
 	Response  *dns.Msg
+	StoredAt  time.Time
 	ExpiresAt time.Time
+
+	recency     *list.Element // Position in the zone cache's LRU list, holding the key
+	synthesized bool          // Built locally rather than answered by a backend
 }
 
+// staleAnswerTTL is the TTL given to records served stale (RFC 8767 section 4)
+const staleAnswerTTL = 30
+
+// defaultNegativeTTL caches negative answers without an SOA until
+// SetNegativeTTL says otherwise
+const defaultNegativeTTL = 60 * time.Second
+
 type ZoneCache struct {
-	entries     map[string]*CacheEntry
-	mutex       sync.RWMutex
-	maxSize     int
-	ttl         time.Duration
-	zoneName    string
-	memoryUsage int64
-	stopCleanup chan struct{}
+	entries        map[string]*CacheEntry
+	mutex          sync.RWMutex
+	maxSize        int
+	ttl            time.Duration
+	staleRetention time.Duration // How long expired entries are kept for GetStale
+	negativeTTL    time.Duration // Lifetime of NXDOMAIN/NODATA entries without an SOA
+	minTTL         time.Duration // Floor on the lifetime taken from upstream answer TTLs
+	preserveAA     bool          // Serve backend answers with the AA bit they arrived with
+	zoneName       string
+	memoryUsage    int64
+	maxBytes       int64 // Most memoryUsage before least recently used entries are evicted (0 = no limit)
+	stopCleanup    chan struct{}
+
+	// lru orders keys from most to least recently stored or served. Hits only
+	// hold the read lock, so moving an entry to the front takes lruMutex too.
+	lru      *list.List
+	lruMutex sync.Mutex
 }
 
 func NewZoneCache(maxSize int, ttl time.Duration) *ZoneCache {
@@ -30,8 +53,10 @@ func NewZoneCache(maxSize int, ttl time.Duration) *ZoneCache {
 		entries:     make(map[string]*CacheEntry),
 		maxSize:     maxSize,
 		ttl:         ttl,
+		negativeTTL: defaultNegativeTTL,
 		memoryUsage: 0,
 		stopCleanup: make(chan struct{}),
+		lru:         list.New(),
 	}
 	go cache.startCleanupRoutine()
 	return cache
@@ -43,14 +68,27 @@ func NewZoneCacheWithName(maxSize int, ttl time.Duration, zoneName string) *Zone
 		maxSize:     maxSize,
 		ttl:         ttl,
 		zoneName:    zoneName,
+		negativeTTL: defaultNegativeTTL,
 		memoryUsage: 0,
 		stopCleanup: make(chan struct{}),
+		lru:         list.New(),
 	}
 	go cache.startCleanupRoutine()
 	return cache
 }
 
+// Get returns the unexpired response cached under key and marks it recently used
 func (zc *ZoneCache) Get(key string) (*dns.Msg, bool) {
+	return zc.get(key, true)
+}
+
+// Peek is Get without marking the entry recently used, for diagnostics that
+// must not change which entries are evicted
+func (zc *ZoneCache) Peek(key string) (*dns.Msg, bool) {
+	return zc.get(key, false)
+}
+
+func (zc *ZoneCache) get(key string, touch bool) (*dns.Msg, bool) {
 	zc.mutex.RLock()
 	defer zc.mutex.RUnlock()
 
@@ -63,38 +101,256 @@ func (zc *ZoneCache) Get(key string) (*dns.Msg, bool) {
 		// Entry expired, will be cleaned up later
 		return nil, false
 	}
+	if touch {
+		zc.lruMutex.Lock()
+		zc.lru.MoveToFront(entry.recency)
+		zc.lruMutex.Unlock()
+	}
+
+	// Return a copy of the response with TTLs aged by the time spent in cache
+	response := entry.Response.Copy()
+	decrementTTLs(response, time.Since(entry.StoredAt))
+	zc.clearAuthoritative(entry, response)
+	return response, true
+}
+
+// GetStale returns an expired entry that is still within the stale retention
+// window, with every TTL set to staleAnswerTTL
+func (zc *ZoneCache) GetStale(key string) (*dns.Msg, bool) {
+	zc.mutex.RLock()
+	defer zc.mutex.RUnlock()
+
+	entry, exists := zc.entries[key]
+	if !exists {
+		return nil, false
+	}
+
+	now := time.Now()
+	if !now.After(entry.ExpiresAt) || now.After(entry.ExpiresAt.Add(zc.staleRetention)) {
+		return nil, false
+	}
+
+	response := entry.Response.Copy()
+	for _, section := range [][]dns.RR{response.Answer, response.Ns, response.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype != dns.TypeOPT {
+				rr.Header().Ttl = staleAnswerTTL
+			}
+		}
+	}
+	zc.clearAuthoritative(entry, response)
+	return response, true
+}
+
+// clearAuthoritative clears the AA bit on a backend answer served from the
+// cache: the backend was authoritative when it answered, but a cached copy
+// isn't (RFC 1035 section 6.1.3). Synthesized answers are this server's own
+// and keep it. Callers hold zc.mutex.
+func (zc *ZoneCache) clearAuthoritative(entry *CacheEntry, response *dns.Msg) {
+	if !entry.synthesized && !zc.preserveAA {
+		response.Authoritative = false
+	}
+}
+
+// SetMinTTL sets the shortest time an answer is cached when its records carry
+// a lower TTL. The zone TTL still caps it.
+func (zc *ZoneCache) SetMinTTL(d time.Duration) {
+	zc.mutex.Lock()
+	defer zc.mutex.Unlock()
+
+	zc.minTTL = d
+}
+
+// SetMaxBytes bounds the cache's estimated memory usage: storing an entry
+// evicts expired, then least recently used entries until it fits, and entries
+// larger than the whole budget are not cached. 0 (the default) leaves only the
+// entry count bound.
+func (zc *ZoneCache) SetMaxBytes(n int64) {
+	zc.mutex.Lock()
+	defer zc.mutex.Unlock()
+
+	zc.maxBytes = max(n, 0)
+}
+
+// SetPreserveAA makes backend answers keep the AA bit they were cached with
+// instead of having it cleared when served
+func (zc *ZoneCache) SetPreserveAA(preserve bool) {
+	zc.mutex.Lock()
+	defer zc.mutex.Unlock()
 
-	// Return a copy of the response
-	return entry.Response.Copy(), true
+	zc.preserveAA = preserve
 }
 
+// SetNegativeTTL sets how long NXDOMAIN and NODATA answers are cached when
+// they carry no SOA record to take the negative TTL from (RFC 2308)
+func (zc *ZoneCache) SetNegativeTTL(d time.Duration) {
+	zc.mutex.Lock()
+	defer zc.mutex.Unlock()
+
+	zc.negativeTTL = d
+}
+
+// SetStaleRetention keeps expired entries for d so they can be served by
+// GetStale; 0 (the default) removes entries as soon as they expire
+func (zc *ZoneCache) SetStaleRetention(d time.Duration) {
+	zc.mutex.Lock()
+	defer zc.mutex.Unlock()
+
+	zc.staleRetention = d
+}
+
+// Set caches an upstream response until the lowest TTL among its answers runs
+// out, kept within the cache's minimum TTL and the zone TTL
 func (zc *ZoneCache) Set(key string, response *dns.Msg) {
+	zc.set(key, response, false, noMaxTTL)
+}
+
+// SetSynthesized caches a locally built response, such as a 4via6 answer, for
+// its own record TTLs without raising them to the cache's minimum TTL
+func (zc *ZoneCache) SetSynthesized(key string, response *dns.Msg) {
+	zc.set(key, response, true, noMaxTTL)
+}
+
+// SetSynthesizedFor is SetSynthesized for a response built from data that is
+// only valid for maxTTL, such as a 4via6 answer from the reflected name's A
+// records, so the entry expires with that data even if its records carry a
+// longer TTL
+func (zc *ZoneCache) SetSynthesizedFor(key string, response *dns.Msg, maxTTL time.Duration) {
+	zc.set(key, response, true, max(maxTTL, 0))
+}
+
+// noMaxTTL leaves an entry's lifetime to its records and the zone TTL
+const noMaxTTL time.Duration = -1
+
+func (zc *ZoneCache) set(key string, response *dns.Msg, synthesized bool, maxTTL time.Duration) {
+	// A server failure says nothing about the name, only that no backend
+	// could answer this time, so the next query asks again
+	if response != nil && response.Rcode == dns.RcodeServerFailure {
+		return
+	}
+
 	zc.mutex.Lock()
 	defer zc.mutex.Unlock()
 
-	// Check if we need to evict entries
+	// Calculate memory usage for the new entry
+	entrySize := zc.calculateEntrySize(key, response)
+
+	// Replacing an entry frees its memory and its place; adding one may
+	// require evicting others
+	if existing, exists := zc.entries[key]; exists {
+		zc.remove(key, existing)
+	}
+	if zc.maxBytes > 0 && entrySize > zc.maxBytes {
+		return
+	}
 	if len(zc.entries) >= zc.maxSize {
 		zc.evictExpired()
 		
-		// If still at capacity, evict oldest entry
+		// If still at capacity, evict the least recently used entry
 		if len(zc.entries) >= zc.maxSize {
-			zc.evictOldest()
+			zc.evictOldest("lru")
+		}
+	}
+	if zc.maxBytes > 0 && zc.memoryUsage+entrySize > zc.maxBytes {
+		zc.evictExpired()
+		for zc.memoryUsage+entrySize > zc.maxBytes && len(zc.entries) > 0 {
+			zc.evictOldest("memory")
 		}
 	}
-
-	// Calculate memory usage for the new entry
-	entrySize := zc.calculateEntrySize(key, response)
 	
-	// Store a copy of the response
+	// Never keep an entry longer than the shortest TTL in its answer chain
+	ttl := zc.ttl
+	if minTTL, ok := minAnswerTTL(response); ok && minTTL < ttl {
+		ttl = minTTL
+		if !synthesized && ttl < zc.minTTL {
+			ttl = min(zc.minTTL, zc.ttl)
+		}
+	}
+	if isNegative(response) {
+		if negTTL := zc.negativeTTLOf(response); negTTL < ttl {
+			ttl = negTTL
+		}
+	}
+	if maxTTL != noMaxTTL && maxTTL < ttl {
+		ttl = maxTTL
+	}
+
+	// Store a copy of the response as the most recently used entry
+	zc.lruMutex.Lock()
+	recency := zc.lru.PushFront(key)
+	zc.lruMutex.Unlock()
+
+	now := time.Now()
 	zc.entries[key] = &CacheEntry{
-		Response:  response.Copy(),
-		ExpiresAt: time.Now().Add(zc.ttl),
+		Response:    response.Copy(),
+		StoredAt:    now,
+		ExpiresAt:   now.Add(ttl),
+		recency:     recency,
+		synthesized: synthesized,
 	}
 	
 	// Update memory usage
 	zc.memoryUsage += entrySize
 }
 
+// minAnswerTTL returns the lowest TTL across the answer section, which for a
+// CNAME chain bounds how long the whole chain may be served
+func minAnswerTTL(msg *dns.Msg) (time.Duration, bool) {
+	if msg == nil || len(msg.Answer) == 0 {
+		return 0, false
+	}
+
+	minTTL := msg.Answer[0].Header().Ttl
+	for _, rr := range msg.Answer[1:] {
+		if ttl := rr.Header().Ttl; ttl < minTTL {
+			minTTL = ttl
+		}
+	}
+	return time.Duration(minTTL) * time.Second, true
+}
+
+// isNegative reports whether msg is an NXDOMAIN or NODATA answer (RFC 2308)
+func isNegative(msg *dns.Msg) bool {
+	if msg == nil {
+		return false
+	}
+	return msg.Rcode == dns.RcodeNameError || (msg.Rcode == dns.RcodeSuccess && len(msg.Answer) == 0)
+}
+
+// negativeTTLOf returns how long a negative answer may be cached: the lower
+// of the SOA record's TTL and MINIMUM field in the authority section (RFC 2308
+// section 5), or the cache's negative TTL without an SOA
+func (zc *ZoneCache) negativeTTLOf(msg *dns.Msg) time.Duration {
+	for _, rr := range msg.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			return time.Duration(min(soa.Hdr.Ttl, soa.Minttl)) * time.Second
+		}
+	}
+	return zc.negativeTTL
+}
+
+// decrementTTLs reduces every record's TTL by the elapsed time, flooring at zero
+func decrementTTLs(msg *dns.Msg, elapsed time.Duration) {
+	age := uint32(elapsed / time.Second)
+	if age == 0 {
+		return
+	}
+
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range section {
+			hdr := rr.Header()
+			if hdr.Rrtype == dns.TypeOPT {
+				continue // OPT reuses the TTL field for EDNS flags
+			}
+			if hdr.Ttl > age {
+				hdr.Ttl -= age
+			} else {
+				hdr.Ttl = 0
+			}
+		}
+	}
+}
+
 // calculateDNSMsgSize estimates the memory usage of a DNS message
 func (zc *ZoneCache) calculateDNSMsgSize(msg *dns.Msg) int64 {
 	if msg == nil {
@@ -193,12 +449,55 @@ func (zc *ZoneCache) calculateEntrySize(key string, response *dns.Msg) int64 {
 	return keySize + responseSize + entryStructSize + mapOverhead
 }
 
-func (zc *ZoneCache) Clear() {
+// Clear removes every entry, returning how many there were
+func (zc *ZoneCache) Clear() int {
 	zc.mutex.Lock()
 	defer zc.mutex.Unlock()
 	
+	cleared := len(zc.entries)
 	zc.entries = make(map[string]*CacheEntry)
+	zc.lruMutex.Lock()
+	zc.lru.Init()
+	zc.lruMutex.Unlock()
 	zc.memoryUsage = 0
+	return cleared
+}
+
+// EntryInfo describes a cache entry without its response
+type EntryInfo struct {
+	Key       string
+	StoredAt  time.Time
+	ExpiresAt time.Time
+	Size      int64 // Estimated bytes, as counted by MemoryUsage
+}
+
+// Entries returns up to limit entries ordered by key, or every entry if limit
+// is 0, along with how many entries the cache holds. Expired entries kept for
+// stale answers are included.
+func (zc *ZoneCache) Entries(limit int) ([]EntryInfo, int) {
+	zc.mutex.RLock()
+	defer zc.mutex.RUnlock()
+
+	keys := make([]string, 0, len(zc.entries))
+	for key := range zc.entries {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+
+	infos := make([]EntryInfo, 0, len(keys))
+	for _, key := range keys {
+		entry := zc.entries[key]
+		infos = append(infos, EntryInfo{
+			Key:       key,
+			StoredAt:  entry.StoredAt,
+			ExpiresAt: entry.ExpiresAt,
+			Size:      zc.calculateEntrySize(key, entry.Response),
+		})
+	}
+	return infos, len(zc.entries)
 }
 
 func (zc *ZoneCache) Stop() {
@@ -246,11 +545,9 @@ func (zc *ZoneCache) evictExpired() {
 	now := time.Now()
 	evictedCount := 0
 	for key, entry := range zc.entries {
-		if now.After(entry.ExpiresAt) {
+		if now.After(entry.ExpiresAt.Add(zc.staleRetention)) {
 			// Subtract memory usage before deletion
-			entrySize := zc.calculateEntrySize(key, entry.Response)
-			zc.memoryUsage -= entrySize
-			delete(zc.entries, key)
+			zc.remove(key, entry)
 			evictedCount++
 		}
 	}
@@ -263,34 +560,32 @@ func (zc *ZoneCache) evictExpired() {
 	}
 }
 
-func (zc *ZoneCache) evictOldest() {
-	if len(zc.entries) == 0 {
+// evictOldest removes the least recently used entry: the one stored or
+// served longest ago, whatever its TTL. reason labels the eviction metric.
+func (zc *ZoneCache) evictOldest(reason string) {
+	zc.lruMutex.Lock()
+	oldest := zc.lru.Back()
+	zc.lruMutex.Unlock()
+	if oldest == nil {
 		return
 	}
 
-	var oldestKey string
-	var oldestEntry *CacheEntry
-	var oldestTime time.Time
+	key := oldest.Value.(string)
+	zc.remove(key, zc.entries[key])
 
-	for key, entry := range zc.entries {
-		if oldestKey == "" || entry.ExpiresAt.Before(oldestTime) {
-			oldestKey = key
-			oldestEntry = entry
-			oldestTime = entry.ExpiresAt
-		}
+	// Record eviction metrics
+	if zc.zoneName != "" {
+		metrics.RecordCacheEviction(zc.zoneName, reason)
 	}
+}
 
-	if oldestKey != "" {
-		// Subtract memory usage before deletion
-		entrySize := zc.calculateEntrySize(oldestKey, oldestEntry.Response)
-		zc.memoryUsage -= entrySize
-		delete(zc.entries, oldestKey)
-		
-		// Record eviction metrics
-		if zc.zoneName != "" {
-			metrics.RecordCacheEviction(zc.zoneName, "lru")
-		}
-	}
+// remove deletes entry and releases its memory. The caller holds the write lock.
+func (zc *ZoneCache) remove(key string, entry *CacheEntry) {
+	zc.memoryUsage -= zc.calculateEntrySize(key, entry.Response)
+	delete(zc.entries, key)
+	zc.lruMutex.Lock()
+	zc.lru.Remove(entry.recency)
+	zc.lruMutex.Unlock()
 }
 
 // CacheKey generates a cache key for DNS queries
@@ -1,6 +1,9 @@
 package cache
 
 import (
+	"fmt"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -103,7 +106,9 @@ func TestCacheMemoryAccuracy(t *testing.T) {
 		}
 
 		// Clear cache
-		cache.Clear()
+		if cleared := cache.Clear(); cleared != 2 {
+			t.Errorf("Expected 2 entries cleared, got %d", cleared)
+		}
 		if cache.MemoryUsage() != 0 {
 			t.Errorf("Expected memory usage to be 0 after clear, got %d", cache.MemoryUsage())
 		}
@@ -128,6 +133,25 @@ func TestCacheMemoryAccuracy(t *testing.T) {
 		
 		t.Logf("Consistent memory calculation: %d bytes", expectedSize)
 	})
+
+	t.Run("overwrite_keeps_usage", func(t *testing.T) {
+		cache.Clear()
+		key := "overwrite.example.com:A"
+		msg := createSimpleARecord()
+
+		cache.Set(key, msg)
+		usage := cache.MemoryUsage()
+		for i := 0; i < 3; i++ {
+			cache.Set(key, msg)
+		}
+
+		if cache.MemoryUsage() != usage {
+			t.Errorf("Expected overwriting an entry to keep memory usage at %d, got %d", usage, cache.MemoryUsage())
+		}
+		if cache.Size() != 1 {
+			t.Errorf("Expected 1 entry after overwrites, got %d", cache.Size())
+		}
+	})
 }
 
 func TestDNSMessageSizeCalculation(t *testing.T) {
@@ -459,6 +483,209 @@ func TestZoneCacheEviction(t *testing.T) {
 	}
 }
 
+func TestZoneCacheEvictionLRU(t *testing.T) {
+	cache := NewZoneCacheWithName(2, 5*time.Minute, "test-zone")
+	defer cache.Stop()
+
+	answer := func(ttl uint32) *dns.Msg {
+		msg := new(dns.Msg)
+		msg.SetQuestion("app.example.com.", dns.TypeA)
+		msg.Response = true
+		msg.Answer = append(msg.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: "app.example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+			A:   []byte{10, 0, 0, 1},
+		})
+		return msg
+	}
+
+	// A long-TTL entry that is never read again loses to a fresh short-TTL one
+	cache.Set("idle", answer(300))
+	time.Sleep(time.Millisecond)
+	cache.Set("fresh", answer(10))
+	time.Sleep(time.Millisecond)
+	cache.Set("third", answer(300))
+
+	if _, found := cache.Get("idle"); found {
+		t.Error("Expected the least recently used entry to be evicted, not the earliest expiring")
+	}
+	if _, found := cache.Get("fresh"); !found {
+		t.Error("Expected the short-TTL entry to be kept")
+	}
+
+	// Reading an entry keeps it: "fresh" was just read, so "third" goes next
+	time.Sleep(time.Millisecond)
+	cache.Get("fresh")
+	time.Sleep(time.Millisecond)
+	cache.Set("fourth", answer(300))
+
+	if _, found := cache.Get("third"); found {
+		t.Error("Expected the entry not read since it was stored to be evicted")
+	}
+	if _, found := cache.Get("fresh"); !found {
+		t.Error("Expected the recently read entry to be kept")
+	}
+
+	// Peeking leaves the order alone: "fourth" is still least recently used
+	if _, found := cache.Peek("fourth"); !found {
+		t.Error("Expected Peek to find the stored entry")
+	}
+	cache.Set("fifth", answer(300))
+	if _, found := cache.Peek("fourth"); found {
+		t.Error("Expected the peeked entry to be evicted as least recently used")
+	}
+}
+
+func TestZoneCacheMaxBytes(t *testing.T) {
+	cache := NewZoneCacheWithName(1000, 5*time.Minute, "test-zone")
+	defer cache.Stop()
+
+	// txt returns a response whose TXT record holds size bytes
+	txt := func(name string, size int) *dns.Msg {
+		msg := new(dns.Msg)
+		msg.SetQuestion(name, dns.TypeTXT)
+		msg.Response = true
+		msg.Answer = append(msg.Answer, &dns.TXT{
+			Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 300},
+			Txt: []string{strings.Repeat("x", size)},
+		})
+		return msg
+	}
+
+	entrySize := cache.calculateEntrySize("large-0", txt("large-0.example.com.", 4096))
+	maxBytes := 3*entrySize + entrySize/2
+	cache.SetMaxBytes(maxBytes)
+
+	for i := range 10 {
+		key := fmt.Sprintf("large-%d", i)
+		cache.Set(key, txt(key+".example.com.", 4096))
+		if usage := cache.MemoryUsage(); usage > maxBytes {
+			t.Fatalf("Expected memory usage within %d bytes after %d entries, got %d", maxBytes, i+1, usage)
+		}
+	}
+	if size := cache.Size(); size != 3 {
+		t.Errorf("Expected 3 entries to fit the byte limit, got %d", size)
+	}
+
+	// The least recently used entries were evicted to make room
+	for i := range 10 {
+		_, found := cache.Peek(fmt.Sprintf("large-%d", i))
+		if want := i >= 7; found != want {
+			t.Errorf("Expected entry %d cached=%v, got %v", i, want, found)
+		}
+	}
+
+	// Replacing an entry counts only its new size
+	cache.Set("large-9", txt("large-9.example.com.", 4096))
+	if size := cache.Size(); size != 3 {
+		t.Errorf("Expected replacing an entry to evict nothing, got %d entries", size)
+	}
+
+	// An entry larger than the whole budget is not cached, and evicts nothing
+	cache.Set("huge", txt("huge.example.com.", 16384))
+	if _, found := cache.Peek("huge"); found {
+		t.Error("Expected an entry over the byte limit not to be cached")
+	}
+	if size := cache.Size(); size != 3 {
+		t.Errorf("Expected the oversized entry to evict nothing, got %d entries", size)
+	}
+
+	// Accounting stays exact through evictions
+	var total int64
+	infos, _ := cache.Entries(0)
+	for _, info := range infos {
+		total += info.Size
+	}
+	if usage := cache.MemoryUsage(); usage != total {
+		t.Errorf("Expected memory usage %d to match the entries' sizes, got %d", total, usage)
+	}
+}
+
+func TestZoneCacheConcurrentLRU(t *testing.T) {
+	cache := NewZoneCacheWithName(8, 5*time.Minute, "test-zone")
+	defer cache.Stop()
+
+	// Hits share the read lock while inserts evict, so run both at once
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				key := fmt.Sprintf("key%d", (g*7+i)%16)
+				if _, found := cache.Get(key); !found {
+					cache.Set(key, createSimpleARecord())
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if size := cache.Size(); size > 8 {
+		t.Errorf("Expected at most 8 entries, got %d", size)
+	}
+	var total int64
+	entries, _ := cache.Entries(0)
+	for _, entry := range entries {
+		total += entry.Size
+	}
+	if total != cache.MemoryUsage() {
+		t.Errorf("Expected memory usage %d to match the entries left, got %d", total, cache.MemoryUsage())
+	}
+}
+
+func TestZoneCacheCNAMEChainTTL(t *testing.T) {
+	cache := NewZoneCache(10, 5*time.Minute)
+	defer cache.Stop()
+
+	msg := new(dns.Msg)
+	msg.SetQuestion("www.example.com.", dns.TypeA)
+	msg.Response = true
+	msg.Answer = []dns.RR{
+		&dns.CNAME{
+			Hdr:    dns.RR_Header{Name: "www.example.com.", Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 300},
+			Target: "edge.example.net.",
+		},
+		&dns.CNAME{
+			Hdr:    dns.RR_Header{Name: "edge.example.net.", Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 120},
+			Target: "pop1.example.net.",
+		},
+		&dns.A{
+			Hdr: dns.RR_Header{Name: "pop1.example.net.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   []byte{192, 0, 2, 1},
+		},
+	}
+
+	key := "www.example.com.:A"
+	cache.Set(key, msg)
+
+	// Expiry follows the shortest TTL in the chain, not the zone TTL
+	entry := cache.entries[key]
+	lifetime := entry.ExpiresAt.Sub(entry.StoredAt)
+	if lifetime != 60*time.Second {
+		t.Errorf("Expected entry lifetime 60s (minimum chain TTL), got %v", lifetime)
+	}
+
+	// Simulate 30s in cache: every record in the chain must be aged
+	entry.StoredAt = entry.StoredAt.Add(-30 * time.Second)
+
+	result, found := cache.Get(key)
+	if !found {
+		t.Fatal("Expected cache hit")
+	}
+
+	want := []uint32{270, 90, 30}
+	for i, rr := range result.Answer {
+		if rr.Header().Ttl != want[i] {
+			t.Errorf("Answer[%d] TTL = %d, want %d", i, rr.Header().Ttl, want[i])
+		}
+	}
+
+	// The stored entry itself must stay untouched
+	if ttl := cache.entries[key].Response.Answer[2].Header().Ttl; ttl != 60 {
+		t.Errorf("Stored entry TTL modified: got %d, want 60", ttl)
+	}
+}
+
 func TestZoneCacheBackgroundCleanup(t *testing.T) {
 	cache := NewZoneCacheWithName(10, 50*time.Millisecond, "test-zone")
 	defer cache.Stop()
@@ -554,4 +781,239 @@ func BenchmarkDNSMessageSizeCalculation(b *testing.B) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+func TestZoneCacheGetStale(t *testing.T) {
+	cache := NewZoneCache(10, 5*time.Minute)
+	defer cache.Stop()
+
+	msg := createSimpleARecord()
+	key := "test.com.:A"
+	cache.Set(key, msg)
+
+	// Fresh entries are not stale
+	if _, found := cache.GetStale(key); found {
+		t.Error("Expected no stale answer for a fresh entry")
+	}
+
+	// Simulate expiry 10 minutes ago
+	entry := cache.entries[key]
+	entry.ExpiresAt = time.Now().Add(-10 * time.Minute)
+
+	if _, found := cache.Get(key); found {
+		t.Error("Expected cache miss for expired entry")
+	}
+	if _, found := cache.GetStale(key); found {
+		t.Error("Expected no stale answer without stale retention")
+	}
+
+	cache.SetStaleRetention(time.Hour)
+	result, found := cache.GetStale(key)
+	if !found {
+		t.Fatal("Expected stale answer within retention")
+	}
+	if ttl := result.Answer[0].Header().Ttl; ttl != staleAnswerTTL {
+		t.Errorf("Expected stale TTL %d, got %d", staleAnswerTTL, ttl)
+	}
+
+	// Cleanup keeps entries inside the retention window...
+	cache.cleanupExpired()
+	if cache.Size() != 1 {
+		t.Errorf("Expected stale entry to survive cleanup, size %d", cache.Size())
+	}
+
+	// ...and removes them once it has passed
+	cache.SetStaleRetention(5 * time.Minute)
+	cache.cleanupExpired()
+	if cache.Size() != 0 {
+		t.Errorf("Expected entry past retention to be removed, size %d", cache.Size())
+	}
+}
+
+func TestZoneCacheNegativeTTL(t *testing.T) {
+	cache := NewZoneCache(10, time.Hour)
+	defer cache.Stop()
+	cache.SetNegativeTTL(100 * time.Millisecond)
+
+	nxdomain := new(dns.Msg)
+	nxdomain.SetQuestion("missing.test.com.", dns.TypeA)
+	nxdomain.Rcode = dns.RcodeNameError
+	cache.Set("missing.test.com.:A", nxdomain)
+
+	// The SOA's MINIMUM wins over its higher record TTL
+	nodata := new(dns.Msg)
+	nodata.SetQuestion("test.com.", dns.TypeAAAA)
+	nodata.Ns = append(nodata.Ns, &dns.SOA{
+		Hdr:    dns.RR_Header{Name: "test.com.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 3600},
+		Ns:     "ns.test.com.",
+		Mbox:   "admin.test.com.",
+		Minttl: 1,
+	})
+	cache.Set("test.com.:AAAA", nodata)
+
+	cache.Set("test.com.:A", createSimpleARecord())
+
+	if _, found := cache.Get("missing.test.com.:A"); !found {
+		t.Error("Expected NXDOMAIN to be cached")
+	}
+	if _, found := cache.Get("test.com.:AAAA"); !found {
+		t.Error("Expected NODATA to be cached")
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if _, found := cache.Get("missing.test.com.:A"); found {
+		t.Error("Expected NXDOMAIN to expire after the negative TTL")
+	}
+	if _, found := cache.Get("test.com.:AAAA"); !found {
+		t.Error("Expected NODATA to be kept for the SOA minimum")
+	}
+	if _, found := cache.Get("test.com.:A"); !found {
+		t.Error("Expected positive answer to be kept for its own TTL")
+	}
+
+	time.Sleep(time.Second)
+	if _, found := cache.Get("test.com.:AAAA"); found {
+		t.Error("Expected NODATA to expire after the SOA minimum")
+	}
+
+	// Server failures are never cached, so a transient outage isn't remembered
+	servfail := new(dns.Msg)
+	servfail.SetQuestion("down.test.com.", dns.TypeA)
+	servfail.Rcode = dns.RcodeServerFailure
+	cache.Set("down.test.com.:A", servfail)
+	if _, found := cache.Get("down.test.com.:A"); found {
+		t.Error("Expected SERVFAIL not to be cached")
+	}
+}
+
+func TestZoneCacheUpstreamTTL(t *testing.T) {
+	cache := NewZoneCache(10, 300*time.Second)
+	defer cache.Stop()
+
+	newAnswer := func(name string, ttl uint32) *dns.Msg {
+		msg := new(dns.Msg)
+		msg.SetQuestion(name, dns.TypeA)
+		msg.Answer = append(msg.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+			A:   []byte{192, 0, 2, 1},
+		})
+		return msg
+	}
+	lifetime := func(key string) time.Duration {
+		entry := cache.entries[key]
+		return entry.ExpiresAt.Sub(entry.StoredAt)
+	}
+
+	cache.Set("short.example.com.:A", newAnswer("short.example.com.", 10))
+	if got := lifetime("short.example.com.:A"); got != 10*time.Second {
+		t.Errorf("Expected 10s record to expire after 10s with a 300s zone TTL, got %v", got)
+	}
+
+	cache.Set("long.example.com.:A", newAnswer("long.example.com.", 3600))
+	if got := lifetime("long.example.com.:A"); got != 300*time.Second {
+		t.Errorf("Expected zone TTL to cap a 3600s record at 300s, got %v", got)
+	}
+
+	cache.SetMinTTL(30 * time.Second)
+	cache.Set("short.example.com.:A", newAnswer("short.example.com.", 10))
+	if got := lifetime("short.example.com.:A"); got != 30*time.Second {
+		t.Errorf("Expected minTTL to raise a 10s record to 30s, got %v", got)
+	}
+
+	// Synthesized answers keep their own TTLs
+	cache.SetSynthesized("via6.example.com.:AAAA", newAnswer("via6.example.com.", 10))
+	if got := lifetime("via6.example.com.:AAAA"); got != 10*time.Second {
+		t.Errorf("Expected synthesized answer to expire after 10s, got %v", got)
+	}
+
+	// ...unless the data they were built from expires sooner
+	cache.SetSynthesizedFor("via6.example.com.:AAAA", newAnswer("via6.example.com.", 300), 20*time.Second)
+	if got := lifetime("via6.example.com.:AAAA"); got != 20*time.Second {
+		t.Errorf("Expected synthesized answer to expire with its 20s source, got %v", got)
+	}
+	cache.SetSynthesizedFor("via6.example.com.:AAAA", newAnswer("via6.example.com.", 10), time.Hour)
+	if got := lifetime("via6.example.com.:AAAA"); got != 10*time.Second {
+		t.Errorf("Expected a longer-lived source not to extend a 10s answer, got %v", got)
+	}
+
+	// The zone TTL caps the minimum too
+	cache.SetMinTTL(time.Hour)
+	cache.Set("short.example.com.:A", newAnswer("short.example.com.", 10))
+	if got := lifetime("short.example.com.:A"); got != 300*time.Second {
+		t.Errorf("Expected zone TTL to cap minTTL at 300s, got %v", got)
+	}
+}
+
+func TestZoneCacheAuthoritative(t *testing.T) {
+	cache := NewZoneCache(10, 5*time.Minute)
+	defer cache.Stop()
+	cache.SetStaleRetention(time.Hour)
+
+	msg := createSimpleARecord()
+	msg.Authoritative = true
+	cache.Set("test.com.:A", msg)
+	cache.SetSynthesized("via6.test.com.:AAAA", msg)
+
+	// Backend answers lose AA once cached; the stored copy keeps it
+	if result, _ := cache.Get("test.com.:A"); result.Authoritative {
+		t.Error("Expected AA cleared on a cached backend answer")
+	}
+	if !cache.entries["test.com.:A"].Response.Authoritative {
+		t.Error("Expected the cached entry to keep the backend's AA bit")
+	}
+	cache.entries["test.com.:A"].ExpiresAt = time.Now().Add(-time.Minute)
+	if result, _ := cache.GetStale("test.com.:A"); result.Authoritative {
+		t.Error("Expected AA cleared on a stale backend answer")
+	}
+
+	// Synthesized answers are this server's own
+	if result, _ := cache.Get("via6.test.com.:AAAA"); !result.Authoritative {
+		t.Error("Expected AA kept on a synthesized answer")
+	}
+
+	cache.SetPreserveAA(true)
+	if result, _ := cache.GetStale("test.com.:A"); !result.Authoritative {
+		t.Error("Expected AA kept when preserving the backend's AA bit")
+	}
+}
+
+func TestZoneCacheEntries(t *testing.T) {
+	cache := NewZoneCache(100, 300*time.Second)
+	defer cache.Stop()
+
+	for i, name := range []string{"c.example.com.", "a.example.com.", "b.example.com."} {
+		msg := new(dns.Msg)
+		msg.SetQuestion(name, dns.TypeA)
+		msg.Answer = append(msg.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: uint32(60 * (i + 1))},
+			A:   []byte{10, 0, 0, byte(i)},
+		})
+		cache.Set(name+":A", msg)
+	}
+
+	entries, total := cache.Entries(0)
+	if total != 3 || len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %d of %d", len(entries), total)
+	}
+	if entries[0].Key != "a.example.com.:A" || entries[2].Key != "c.example.com.:A" {
+		t.Errorf("Expected entries ordered by key, got %v", entries)
+	}
+	var size int64
+	for _, entry := range entries {
+		size += entry.Size
+	}
+	if size != cache.MemoryUsage() {
+		t.Errorf("Expected entry sizes to add up to %d, got %d", cache.MemoryUsage(), size)
+	}
+	// a was stored with a 120s record, c with 60s
+	if got := entries[0].ExpiresAt.Sub(entries[0].StoredAt); got != 120*time.Second {
+		t.Errorf("Expected a.example.com to expire after 120s, got %v", got)
+	}
+	if got := entries[2].ExpiresAt.Sub(entries[2].StoredAt); got != 60*time.Second {
+		t.Errorf("Expected c.example.com to expire after 60s, got %v", got)
+	}
+
+	entries, total = cache.Entries(2)
+	if total != 3 || len(entries) != 2 || entries[1].Key != "b.example.com.:A" {
+		t.Errorf("Expected the first 2 of 3 entries, got %v of %d", entries, total)
+	}
+}
@@ -3,7 +3,10 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"net"
+	"net/netip"
 	"os"
+	"strings"
 
 	"github.com/tailscale/hujson"
 )
@@ -16,8 +19,23 @@ type Config struct {
 // ServerConfig removed - moved to environment variables and flags
 
 type GlobalConfig struct {
-	Backend BackendConfig `json:"backend"`
-	Cache   CacheConfig   `json:"cache"`
+	Backend           BackendConfig     `json:"backend"`
+	Cache             CacheConfig       `json:"cache"`
+	SpecialUseDomains map[string]string `json:"specialUseDomains,omitempty"` // RFC 6761 domain -> policy
+	GeoIP             *GeoIPConfig      `json:"geoip,omitempty"`             // Optional client location lookup
+	Compress          *bool             `json:"compress,omitempty"`          // Default DNS name compression for zones (default true)
+	CircuitBreaker    *CircuitBreaker   `json:"circuitBreaker,omitempty"`    // Skip backends after repeated failures (on by default)
+	Warmup            []string          `json:"warmup,omitempty"`            // Names resolved at startup to fill the zone caches
+}
+
+type CircuitBreaker struct {
+	Disabled         bool   `json:"disabled,omitempty"` // Query every backend regardless of failures
+	FailureThreshold int    `json:"failureThreshold"`   // Consecutive failures before a backend's circuit opens
+	Cooldown         string `json:"cooldown"`           // How long an open circuit skips the backend
+}
+
+type GeoIPConfig struct {
+	Database string `json:"database"` // Path to a MaxMind (.mmdb) City, Country or ASN database
 }
 
 type Zone struct {
@@ -27,20 +45,123 @@ type Zone struct {
 	TranslateID          *uint16       `json:"translateid,omitempty"`     // Optional 4via6
 	PrefixSubnet         string        `json:"prefixSubnet,omitempty"`    // Optional 4via6
 	Cache                *CacheConfig  `json:"cache,omitempty"`
+	Priority             int           `json:"priority,omitempty"`             // Higher priority zones win matches regardless of pattern length
 	AllowExternalClients bool          `json:"allowExternalClients,omitempty"` // Allow non-Tailscale clients
+	CachePerClient       bool          `json:"cachePerClient,omitempty"`       // Segment cache entries by client IP
+	SynthesizedEDE       bool          `json:"synthesizedEDE,omitempty"`       // Mark 4via6 answers to DO clients as synthesized (RFC 8914)
+	Compress             *bool         `json:"compress,omitempty"`             // DNS name compression (inherits global)
+	ServeStale           *ServeStale   `json:"serveStale,omitempty"`           // Answer from expired cache entries (RFC 8767)
+	HealthCheck          *HealthCheck  `json:"healthCheck,omitempty"`          // Actively probe zone backends
+	BackendStrategy      string        `json:"backendStrategy,omitempty"`      // Query backends "sequential"ly or all in "parallel"
+	PassthroughAAAA      bool          `json:"passthroughAAAA,omitempty"`      // Also answer the reflected name's real AAAA on 4via6 zones
+	ReflectAAAA          bool          `json:"reflectAAAA,omitempty"`          // Answer AAAA with the reflected name's real AAAA instead of 4via6
+	Via6Order            string        `json:"via6Order,omitempty"`            // Place 4via6 AAAA "first" or "last" among real AAAA
+	ReflectMode          string        `json:"reflectMode,omitempty"`          // Answer 4via6 zone AAAA queries with "via6" addresses or the reflected name's "direct" AAAA
+	InheritUpstreamTTL   bool          `json:"inheritUpstreamTTL,omitempty"`   // 4via6 AAAA TTL follows the reflected A record, capped at the default TTL
+	ExpectedCIDRs        []string      `json:"expectedCIDRs,omitempty"`        // Prefer backend answers for the reflected domain within these networks
+	AllowedClients       []string      `json:"allowedClients,omitempty"`       // Only clients within these CIDRs may query the zone
+	DeniedClients        []string      `json:"deniedClients,omitempty"`        // Clients within these CIDRs are refused, whatever else matches
+	MagicDNSName         string        `json:"magicDNSName,omitempty"`         // CNAME 4via6 answers to the matching name under this MagicDNS domain
+	CNAMEOnly            bool          `json:"cnameOnly,omitempty"`            // Answer with the MagicDNS CNAME alone, without the 4via6 records
+	RequireTCP           bool          `json:"requireTCP,omitempty"`           // Answer UDP queries with TC=1 so clients retry over TCP
+	SOA                  *SOAConfig    `json:"soa,omitempty"`                  // Fields of the SOA synthesized at the zone apex
+	Nameservers          []string      `json:"nameservers,omitempty"`          // NS targets of the zone apex (default this node's MagicDNS name)
+	AuthorityNS          bool          `json:"authorityNS,omitempty"`          // Also carry the apex NS records in the authority section of 4via6 answers
+	TTL                  *uint32       `json:"ttl,omitempty"`                  // TTL of the zone's synthesized answers (default TSDNS_DEFAULT_TTL)
+	FixedTTL             *uint32       `json:"fixedTTL,omitempty"`             // Rewrite every answer record TTL to this value
+	RotateAnswers        bool          `json:"rotateAnswers,omitempty"`        // Rotate the answered records by one position per query, spreading clients across addresses
+	CacheOnlyResponse    string        `json:"cacheOnlyResponse,omitempty"`    // Answer to cache misses in cache-only mode (default servfail)
+	Warmup               []string      `json:"warmup,omitempty"`               // Names in this zone resolved at startup to fill its cache
+	ResponseTimeFloor    string        `json:"responseTimeFloor,omitempty"`    // Delay faster responses to this latency so cache hits look like backend lookups
+	MaxCNAMEDepth        int           `json:"maxCnameDepth,omitempty"`        // Most CNAMEs followed resolving the reflected domain (default 8)
+	ReresolveInterval    string        `json:"reresolveInterval,omitempty"`    // Re-resolve reflected names in the background this often, so 4via6 queries never wait on backends
+
+	// Backends selected by client GeoIP region: "AS<number>", ISO country code or continent code
+	RegionBackends map[string]BackendConfig `json:"regionBackends,omitempty"`
+
+	// Backends selected by the tailnet client's ACL tag, e.g. "tag:prod"
+	BackendByTag map[string]BackendConfig `json:"backendByTag,omitempty"`
+
+	allowedPrefixes []netip.Prefix // AllowedClients, parsed by ValidateZones
+	deniedPrefixes  []netip.Prefix // DeniedClients, parsed by ValidateZones
 }
 
 type BackendConfig struct {
-	DNSServers []string `json:"dnsServers"`
-	Timeout    string   `json:"timeout"`
-	Retries    int      `json:"retries"`
+	DNSServers    []string `json:"dnsServers"`
+	Timeout       string   `json:"timeout"`
+	Retries       int      `json:"retries"`
+	SourceAddress string   `json:"sourceAddress,omitempty"` // Local IP to send forwarded queries from
+	TLSServerName string   `json:"tlsServerName,omitempty"` // Certificate name of tls:// servers (default their host)
+}
+
+// SOAConfig sets the fields of a zone's synthesized SOA record; unset fields
+// keep their defaults
+type SOAConfig struct {
+	MName   string `json:"mname,omitempty"`   // Primary nameserver (default ns.<apex>)
+	RName   string `json:"rname,omitempty"`   // Responsible mailbox as a name (default hostmaster.<apex>)
+	Serial  uint32 `json:"serial,omitempty"`  // Zone serial (default 1)
+	Refresh uint32 `json:"refresh,omitempty"` // Seconds, default 3600
+	Retry   uint32 `json:"retry,omitempty"`   // Seconds, default 600
+	Expire  uint32 `json:"expire,omitempty"`  // Seconds, default 86400
+	Minimum uint32 `json:"minimum,omitempty"` // Negative answer TTL in seconds (default the zone's negative cache TTL)
+}
+
+type ServeStale struct {
+	OnCircuitOpen bool   `json:"onCircuitOpen"`      // Serve stale entries while every backend's circuit is open
+	OnFailure     bool   `json:"onFailure"`          // Serve stale entries when every backend fails
+	MaxStale      string `json:"maxStale,omitempty"` // How long expired entries are kept for stale answers
+}
+
+// 4via6 answer ordering in mixed AAAA responses
+const (
+	Via6OrderFirst = "first" // 4via6 AAAA before real AAAA, so clients prefer the tailnet path
+	Via6OrderLast  = "last"  // Real AAAA before 4via6 AAAA
+)
+
+// How 4via6 zones answer AAAA queries
+const (
+	ReflectModeVia6   = "via6"   // 4via6 addresses translated from the reflected name's A records
+	ReflectModeDirect = "direct" // The reflected name's own AAAA records, for IPv6-only reflected domains
+)
+
+// Backend query strategies
+const (
+	BackendStrategySequential = "sequential" // Try backends one after another
+	BackendStrategyParallel   = "parallel"   // Query every backend at once, the first answer wins
+)
+
+type HealthCheck struct {
+	Name     string `json:"name,omitempty"`     // Query name (default reflectedDomain, or ".")
+	Type     string `json:"type,omitempty"`     // Query type (default SOA)
+	Interval string `json:"interval,omitempty"` // Time between probes (default 30s)
+
+	// Healthy backends needed before the server is ready (default the runtime minimum)
+	MinHealthy int `json:"minHealthy,omitempty"`
 }
 
 type CacheConfig struct {
-	MaxSize int    `json:"maxSize"`
-	TTL     string `json:"ttl"`
+	MaxSize     int    `json:"maxSize"`
+	TTL         string `json:"ttl"`
+	OnExpiry    string `json:"onExpiry,omitempty"`    // What happens to expired entries (default evict)
+	NegativeTTL string `json:"negativeTTL,omitempty"` // How long NXDOMAIN/NODATA answers without an SOA are cached (default 60s)
+	MinTTL      string `json:"minTTL,omitempty"`      // Shortest time an answer is cached, whatever its upstream TTL (default 0)
+	PreserveAA  *bool  `json:"preserveAA,omitempty"`  // Keep the backend's AA bit on cached answers (default false: cleared)
 }
 
+// Cache on-expiry policies
+const (
+	CacheOnExpiryEvict           = "evict"                     // Drop expired entries; the next query waits for a backend
+	CacheOnExpiryRefreshSync     = "refresh-sync"              // Wait for a backend, answering stale if every backend fails
+	CacheOnExpiryServeStaleAsync = "serve-stale-refresh-async" // Answer stale at once and refresh in the background
+)
+
+// Answers to cache misses in cache-only mode
+const (
+	CacheOnlyServFail = "servfail" // SERVFAIL with an Extended DNS Error
+	CacheOnlyRefused  = "refused"  // REFUSED, so clients move on to another server
+	CacheOnlyStale    = "stale"    // Expired entries if still retained, otherwise SERVFAIL
+)
+
 // TailscaleConfig and OAuthConfig removed - moved to environment variables
 
 
@@ -94,6 +215,31 @@ func (c *Config) setDefaults() error {
 		c.Global.Cache.TTL = "300s"
 	}
 
+	// Dead backends are skipped unless explicitly disabled
+	if c.Global.CircuitBreaker == nil {
+		c.Global.CircuitBreaker = &CircuitBreaker{}
+	}
+	if !c.Global.CircuitBreaker.Disabled {
+		if c.Global.CircuitBreaker.FailureThreshold == 0 {
+			c.Global.CircuitBreaker.FailureThreshold = 5
+		}
+		if c.Global.CircuitBreaker.Cooldown == "" {
+			c.Global.CircuitBreaker.Cooldown = "30s"
+		}
+	}
+
+	if c.Global.Compress == nil {
+		compress := true
+		c.Global.Compress = &compress
+	}
+
+	// Operator entries override the RFC 6761 defaults per domain
+	specialUse := DefaultSpecialUseDomains()
+	for domain, policy := range c.Global.SpecialUseDomains {
+		specialUse[strings.ToLower(strings.Trim(domain, "."))] = strings.ToLower(policy)
+	}
+	c.Global.SpecialUseDomains = specialUse
+
 	// Apply defaults to zones
 	for zoneName, zone := range c.Zones {
 		if err := c.setZoneDefaults(zoneName, zone); err != nil {
@@ -113,9 +259,13 @@ func (c *Config) setZoneDefaults(zoneName string, zone *Zone) error {
 		return fmt.Errorf("zone %s must have at least one domain", zoneName)
 	}
 
-	// Inherit global backend settings if not specified
+	// Inherit global backend settings if not specified. The certificate name
+	// belongs to the global servers, so it only comes along with them.
 	if len(zone.Backend.DNSServers) == 0 {
 		zone.Backend.DNSServers = c.Global.Backend.DNSServers
+		if zone.Backend.TLSServerName == "" {
+			zone.Backend.TLSServerName = c.Global.Backend.TLSServerName
+		}
 	}
 	if zone.Backend.Timeout == "" {
 		zone.Backend.Timeout = c.Global.Backend.Timeout
@@ -123,6 +273,81 @@ func (c *Config) setZoneDefaults(zoneName string, zone *Zone) error {
 	if zone.Backend.Retries == 0 {
 		zone.Backend.Retries = c.Global.Backend.Retries
 	}
+	if zone.Backend.SourceAddress == "" {
+		zone.Backend.SourceAddress = c.Global.Backend.SourceAddress
+	}
+
+	if zone.Compress == nil {
+		zone.Compress = c.Global.Compress
+	}
+
+	if hc := zone.HealthCheck; hc != nil {
+		if hc.Name == "" {
+			hc.Name = "."
+			if zone.ReflectedDomain != "" && net.ParseIP(zone.ReflectedDomain) == nil {
+				hc.Name = zone.ReflectedDomain
+			}
+		}
+		if hc.Type == "" {
+			hc.Type = "SOA"
+		}
+		if hc.Interval == "" {
+			hc.Interval = "30s"
+		}
+	}
+
+	if zone.Via6Order == "" {
+		zone.Via6Order = Via6OrderFirst
+	}
+	if zone.ReflectMode == "" && zone.Has4via6() {
+		zone.ReflectMode = ReflectModeVia6
+	}
+
+	if zone.BackendStrategy == "" {
+		zone.BackendStrategy = BackendStrategySequential
+	}
+
+	if zone.ServeStale != nil && zone.ServeStale.MaxStale == "" {
+		zone.ServeStale.MaxStale = "1h"
+	}
+
+	// Region backends inherit connection settings from the zone backend
+	if len(zone.RegionBackends) > 0 {
+		regionBackends := make(map[string]BackendConfig, len(zone.RegionBackends))
+		for region, backend := range zone.RegionBackends {
+			if backend.Timeout == "" {
+				backend.Timeout = zone.Backend.Timeout
+			}
+			if backend.Retries == 0 {
+				backend.Retries = zone.Backend.Retries
+			}
+			if backend.SourceAddress == "" {
+				backend.SourceAddress = zone.Backend.SourceAddress
+			}
+			if backend.TLSServerName == "" {
+				backend.TLSServerName = zone.Backend.TLSServerName
+			}
+			regionBackends[strings.ToUpper(region)] = backend
+		}
+		zone.RegionBackends = regionBackends
+	}
+
+	// Tag backends inherit connection settings the same way
+	for tag, backend := range zone.BackendByTag {
+		if backend.Timeout == "" {
+			backend.Timeout = zone.Backend.Timeout
+		}
+		if backend.Retries == 0 {
+			backend.Retries = zone.Backend.Retries
+		}
+		if backend.SourceAddress == "" {
+			backend.SourceAddress = zone.Backend.SourceAddress
+		}
+		if backend.TLSServerName == "" {
+			backend.TLSServerName = zone.Backend.TLSServerName
+		}
+		zone.BackendByTag[tag] = backend
+	}
 
 	// Set defaults for unified fields
 	if zone.TranslateID != nil {
@@ -140,6 +365,18 @@ func (c *Config) setZoneDefaults(zoneName string, zone *Zone) error {
 			TTL:     c.Global.Cache.TTL,
 		}
 	}
+	if zone.Cache != nil && zone.Cache.OnExpiry == "" {
+		zone.Cache.OnExpiry = c.Global.Cache.OnExpiry
+	}
+	if zone.Cache != nil && zone.Cache.NegativeTTL == "" {
+		zone.Cache.NegativeTTL = c.Global.Cache.NegativeTTL
+	}
+	if zone.Cache != nil && zone.Cache.MinTTL == "" {
+		zone.Cache.MinTTL = c.Global.Cache.MinTTL
+	}
+	if zone.Cache != nil && zone.Cache.PreserveAA == nil {
+		zone.Cache.PreserveAA = c.Global.Cache.PreserveAA
+	}
 
 	return nil
 }
@@ -1,8 +1,10 @@
 package config
 
 import (
+	"net/netip"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -210,6 +212,22 @@ func TestSetDefaults(t *testing.T) {
 	if cfg.Global.Cache.TTL != "300s" {
 		t.Errorf("Expected default cache TTL '300s', got '%s'", cfg.Global.Cache.TTL)
 	}
+	if cb := cfg.Global.CircuitBreaker; cb == nil || cb.Disabled || cb.FailureThreshold != 5 || cb.Cooldown != "30s" {
+		t.Errorf("Expected circuit breaker on by default with threshold 5 and cooldown 30s, got %+v", cb)
+	}
+
+	disabled := &Config{
+		Global: GlobalConfig{CircuitBreaker: &CircuitBreaker{Disabled: true}},
+		Zones: map[string]*Zone{
+			"test": {Domains: []string{"*.test.local"}, Backend: BackendConfig{DNSServers: []string{"10.0.0.1:53"}}},
+		},
+	}
+	if err := disabled.setDefaults(); err != nil {
+		t.Fatalf("setDefaults failed: %v", err)
+	}
+	if err := disabled.ValidateZones(); err != nil {
+		t.Errorf("Expected disabled circuit breaker to need no settings, got %v", err)
+	}
 }
 
 // Environment variable tests removed - now handled by RuntimeConfig
@@ -349,3 +367,965 @@ func TestZoneConfiguration(t *testing.T) {
 }
 
 // OAuth and Tailscale configuration tests removed - now handled by RuntimeConfig
+
+func TestSpecialUseDomains(t *testing.T) {
+	cfg := &Config{
+		Global: GlobalConfig{
+			SpecialUseDomains: map[string]string{
+				"test.":        "forward",
+				"corp.invalid": "loopback",
+			},
+		},
+	}
+	if err := cfg.setDefaults(); err != nil {
+		t.Fatalf("setDefaults failed: %v", err)
+	}
+
+	tests := []struct {
+		domain string
+		want   string
+	}{
+		{"foo.invalid.", SpecialUseNXDomain},
+		{"INVALID.", SpecialUseNXDomain},
+		{"localhost.", SpecialUseLoopback},
+		{"app.localhost", SpecialUseLoopback},
+		{"app.test.", SpecialUseForward},           // Overridden by operator
+		{"host.corp.invalid.", SpecialUseLoopback}, // Most specific entry wins
+		{"www.example.com.", SpecialUseForward},
+		{"notinvalid.", SpecialUseForward},
+	}
+
+	for _, tt := range tests {
+		if got := cfg.SpecialUsePolicy(tt.domain); got != tt.want {
+			t.Errorf("SpecialUsePolicy(%q) = %q, want %q", tt.domain, got, tt.want)
+		}
+	}
+
+	cfg.Zones = map[string]*Zone{
+		"test": {Domains: []string{"*.test.local"}, Backend: BackendConfig{DNSServers: []string{"10.0.0.1:53"}}},
+	}
+	cfg.Global.SpecialUseDomains["invalid"] = "drop"
+	if err := cfg.ValidateZones(); err == nil {
+		t.Error("Expected error for unknown special-use policy")
+	}
+}
+
+func TestZoneInheritsTLSServerName(t *testing.T) {
+	cfg := &Config{
+		Global: GlobalConfig{
+			Backend: BackendConfig{DNSServers: []string{"tls://10.0.0.1:853"}, TLSServerName: "dns.global.local"},
+		},
+		Zones: map[string]*Zone{
+			"inherited": {Domains: []string{"*.inherited.local"}},
+			"own":       {Domains: []string{"*.own.local"}, Backend: BackendConfig{DNSServers: []string{"tls://10.0.0.2:853"}}},
+		},
+	}
+	if err := cfg.setDefaults(); err != nil {
+		t.Fatalf("setDefaults failed: %v", err)
+	}
+
+	if got := cfg.Zones["inherited"].Backend.TLSServerName; got != "dns.global.local" {
+		t.Errorf("Expected zone inheriting the global servers to inherit their TLS server name, got %q", got)
+	}
+	if got := cfg.Zones["own"].Backend.TLSServerName; got != "" {
+		t.Errorf("Expected zone with its own servers to keep no TLS server name, got %q", got)
+	}
+}
+
+func TestRegionBackends(t *testing.T) {
+	cfg := &Config{
+		Zones: map[string]*Zone{
+			"geo": {
+				Domains: []string{"*.geo.local"},
+				Backend: BackendConfig{DNSServers: []string{"tls://10.0.0.10:853"}, Timeout: "2s", Retries: 2, TLSServerName: "dns.geo.local"},
+				RegionBackends: map[string]BackendConfig{
+					"eu":      {DNSServers: []string{"tls://10.0.0.20:853"}},
+					"AS13335": {DNSServers: []string{"tls://10.0.0.30:853"}, Timeout: "1s", TLSServerName: "dns.as13335.local"},
+				},
+			},
+		},
+	}
+	if err := cfg.setDefaults(); err != nil {
+		t.Fatalf("setDefaults failed: %v", err)
+	}
+	if err := cfg.ValidateZones(); err != nil {
+		t.Fatalf("ValidateZones failed: %v", err)
+	}
+
+	zone := cfg.Zones["geo"]
+	eu, ok := zone.RegionBackends["EU"]
+	if !ok {
+		t.Fatalf("Expected region keys to be upper-cased, got %v", zone.RegionBackends)
+	}
+	if eu.Timeout != "2s" || eu.Retries != 2 {
+		t.Errorf("Expected region backend to inherit zone timeout/retries, got %s/%d", eu.Timeout, eu.Retries)
+	}
+	if eu.TLSServerName != "dns.geo.local" {
+		t.Errorf("Expected region backend to inherit zone TLS server name, got %q", eu.TLSServerName)
+	}
+	if asn := zone.RegionBackends["AS13335"]; asn.Timeout != "1s" || asn.TLSServerName != "dns.as13335.local" {
+		t.Errorf("Expected explicit region timeout/TLS server name to be kept, got %s/%q", asn.Timeout, asn.TLSServerName)
+	}
+
+	zone.RegionBackends["US"] = BackendConfig{}
+	if err := cfg.ValidateZones(); err == nil {
+		t.Error("Expected error for region backend without DNS servers")
+	}
+}
+
+func TestBackendByTag(t *testing.T) {
+	cfg := &Config{
+		Zones: map[string]*Zone{
+			"tagged": {
+				Domains: []string{"*.tagged.local"},
+				Backend: BackendConfig{DNSServers: []string{"tls://10.0.0.10:853"}, Timeout: "2s", Retries: 2, TLSServerName: "dns.tagged.local"},
+				BackendByTag: map[string]BackendConfig{
+					"tag:prod": {DNSServers: []string{"tls://10.0.0.20:853"}},
+				},
+			},
+		},
+	}
+	if err := cfg.setDefaults(); err != nil {
+		t.Fatalf("setDefaults failed: %v", err)
+	}
+	if err := cfg.ValidateZones(); err != nil {
+		t.Fatalf("ValidateZones failed: %v", err)
+	}
+
+	zone := cfg.Zones["tagged"]
+	if prod := zone.BackendByTag["tag:prod"]; prod.Timeout != "2s" || prod.Retries != 2 {
+		t.Errorf("Expected tag backend to inherit zone timeout/retries, got %s/%d", prod.Timeout, prod.Retries)
+	}
+	if prod := zone.BackendByTag["tag:prod"]; prod.TLSServerName != "dns.tagged.local" {
+		t.Errorf("Expected tag backend to inherit zone TLS server name, got %q", prod.TLSServerName)
+	}
+
+	zone.BackendByTag["prod"] = BackendConfig{DNSServers: []string{"10.0.0.30:53"}}
+	if err := cfg.ValidateZones(); err == nil {
+		t.Error("Expected error for backendByTag key without tag: prefix")
+	}
+	delete(zone.BackendByTag, "prod")
+
+	zone.BackendByTag["tag:dev"] = BackendConfig{}
+	if err := cfg.ValidateZones(); err == nil {
+		t.Error("Expected error for tag backend without DNS servers")
+	}
+}
+
+func TestCompressResponses(t *testing.T) {
+	disabled := false
+	cfg := &Config{
+		Zones: map[string]*Zone{
+			"default": {Domains: []string{"*.default.local"}},
+			"legacy":  {Domains: []string{"*.legacy.local"}, Compress: &disabled},
+		},
+	}
+	if err := cfg.setDefaults(); err != nil {
+		t.Fatalf("setDefaults failed: %v", err)
+	}
+
+	if !cfg.CompressResponses(nil) {
+		t.Error("Expected compression on by default")
+	}
+	if !cfg.CompressResponses(cfg.Zones["default"]) {
+		t.Error("Expected zone to inherit global compression")
+	}
+	if cfg.CompressResponses(cfg.Zones["legacy"]) {
+		t.Error("Expected zone override to disable compression")
+	}
+
+	// Zones inherit a global opt-out
+	cfg = &Config{
+		Global: GlobalConfig{Compress: &disabled},
+		Zones:  map[string]*Zone{"default": {Domains: []string{"*.default.local"}}},
+	}
+	if err := cfg.setDefaults(); err != nil {
+		t.Fatalf("setDefaults failed: %v", err)
+	}
+	if cfg.CompressResponses(cfg.Zones["default"]) {
+		t.Error("Expected zone to inherit disabled global compression")
+	}
+}
+
+func TestHealthCheckDefaults(t *testing.T) {
+	cfg := &Config{
+		Zones: map[string]*Zone{
+			"k8s": {
+				Domains:         []string{"*.cluster1.local"},
+				ReflectedDomain: "cluster.local",
+				HealthCheck:     &HealthCheck{},
+			},
+			"ip": {
+				Domains:         []string{"*.ip.local"},
+				ReflectedDomain: "10.0.0.1",
+				HealthCheck:     &HealthCheck{Type: "a", Interval: "10s"},
+			},
+		},
+	}
+	if err := cfg.setDefaults(); err != nil {
+		t.Fatalf("setDefaults failed: %v", err)
+	}
+	if err := cfg.ValidateZones(); err != nil {
+		t.Fatalf("ValidateZones failed: %v", err)
+	}
+
+	hc := cfg.Zones["k8s"].HealthCheck
+	if hc.Name != "cluster.local" || hc.Type != "SOA" || hc.Interval != "30s" {
+		t.Errorf("Unexpected defaults: %+v", hc)
+	}
+	if name := cfg.Zones["ip"].HealthCheck.Name; name != "." {
+		t.Errorf("Expected root probe name for IP reflection, got %q", name)
+	}
+
+	cfg.Zones["ip"].HealthCheck.Type = "BOGUS"
+	if err := cfg.ValidateZones(); err == nil {
+		t.Error("Expected error for unknown health check type")
+	}
+	cfg.Zones["ip"].HealthCheck.Type = "A"
+
+	cfg.Zones["ip"].HealthCheck.MinHealthy = len(cfg.Zones["ip"].Backend.DNSServers) + 1
+	if err := cfg.ValidateZones(); err == nil {
+		t.Error("Expected error for minHealthy above the number of DNS servers")
+	}
+}
+
+func TestVia6Order(t *testing.T) {
+	cfg := &Config{
+		Zones: map[string]*Zone{
+			"k8s": {
+				Domains:         []string{"*.cluster1.local"},
+				Backend:         BackendConfig{DNSServers: []string{"10.0.0.10:53"}},
+				ReflectedDomain: "cluster.local",
+			},
+		},
+	}
+	if err := cfg.setDefaults(); err != nil {
+		t.Fatalf("setDefaults failed: %v", err)
+	}
+	if order := cfg.Zones["k8s"].Via6Order; order != Via6OrderFirst {
+		t.Errorf("Expected default via6Order %q, got %q", Via6OrderFirst, order)
+	}
+
+	cfg.Zones["k8s"].Via6Order = "middle"
+	if err := cfg.ValidateZones(); err == nil {
+		t.Error("Expected error for unknown via6Order")
+	}
+
+	if strategy := cfg.Zones["k8s"].BackendStrategy; strategy != BackendStrategySequential {
+		t.Errorf("Expected default backendStrategy %q, got %q", BackendStrategySequential, strategy)
+	}
+	cfg.Zones["k8s"].Via6Order = Via6OrderFirst
+	cfg.Zones["k8s"].BackendStrategy = "random"
+	if err := cfg.ValidateZones(); err == nil {
+		t.Error("Expected error for unknown backendStrategy")
+	}
+}
+
+func TestCacheOnExpiry(t *testing.T) {
+	cfg := &Config{
+		Global: GlobalConfig{
+			Cache: CacheConfig{OnExpiry: CacheOnExpiryRefreshSync},
+		},
+		Zones: map[string]*Zone{
+			"inherit": {
+				Domains: []string{"*.inherit.local"},
+				Backend: BackendConfig{DNSServers: []string{"10.0.0.10:53"}},
+			},
+			"async": {
+				Domains: []string{"*.async.local"},
+				Backend: BackendConfig{DNSServers: []string{"10.0.0.10:53"}},
+				Cache:   &CacheConfig{OnExpiry: CacheOnExpiryServeStaleAsync},
+			},
+		},
+	}
+	if err := cfg.setDefaults(); err != nil {
+		t.Fatalf("setDefaults failed: %v", err)
+	}
+	if err := cfg.ValidateZones(); err != nil {
+		t.Fatalf("ValidateZones failed: %v", err)
+	}
+
+	if got := cfg.Zones["inherit"].CacheOnExpiry(); got != CacheOnExpiryRefreshSync {
+		t.Errorf("Expected inherited onExpiry %q, got %q", CacheOnExpiryRefreshSync, got)
+	}
+	if got := cfg.Zones["async"].CacheOnExpiry(); got != CacheOnExpiryServeStaleAsync {
+		t.Errorf("Expected onExpiry %q, got %q", CacheOnExpiryServeStaleAsync, got)
+	}
+	if got := cfg.Zones["async"].StaleRetention(); got != time.Hour {
+		t.Errorf("Expected default stale retention 1h, got %v", got)
+	}
+	if got := (&Zone{}).StaleRetention(); got != 0 {
+		t.Errorf("Expected no stale retention for evicting zone, got %v", got)
+	}
+
+	if got := cfg.Zones["async"].NegativeCacheTTL(); got != DefaultNegativeTTL {
+		t.Errorf("Expected default negative TTL %v, got %v", DefaultNegativeTTL, got)
+	}
+	cfg.Zones["async"].Cache.NegativeTTL = "5s"
+	if got := cfg.Zones["async"].NegativeCacheTTL(); got != 5*time.Second {
+		t.Errorf("Expected negative TTL 5s, got %v", got)
+	}
+	cfg.Zones["async"].Cache.NegativeTTL = "-5s"
+	if err := cfg.ValidateZones(); err == nil {
+		t.Error("Expected error for negative negativeTTL")
+	}
+	cfg.Zones["async"].Cache.NegativeTTL = ""
+
+	if got := cfg.Zones["async"].CacheMinTTL(); got != 0 {
+		t.Errorf("Expected no default minTTL, got %v", got)
+	}
+	cfg.Zones["async"].Cache.MinTTL = "30s"
+	if got := cfg.Zones["async"].CacheMinTTL(); got != 30*time.Second {
+		t.Errorf("Expected minTTL 30s, got %v", got)
+	}
+	cfg.Zones["async"].Cache.MinTTL = "soon"
+	if err := cfg.ValidateZones(); err == nil {
+		t.Error("Expected error for bad minTTL")
+	}
+	cfg.Zones["async"].Cache.MinTTL = ""
+
+	cfg.Zones["async"].Cache.OnExpiry = "prefetch"
+	if err := cfg.ValidateZones(); err == nil {
+		t.Error("Expected error for unknown onExpiry")
+	}
+}
+
+func TestCachePreservesAA(t *testing.T) {
+	preserve, drop := true, false
+	cfg := &Config{
+		Global: GlobalConfig{
+			Cache: CacheConfig{PreserveAA: &preserve},
+		},
+		Zones: map[string]*Zone{
+			"inherit": {
+				Domains: []string{"*.inherit.local"},
+				Backend: BackendConfig{DNSServers: []string{"10.0.0.10:53"}},
+			},
+			"override": {
+				Domains: []string{"*.override.local"},
+				Backend: BackendConfig{DNSServers: []string{"10.0.0.10:53"}},
+				Cache:   &CacheConfig{PreserveAA: &drop},
+			},
+		},
+	}
+	if err := cfg.setDefaults(); err != nil {
+		t.Fatalf("setDefaults failed: %v", err)
+	}
+
+	if !cfg.Zones["inherit"].CachePreservesAA() {
+		t.Error("Expected preserveAA inherited from the global cache")
+	}
+	if cfg.Zones["override"].CachePreservesAA() {
+		t.Error("Expected the zone's preserveAA to override the global one")
+	}
+	if (&Zone{}).CachePreservesAA() {
+		t.Error("Expected AA cleared by default")
+	}
+}
+
+func TestMagicDNSNameValidation(t *testing.T) {
+	translateID := uint16(1)
+	tests := []struct {
+		name    string
+		zone    Zone
+		wantErr bool
+	}{
+		{"valid", Zone{MagicDNSName: "tail1234.ts.net", TranslateID: &translateID}, false},
+		{"cnameOnly", Zone{MagicDNSName: "tail1234.ts.net", CNAMEOnly: true, TranslateID: &translateID}, false},
+		{"without 4via6", Zone{MagicDNSName: "tail1234.ts.net"}, true},
+		{"IP address", Zone{MagicDNSName: "100.64.0.1", TranslateID: &translateID}, true},
+		{"cnameOnly without name", Zone{CNAMEOnly: true, TranslateID: &translateID}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zone := tt.zone
+			zone.Domains = []string{"*.prod.local"}
+			zone.Backend = BackendConfig{DNSServers: []string{"10.0.0.10:53"}}
+			zone.ReflectedDomain = "cluster.local"
+			cfg := &Config{Zones: map[string]*Zone{"prod": &zone}}
+
+			err := cfg.ValidateZones()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateZones() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReflectAAAAValidation(t *testing.T) {
+	translateID := uint16(1)
+	tests := []struct {
+		name    string
+		zone    Zone
+		wantErr bool
+	}{
+		{"domain", Zone{ReflectAAAA: true, ReflectedDomain: "cluster.local"}, false},
+		{"IPv6 address", Zone{ReflectAAAA: true, ReflectedDomain: "fd00::10"}, false},
+		{"IPv4 address", Zone{ReflectAAAA: true, ReflectedDomain: "10.0.0.10"}, true},
+		{"without reflectedDomain", Zone{ReflectAAAA: true}, true},
+		{"with 4via6", Zone{ReflectAAAA: true, ReflectedDomain: "cluster.local", TranslateID: &translateID}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zone := tt.zone
+			zone.Domains = []string{"*.v6.local"}
+			zone.Backend = BackendConfig{DNSServers: []string{"[fd00::53]:53"}}
+			cfg := &Config{Zones: map[string]*Zone{"v6": &zone}}
+
+			err := cfg.ValidateZones()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateZones() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReflectModeValidation(t *testing.T) {
+	translateID := uint16(1)
+	tests := []struct {
+		name    string
+		zone    Zone
+		wantErr bool
+	}{
+		{"via6", Zone{ReflectMode: ReflectModeVia6, TranslateID: &translateID}, false},
+		{"direct", Zone{ReflectMode: ReflectModeDirect, TranslateID: &translateID}, false},
+		{"direct without 4via6", Zone{ReflectMode: ReflectModeDirect}, true},
+		{"direct with passthroughAAAA", Zone{ReflectMode: ReflectModeDirect, TranslateID: &translateID, PassthroughAAAA: true}, true},
+		{"unknown", Zone{ReflectMode: "mirror", TranslateID: &translateID}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zone := tt.zone
+			zone.Domains = []string{"*.v6.local"}
+			zone.Backend = BackendConfig{DNSServers: []string{"[fd00::53]:53"}}
+			zone.ReflectedDomain = "cluster.local"
+			cfg := &Config{Zones: map[string]*Zone{"v6": &zone}}
+
+			err := cfg.ValidateZones()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateZones() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReresolveIntervalValidation(t *testing.T) {
+	translateID := uint16(1)
+	tests := []struct {
+		name    string
+		zone    Zone
+		want    time.Duration
+		wantErr bool
+	}{
+		{"unset", Zone{TranslateID: &translateID}, 0, false},
+		{"interval", Zone{ReresolveInterval: "30s", TranslateID: &translateID}, 30 * time.Second, false},
+		{"zero", Zone{ReresolveInterval: "0s", TranslateID: &translateID}, 0, true},
+		{"bad", Zone{ReresolveInterval: "often", TranslateID: &translateID}, 0, true},
+		{"without 4via6", Zone{ReresolveInterval: "30s"}, 30 * time.Second, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zone := tt.zone
+			zone.Domains = []string{"*.prod.local"}
+			zone.Backend = BackendConfig{DNSServers: []string{"10.0.0.53:53"}}
+			zone.ReflectedDomain = "cluster.local"
+			cfg := &Config{Zones: map[string]*Zone{"prod": &zone}}
+
+			err := cfg.ValidateZones()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateZones() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := zone.ReresolveEvery(); got != tt.want {
+				t.Errorf("ReresolveEvery() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetZoneEqualSpecificity(t *testing.T) {
+	cfg := &Config{
+		Zones: map[string]*Zone{
+			"charlie": {Domains: []string{"*.test.local"}},
+			"alpha":   {Domains: []string{"*.test.local"}},
+			"beta":    {Domains: []string{"*.test.local"}},
+			"longer":  {Domains: []string{"*.api.test.local"}},
+		},
+	}
+
+	// Ties go to the alphabetically first zone name, whatever the map order
+	for i := 0; i < 50; i++ {
+		if zone := cfg.GetZone("app.test.local"); zone != cfg.Zones["alpha"] {
+			t.Fatalf("Expected zone alpha, got %+v", zone)
+		}
+	}
+
+	// A higher priority wins the tie
+	cfg.Zones["beta"].Priority = 10
+	for i := 0; i < 50; i++ {
+		if zone := cfg.GetZone("app.test.local"); zone != cfg.Zones["beta"] {
+			t.Fatalf("Expected zone beta, got %+v", zone)
+		}
+	}
+
+	// Priority wins over a more specific pattern
+	if zone := cfg.GetZone("web.api.test.local"); zone != cfg.Zones["beta"] {
+		t.Errorf("Expected zone beta, got %+v", zone)
+	}
+	cfg.Zones["beta"].Priority = 0
+	if zone := cfg.GetZone("web.api.test.local"); zone != cfg.Zones["longer"] {
+		t.Errorf("Expected zone longer, got %+v", zone)
+	}
+}
+
+func TestZonePriorityValidation(t *testing.T) {
+	cfg := &Config{
+		Zones: map[string]*Zone{
+			"test": {
+				Domains:  []string{"*.test.local"},
+				Backend:  BackendConfig{DNSServers: []string{"10.0.0.10:53"}},
+				Priority: 5,
+			},
+		},
+	}
+	if err := cfg.ValidateZones(); err != nil {
+		t.Fatalf("ValidateZones failed: %v", err)
+	}
+
+	cfg.Zones["test"].Priority = -1
+	if err := cfg.ValidateZones(); err == nil {
+		t.Error("Expected error for negative priority")
+	}
+}
+
+func TestCacheOnlyResponseValidation(t *testing.T) {
+	for _, response := range []string{"", CacheOnlyServFail, CacheOnlyRefused, CacheOnlyStale} {
+		cfg := &Config{
+			Zones: map[string]*Zone{
+				"test": {
+					Domains:           []string{"*.test.local"},
+					Backend:           BackendConfig{DNSServers: []string{"10.0.0.10:53"}},
+					CacheOnlyResponse: response,
+				},
+			},
+		}
+		if err := cfg.ValidateZones(); err != nil {
+			t.Errorf("ValidateZones failed for %q: %v", response, err)
+		}
+	}
+
+	cfg := &Config{
+		Zones: map[string]*Zone{
+			"test": {
+				Domains:           []string{"*.test.local"},
+				Backend:           BackendConfig{DNSServers: []string{"10.0.0.10:53"}},
+				CacheOnlyResponse: "nxdomain",
+			},
+		},
+	}
+	if err := cfg.ValidateZones(); err == nil {
+		t.Error("Expected error for unknown cacheOnlyResponse")
+	}
+}
+
+func TestWarmupValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		global  []string
+		zone    []string
+		wantErr bool
+	}{
+		{"zone names", nil, []string{"api.test.local", "db.test.local."}, false},
+		{"global names", []string{"example.com"}, nil, false},
+		{"zone name outside zone", nil, []string{"api.other.local"}, true},
+		{"bad zone name", nil, []string{""}, true},
+		{"bad global name", []string{"bad..name"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Global: GlobalConfig{Warmup: tt.global},
+				Zones: map[string]*Zone{
+					"test": {
+						Domains: []string{"*.test.local"},
+						Backend: BackendConfig{DNSServers: []string{"10.0.0.10:53"}},
+						Warmup:  tt.zone,
+					},
+				},
+			}
+			if err := cfg.ValidateZones(); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateZones() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseBackend(t *testing.T) {
+	tests := []struct {
+		server   string
+		addr     string
+		protocol string
+		wantErr  bool
+	}{
+		{"10.0.0.1:53", "10.0.0.1:53", BackendProtocolDNS, false},
+		{"tls://1.1.1.1", "1.1.1.1:853", BackendProtocolTLS, false},
+		{"tls://1.1.1.1:8853", "1.1.1.1:8853", BackendProtocolTLS, false},
+		{"tls://dns.example.com", "dns.example.com:853", BackendProtocolTLS, false},
+		{"tls://[2606:4700:4700::1111]", "[2606:4700:4700::1111]:853", BackendProtocolTLS, false},
+		{"tls://[2606:4700:4700::1111]:853", "[2606:4700:4700::1111]:853", BackendProtocolTLS, false},
+		{"https://dns.google/dns-query", "https://dns.google/dns-query", BackendProtocolHTTPS, false},
+		{"https://10.0.0.1:8443/dns-query", "https://10.0.0.1:8443/dns-query", BackendProtocolHTTPS, false},
+		{"tls://", "", "", true},
+		{"tls://:853", "", "", true},
+		{"https:///dns-query", "", "", true},
+		{"[2606:4700:4700::1111]:53", "[2606:4700:4700::1111]:53", BackendProtocolDNS, false},
+		{"dns.example.com:5353", "dns.example.com:5353", BackendProtocolDNS, false},
+		{"8.8.8.8", "", "", true},
+		{"8.8.8.8:", "", "", true},
+		{"8.8.8.8:dns", "", "", true},
+		{"8.8.8.8:70000", "", "", true},
+		{":53", "", "", true},
+		{"tls//1.1.1.1", "", "", true},
+		{"udp://1.1.1.1:53", "", "", true},
+		{"tls://1.1.1.1:dot", "", "", true},
+	}
+	for _, tt := range tests {
+		addr, protocol, err := ParseBackend(tt.server)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseBackend(%q) error = %v, wantErr %v", tt.server, err, tt.wantErr)
+			continue
+		}
+		if addr != tt.addr || protocol != tt.protocol {
+			t.Errorf("ParseBackend(%q) = %q, %q, want %q, %q", tt.server, addr, protocol, tt.addr, tt.protocol)
+		}
+	}
+
+	cfg := &Config{
+		Zones: map[string]*Zone{
+			"dot": {Domains: []string{"*.dot.local"}, Backend: BackendConfig{DNSServers: []string{"tls://"}}},
+		},
+	}
+	if err := cfg.ValidateZones(); err == nil {
+		t.Error("Expected error for DNS over TLS server without a host")
+	}
+}
+
+func TestBackendServerValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		global  []string
+		zone    []string
+		region  []string
+		tag     []string
+		wantErr string
+	}{
+		{"valid", []string{"8.8.8.8:53"}, []string{"10.0.0.10:53", "tls://1.1.1.1", "https://dns.google/dns-query"}, nil, nil, ""},
+		{"global missing port", []string{"8.8.8.8"}, nil, nil, nil, `global backend: bad DNS server "8.8.8.8"`},
+		{"zone missing port", nil, []string{"10.0.0.10:53", "10.0.0.11"}, nil, nil, `zone test: bad DNS server "10.0.0.11"`},
+		{"zone mistyped scheme", nil, []string{"tls//1.1.1.1"}, nil, nil, `zone test: bad DNS server "tls//1.1.1.1"`},
+		{"zone unknown scheme", nil, []string{"quic://1.1.1.1"}, nil, nil, `unsupported scheme "quic"`},
+		{"region missing port", nil, nil, []string{"9.9.9.9"}, nil, `zone test: region EU: bad DNS server "9.9.9.9"`},
+		{"tag missing port", nil, nil, nil, []string{"10.1.0.10"}, `zone test: tag tag:prod: bad DNS server "10.1.0.10"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zone := &Zone{
+				Domains: []string{"*.test.local"},
+				Backend: BackendConfig{DNSServers: []string{"10.0.0.10:53"}},
+			}
+			if tt.zone != nil {
+				zone.Backend.DNSServers = tt.zone
+			}
+			if tt.region != nil {
+				zone.RegionBackends = map[string]BackendConfig{"EU": {DNSServers: tt.region}}
+			}
+			if tt.tag != nil {
+				zone.BackendByTag = map[string]BackendConfig{"tag:prod": {DNSServers: tt.tag}}
+			}
+			cfg := &Config{
+				Global: GlobalConfig{Backend: BackendConfig{DNSServers: tt.global}},
+				Zones:  map[string]*Zone{"test": zone},
+			}
+
+			err := cfg.ValidateZones()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("ValidateZones failed: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ValidateZones() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestZoneTTL(t *testing.T) {
+	zone := &Zone{}
+	if got := zone.AnswerTTL(300); got != 300 {
+		t.Errorf("AnswerTTL() = %d, want the default 300", got)
+	}
+	ttl := uint32(30)
+	zone.TTL = &ttl
+	if got := zone.AnswerTTL(300); got != 30 {
+		t.Errorf("AnswerTTL() = %d, want 30", got)
+	}
+
+	tooLong := uint32(MaxTTL + 1)
+	cfg := &Config{
+		Zones: map[string]*Zone{
+			"test": {
+				Domains: []string{"*.test.local"},
+				Backend: BackendConfig{DNSServers: []string{"10.0.0.10:53"}},
+				TTL:     &tooLong,
+			},
+		},
+	}
+	if err := cfg.ValidateZones(); err == nil {
+		t.Error("Expected error for ttl above the RFC 2181 maximum")
+	}
+}
+
+func TestZoneClientLists(t *testing.T) {
+	zone := &Zone{
+		Domains:        []string{"*.test.local"},
+		Backend:        BackendConfig{DNSServers: []string{"10.0.0.10:53"}},
+		AllowedClients: []string{"10.0.0.0/8", "fd00::/8"},
+		DeniedClients:  []string{"10.6.6.6", "10.9.0.0/16"},
+	}
+	cfg := &Config{Zones: map[string]*Zone{"test": zone}}
+	if err := cfg.ValidateZones(); err != nil {
+		t.Fatalf("ValidateZones failed: %v", err)
+	}
+
+	tests := []struct {
+		client string
+		want   bool
+	}{
+		{"10.1.2.3", true},
+		{"fd00::1", true},
+		{"::ffff:10.1.2.3", true},
+		{"192.168.1.1", false},
+		{"10.6.6.6", false}, // Denied wins when both lists match
+		{"10.9.1.1", false},
+	}
+	for _, tt := range tests {
+		if got := zone.AllowsClient(netip.MustParseAddr(tt.client)); got != tt.want {
+			t.Errorf("AllowsClient(%s) = %v, want %v", tt.client, got, tt.want)
+		}
+	}
+
+	open := &Zone{Domains: []string{"*.open.local"}, Backend: zone.Backend, DeniedClients: []string{"10.6.6.6"}}
+	cfg = &Config{Zones: map[string]*Zone{"open": open}}
+	if err := cfg.ValidateZones(); err != nil {
+		t.Fatalf("ValidateZones failed: %v", err)
+	}
+	if !open.AllowsClient(netip.MustParseAddr("192.168.1.1")) {
+		t.Error("Expected clients outside deniedClients to be allowed without allowedClients")
+	}
+
+	for _, bad := range []string{"10.0.0.0/33", "not-a-cidr"} {
+		cfg := &Config{Zones: map[string]*Zone{"bad": {
+			Domains:        []string{"*.bad.local"},
+			Backend:        zone.Backend,
+			AllowedClients: []string{bad},
+		}}}
+		if err := cfg.ValidateZones(); err == nil {
+			t.Errorf("Expected error for allowedClients entry %q", bad)
+		}
+	}
+}
+
+func TestSOAValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		soa     *SOAConfig
+		wantErr bool
+	}{
+		{"unset", nil, false},
+		{"names", &SOAConfig{MName: "ns1.example.net", RName: "dns-admin.example.net."}, false},
+		{"numbers only", &SOAConfig{Serial: 7, Minimum: 30}, false},
+		{"bad mname", &SOAConfig{MName: "bad..name"}, true},
+		{"bad rname", &SOAConfig{RName: "bad..name"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Zones: map[string]*Zone{
+					"test": {
+						Domains: []string{"*.test.local"},
+						Backend: BackendConfig{DNSServers: []string{"10.0.0.10:53"}},
+						SOA:     tt.soa,
+					},
+				},
+			}
+			if err := cfg.ValidateZones(); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateZones() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNameserversValidation(t *testing.T) {
+	for _, tt := range []struct {
+		nameservers []string
+		wantErr     bool
+	}{
+		{nil, false},
+		{[]string{"ns1.example.net", "ns2.example.net."}, false},
+		{[]string{""}, true},
+		{[]string{"bad..name"}, true},
+	} {
+		cfg := &Config{
+			Zones: map[string]*Zone{
+				"test": {
+					Domains:     []string{"*.test.local"},
+					Backend:     BackendConfig{DNSServers: []string{"10.0.0.10:53"}},
+					Nameservers: tt.nameservers,
+				},
+			},
+		}
+		if err := cfg.ValidateZones(); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateZones(%v) error = %v, wantErr %v", tt.nameservers, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCacheMaxSizeValidation(t *testing.T) {
+	newConfig := func(global, zone int) *Config {
+		return &Config{
+			Global: GlobalConfig{Cache: CacheConfig{MaxSize: global}},
+			Zones: map[string]*Zone{
+				"test": {
+					Domains: []string{"*.test.local"},
+					Backend: BackendConfig{DNSServers: []string{"8.8.8.8:53"}},
+					Cache:   &CacheConfig{MaxSize: zone, TTL: "300s"},
+				},
+			},
+		}
+	}
+
+	cfg := newConfig(100, 0)
+	if err := cfg.ValidateZones(); err != nil {
+		t.Errorf("Expected zone to inherit global maxSize, got %v", err)
+	}
+	if got := cfg.CacheMaxSize(cfg.Zones["test"]); got != 100 {
+		t.Errorf("Expected inherited maxSize 100, got %d", got)
+	}
+
+	if err := newConfig(100, -1).ValidateZones(); err == nil {
+		t.Error("Expected error for negative zone maxSize")
+	}
+	if err := newConfig(-1, 0).ValidateZones(); err == nil {
+		t.Error("Expected error for zone inheriting a negative global maxSize")
+	}
+	if err := newConfig(-1, 50).ValidateZones(); err != nil {
+		t.Errorf("Expected zone maxSize to override global, got %v", err)
+	}
+}
+
+func TestZoneApex(t *testing.T) {
+	zone := &Zone{Domains: []string{"*.prod.local", "app.example.com"}}
+
+	tests := map[string]string{
+		"web.prod.local.":      "prod.local.",
+		"a.b.prod.local":       "prod.local.",
+		"app.example.com.":     "app.example.com.",
+		"api.app.example.com.": "app.example.com.",
+		"example.org.":         "",
+	}
+	for name, want := range tests {
+		if got := zone.Apex(name); got != want {
+			t.Errorf("Apex(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestUnmapName(t *testing.T) {
+	zone := &Zone{Domains: []string{"*.cluster1.local", "*.c1.local", "exact.local"}}
+
+	names := zone.UnmapName("web.default.svc.cluster.local.", "cluster.local")
+	want := []string{"web.default.svc.cluster1.local.", "web.default.svc.c1.local."}
+	if len(names) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, names)
+	}
+	for i, name := range names {
+		if name != want[i] {
+			t.Errorf("Expected %s, got %s", want[i], name)
+		}
+		// UnmapName is the inverse of MapName
+		if mapped := zone.MapName(name, "cluster.local"); mapped != "web.default.svc.cluster.local." {
+			t.Errorf("MapName(%s) = %s, expected the reflected name back", name, mapped)
+		}
+	}
+
+	if names := zone.UnmapName("web.other.local.", "cluster.local"); len(names) != 0 {
+		t.Errorf("Expected no names outside the target, got %v", names)
+	}
+	if names := zone.UnmapName("cluster.local.", "cluster.local"); len(names) != 0 {
+		t.Errorf("Expected no names for the target itself, got %v", names)
+	}
+}
+
+func TestResponseTimeFloor(t *testing.T) {
+	for floor, want := range map[string]time.Duration{"": 0, "25ms": 25 * time.Millisecond} {
+		zone := &Zone{
+			Domains:           []string{"*.test.local"},
+			Backend:           BackendConfig{DNSServers: []string{"10.0.0.10:53"}},
+			ResponseTimeFloor: floor,
+		}
+		if err := (&Config{Zones: map[string]*Zone{"test": zone}}).ValidateZones(); err != nil {
+			t.Errorf("ValidateZones failed for %q: %v", floor, err)
+		}
+		if got := zone.ResponseFloor(); got != want {
+			t.Errorf("ResponseFloor() for %q = %v, want %v", floor, got, want)
+		}
+	}
+
+	for _, floor := range []string{"soon", "-5ms"} {
+		cfg := &Config{
+			Zones: map[string]*Zone{
+				"test": {
+					Domains:           []string{"*.test.local"},
+					Backend:           BackendConfig{DNSServers: []string{"10.0.0.10:53"}},
+					ResponseTimeFloor: floor,
+				},
+			},
+		}
+		if err := cfg.ValidateZones(); err == nil {
+			t.Errorf("Expected error for responseTimeFloor %q", floor)
+		}
+	}
+}
+
+func TestMaxCNAMEDepth(t *testing.T) {
+	zone := &Zone{
+		Domains: []string{"*.test.local"},
+		Backend: BackendConfig{DNSServers: []string{"8.8.8.8:53"}},
+	}
+	cfg := &Config{Zones: map[string]*Zone{"test": zone}}
+
+	if err := cfg.ValidateZones(); err != nil {
+		t.Fatalf("ValidateZones failed: %v", err)
+	}
+	if got := zone.CNAMEDepth(); got != DefaultMaxCNAMEDepth {
+		t.Errorf("Expected default depth %d, got %d", DefaultMaxCNAMEDepth, got)
+	}
+
+	zone.MaxCNAMEDepth = 3
+	if got := zone.CNAMEDepth(); got != 3 {
+		t.Errorf("Expected depth 3, got %d", got)
+	}
+
+	zone.MaxCNAMEDepth = -1
+	if err := cfg.ValidateZones(); err == nil {
+		t.Error("Expected error for negative maxCnameDepth")
+	}
+}
@@ -2,9 +2,14 @@ package config
 
 import (
 	"flag"
+	"fmt"
+	"math"
 	"os"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/miekg/dns"
 )
 
 // RuntimeConfig holds configuration from environment variables and flags
@@ -12,13 +17,61 @@ type RuntimeConfig struct {
 	// Server configuration
 	Hostname       string
 	DNSPort        int
+	DNSTCPPort     int // TCP DNS port (0 = same as DNSPort)
 	HTTPPort       int
 	BindAddress    string
 	DefaultTTL     uint32
 	HealthEnabled  bool
 	HealthPath     string
+	ReadyPath      string
 	MetricsEnabled bool
 	MetricsPath    string
+	PprofEnabled   bool // Serve net/http/pprof under /debug/pprof/ on the HTTP port
+
+	// CHAOS version.bind / hostname.bind answers
+	VersionQueries    string // tailscale (reveal to Tailscale clients), all or none
+	VersionObfuscated string // Answer for clients not allowed the real value (empty = no answer)
+
+	// Instance identifier returned to clients requesting an NSID (RFC 5001), under
+	// the version query policy (empty = none)
+	NSID string
+
+	// Socket tuning (0 keeps the OS default)
+	UDPRecvBufSize   int
+	UDPSendBufSize   int
+	TCPListenBacklog int
+
+	// Set SO_REUSEPORT on OS listeners so two instances can share the DNS port during upgrades
+	ReusePort bool
+
+	// Largest DNS message accepted over TCP in bytes (0 = protocol maximum)
+	TCPMaxMessageSize int
+
+	// Most open TCP DNS connections; further connections are closed on accept (0 = no limit)
+	MaxTCPConnections int
+
+	// Time a TCP DNS connection may sit idle between queries before it is closed (0 = library default)
+	TCPIdleTimeout time.Duration
+
+	// Queries per second each client IP may send, refilling a bucket of PerClientBurst (0 = no limit)
+	PerClientQPS   float64
+	PerClientBurst int // Queries a client may send at once (0 = PerClientQPS rounded up)
+
+	// UDP payload size advertised in EDNS0 responses (0 = DefaultEDNSBufferSize)
+	EDNSBufferSize int
+
+	// Most zones a configuration may have, so every zone is memory monitored (0 = DefaultMaxZones)
+	MaxZones int
+
+	// Healthy backends each health-checked zone needs before the server is ready (0 = no minimum)
+	MinHealthyBackends int
+
+	// Overall time budget for answering a query; backend timeouts shrink to fit it (0 = no limit)
+	QueryDeadline time.Duration
+
+	// How often Tailscale status metrics and memory usage are checked (0 = DefaultPollInterval)
+	StatusPollInterval  time.Duration
+	MemoryCheckInterval time.Duration
 
 	// Tailscale configuration
 	TSAuthKey             string
@@ -27,6 +80,10 @@ type RuntimeConfig struct {
 	TSStateDir            string
 	TSExitNode            bool
 	TSAutoSplitDNS        bool
+	TSBindFamily          string // ipv4 (prefer IPv4), ipv6 (prefer IPv6) or dual
+	MagicDNSTags          string // Comma-separated tags; only peers with one of them resolve via MagicDNS (empty = all)
+	MagicDNSSuffix        string // Domain resolved via MagicDNS (empty = the tailnet's own, detected at startup)
+	MagicDNSStrictShort   bool   // Answer NXDOMAIN for short hostnames shared by several nodes instead of the first match
 	TSOAuthURL            string
 	TSOAuthTags           string
 	TSOAuthEphemeral      bool
@@ -43,11 +100,50 @@ type RuntimeConfig struct {
 	LogFormat     string
 	LogQueries    bool
 	LogFile       string
+
+	// Log queries slower than this with a latency breakdown, even without LogQueries (0 = off)
+	SlowQueryThreshold time.Duration
+
+	// Answer _trace.<name> TXT queries with how <name> would be resolved
+	AllowTraceQueries bool
+
+	// Name answered locally, so DNS health probes don't depend on backends (empty = disabled)
+	DNSHealthProbeName string
+
+	// Queries with more than one question: formerr (reject) or firstonly (answer the first)
+	MultiQuestionPolicy string
+
+	// Answer for names on a 4via6 network with no host part: nxdomain or nodata
+	Via6ApexAnswer string
+
+	// Answer only from cache, never querying backends
+	CacheOnly bool
+
+	// Bearer token for admin HTTP endpoints such as cache flushing (empty = disabled)
+	AdminToken string
+
+	// Most entries listed per zone by the cache dump endpoint (0 = DefaultCacheDumpMaxEntries)
+	CacheDumpMaxEntries int
+
+	// Audit log of external-client queries, separate from the operational log
+	AuditExternalAccess bool
+	AuditLogFile        string        // Audit log path (stdout if empty)
+	AuditRetention      time.Duration // Retention hint attached to audit records (0 = none)
 	
 	// Internal: used to handle flag parsing
 	defaultTTLFlag *uint64
 }
 
+// osHostname returns the OS hostname, which is the pod name in Kubernetes, or
+// empty if it is unknown
+func osHostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	return name
+}
+
 // defaultEnv returns the value of the named env var, or defaultVal if unset
 func defaultEnv(name, defaultVal string) string {
 	if val, ok := os.LookupEnv(name); ok {
@@ -76,6 +172,26 @@ func defaultInt(name string, defaultVal int) int {
 	return ret
 }
 
+// defaultDuration returns the duration value of the named env var, or defaultVal if unset or not a duration
+func defaultDuration(name string, defaultVal time.Duration) time.Duration {
+	v := os.Getenv(name)
+	ret, err := time.ParseDuration(v)
+	if err != nil {
+		return defaultVal
+	}
+	return ret
+}
+
+// defaultFloat64 returns the float64 value of the named env var, or defaultVal if unset or not a float64
+func defaultFloat64(name string, defaultVal float64) float64 {
+	v := os.Getenv(name)
+	ret, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return defaultVal
+	}
+	return ret
+}
+
 // defaultUint32 returns the uint32 value of the named env var, or defaultVal if unset or not a uint32
 func defaultUint32(name string, defaultVal uint32) uint32 {
 	v := os.Getenv(name)
@@ -98,6 +214,8 @@ func NewRuntimeConfig() *RuntimeConfig {
 		"Server hostname. Can also be set via TSDNS_HOSTNAME env var.")
 	flag.IntVar(&rc.DNSPort, "dns-port", defaultInt("TSDNS_DNS_PORT", 53),
 		"DNS port. Can also be set via TSDNS_DNS_PORT env var.")
+	flag.IntVar(&rc.DNSTCPPort, "dns-tcp-port", defaultInt("TSDNS_DNS_TCP_PORT", 0),
+		"DNS port for TCP (0 = same as -dns-port). Can also be set via TSDNS_DNS_TCP_PORT env var.")
 	flag.IntVar(&rc.HTTPPort, "http-port", defaultInt("TSDNS_HTTP_PORT", 8080),
 		"HTTP port for metrics/health. Can also be set via TSDNS_HTTP_PORT env var.")
 	flag.StringVar(&rc.BindAddress, "bind-address", defaultEnv("TSDNS_BIND_ADDRESS", "0.0.0.0"),
@@ -108,10 +226,46 @@ func NewRuntimeConfig() *RuntimeConfig {
 		"Enable health endpoint. Can also be set via TSDNS_HEALTH_ENABLED env var.")
 	flag.StringVar(&rc.HealthPath, "health-path", defaultEnv("TSDNS_HEALTH_PATH", "/health"),
 		"Health endpoint path. Can also be set via TSDNS_HEALTH_PATH env var.")
+	flag.StringVar(&rc.ReadyPath, "ready-path", defaultEnv("TSDNS_READY_PATH", "/ready"),
+		"Readiness endpoint path, served when the health endpoint is enabled. Can also be set via TSDNS_READY_PATH env var.")
+	flag.IntVar(&rc.MinHealthyBackends, "min-healthy-backends", defaultInt("TSDNS_MIN_HEALTHY_BACKENDS", 0),
+		"Healthy backends each health-checked zone needs before the server reports ready (0 = no minimum). Can also be set via TSDNS_MIN_HEALTHY_BACKENDS env var.")
 	flag.BoolVar(&rc.MetricsEnabled, "metrics", defaultBool("TSDNS_METRICS_ENABLED", true),
 		"Enable metrics endpoint. Can also be set via TSDNS_METRICS_ENABLED env var.")
 	flag.StringVar(&rc.MetricsPath, "metrics-path", defaultEnv("TSDNS_METRICS_PATH", "/metrics"),
 		"Metrics endpoint path. Can also be set via TSDNS_METRICS_PATH env var.")
+	flag.BoolVar(&rc.PprofEnabled, "pprof", defaultBool("TSDNS_PPROF_ENABLED", false),
+		"Serve Go profiling endpoints under /debug/pprof/ on the HTTP port. Can also be set via TSDNS_PPROF_ENABLED env var.")
+	flag.StringVar(&rc.VersionQueries, "version-queries", strings.ToLower(defaultEnv("TSDNS_VERSION_QUERIES", "tailscale")),
+		"Who gets the real version in CHAOS version.bind answers (tailscale, all, none). Can also be set via TSDNS_VERSION_QUERIES env var.")
+	flag.StringVar(&rc.VersionObfuscated, "version-obfuscated", defaultEnv("TSDNS_VERSION_OBFUSCATED", ""),
+		"Version string shown to other clients (empty = no answer). Can also be set via TSDNS_VERSION_OBFUSCATED env var.")
+	flag.StringVar(&rc.NSID, "nsid", defaultEnv("TSDNS_NSID", osHostname()),
+		"Instance identifier returned in EDNS NSID answers, defaulting to the OS hostname (empty = none). Can also be set via TSDNS_NSID env var.")
+	flag.IntVar(&rc.UDPRecvBufSize, "udp-recv-buf-size", defaultInt("TSDNS_UDP_RECV_BUF_SIZE", 0),
+		"UDP socket receive buffer size in bytes (0 = OS default). Can also be set via TSDNS_UDP_RECV_BUF_SIZE env var.")
+	flag.IntVar(&rc.UDPSendBufSize, "udp-send-buf-size", defaultInt("TSDNS_UDP_SEND_BUF_SIZE", 0),
+		"UDP socket send buffer size in bytes (0 = OS default). Can also be set via TSDNS_UDP_SEND_BUF_SIZE env var.")
+	flag.IntVar(&rc.TCPListenBacklog, "tcp-listen-backlog", defaultInt("TSDNS_TCP_LISTEN_BACKLOG", 0),
+		"TCP listen backlog (0 = OS default). Can also be set via TSDNS_TCP_LISTEN_BACKLOG env var.")
+	flag.BoolVar(&rc.ReusePort, "reuse-port", defaultBool("TSDNS_REUSE_PORT", false),
+		"Set SO_REUSEPORT on DNS listeners so a new instance can bind the port while the old one drains. Can also be set via TSDNS_REUSE_PORT env var.")
+	flag.IntVar(&rc.TCPMaxMessageSize, "tcp-max-message-size", defaultInt("TSDNS_TCP_MAX_MESSAGE_SIZE", 0),
+		"Largest DNS message accepted over TCP in bytes (0 = no limit). Can also be set via TSDNS_TCP_MAX_MESSAGE_SIZE env var.")
+	flag.IntVar(&rc.MaxTCPConnections, "max-tcp-connections", defaultInt("TSDNS_MAX_TCP_CONNECTIONS", DefaultMaxTCPConnections),
+		"Most open TCP DNS connections; further connections are closed on accept (0 = no limit). Can also be set via TSDNS_MAX_TCP_CONNECTIONS env var.")
+	flag.DurationVar(&rc.TCPIdleTimeout, "tcp-idle-timeout", defaultDuration("TSDNS_TCP_IDLE_TIMEOUT", DefaultTCPIdleTimeout),
+		"Time a TCP DNS connection may sit idle between queries before it is closed. Can also be set via TSDNS_TCP_IDLE_TIMEOUT env var.")
+	flag.Float64Var(&rc.PerClientQPS, "per-client-qps", defaultFloat64("TSDNS_PER_CLIENT_QPS", 0),
+		"Queries per second each client IP may send; queries over the limit are refused (0 = no limit). Can also be set via TSDNS_PER_CLIENT_QPS env var.")
+	flag.IntVar(&rc.PerClientBurst, "per-client-burst", defaultInt("TSDNS_PER_CLIENT_BURST", 0),
+		"Queries a client IP may send at once before -per-client-qps applies (0 = the QPS rounded up). Can also be set via TSDNS_PER_CLIENT_BURST env var.")
+	flag.IntVar(&rc.EDNSBufferSize, "edns-buffer-size", defaultInt("TSDNS_EDNS_BUFFER_SIZE", DefaultEDNSBufferSize),
+		"UDP payload size in bytes advertised in EDNS0 responses. Can also be set via TSDNS_EDNS_BUFFER_SIZE env var.")
+	flag.IntVar(&rc.MaxZones, "max-zones", defaultInt("TSDNS_MAX_ZONES", DefaultMaxZones),
+		"Most zones a configuration may have; larger configurations are rejected at startup and reload. Can also be set via TSDNS_MAX_ZONES env var.")
+	flag.DurationVar(&rc.QueryDeadline, "query-deadline", defaultDuration("TSDNS_QUERY_DEADLINE", 0),
+		"Overall time budget for answering a query across backend retries (0 = no limit). Can also be set via TSDNS_QUERY_DEADLINE env var.")
 
 	// Logging flags
 	flag.StringVar(&rc.LogLevel, "log-level", defaultEnv("TSDNS_LOG_LEVEL", "info"),
@@ -122,6 +276,30 @@ func NewRuntimeConfig() *RuntimeConfig {
 		"Enable query logging. Can also be set via TSDNS_LOG_QUERIES env var.")
 	flag.StringVar(&rc.LogFile, "log-file", defaultEnv("TSDNS_LOG_FILE", ""),
 		"Log file path (stdout if empty). Can also be set via TSDNS_LOG_FILE env var.")
+	flag.DurationVar(&rc.SlowQueryThreshold, "slow-query-threshold", defaultDuration("TSDNS_SLOW_QUERY_THRESHOLD", 0),
+		"Log queries slower than this duration (0 = disabled). Can also be set via TSDNS_SLOW_QUERY_THRESHOLD env var.")
+	flag.BoolVar(&rc.AllowTraceQueries, "allow-trace-queries", defaultBool("TSDNS_ALLOW_TRACE_QUERIES", false),
+		"Answer _trace.<name> TXT debug queries. Can also be set via TSDNS_ALLOW_TRACE_QUERIES env var.")
+	flag.StringVar(&rc.DNSHealthProbeName, "dns-health-probe-name", defaultEnv("TSDNS_DNS_HEALTH_PROBE_NAME", ""),
+		"Name answered locally for DNS health probes, without querying backends (empty = disabled). Can also be set via TSDNS_DNS_HEALTH_PROBE_NAME env var.")
+	flag.StringVar(&rc.MultiQuestionPolicy, "multi-question-policy", strings.ToLower(defaultEnv("TSDNS_MULTI_QUESTION_POLICY", "formerr")),
+		"How queries with more than one question are answered (formerr, firstonly). Can also be set via TSDNS_MULTI_QUESTION_POLICY env var.")
+	flag.StringVar(&rc.Via6ApexAnswer, "via6-apex-answer", strings.ToLower(defaultEnv("TSDNS_VIA6_APEX_ANSWER", "nxdomain")),
+		"Answer for reverse names of a 4via6 network without a host part (nxdomain, nodata). Can also be set via TSDNS_VIA6_APEX_ANSWER env var.")
+	flag.BoolVar(&rc.CacheOnly, "cache-only", defaultBool("TSDNS_CACHE_ONLY", false),
+		"Answer only from cache and never query backends. Can also be set via TSDNS_CACHE_ONLY env var.")
+	flag.IntVar(&rc.CacheDumpMaxEntries, "cache-dump-max-entries", defaultInt("TSDNS_CACHE_DUMP_MAX_ENTRIES", DefaultCacheDumpMaxEntries),
+		"Most entries listed per zone by the /cache/dump admin endpoint. Can also be set via TSDNS_CACHE_DUMP_MAX_ENTRIES env var.")
+	flag.BoolVar(&rc.AuditExternalAccess, "audit-external-access", defaultBool("TSDNS_AUDIT_EXTERNAL_ACCESS", false),
+		"Write an audit record for every external-client query. Can also be set via TSDNS_AUDIT_EXTERNAL_ACCESS env var.")
+	flag.StringVar(&rc.AuditLogFile, "audit-log-file", defaultEnv("TSDNS_AUDIT_LOG_FILE", ""),
+		"Audit log file path (stdout if empty). Can also be set via TSDNS_AUDIT_LOG_FILE env var.")
+	flag.DurationVar(&rc.AuditRetention, "audit-retention", defaultDuration("TSDNS_AUDIT_RETENTION", 0),
+		"Retention hint attached to audit records, e.g. 2160h (0 = none). Can also be set via TSDNS_AUDIT_RETENTION env var.")
+	flag.DurationVar(&rc.StatusPollInterval, "status-poll-interval", defaultDuration("TSDNS_STATUS_POLL_INTERVAL", DefaultPollInterval),
+		"How often Tailscale status metrics are refreshed (at least 1s). Can also be set via TSDNS_STATUS_POLL_INTERVAL env var.")
+	flag.DurationVar(&rc.MemoryCheckInterval, "memory-check-interval", defaultDuration("TSDNS_MEMORY_CHECK_INTERVAL", DefaultPollInterval),
+		"How often memory usage is checked against its limits (at least 1s). Can also be set via TSDNS_MEMORY_CHECK_INTERVAL env var.")
 
 	// Set default TTL from env var for now - will be overridden after flag.Parse()
 	rc.DefaultTTL = defaultUint32("TSDNS_DEFAULT_TTL", 300)
@@ -130,6 +308,195 @@ func NewRuntimeConfig() *RuntimeConfig {
 	return rc
 }
 
+// TCPPort returns the port DNS is served on over TCP
+func (rc *RuntimeConfig) TCPPort() int {
+	if rc.DNSTCPPort != 0 {
+		return rc.DNSTCPPort
+	}
+	return rc.DNSPort
+}
+
+// DefaultEDNSBufferSize is the EDNS0 UDP payload size recommended by DNS Flag
+// Day 2020, which avoids IP fragmentation on common paths
+const DefaultEDNSBufferSize = 1232
+
+// EDNSUDPSize returns the UDP payload size advertised in EDNS0 responses
+func (rc *RuntimeConfig) EDNSUDPSize() uint16 {
+	switch {
+	case rc.EDNSBufferSize <= 0:
+		return DefaultEDNSBufferSize
+	case rc.EDNSBufferSize < dns.MinMsgSize:
+		return dns.MinMsgSize
+	case rc.EDNSBufferSize > dns.MaxMsgSize:
+		return dns.MaxMsgSize
+	}
+	return uint16(rc.EDNSBufferSize)
+}
+
+// Defaults bounding TCP DNS connections, so idle or slow clients can't hold
+// resources indefinitely
+const (
+	DefaultMaxTCPConnections = 1000
+	DefaultTCPIdleTimeout    = 10 * time.Second
+)
+
+// DefaultCacheDumpMaxEntries is the cache dump limit when CacheDumpMaxEntries is unset
+const DefaultCacheDumpMaxEntries = 1000
+
+// CacheDumpLimit returns the most entries listed per zone in a cache dump
+func (rc *RuntimeConfig) CacheDumpLimit() int {
+	if rc.CacheDumpMaxEntries <= 0 {
+		return DefaultCacheDumpMaxEntries
+	}
+	return rc.CacheDumpMaxEntries
+}
+
+// DefaultMaxZones is the zone limit when MaxZones is unset
+const DefaultMaxZones = 100
+
+// ZoneLimit returns the most zones a configuration may have
+func (rc *RuntimeConfig) ZoneLimit() int {
+	if rc.MaxZones <= 0 {
+		return DefaultMaxZones
+	}
+	return rc.MaxZones
+}
+
+// Bounds of the periodic Tailscale status and memory checks
+const (
+	DefaultPollInterval = 30 * time.Second
+	MinPollInterval     = time.Second
+)
+
+// StatusPollEvery returns how often Tailscale status metrics are refreshed
+func (rc *RuntimeConfig) StatusPollEvery() time.Duration {
+	if rc.StatusPollInterval <= 0 {
+		return DefaultPollInterval
+	}
+	return rc.StatusPollInterval
+}
+
+// MemoryCheckEvery returns how often memory usage is checked
+func (rc *RuntimeConfig) MemoryCheckEvery() time.Duration {
+	if rc.MemoryCheckInterval <= 0 {
+		return DefaultPollInterval
+	}
+	return rc.MemoryCheckInterval
+}
+
+// ValidatePollIntervals rejects status and memory check intervals under
+// MinPollInterval, which would spend more time polling than serving
+func (rc *RuntimeConfig) ValidatePollIntervals() error {
+	if rc.StatusPollInterval != 0 && rc.StatusPollInterval < MinPollInterval {
+		return fmt.Errorf("status poll interval %s is below the minimum of %s (TSDNS_STATUS_POLL_INTERVAL)", rc.StatusPollInterval, MinPollInterval)
+	}
+	if rc.MemoryCheckInterval != 0 && rc.MemoryCheckInterval < MinPollInterval {
+		return fmt.Errorf("memory check interval %s is below the minimum of %s (TSDNS_MEMORY_CHECK_INTERVAL)", rc.MemoryCheckInterval, MinPollInterval)
+	}
+	return nil
+}
+
+// RateLimitBurst returns the most queries a client may send at once, or 0 if
+// clients aren't rate limited
+func (rc *RuntimeConfig) RateLimitBurst() int {
+	if rc.PerClientQPS <= 0 {
+		return 0
+	}
+	if rc.PerClientBurst > 0 {
+		return rc.PerClientBurst
+	}
+	return max(1, int(math.Ceil(rc.PerClientQPS)))
+}
+
+// ValidateRateLimit rejects negative per-client rate limits, which would
+// otherwise silently disable limiting
+func (rc *RuntimeConfig) ValidateRateLimit() error {
+	if rc.PerClientQPS < 0 || math.IsNaN(rc.PerClientQPS) || math.IsInf(rc.PerClientQPS, 0) {
+		return fmt.Errorf("per-client QPS %v must be a non-negative number (TSDNS_PER_CLIENT_QPS)", rc.PerClientQPS)
+	}
+	if rc.PerClientBurst < 0 {
+		return fmt.Errorf("per-client burst %d must not be negative (TSDNS_PER_CLIENT_BURST)", rc.PerClientBurst)
+	}
+	return nil
+}
+
+// Multi-question policies
+const (
+	MultiQuestionFormErr   = "formerr"   // Reject the query with FORMERR, as most servers do
+	MultiQuestionFirstOnly = "firstonly" // Answer the first question and drop the rest
+)
+
+// ValidateMultiQuestionPolicy rejects unknown multi-question policies, which
+// would otherwise silently behave as formerr
+func (rc *RuntimeConfig) ValidateMultiQuestionPolicy() error {
+	switch rc.MultiQuestionPolicy {
+	case "", MultiQuestionFormErr, MultiQuestionFirstOnly:
+		return nil
+	}
+	return fmt.Errorf("unknown multi-question policy %q, must be %s or %s (TSDNS_MULTI_QUESTION_POLICY)", rc.MultiQuestionPolicy, MultiQuestionFormErr, MultiQuestionFirstOnly)
+}
+
+// Answers for 4via6 network names without a host part
+const (
+	Via6ApexNXDomain = "nxdomain" // No such name
+	Via6ApexNoData   = "nodata"   // The name exists, with addresses below it
+)
+
+// ValidateVia6ApexAnswer rejects unknown 4via6 apex answers, which would
+// otherwise silently answer NXDOMAIN
+func (rc *RuntimeConfig) ValidateVia6ApexAnswer() error {
+	switch rc.Via6ApexAnswer {
+	case "", Via6ApexNXDomain, Via6ApexNoData:
+		return nil
+	}
+	return fmt.Errorf("unknown 4via6 apex answer %q, must be %s or %s (TSDNS_VIA6_APEX_ANSWER)", rc.Via6ApexAnswer, Via6ApexNXDomain, Via6ApexNoData)
+}
+
+// Version query policies
+const (
+	VersionQueriesTailscale = "tailscale" // Real version for Tailscale clients only
+	VersionQueriesAll       = "all"       // Real version for every client
+	VersionQueriesNone      = "none"      // Never reveal the real version
+)
+
+// ValidateVersionQueries rejects unknown version query policies, which would
+// otherwise silently behave as tailscale
+func (rc *RuntimeConfig) ValidateVersionQueries() error {
+	switch rc.VersionQueries {
+	case "", VersionQueriesTailscale, VersionQueriesAll, VersionQueriesNone:
+		return nil
+	}
+	return fmt.Errorf("unknown version query policy %q, must be %s, %s or %s (TSDNS_VERSION_QUERIES)", rc.VersionQueries, VersionQueriesTailscale, VersionQueriesAll, VersionQueriesNone)
+}
+
+// Address families the Tailscale listener binds
+const (
+	TSBindIPv4 = "ipv4" // Prefer the IPv4 address
+	TSBindIPv6 = "ipv6" // Prefer the IPv6 address
+	TSBindDual = "dual" // Listen on both
+)
+
+// ValidateTSBindFamily rejects unknown Tailscale bind families, which would
+// otherwise silently bind IPv4
+func (rc *RuntimeConfig) ValidateTSBindFamily() error {
+	switch rc.TSBindFamily {
+	case "", TSBindIPv4, TSBindIPv6, TSBindDual:
+		return nil
+	}
+	return fmt.Errorf("unknown Tailscale bind family %q, must be %s, %s or %s (TSDNS_TS_BIND_FAMILY)", rc.TSBindFamily, TSBindIPv4, TSBindIPv6, TSBindDual)
+}
+
+// MagicDNSTagList returns the tags limiting MagicDNS resolution, or nil if every peer resolves
+func (rc *RuntimeConfig) MagicDNSTagList() []string {
+	var tags []string
+	for _, tag := range strings.Split(rc.MagicDNSTags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
 // SetupEnvOnlyValues sets values that are only available via environment variables
 func (rc *RuntimeConfig) SetupEnvOnlyValues() {
 	// Apply TTL from flag if it was parsed
@@ -141,6 +508,9 @@ func (rc *RuntimeConfig) SetupEnvOnlyValues() {
 	rc.TSAuthKey = os.Getenv("TS_AUTHKEY")
 	rc.TSState = os.Getenv("TS_STATE")
 
+	// Secrets stay out of flags, which other local users can see
+	rc.AdminToken = os.Getenv("TSDNS_ADMIN_TOKEN")
+
 	// OAuth file paths (k8s-operator pattern)
 	rc.ClientIDFile = os.Getenv("CLIENT_ID_FILE")
 	rc.ClientSecretFile = os.Getenv("CLIENT_SECRET_FILE")
@@ -154,6 +524,10 @@ func (rc *RuntimeConfig) SetupEnvOnlyValues() {
 	rc.TSStateDir = defaultEnv("TSDNS_TS_STATE_DIR", "/tmp/tailscale")
 	rc.TSExitNode = defaultBool("TSDNS_TS_EXIT_NODE", false)
 	rc.TSAutoSplitDNS = defaultBool("TSDNS_TS_AUTO_SPLIT_DNS", false)
+	rc.TSBindFamily = strings.ToLower(defaultEnv("TSDNS_TS_BIND_FAMILY", TSBindIPv4))
+	rc.MagicDNSTags = defaultEnv("TSDNS_MAGICDNS_TAGS", "")
+	rc.MagicDNSSuffix = defaultEnv("TSDNS_MAGICDNS_SUFFIX", "")
+	rc.MagicDNSStrictShort = defaultBool("TSDNS_MAGICDNS_STRICT_SHORT_NAMES", false)
 	
 	// OAuth configuration
 	rc.TSOAuthURL = defaultEnv("TSDNS_TS_OAUTH_URL", "https://login.tailscale.com")
@@ -209,6 +583,15 @@ func (rc *RuntimeConfig) ToLoggingConfig() LoggingConfig {
 	}
 }
 
+// ReloadLogging returns cfg with the settings that can change without a
+// restart, the log level and query logging, read again from
+// TSDNS_LOG_LEVEL and TSDNS_LOG_QUERIES. Unset variables keep cfg's values.
+func ReloadLogging(cfg LoggingConfig) LoggingConfig {
+	cfg.Level = defaultEnv("TSDNS_LOG_LEVEL", cfg.Level)
+	cfg.LogQueries = defaultBool("TSDNS_LOG_QUERIES", cfg.LogQueries)
+	return cfg
+}
+
 // ToTailscaleConfig converts RuntimeConfig to the old TailscaleConfig format for compatibility
 func (rc *RuntimeConfig) ToTailscaleConfig() TailscaleConfig {
 	cfg := TailscaleConfig{
@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"testing"
+	"time"
 )
 
 func TestRuntimeConfig(t *testing.T) {
@@ -84,6 +85,140 @@ func TestRuntimeConfigDefaults(t *testing.T) {
 	}
 }
 
+func TestPollIntervals(t *testing.T) {
+	t.Setenv("TSDNS_STATUS_POLL_INTERVAL", "5s")
+	t.Setenv("TSDNS_MEMORY_CHECK_INTERVAL", "2m")
+	rc := &RuntimeConfig{
+		StatusPollInterval:  defaultDuration("TSDNS_STATUS_POLL_INTERVAL", DefaultPollInterval),
+		MemoryCheckInterval: defaultDuration("TSDNS_MEMORY_CHECK_INTERVAL", DefaultPollInterval),
+	}
+	if rc.StatusPollEvery() != 5*time.Second || rc.MemoryCheckEvery() != 2*time.Minute {
+		t.Errorf("Expected intervals 5s and 2m, got %s and %s", rc.StatusPollEvery(), rc.MemoryCheckEvery())
+	}
+	if err := rc.ValidatePollIntervals(); err != nil {
+		t.Errorf("Expected valid intervals, got %v", err)
+	}
+
+	// Unset intervals fall back to the default
+	rc = &RuntimeConfig{}
+	if rc.StatusPollEvery() != DefaultPollInterval || rc.MemoryCheckEvery() != DefaultPollInterval {
+		t.Errorf("Expected default intervals, got %s and %s", rc.StatusPollEvery(), rc.MemoryCheckEvery())
+	}
+	if err := rc.ValidatePollIntervals(); err != nil {
+		t.Errorf("Expected unset intervals to be valid, got %v", err)
+	}
+
+	for _, rc := range []*RuntimeConfig{
+		{StatusPollInterval: 500 * time.Millisecond},
+		{MemoryCheckInterval: -time.Second},
+	} {
+		if err := rc.ValidatePollIntervals(); err == nil {
+			t.Errorf("Expected intervals %s/%s to be rejected", rc.StatusPollInterval, rc.MemoryCheckInterval)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	tests := []struct {
+		qps       float64
+		burst     int
+		wantBurst int
+		wantErr   bool
+	}{
+		{0, 0, 0, false},
+		{0, 5, 0, false},
+		{10, 0, 10, false},
+		{0.5, 0, 1, false},
+		{2.5, 0, 3, false},
+		{10, 50, 50, false},
+		{-1, 0, 0, true},
+		{10, -1, 10, true},
+	}
+	for _, tt := range tests {
+		rc := &RuntimeConfig{PerClientQPS: tt.qps, PerClientBurst: tt.burst}
+		if err := rc.ValidateRateLimit(); (err != nil) != tt.wantErr {
+			t.Errorf("qps %v burst %d: expected error %v, got %v", tt.qps, tt.burst, tt.wantErr, err)
+		}
+		if got := rc.RateLimitBurst(); !tt.wantErr && got != tt.wantBurst {
+			t.Errorf("qps %v burst %d: expected burst %d, got %d", tt.qps, tt.burst, tt.wantBurst, got)
+		}
+	}
+}
+
+func TestValidateMultiQuestionPolicy(t *testing.T) {
+	tests := []struct {
+		policy  string
+		wantErr bool
+	}{
+		{"", false},
+		{MultiQuestionFormErr, false},
+		{MultiQuestionFirstOnly, false},
+		{"first-only", true},
+	}
+	for _, tt := range tests {
+		rc := &RuntimeConfig{MultiQuestionPolicy: tt.policy}
+		if err := rc.ValidateMultiQuestionPolicy(); (err != nil) != tt.wantErr {
+			t.Errorf("policy %q: expected error %v, got %v", tt.policy, tt.wantErr, err)
+		}
+	}
+}
+
+func TestValidateVia6ApexAnswer(t *testing.T) {
+	tests := []struct {
+		answer  string
+		wantErr bool
+	}{
+		{"", false},
+		{Via6ApexNXDomain, false},
+		{Via6ApexNoData, false},
+		{"servfail", true},
+	}
+	for _, tt := range tests {
+		rc := &RuntimeConfig{Via6ApexAnswer: tt.answer}
+		if err := rc.ValidateVia6ApexAnswer(); (err != nil) != tt.wantErr {
+			t.Errorf("answer %q: expected error %v, got %v", tt.answer, tt.wantErr, err)
+		}
+	}
+}
+
+func TestValidateVersionQueries(t *testing.T) {
+	tests := []struct {
+		policy  string
+		wantErr bool
+	}{
+		{"", false},
+		{VersionQueriesTailscale, false},
+		{VersionQueriesAll, false},
+		{VersionQueriesNone, false},
+		{"tailnet", true},
+	}
+	for _, tt := range tests {
+		rc := &RuntimeConfig{VersionQueries: tt.policy}
+		if err := rc.ValidateVersionQueries(); (err != nil) != tt.wantErr {
+			t.Errorf("policy %q: expected error %v, got %v", tt.policy, tt.wantErr, err)
+		}
+	}
+}
+
+func TestValidateTSBindFamily(t *testing.T) {
+	tests := []struct {
+		family  string
+		wantErr bool
+	}{
+		{"", false},
+		{TSBindIPv4, false},
+		{TSBindIPv6, false},
+		{TSBindDual, false},
+		{"both", true},
+	}
+	for _, tt := range tests {
+		rc := &RuntimeConfig{TSBindFamily: tt.family}
+		if err := rc.ValidateTSBindFamily(); (err != nil) != tt.wantErr {
+			t.Errorf("family %q: expected error %v, got %v", tt.family, tt.wantErr, err)
+		}
+	}
+}
+
 func TestToServerConfig(t *testing.T) {
 	rc := &RuntimeConfig{
 		Hostname:       "test-server",
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"strings"
+)
+
+// Special-use domain policies (RFC 6761)
+const (
+	SpecialUseNXDomain = "nxdomain" // Answer NXDOMAIN locally
+	SpecialUseLoopback = "loopback" // Answer with 127.0.0.1 / ::1
+	SpecialUseForward  = "forward"  // Handle like any other domain
+)
+
+// DefaultSpecialUseDomains returns the RFC 6761 special-use domain table
+func DefaultSpecialUseDomains() map[string]string {
+	return map[string]string{
+		"localhost": SpecialUseLoopback,
+		"invalid":   SpecialUseNXDomain,
+		"test":      SpecialUseNXDomain,
+		"example":   SpecialUseForward,
+	}
+}
+
+func isValidSpecialUsePolicy(policy string) bool {
+	switch policy {
+	case SpecialUseNXDomain, SpecialUseLoopback, SpecialUseForward:
+		return true
+	}
+	return false
+}
+
+// SpecialUsePolicy returns the policy for the most specific special-use domain
+// matching domain, or SpecialUseForward if none matches
+func (c *Config) SpecialUsePolicy(domain string) string {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+
+	policy := SpecialUseForward
+	bestMatchLength := -1
+	for suffix, p := range c.Global.SpecialUseDomains {
+		suffix = strings.ToLower(strings.Trim(suffix, "."))
+		if domain != suffix && !strings.HasSuffix(domain, "."+suffix) {
+			continue
+		}
+		if len(suffix) > bestMatchLength {
+			policy = p
+			bestMatchLength = len(suffix)
+		}
+	}
+
+	return policy
+}
@@ -2,8 +2,14 @@ package config
 
 import (
 	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/miekg/dns"
 )
 
 func (c *Config) GetZone(domain string) *Zone {
@@ -12,16 +18,20 @@ func (c *Config) GetZone(domain string) *Zone {
 	}
 
 	var bestMatch *Zone
+	var bestMatchName string
 	var bestMatchLength int
 
-	for _, zone := range c.Zones {
+	for name, zone := range c.Zones {
 		// Zone is enabled simply by existing in the configuration
 		for _, zoneDomain := range zone.Domains {
 			if zone.MatchesDomain(domain, zoneDomain) {
-				// Prefer more specific matches (longer domain patterns)
+				// Prefer the higher priority, then more specific matches
+				// (longer domain patterns), then the alphabetically first
+				// zone name, so selection doesn't depend on map order.
 				domainLength := len(zoneDomain)
-				if bestMatch == nil || domainLength > bestMatchLength {
+				if bestMatch == nil || zoneMatchBeats(zone.Priority, domainLength, name, bestMatch.Priority, bestMatchLength, bestMatchName) {
 					bestMatch = zone
+					bestMatchName = name
 					bestMatchLength = domainLength
 				}
 			}
@@ -31,6 +41,17 @@ func (c *Config) GetZone(domain string) *Zone {
 	return bestMatch
 }
 
+// zoneMatchBeats reports whether a zone match ranks above the current best
+func zoneMatchBeats(priority, length int, name string, bestPriority, bestLength int, bestName string) bool {
+	if priority != bestPriority {
+		return priority > bestPriority
+	}
+	if length != bestLength {
+		return length > bestLength
+	}
+	return name < bestName
+}
+
 // MatchesDomain checks if a domain matches a zone domain pattern
 func (z *Zone) MatchesDomain(domain, zoneDomain string) bool {
 	if !strings.HasSuffix(domain, ".") {
@@ -47,11 +68,126 @@ func (z *Zone) MatchesDomain(domain, zoneDomain string) bool {
 	return domain == zoneDomain || strings.HasSuffix(domain, "."+zoneDomain)
 }
 
+// Apex returns the domain of the zone that domain falls under, fully qualified
+// and without its wildcard label, or "" if the zone doesn't match domain
+func (z *Zone) Apex(domain string) string {
+	for _, zoneDomain := range z.Domains {
+		if z.MatchesDomain(domain, zoneDomain) {
+			return dns.Fqdn(strings.TrimPrefix(zoneDomain, "*."))
+		}
+	}
+	return ""
+}
+
+// matchesAnyDomain reports whether domain matches one of the zone's patterns
+func (z *Zone) matchesAnyDomain(domain string) bool {
+	for _, zoneDomain := range z.Domains {
+		if z.MatchesDomain(domain, zoneDomain) {
+			return true
+		}
+	}
+	return false
+}
+
+// MapName replaces the suffix matched by the zone's wildcard domain in
+// originalDomain with target. Names under non-wildcard domains map to target itself.
+func (z *Zone) MapName(originalDomain, target string) string {
+	// Handle subdomain mapping
+	for _, zoneDomain := range z.Domains {
+		if z.MatchesDomain(originalDomain, zoneDomain) {
+			// Extract subdomain and replace the zone suffix with the target
+			if strings.HasPrefix(zoneDomain, "*.") {
+				// Remove the wildcard prefix to get the base domain
+				baseDomain := strings.TrimPrefix(zoneDomain, "*.")
+				if !strings.HasSuffix(baseDomain, ".") {
+					baseDomain += "."
+				}
+				if !strings.HasSuffix(originalDomain, ".") {
+					originalDomain += "."
+				}
+				// Replace the zone's base domain with the target
+				if strings.HasSuffix(originalDomain, baseDomain) {
+					prefix := strings.TrimSuffix(originalDomain, baseDomain)
+					if !strings.HasSuffix(target, ".") {
+						target += "."
+					}
+					target = prefix + target
+				}
+			}
+			break
+		}
+	}
+
+	if !strings.HasSuffix(target, ".") {
+		target += "."
+	}
+	return target
+}
+
+// UnmapName is the inverse of MapName: it returns the names in the zone that
+// map onto reflectedName, one per wildcard domain, or none if reflectedName is
+// not under target
+func (z *Zone) UnmapName(reflectedName, target string) []string {
+	reflectedName = dns.Fqdn(reflectedName)
+	target = dns.Fqdn(target)
+	if !strings.HasSuffix(reflectedName, "."+target) {
+		return nil
+	}
+	prefix := strings.TrimSuffix(reflectedName, target)
+
+	var names []string
+	for _, zoneDomain := range z.Domains {
+		if strings.HasPrefix(zoneDomain, "*.") {
+			names = append(names, prefix+dns.Fqdn(strings.TrimPrefix(zoneDomain, "*.")))
+		}
+	}
+	return names
+}
+
 func (c *Config) ValidateZones() error {
 	if len(c.Zones) == 0 {
 		return fmt.Errorf("no zones configured")
 	}
 
+	for domain, policy := range c.Global.SpecialUseDomains {
+		if !isValidSpecialUsePolicy(policy) {
+			return fmt.Errorf("special-use domain %s: unknown policy %q", domain, policy)
+		}
+	}
+
+	if err := validateDNSServers(c.Global.Backend.DNSServers); err != nil {
+		return fmt.Errorf("global backend: %w", err)
+	}
+	if err := validateSourceAddress(c.Global.Backend.SourceAddress); err != nil {
+		return fmt.Errorf("global backend: %w", err)
+	}
+
+	if !isValidCacheOnExpiry(c.Global.Cache.OnExpiry) {
+		return fmt.Errorf("global cache: unknown onExpiry %q", c.Global.Cache.OnExpiry)
+	}
+
+	if err := validateCacheDuration("negativeTTL", c.Global.Cache.NegativeTTL); err != nil {
+		return fmt.Errorf("global cache: %w", err)
+	}
+	if err := validateCacheDuration("minTTL", c.Global.Cache.MinTTL); err != nil {
+		return fmt.Errorf("global cache: %w", err)
+	}
+
+	if cb := c.Global.CircuitBreaker; cb != nil && !cb.Disabled {
+		if cb.FailureThreshold < 1 {
+			return fmt.Errorf("circuitBreaker: failureThreshold must be at least 1")
+		}
+		if _, err := time.ParseDuration(cb.Cooldown); err != nil {
+			return fmt.Errorf("circuitBreaker: bad cooldown")
+		}
+	}
+
+	for _, name := range c.Global.Warmup {
+		if _, ok := dns.IsDomainName(name); !ok || name == "" {
+			return fmt.Errorf("global warmup: bad name %q", name)
+		}
+	}
+
 	translateIDs := make(map[uint16]string)
 
 	for name, zone := range c.Zones {
@@ -63,12 +199,53 @@ func (c *Config) ValidateZones() error {
 			return fmt.Errorf("zone %s: no DNS servers", name)
 		}
 
+		if zone.Priority < 0 {
+			return fmt.Errorf("zone %s: priority cannot be negative", name)
+		}
+
 		if zone.Backend.Timeout != "" {
 			if _, err := time.ParseDuration(zone.Backend.Timeout); err != nil {
 				return fmt.Errorf("zone %s: bad timeout", name)
 			}
 		}
 
+		if err := validateDNSServers(zone.Backend.DNSServers); err != nil {
+			return fmt.Errorf("zone %s: %w", name, err)
+		}
+		if err := validateSourceAddress(zone.Backend.SourceAddress); err != nil {
+			return fmt.Errorf("zone %s: %w", name, err)
+		}
+
+		for region, backend := range zone.RegionBackends {
+			if region == "" {
+				return fmt.Errorf("zone %s: empty region in regionBackends", name)
+			}
+			if len(backend.DNSServers) == 0 {
+				return fmt.Errorf("zone %s: region %s has no DNS servers", name, region)
+			}
+			if err := validateDNSServers(backend.DNSServers); err != nil {
+				return fmt.Errorf("zone %s: region %s: %w", name, region, err)
+			}
+			if err := validateSourceAddress(backend.SourceAddress); err != nil {
+				return fmt.Errorf("zone %s: region %s: %w", name, region, err)
+			}
+		}
+
+		for tag, backend := range zone.BackendByTag {
+			if !strings.HasPrefix(tag, "tag:") || len(tag) == len("tag:") {
+				return fmt.Errorf("zone %s: backendByTag key %q must be an ACL tag like tag:name", name, tag)
+			}
+			if len(backend.DNSServers) == 0 {
+				return fmt.Errorf("zone %s: tag %s has no DNS servers", name, tag)
+			}
+			if err := validateDNSServers(backend.DNSServers); err != nil {
+				return fmt.Errorf("zone %s: tag %s: %w", name, tag, err)
+			}
+			if err := validateSourceAddress(backend.SourceAddress); err != nil {
+				return fmt.Errorf("zone %s: tag %s: %w", name, tag, err)
+			}
+		}
+
 		if zone.Has4via6() {
 			id := *zone.TranslateID
 			if id == 0 {
@@ -84,14 +261,166 @@ func (c *Config) ValidateZones() error {
 			}
 		}
 
+		if zone.ReflectAAAA {
+			if zone.Has4via6() {
+				return fmt.Errorf("zone %s: reflectAAAA and 4via6 are exclusive", name)
+			}
+			if zone.ReflectedDomain == "" {
+				return fmt.Errorf("zone %s: needs reflectedDomain for reflectAAAA", name)
+			}
+			if ip := net.ParseIP(zone.ReflectedDomain); ip != nil && ip.To4() != nil {
+				return fmt.Errorf("zone %s: reflectAAAA needs an IPv6 or domain reflectedDomain", name)
+			}
+		}
+
 		if zone.Cache != nil && zone.Cache.TTL != "" {
 			if _, err := time.ParseDuration(zone.Cache.TTL); err != nil {
 				return fmt.Errorf("zone %s: bad cache TTL", name)
 			}
 		}
 
-		if zone.AllowExternalClients && zone.Has4via6() {
-			return fmt.Errorf("zone %s: no external clients on 4via6", name)
+		switch zone.CacheOnlyResponse {
+		case "", CacheOnlyServFail, CacheOnlyRefused, CacheOnlyStale:
+		default:
+			return fmt.Errorf("zone %s: unknown cacheOnlyResponse %q", name, zone.CacheOnlyResponse)
+		}
+
+		if zone.Cache != nil && !isValidCacheOnExpiry(zone.Cache.OnExpiry) {
+			return fmt.Errorf("zone %s: unknown cache onExpiry %q", name, zone.Cache.OnExpiry)
+		}
+
+		if zone.Cache != nil {
+			// A cache that can't hold an entry evicts every answer as it's stored
+			if size := c.CacheMaxSize(zone); size < 1 {
+				return fmt.Errorf("zone %s: cache maxSize must be at least 1, got %d", name, size)
+			}
+			if err := validateCacheDuration("negativeTTL", zone.Cache.NegativeTTL); err != nil {
+				return fmt.Errorf("zone %s: cache: %w", name, err)
+			}
+			if err := validateCacheDuration("minTTL", zone.Cache.MinTTL); err != nil {
+				return fmt.Errorf("zone %s: cache: %w", name, err)
+			}
+		}
+
+		if hc := zone.HealthCheck; hc != nil {
+			if hc.Type != "" {
+				if _, ok := dns.StringToType[strings.ToUpper(hc.Type)]; !ok {
+					return fmt.Errorf("zone %s: unknown healthCheck type %q", name, hc.Type)
+				}
+			}
+			if hc.Interval != "" {
+				if interval, err := time.ParseDuration(hc.Interval); err != nil || interval <= 0 {
+					return fmt.Errorf("zone %s: bad healthCheck interval", name)
+				}
+			}
+			if hc.MinHealthy < 0 || hc.MinHealthy > len(zone.Backend.DNSServers) {
+				return fmt.Errorf("zone %s: healthCheck minHealthy must be between 0 and the number of DNS servers", name)
+			}
+		}
+
+		if zone.MagicDNSName != "" {
+			if !zone.Has4via6() {
+				return fmt.Errorf("zone %s: magicDNSName needs 4via6", name)
+			}
+			if _, ok := dns.IsDomainName(zone.MagicDNSName); !ok || net.ParseIP(zone.MagicDNSName) != nil {
+				return fmt.Errorf("zone %s: bad magicDNSName %q", name, zone.MagicDNSName)
+			}
+		} else if zone.CNAMEOnly {
+			return fmt.Errorf("zone %s: cnameOnly needs magicDNSName", name)
+		}
+
+		for _, cidr := range zone.ExpectedCIDRs {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return fmt.Errorf("zone %s: bad expectedCIDRs entry %q", name, cidr)
+			}
+		}
+
+		// Parsed once here, so queries match client prefixes without reparsing
+		allowed, err := parseClientPrefixes(zone.AllowedClients)
+		if err != nil {
+			return fmt.Errorf("zone %s: allowedClients: %w", name, err)
+		}
+		denied, err := parseClientPrefixes(zone.DeniedClients)
+		if err != nil {
+			return fmt.Errorf("zone %s: deniedClients: %w", name, err)
+		}
+		zone.allowedPrefixes, zone.deniedPrefixes = allowed, denied
+
+		switch zone.Via6Order {
+		case "", Via6OrderFirst, Via6OrderLast:
+		default:
+			return fmt.Errorf("zone %s: via6Order must be %q or %q", name, Via6OrderFirst, Via6OrderLast)
+		}
+
+		if zone.ReresolveInterval != "" {
+			if d, err := time.ParseDuration(zone.ReresolveInterval); err != nil || d <= 0 {
+				return fmt.Errorf("zone %s: bad reresolveInterval %q", name, zone.ReresolveInterval)
+			}
+			if !zone.Has4via6() {
+				return fmt.Errorf("zone %s: reresolveInterval needs translateid", name)
+			}
+		}
+
+		switch zone.ReflectMode {
+		case "", ReflectModeVia6:
+		case ReflectModeDirect:
+			if !zone.Has4via6() {
+				return fmt.Errorf("zone %s: reflectMode %q needs translateid; use reflectAAAA without 4via6", name, ReflectModeDirect)
+			}
+			if zone.PassthroughAAAA {
+				return fmt.Errorf("zone %s: reflectMode %q already answers real AAAA; drop passthroughAAAA", name, ReflectModeDirect)
+			}
+		default:
+			return fmt.Errorf("zone %s: reflectMode must be %q or %q", name, ReflectModeVia6, ReflectModeDirect)
+		}
+
+		switch zone.BackendStrategy {
+		case "", BackendStrategySequential, BackendStrategyParallel:
+		default:
+			return fmt.Errorf("zone %s: backendStrategy must be %q or %q", name, BackendStrategySequential, BackendStrategyParallel)
+		}
+
+		if zone.ResponseTimeFloor != "" {
+			if floor, err := time.ParseDuration(zone.ResponseTimeFloor); err != nil || floor < 0 {
+				return fmt.Errorf("zone %s: bad responseTimeFloor", name)
+			}
+		}
+
+		if soa := zone.SOA; soa != nil {
+			for field, value := range map[string]string{"mname": soa.MName, "rname": soa.RName} {
+				if _, ok := dns.IsDomainName(value); value != "" && !ok {
+					return fmt.Errorf("zone %s: bad soa %s %q", name, field, value)
+				}
+			}
+		}
+
+		for _, ns := range zone.Nameservers {
+			if _, ok := dns.IsDomainName(ns); !ok || ns == "" {
+				return fmt.Errorf("zone %s: bad nameserver %q", name, ns)
+			}
+		}
+
+		if zone.TTL != nil && *zone.TTL > MaxTTL {
+			return fmt.Errorf("zone %s: ttl must be at most %d, got %d", name, MaxTTL, *zone.TTL)
+		}
+
+		if zone.MaxCNAMEDepth < 0 {
+			return fmt.Errorf("zone %s: maxCnameDepth must not be negative, got %d", name, zone.MaxCNAMEDepth)
+		}
+
+		if zone.ServeStale != nil && zone.ServeStale.MaxStale != "" {
+			if _, err := time.ParseDuration(zone.ServeStale.MaxStale); err != nil {
+				return fmt.Errorf("zone %s: bad serveStale maxStale", name)
+			}
+		}
+
+		for _, warm := range zone.Warmup {
+			if _, ok := dns.IsDomainName(warm); !ok || warm == "" {
+				return fmt.Errorf("zone %s: bad warmup name %q", name, warm)
+			}
+			if !zone.matchesAnyDomain(warm) {
+				return fmt.Errorf("zone %s: warmup name %s is outside the zone's domains", name, warm)
+			}
 		}
 	}
 
@@ -106,4 +435,266 @@ func (z *Zone) Has4via6() bool {
 	return z.TranslateID != nil && *z.TranslateID != 0
 }
 
+// ReflectsDirectly reports whether the zone answers AAAA queries with the
+// reflected name's own AAAA records instead of 4via6 addresses
+func (z *Zone) ReflectsDirectly() bool {
+	return z.Has4via6() && z.ReflectMode == ReflectModeDirect
+}
+
+// CompressResponses reports whether responses for zone (nil for queries
+// without a zone) use DNS name compression. Compression is on unless disabled.
+func (c *Config) CompressResponses(zone *Zone) bool {
+	if zone != nil && zone.Compress != nil {
+		return *zone.Compress
+	}
+	return c.Global.Compress == nil || *c.Global.Compress
+}
+
+// CacheMaxSize returns how many entries zone's cache holds: its own maxSize,
+// or the global one when unset
+func (c *Config) CacheMaxSize(zone *Zone) int {
+	if zone.Cache != nil && zone.Cache.MaxSize != 0 {
+		return zone.Cache.MaxSize
+	}
+	return c.Global.Cache.MaxSize
+}
+
+// ResponseFloor returns the minimum time to answer the zone's queries in
+// (0 = answer as soon as possible)
+func (z *Zone) ResponseFloor() time.Duration {
+	floor, err := time.ParseDuration(z.ResponseTimeFloor)
+	if err != nil || floor < 0 {
+		return 0
+	}
+	return floor
+}
+
+// StaleRetention returns how long expired cache entries are kept for stale
+// answers, or 0 if the zone never serves stale
+func (z *Zone) StaleRetention() time.Duration {
+	var maxStale string
+	switch {
+	case z.ServeStale != nil:
+		maxStale = z.ServeStale.MaxStale
+	case z.CacheOnExpiry() == CacheOnExpiryEvict:
+		return 0
+	}
+	retention, err := time.ParseDuration(maxStale)
+	if err != nil {
+		return time.Hour
+	}
+	return retention
+}
+
+// CacheOnExpiry returns the zone's cache on-expiry policy
+func (z *Zone) CacheOnExpiry() string {
+	if z.Cache == nil || z.Cache.OnExpiry == "" {
+		return CacheOnExpiryEvict
+	}
+	return z.Cache.OnExpiry
+}
+
+// AllowsClient reports whether the client at ip may query the zone: not
+// within deniedClients, and within allowedClients if the zone sets any. A
+// client matching both lists is denied.
+func (z *Zone) AllowsClient(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	for _, prefix := range z.deniedPrefixes {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	if len(z.allowedPrefixes) == 0 {
+		return true
+	}
+	for _, prefix := range z.allowedPrefixes {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseClientPrefixes parses client CIDRs; a bare address is its own /32 or /128
+func parseClientPrefixes(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(entry)
+			if addrErr != nil {
+				return nil, fmt.Errorf("bad CIDR %q", entry)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// MaxTTL is the largest TTL a record may carry (RFC 2181 section 8)
+const MaxTTL = 1<<31 - 1
+
+// AnswerTTL returns the TTL of the zone's synthesized answers: its own ttl,
+// or defaultTTL if it sets none
+func (z *Zone) AnswerTTL(defaultTTL uint32) uint32 {
+	if z.TTL == nil {
+		return defaultTTL
+	}
+	return *z.TTL
+}
 
+// DefaultMaxCNAMEDepth is how many CNAMEs are followed resolving a reflected
+// domain unless the zone sets maxCnameDepth
+const DefaultMaxCNAMEDepth = 8
+
+// CNAMEDepth returns the most CNAMEs followed resolving the zone's reflected
+// domain before giving up
+func (z *Zone) CNAMEDepth() int {
+	if z.MaxCNAMEDepth <= 0 {
+		return DefaultMaxCNAMEDepth
+	}
+	return z.MaxCNAMEDepth
+}
+
+// DefaultNegativeTTL is how long negative answers without an SOA are cached
+const DefaultNegativeTTL = 60 * time.Second
+
+// NegativeCacheTTL returns how long the zone caches NXDOMAIN and NODATA
+// answers that carry no SOA to take the negative TTL from
+func (z *Zone) NegativeCacheTTL() time.Duration {
+	if z.Cache == nil || z.Cache.NegativeTTL == "" {
+		return DefaultNegativeTTL
+	}
+	ttl, err := time.ParseDuration(z.Cache.NegativeTTL)
+	if err != nil || ttl < 0 {
+		return DefaultNegativeTTL
+	}
+	return ttl
+}
+
+// CacheMinTTL returns the shortest time the zone caches an answer, even when
+// its upstream TTL is lower
+func (z *Zone) CacheMinTTL() time.Duration {
+	if z.Cache == nil || z.Cache.MinTTL == "" {
+		return 0
+	}
+	ttl, err := time.ParseDuration(z.Cache.MinTTL)
+	if err != nil || ttl < 0 {
+		return 0
+	}
+	return ttl
+}
+
+// CachePreservesAA reports whether the zone serves cached backend answers
+// with the AA bit the backend set, rather than clearing it because an answer
+// from the cache isn't authoritative
+func (z *Zone) CachePreservesAA() bool {
+	return z.Cache != nil && z.Cache.PreserveAA != nil && *z.Cache.PreserveAA
+}
+
+// ReresolveEvery returns how often the zone's reflected names are resolved
+// again in the background, or 0 if they are resolved when queried
+func (z *Zone) ReresolveEvery() time.Duration {
+	d, err := time.ParseDuration(z.ReresolveInterval)
+	if err != nil || d <= 0 {
+		return 0
+	}
+	return d
+}
+
+func validateCacheDuration(field, value string) error {
+	if value == "" {
+		return nil
+	}
+	if d, err := time.ParseDuration(value); err != nil || d < 0 {
+		return fmt.Errorf("bad %s %q", field, value)
+	}
+	return nil
+}
+
+func isValidCacheOnExpiry(policy string) bool {
+	switch policy {
+	case "", CacheOnExpiryEvict, CacheOnExpiryRefreshSync, CacheOnExpiryServeStaleAsync:
+		return true
+	}
+	return false
+}
+
+// Protocols DNS servers are queried over, chosen by the server entry's scheme
+const (
+	BackendProtocolDNS   = "dns"   // Plain DNS over UDP, retried over TCP when truncated
+	BackendProtocolTLS   = "tls"   // DNS over TLS (RFC 7858)
+	BackendProtocolHTTPS = "https" // DNS over HTTPS (RFC 8484)
+)
+
+// BackendTLSScheme marks DNS servers queried over TLS (RFC 7858)
+const BackendTLSScheme = "tls://"
+
+// BackendHTTPSScheme marks DNS servers queried over HTTPS (RFC 8484); the
+// entry is the full query URL, e.g. https://dns.google/dns-query
+const BackendHTTPSScheme = "https://"
+
+// DefaultTLSPort is the DNS over TLS port used when a tls:// server has none
+const DefaultTLSPort = "853"
+
+// ParseBackend returns what to dial for a DNS server entry and the protocol it
+// is queried over. tls:// servers default to port 853, https:// servers are
+// returned as their URL and plain servers as they are.
+func ParseBackend(server string) (addr string, protocol string, err error) {
+	if strings.HasPrefix(server, BackendHTTPSScheme) {
+		u, err := url.Parse(server)
+		if err != nil || u.Hostname() == "" {
+			return "", "", fmt.Errorf("bad DNS over HTTPS server %q", server)
+		}
+		return server, BackendProtocolHTTPS, nil
+	}
+
+	rest, ok := strings.CutPrefix(server, BackendTLSScheme)
+	if !ok {
+		if scheme, _, found := strings.Cut(server, "://"); found {
+			return "", "", fmt.Errorf("DNS server %q: unsupported scheme %q", server, scheme)
+		}
+		if !isHostPort(server) {
+			return "", "", fmt.Errorf("bad DNS server %q: want host:port", server)
+		}
+		return server, BackendProtocolDNS, nil
+	}
+	if _, _, err := net.SplitHostPort(rest); err != nil {
+		rest = net.JoinHostPort(strings.Trim(rest, "[]"), DefaultTLSPort)
+	}
+	if !isHostPort(rest) {
+		return "", "", fmt.Errorf("bad DNS over TLS server %q", server)
+	}
+	return rest, BackendProtocolTLS, nil
+}
+
+// isHostPort reports whether addr is a host with a numeric port, as plain DNS
+// servers are dialed without any default port
+func isHostPort(addr string) bool {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host == "" {
+		return false
+	}
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n <= 65535
+}
+
+func validateDNSServers(servers []string) error {
+	for _, server := range servers {
+		if _, _, err := ParseBackend(server); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateSourceAddress(addr string) error {
+	if addr == "" {
+		return nil
+	}
+	if net.ParseIP(addr) == nil {
+		return fmt.Errorf("bad sourceAddress %q", addr)
+	}
+	return nil
+}
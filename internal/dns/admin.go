@@ -0,0 +1,151 @@
+package dns
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+	"time"
+
+	"github.com/rajsingh/tsdnsreflector/internal/cache"
+	"github.com/rajsingh/tsdnsreflector/internal/metrics"
+)
+
+// Admin endpoints, served when an admin token is configured
+const (
+	CacheFlushPath = "/cache/flush"
+	CacheDumpPath  = "/cache/dump"
+)
+
+// PprofPath is where profiling endpoints are served when enabled
+const PprofPath = "/debug/pprof/"
+
+// registerPprof serves the net/http/pprof endpoints on mux. They are not
+// authenticated, so they are only registered when explicitly enabled.
+func registerPprof(mux *http.ServeMux) {
+	mux.HandleFunc(PprofPath, pprof.Index)
+	mux.HandleFunc(PprofPath+"cmdline", pprof.Cmdline)
+	mux.HandleFunc(PprofPath+"profile", pprof.Profile)
+	mux.HandleFunc(PprofPath+"symbol", pprof.Symbol)
+	mux.HandleFunc(PprofPath+"trace", pprof.Trace)
+}
+
+// cacheFlushResponse reports the entries cleared per zone
+type cacheFlushResponse struct {
+	Cleared map[string]int `json:"cleared"`
+}
+
+// cacheDumpResponse lists the cached entries per zone
+type cacheDumpResponse struct {
+	Zones map[string]cacheDump `json:"zones"`
+}
+
+type cacheDump struct {
+	Entries     []cacheDumpEntry `json:"entries"`
+	Total       int              `json:"total"`     // Entries in the cache, listed or not
+	Truncated   bool             `json:"truncated"` // Whether entries beyond the dump limit were left out
+	MemoryBytes int64            `json:"memoryBytes"`
+}
+
+type cacheDumpEntry struct {
+	Key   string `json:"key"`
+	TTL   int64  `json:"ttl"`             // Seconds until the entry expires
+	Stale bool   `json:"stale,omitempty"` // Expired, kept only for stale answers
+	Size  int64  `json:"size"`            // Estimated bytes
+}
+
+// authorized reports whether r carries the admin bearer token. Without a
+// configured token nothing is authorized.
+func (s *Server) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && s.runtimeCfg.AdminToken != "" &&
+		subtle.ConstantTimeCompare([]byte(token), []byte(s.runtimeCfg.AdminToken)) == 1
+}
+
+// adminCaches checks an admin request's method and token, then returns the
+// cache of the zone named by its zone query parameter, or every zone cache
+// without one. On failure the error response is written and ok is false.
+func (s *Server) adminCaches(w http.ResponseWriter, r *http.Request, method string) (caches map[string]*cache.ZoneCache, ok bool) {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	zoneName := r.URL.Query().Get("zone")
+	if zoneName == "" {
+		return s.zoneCaches, true
+	}
+	zoneCache, exists := s.zoneCaches[zoneName]
+	if !exists {
+		http.Error(w, "unknown or uncached zone "+zoneName, http.StatusNotFound)
+		return nil, false
+	}
+	return map[string]*cache.ZoneCache{zoneName: zoneCache}, true
+}
+
+// cacheFlushHandler clears the cache of the zone named by the zone query
+// parameter, or of every zone without one
+func (s *Server) cacheFlushHandler(w http.ResponseWriter, r *http.Request) {
+	caches, ok := s.adminCaches(w, r, http.MethodPost)
+	if !ok {
+		return
+	}
+
+	resp := cacheFlushResponse{Cleared: make(map[string]int, len(caches))}
+	for zoneName, zoneCache := range caches {
+		resp.Cleared[zoneName] = zoneCache.Clear()
+		metrics.UpdateCacheSize(zoneName, 0)
+		if s.memoryMonitor != nil {
+			_ = s.memoryMonitor.UpdateCacheUsage(zoneName, 0)
+		}
+	}
+	s.logger.Info("Flushed zone caches", "cleared", resp.Cleared)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// cacheDumpHandler lists the keys, remaining TTLs and sizes of the entries in
+// one zone's cache or every zone cache, up to the dump limit per zone
+func (s *Server) cacheDumpHandler(w http.ResponseWriter, r *http.Request) {
+	caches, ok := s.adminCaches(w, r, http.MethodGet)
+	if !ok {
+		return
+	}
+
+	limit := s.runtimeCfg.CacheDumpLimit()
+	now := time.Now()
+	resp := cacheDumpResponse{Zones: make(map[string]cacheDump, len(caches))}
+	for zoneName, zoneCache := range caches {
+		entries, total := zoneCache.Entries(limit)
+		dump := cacheDump{
+			Entries:     make([]cacheDumpEntry, 0, len(entries)),
+			Total:       total,
+			Truncated:   total > len(entries),
+			MemoryBytes: zoneCache.MemoryUsage(),
+		}
+		for _, entry := range entries {
+			remaining := entry.ExpiresAt.Sub(now)
+			dump.Entries = append(dump.Entries, cacheDumpEntry{
+				Key:   entry.Key,
+				TTL:   int64(max(remaining, 0) / time.Second),
+				Stale: remaining <= 0,
+				Size:  entry.Size,
+			})
+		}
+		resp.Zones[zoneName] = dump
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
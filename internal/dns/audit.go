@@ -0,0 +1,29 @@
+package dns
+
+import (
+	"net/netip"
+
+	"github.com/miekg/dns"
+)
+
+// Audit decisions for external-client queries
+const (
+	auditAllowed = "allowed"
+	auditBlocked = "blocked"
+)
+
+// auditExternalAccess writes one audit record for an external client's query.
+// The fields form a stable schema for audit pipelines; see docs/CONFIGURATION.md.
+func (h *TailscaleDNSHandler) auditExternalAccess(clientIP netip.Addr, zoneName string, question dns.Question, decision string) {
+	args := []any{
+		"client", clientIP.String(),
+		"zone", zoneName,
+		"name", question.Name,
+		"type", dns.Type(question.Qtype).String(),
+		"decision", decision,
+	}
+	if h.runtimeCfg.AuditRetention > 0 {
+		args = append(args, "retention", h.runtimeCfg.AuditRetention.String())
+	}
+	h.audit.Info("external_access", args...)
+}
@@ -0,0 +1,96 @@
+package dns
+
+import (
+	"runtime/debug"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/rajsingh/tsdnsreflector/internal/config"
+)
+
+// Version is reported to CHAOS version queries. Set it at build time with
+// -ldflags "-X github.com/rajsingh/tsdnsreflector/internal/dns.Version=v1.2.3";
+// otherwise the module version from the build info is used.
+var Version = ""
+
+// Commit is the source revision reported by the build info metric. Set it at
+// build time like Version; otherwise the VCS revision from the build info is used.
+var Commit = ""
+
+func serverVersion() string {
+	if Version != "" {
+		return Version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "dev"
+}
+
+func serverCommit() string {
+	if Commit != "" {
+		return Commit
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				return setting.Value
+			}
+		}
+	}
+	return "unknown"
+}
+
+// revealIdentity reports whether the version query policy lets a client see
+// the server's real version and instance identity
+func (h *TailscaleDNSHandler) revealIdentity(isTailscaleClient bool) bool {
+	switch h.runtimeCfg.VersionQueries {
+	case config.VersionQueriesAll:
+		return true
+	case config.VersionQueriesNone:
+		return false
+	}
+	return isTailscaleClient
+}
+
+// nsid returns the NSID (RFC 5001) shown to a client, empty if none
+func (h *TailscaleDNSHandler) nsid(isTailscaleClient bool) string {
+	if !h.revealIdentity(isTailscaleClient) {
+		return ""
+	}
+	return h.runtimeCfg.NSID
+}
+
+// handleChaosQuery answers CHAOS-class server identity queries (version.bind,
+// version.server, hostname.bind, id.server). The real values are only revealed
+// to clients allowed by the version query policy; everyone else gets the
+// obfuscated string, or no answer if it is empty. Other CHAOS queries are refused.
+func (h *TailscaleDNSHandler) handleChaosQuery(w dns.ResponseWriter, r *dns.Msg, question dns.Question, isTailscaleClient bool) {
+	msg := new(dns.Msg)
+	msg.SetReply(r)
+
+	var value string
+	switch strings.ToLower(question.Name) {
+	case "version.bind.", "version.server.":
+		value = serverVersion()
+	case "hostname.bind.", "id.server.":
+		value = h.runtimeCfg.Hostname
+	}
+	if value == "" || question.Qtype != dns.TypeTXT {
+		msg.Rcode = dns.RcodeRefused
+		_ = w.WriteMsg(msg)
+		return
+	}
+
+	if !h.revealIdentity(isTailscaleClient) {
+		value = h.runtimeCfg.VersionObfuscated
+	}
+
+	if value != "" {
+		msg.Answer = append(msg.Answer, &dns.TXT{
+			Hdr: dns.RR_Header{Name: question.Name, Rrtype: dns.TypeTXT, Class: dns.ClassCHAOS, Ttl: 0},
+			Txt: []string{value},
+		})
+	}
+	_ = w.WriteMsg(msg)
+}
@@ -0,0 +1,125 @@
+package dns
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rajsingh/tsdnsreflector/internal/config"
+	"github.com/rajsingh/tsdnsreflector/internal/metrics"
+)
+
+// circuitBreaker stops sending queries to a backend after consecutive failures.
+// While the circuit is open the backend is probed in the background once per
+// cooldown, so client queries never wait on it; a passing probe closes the
+// circuit. A nil circuitBreaker allows every backend.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	backends  map[string]*circuitState
+	stop      chan struct{}
+	once      sync.Once
+}
+
+type circuitState struct {
+	failures int
+	open     bool
+	zones    map[string]bool // Zones whose queries failed, for the backend_healthy gauge
+}
+
+func newCircuitBreaker(cfg *config.CircuitBreaker) *circuitBreaker {
+	if cfg == nil || cfg.Disabled {
+		return nil
+	}
+	return &circuitBreaker{
+		threshold: cfg.FailureThreshold,
+		cooldown:  parseTimeout(cfg.Cooldown),
+		backends:  make(map[string]*circuitState),
+		stop:      make(chan struct{}),
+	}
+}
+
+// Allow reports whether backend may be queried
+func (cb *circuitBreaker) Allow(backend string) bool {
+	if cb == nil {
+		return true
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	state, ok := cb.backends[backend]
+	return !ok || !state.open
+}
+
+func (cb *circuitBreaker) RecordSuccess(backend string) {
+	if cb == nil {
+		return
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if state, ok := cb.backends[backend]; ok {
+		if state.open {
+			metrics.UpdateBackendCircuitState(backend, false)
+			for zoneName := range state.zones {
+				metrics.UpdateBackendHealth(zoneName, backend, true)
+			}
+		}
+		delete(cb.backends, backend)
+	}
+}
+
+// RecordFailure counts a failed query from zoneName and reports whether it
+// opened backend's circuit. The caller then starts probing the backend.
+func (cb *circuitBreaker) RecordFailure(zoneName, backend string) (opened bool) {
+	if cb == nil {
+		return false
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	state, ok := cb.backends[backend]
+	if !ok {
+		state = &circuitState{zones: make(map[string]bool)}
+		cb.backends[backend] = state
+	}
+	state.failures++
+	state.zones[zoneName] = true
+	if state.open || state.failures < cb.threshold {
+		return false
+	}
+
+	state.open = true
+	metrics.UpdateBackendCircuitState(backend, true)
+	for zoneName := range state.zones {
+		metrics.UpdateBackendHealth(zoneName, backend, false)
+	}
+	return true
+}
+
+// probe runs check against an open backend after every cooldown until it
+// passes, then closes the circuit. It gives up when the breaker is stopped.
+func (cb *circuitBreaker) probe(backend string, check func() bool) {
+	ticker := time.NewTicker(cb.cooldown)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-cb.stop:
+			return
+		}
+		if check() {
+			cb.RecordSuccess(backend)
+			return
+		}
+	}
+}
+
+// Stop ends the background probes of open circuits
+func (cb *circuitBreaker) Stop() {
+	if cb == nil {
+		return
+	}
+	cb.once.Do(func() { close(cb.stop) })
+}
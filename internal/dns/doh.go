@@ -0,0 +1,85 @@
+package dns
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/rajsingh/tsdnsreflector/internal/doh"
+)
+
+// dohIdleTimeout is how long an idle connection to a DNS over HTTPS server is
+// kept open for the next query
+const dohIdleTimeout = 90 * time.Second
+
+// dohBackend is a DNS over HTTPS server and the HTTP client its queries go
+// through, dialling over TSNet when the forwarder does
+type dohBackend struct {
+	url    string
+	client *http.Client
+}
+
+func (b *dohBackend) exchange(ctx context.Context, r *dns.Msg) (*dns.Msg, error) {
+	return doh.Exchange(ctx, b.client, b.url, r)
+}
+
+// newDoHBackend returns a dohBackend for url dialled the way f dials backends.
+// Idle connections are kept only when the backend is shared through
+// dohBackends, since forwarders are created per query.
+func (f *Forwarder) newDoHBackend(url string, keepAlive bool) *dohBackend {
+	transport := &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: f.tlsRoots, MinVersion: tls.VersionTLS12},
+		ForceAttemptHTTP2: true,
+		IdleConnTimeout:   dohIdleTimeout,
+		DisableKeepAlives: !keepAlive,
+	}
+	if f.tsnetServer != nil {
+		transport.DialContext = f.tsnetServer.Dial
+	} else {
+		dialer := &net.Dialer{Timeout: f.timeout}
+		if f.sourceAddr != nil {
+			dialer.LocalAddr = &net.TCPAddr{IP: f.sourceAddr}
+		}
+		transport.DialContext = dialer.DialContext
+	}
+	return &dohBackend{url: url, client: &http.Client{Transport: transport}}
+}
+
+// dohBackends shares a dohBackend per server and route across forwarders, so
+// queries reuse HTTPS connections. A nil dohBackends gives every query its own
+// connection.
+type dohBackends struct {
+	mu       sync.Mutex
+	backends map[dohKey]*dohBackend
+}
+
+type dohKey struct {
+	url        string
+	tsnet      bool
+	sourceAddr string
+}
+
+func newDoHBackends() *dohBackends {
+	return &dohBackends{backends: make(map[dohKey]*dohBackend)}
+}
+
+// get returns the dohBackend for url as f dials it
+func (d *dohBackends) get(f *Forwarder, url string) *dohBackend {
+	if d == nil {
+		return f.newDoHBackend(url, false)
+	}
+
+	key := dohKey{url: url, tsnet: f.tsnetServer != nil, sourceAddr: f.sourceAddr.String()}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	backend, ok := d.backends[key]
+	if !ok {
+		backend = f.newDoHBackend(url, true)
+		d.backends[key] = backend
+	}
+	return backend
+}
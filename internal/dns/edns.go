@@ -0,0 +1,82 @@
+package dns
+
+import (
+	"encoding/hex"
+
+	"github.com/miekg/dns"
+)
+
+// ednsResponseWriter negotiates EDNS0 (RFC 6891) for every response: clients
+// that sent an OPT record get one back advertising our UDP payload size, other
+// clients get none, and UDP responses larger than the client's buffer are
+// truncated with the TC bit set so the client retries over TCP. Clients
+// asking for an NSID (RFC 5001) get nsid, or none if it is empty.
+type ednsResponseWriter struct {
+	dns.ResponseWriter
+	req     *dns.Msg
+	udpSize uint16 // Payload size advertised to EDNS clients
+	nsid    string // Instance identifier for NSID requests
+}
+
+func (w *ednsResponseWriter) WriteMsg(m *dns.Msg) error {
+	reqOpt := w.req.IsEdns0()
+	if reqOpt == nil {
+		// Answers cached for EDNS clients may carry an OPT this client didn't ask for
+		stripOPT(m)
+	} else if m.IsEdns0() == nil {
+		// Upstream OPT records are kept as they are
+		m.SetEdns0(w.udpSize, reqOpt.Do())
+	}
+	if reqOpt != nil && hasNSID(reqOpt) {
+		setNSID(m.IsEdns0(), w.nsid)
+	}
+
+	// Truncate decides on compression itself, so leave fitting messages alone
+	// to keep the zone's compress setting
+	if size := clientUDPSize(reqOpt); isUDP(w.ResponseWriter) && m.Len() > size {
+		m.Truncate(size)
+	}
+	return w.ResponseWriter.WriteMsg(m)
+}
+
+// clientUDPSize returns the largest UDP response the client accepts
+func clientUDPSize(opt *dns.OPT) int {
+	if opt == nil || opt.UDPSize() < dns.MinMsgSize {
+		return dns.MinMsgSize
+	}
+	return int(opt.UDPSize())
+}
+
+func hasNSID(opt *dns.OPT) bool {
+	for _, option := range opt.Option {
+		if option.Option() == dns.EDNS0NSID {
+			return true
+		}
+	}
+	return false
+}
+
+// setNSID replaces any NSID in opt, such as a backend's, with nsid, or just
+// removes it if nsid is empty
+func setNSID(opt *dns.OPT, nsid string) {
+	options := opt.Option[:0]
+	for _, option := range opt.Option {
+		if option.Option() != dns.EDNS0NSID {
+			options = append(options, option)
+		}
+	}
+	if nsid != "" {
+		options = append(options, &dns.EDNS0_NSID{Code: dns.EDNS0NSID, Nsid: hex.EncodeToString([]byte(nsid))})
+	}
+	opt.Option = options
+}
+
+func stripOPT(m *dns.Msg) {
+	extra := m.Extra[:0]
+	for _, rr := range m.Extra {
+		if rr.Header().Rrtype != dns.TypeOPT {
+			extra = append(extra, rr)
+		}
+	}
+	m.Extra = extra
+}
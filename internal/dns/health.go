@@ -0,0 +1,169 @@
+package dns
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/rajsingh/tsdnsreflector/internal/config"
+	"github.com/rajsingh/tsdnsreflector/internal/logger"
+	"github.com/rajsingh/tsdnsreflector/internal/metrics"
+	"github.com/rajsingh/tsdnsreflector/internal/tailscale"
+)
+
+// healthChecker periodically probes the backends of zones with a healthCheck
+// and tracks which are up. Backends that have not been probed yet, and all
+// backends of zones without a healthCheck, count as healthy. A nil
+// healthChecker reports every backend healthy.
+type healthChecker struct {
+	mu     sync.RWMutex
+	status map[string]map[string]bool // zone -> backend -> healthy
+	stop   chan struct{}
+	once   sync.Once
+	wg     sync.WaitGroup
+	logger *logger.Logger
+}
+
+func newHealthChecker(log *logger.Logger) *healthChecker {
+	return &healthChecker{
+		status: make(map[string]map[string]bool),
+		stop:   make(chan struct{}),
+		logger: log,
+	}
+}
+
+// Start launches a probe loop for every zone with a healthCheck. Probes go
+// through TSNet when tsnetServer is set, like client queries.
+func (hc *healthChecker) Start(cfg *config.Config, tsnetServer *tailscale.TSNetServer) {
+	for zoneName, zone := range cfg.Zones {
+		if zone.HealthCheck == nil {
+			continue
+		}
+
+		var forwarder *Forwarder
+		if tsnetServer != nil {
+			forwarder = NewForwarderWithTSNet(zone.Backend, hc.logger, tsnetServer)
+		} else {
+			forwarder = NewForwarder(zone.Backend, hc.logger)
+		}
+
+		probe := new(dns.Msg)
+		probe.SetQuestion(dns.Fqdn(zone.HealthCheck.Name), dns.StringToType[strings.ToUpper(zone.HealthCheck.Type)])
+		interval := parseTimeout(zone.HealthCheck.Interval)
+
+		hc.wg.Add(1)
+		go hc.run(zoneName, forwarder, probe, interval)
+		hc.logger.ZoneInfo(zoneName, "Backend health checks started", "query", probe.Question[0].Name, "type", zone.HealthCheck.Type, "interval", interval)
+	}
+}
+
+// inherit carries over previous's last results for the backends cfg still
+// health-checks, so replacing the checker on reload doesn't reset readiness
+func (hc *healthChecker) inherit(previous *healthChecker, cfg *config.Config) {
+	if previous == nil {
+		return
+	}
+	previous.mu.RLock()
+	defer previous.mu.RUnlock()
+
+	for zoneName, zone := range cfg.Zones {
+		if zone.HealthCheck == nil || previous.status[zoneName] == nil {
+			continue
+		}
+		for _, backend := range zone.Backend.DNSServers {
+			if healthy, known := previous.status[zoneName][backend]; known {
+				if hc.status[zoneName] == nil {
+					hc.status[zoneName] = make(map[string]bool)
+				}
+				hc.status[zoneName][backend] = healthy
+			}
+		}
+	}
+}
+
+// Stop ends all probe loops and waits for them to exit
+func (hc *healthChecker) Stop() {
+	if hc == nil {
+		return
+	}
+	hc.once.Do(func() { close(hc.stop) })
+	hc.wg.Wait()
+}
+
+func (hc *healthChecker) run(zoneName string, forwarder *Forwarder, probe *dns.Msg, interval time.Duration) {
+	defer hc.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for _, backend := range forwarder.backends {
+			hc.check(zoneName, forwarder, backend, probe)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-hc.stop:
+			return
+		}
+	}
+}
+
+// check probes backend once. Any answer other than SERVFAIL or REFUSED
+// (including NXDOMAIN) means the backend is up.
+func (hc *healthChecker) check(zoneName string, forwarder *Forwarder, backend string, probe *dns.Msg) {
+	req := probe.Copy()
+	req.Id = dns.Id()
+
+	resp, err := forwarder.queryBackend(context.Background(), req, backend, zoneName)
+	healthy := err == nil && resp.Rcode != dns.RcodeServerFailure && resp.Rcode != dns.RcodeRefused
+
+	hc.mu.Lock()
+	if hc.status[zoneName] == nil {
+		hc.status[zoneName] = make(map[string]bool)
+	}
+	previous, known := hc.status[zoneName][backend]
+	hc.status[zoneName][backend] = healthy
+	hc.mu.Unlock()
+
+	metrics.UpdateBackendHealth(zoneName, backend, healthy)
+	if known && previous != healthy {
+		if healthy {
+			hc.logger.ZoneInfo(zoneName, "Backend healthy again", "backend", backend)
+		} else {
+			hc.logger.ZoneWarn(zoneName, "Backend failed health check", "backend", backend, "error", err)
+		}
+	}
+}
+
+// HealthyCount returns how many of the zone's backends passed their last
+// health check. Unlike Healthy, backends not probed yet don't count.
+func (hc *healthChecker) HealthyCount(zoneName string) int {
+	if hc == nil {
+		return 0
+	}
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+
+	count := 0
+	for _, healthy := range hc.status[zoneName] {
+		if healthy {
+			count++
+		}
+	}
+	return count
+}
+
+// Healthy reports whether backend passed its zone's last health check
+func (hc *healthChecker) Healthy(zoneName, backend string) bool {
+	if hc == nil {
+		return true
+	}
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+
+	healthy, known := hc.status[zoneName][backend]
+	return !known || healthy
+}
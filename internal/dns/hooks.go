@@ -0,0 +1,86 @@
+package dns
+
+import (
+	"context"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// ResponseHook post-processes a zone's response after resolution and before it
+// is written to the client. Returning a nil message with a nil error drops the
+// response; returning an error keeps the original response.
+//
+// Custom hooks are compiled in by registering them from an init function, e.g.
+// in a file added to cmd/tsdnsreflector:
+//
+//	func init() {
+//		dns.RegisterResponseHook("cluster1", myHook{})
+//	}
+type ResponseHook interface {
+	Process(ctx context.Context, zone string, req, resp *dns.Msg) (*dns.Msg, error)
+}
+
+// NopResponseHook returns every response unchanged
+type NopResponseHook struct{}
+
+func (NopResponseHook) Process(_ context.Context, _ string, _, resp *dns.Msg) (*dns.Msg, error) {
+	return resp, nil
+}
+
+var (
+	responseHooks   = make(map[string]ResponseHook)
+	responseHooksMu sync.RWMutex
+)
+
+// RegisterResponseHook registers hook for the named zone, replacing any previous hook
+func RegisterResponseHook(zone string, hook ResponseHook) {
+	responseHooksMu.Lock()
+	defer responseHooksMu.Unlock()
+	responseHooks[zone] = hook
+}
+
+// UnregisterResponseHook removes the hook registered for the named zone
+func UnregisterResponseHook(zone string) {
+	responseHooksMu.Lock()
+	defer responseHooksMu.Unlock()
+	delete(responseHooks, zone)
+}
+
+func isNopResponseHook(hook ResponseHook) bool {
+	_, nop := hook.(NopResponseHook)
+	return nop
+}
+
+func getResponseHook(zone string) ResponseHook {
+	responseHooksMu.RLock()
+	defer responseHooksMu.RUnlock()
+	if hook, ok := responseHooks[zone]; ok {
+		return hook
+	}
+	return NopResponseHook{}
+}
+
+// hookResponseWriter runs the zone's ResponseHook on every message written,
+// with the query's context
+type hookResponseWriter struct {
+	dns.ResponseWriter
+	ctx     context.Context
+	hook    ResponseHook
+	zone    string
+	req     *dns.Msg
+	handler *TailscaleDNSHandler
+}
+
+func (w *hookResponseWriter) WriteMsg(resp *dns.Msg) error {
+	processed, err := w.hook.Process(w.ctx, w.zone, w.req, resp)
+	if err != nil {
+		w.handler.log(w.ctx).ZoneWarn(w.zone, "Response hook failed, sending original response", "error", err)
+		return w.ResponseWriter.WriteMsg(resp)
+	}
+	if processed == nil {
+		w.handler.log(w.ctx).ZoneDebug(w.zone, "Response dropped by hook")
+		return nil
+	}
+	return w.ResponseWriter.WriteMsg(processed)
+}
@@ -0,0 +1,34 @@
+package dns
+
+import (
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// isHealthProbe reports whether question asks for the configured DNS health
+// probe name
+func (h *TailscaleDNSHandler) isHealthProbe(question dns.Question) bool {
+	name := h.runtimeCfg.DNSHealthProbeName
+	return name != "" && question.Qclass == dns.ClassINET &&
+		strings.EqualFold(question.Name, dns.Fqdn(name))
+}
+
+// handleHealthProbe answers the DNS health probe name with a loopback address
+// for A and AAAA and an empty answer for other types, with a zero TTL so
+// resolvers never cache it
+func (h *TailscaleDNSHandler) handleHealthProbe(w dns.ResponseWriter, r *dns.Msg, question dns.Question) {
+	msg := new(dns.Msg)
+	msg.SetReply(r)
+	msg.Authoritative = true
+
+	hdr := dns.RR_Header{Name: question.Name, Rrtype: question.Qtype, Class: dns.ClassINET, Ttl: 0}
+	switch question.Qtype {
+	case dns.TypeA:
+		msg.Answer = append(msg.Answer, &dns.A{Hdr: hdr, A: net.IPv4(127, 0, 0, 1)})
+	case dns.TypeAAAA:
+		msg.Answer = append(msg.Answer, &dns.AAAA{Hdr: hdr, AAAA: net.IPv6loopback})
+	}
+	_ = w.WriteMsg(msg)
+}
@@ -0,0 +1,43 @@
+package dns
+
+import (
+	"context"
+
+	"github.com/miekg/dns"
+	"github.com/rajsingh/tsdnsreflector/internal/config"
+)
+
+// msgAcceptFunc returns the check run on incoming message headers. Under the
+// firstonly policy queries with several questions are let through to be
+// trimmed by ServeDNS; otherwise the library default rejects them.
+func msgAcceptFunc(policy string) dns.MsgAcceptFunc {
+	if policy != config.MultiQuestionFirstOnly {
+		return nil
+	}
+	return func(dh dns.Header) dns.MsgAcceptAction {
+		if dh.Qdcount > 1 {
+			dh.Qdcount = 1
+		}
+		return dns.DefaultMsgAcceptFunc(dh)
+	}
+}
+
+// handleMultiQuestion applies the multi-question policy to r. It reports
+// whether the query was answered with FORMERR; under firstonly r is cut down
+// to its first question instead.
+func (h *TailscaleDNSHandler) handleMultiQuestion(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) bool {
+	if len(r.Question) <= 1 {
+		return false
+	}
+	if h.runtimeCfg.MultiQuestionPolicy == config.MultiQuestionFirstOnly {
+		h.log(ctx).Debug("Answering first of several questions", "questions", len(r.Question), "name", r.Question[0].Name)
+		r.Question = r.Question[:1]
+		return false
+	}
+
+	// Like the library's own rejection, without echoing any question
+	msg := new(dns.Msg)
+	msg.SetRcodeFormatError(r)
+	_ = w.WriteMsg(msg)
+	return true
+}
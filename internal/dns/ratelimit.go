@@ -0,0 +1,107 @@
+package dns
+
+import (
+	"hash/maphash"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+const (
+	rateLimitShards = 32
+
+	// Most clients tracked per shard; a full shard drops its idle buckets,
+	// then an arbitrary one, so spoofed sources can't grow memory unbounded
+	rateLimitShardSize = 4096
+
+	// How often a shard drops buckets that have refilled, which behave the
+	// same as a new bucket
+	rateLimitSweepEvery = time.Minute
+)
+
+// clientRateLimiter is a token bucket per client IP. Each client may send
+// burst queries at once, refilled at qps per second. A nil limiter allows
+// every query.
+type clientRateLimiter struct {
+	qps    float64
+	burst  float64
+	seed   maphash.Seed
+	now    func() time.Time
+	shards [rateLimitShards]rateLimitShard
+}
+
+type rateLimitShard struct {
+	mu        sync.Mutex
+	buckets   map[netip.Addr]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newClientRateLimiter returns a limiter allowing qps queries per second with
+// the given burst, or nil if qps is not positive
+func newClientRateLimiter(qps float64, burst int) *clientRateLimiter {
+	if qps <= 0 || burst <= 0 {
+		return nil
+	}
+	l := &clientRateLimiter{
+		qps:   qps,
+		burst: float64(burst),
+		seed:  maphash.MakeSeed(),
+		now:   time.Now,
+	}
+	for i := range l.shards {
+		l.shards[i].buckets = make(map[netip.Addr]*tokenBucket)
+	}
+	return l
+}
+
+// allow takes a token from ip's bucket, reporting false if it is empty
+func (l *clientRateLimiter) allow(ip netip.Addr) bool {
+	if l == nil || !ip.IsValid() {
+		return true
+	}
+	ip = ip.Unmap()
+	s := &l.shards[maphash.Comparable(l.seed, ip)%rateLimitShards]
+	now := l.now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.lastSweep) >= rateLimitSweepEvery {
+		l.sweep(s, now)
+	}
+	b, ok := s.buckets[ip]
+	if !ok {
+		if len(s.buckets) >= rateLimitShardSize {
+			l.sweep(s, now)
+			for evict := range s.buckets {
+				if len(s.buckets) < rateLimitShardSize {
+					break
+				}
+				delete(s.buckets, evict)
+			}
+		}
+		b = &tokenBucket{tokens: l.burst, last: now}
+		s.buckets[ip] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.qps)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// sweep drops the buckets in s that are full again. Callers hold s.mu.
+func (l *clientRateLimiter) sweep(s *rateLimitShard, now time.Time) {
+	for ip, b := range s.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.qps >= l.burst {
+			delete(s.buckets, ip)
+		}
+	}
+	s.lastSweep = now
+}
@@ -1,29 +1,54 @@
 package dns
 
 import (
+	"cmp"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math/rand/v2"
 	"net"
 	"net/http"
 	"net/netip"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/miekg/dns"
 	via6 "github.com/rajsingh/tsdnsreflector/internal/4via6"
 	"github.com/rajsingh/tsdnsreflector/internal/cache"
 	"github.com/rajsingh/tsdnsreflector/internal/config"
+	"github.com/rajsingh/tsdnsreflector/internal/geoip"
 	"github.com/rajsingh/tsdnsreflector/internal/logger"
 	"github.com/rajsingh/tsdnsreflector/internal/memory"
 	"github.com/rajsingh/tsdnsreflector/internal/metrics"
 	"github.com/rajsingh/tsdnsreflector/internal/tailscale"
+	"golang.org/x/sync/singleflight"
 	"tailscale.com/client/local"
+	"tailscale.com/ipn/ipnstate"
 )
 
+// edeSynthesized is the RFC 8914 "Synthesized" extended error code
+const edeSynthesized uint16 = 29
+
+// defaultMagicDNSSuffix is the domain resolved from tailnet peers via
+// MagicDNS until the tailnet's own domain is known
+const defaultMagicDNSSuffix = "ts.net"
+
 type Server struct {
+	mu            sync.RWMutex // Guards config, via6Trans, forwarder, zoneCaches and health, replaced by ReloadConfig
 	config        *config.Config
 	runtimeCfg    *config.RuntimeConfig
 	dnsServer     *dns.Server
+	udpServers    []*dns.Server   // Serve DNS over UDP on further addresses with dnsServer's handler
+	tcpServers    []*dns.Server   // Serve DNS over TCP with the UDP server's handler
+	tcpConns      *tcpConnLimiter // Caps open TCP connections across tcpServers
 	httpServer    *http.Server
 	via6Trans     *via6.Translator
 	forwarder     *Forwarder
@@ -31,15 +56,52 @@ type Server struct {
 	handler       *TailscaleDNSHandler
 	zoneCaches    map[string]*cache.ZoneCache
 	memoryMonitor *memory.Monitor
+	geoip         *geoip.Resolver
+	health        *healthChecker
 	logger        *logger.Logger
+	dnsServing    atomic.Bool // Set once the main DNS listener is serving, for readiness
 }
 
 type Forwarder struct {
 	backends    []string
 	timeout     time.Duration
 	retries     int
+	sourceAddr  net.IP // Optional local address for outgoing queries
 	logger      *logger.Logger
 	tsnetServer *tailscale.TSNetServer // Optional TSNet server for subnet routing
+	breaker     *circuitBreaker        // Optional, shared across forwarders
+	health      *healthChecker         // Optional, skips backends failing health checks
+
+	staleOnCircuitOpen bool // Serve expired cache entries while every backend's circuit is open
+	staleOnFailure     bool // Serve expired cache entries when every backend fails
+	parallel           bool // Query every backend at once instead of one after another
+
+	targets       map[string]backendTarget // How each backend is dialled, by configured name
+	tlsServerName string                   // Certificate name of tls:// backends (default their host)
+	tlsRoots      *x509.CertPool           // Trusted CAs for tls:// and https:// backends (default the system's)
+	doh           *dohBackends             // Optional, shares https:// backend connections across forwarders
+	inflight      *singleflight.Group      // Optional, shares identical concurrent queries across forwarders
+}
+
+// backendTarget is the address or URL a configured backend is dialled at and
+// the protocol it speaks
+type backendTarget struct {
+	addr     string
+	protocol string
+}
+
+// parseBackendTargets maps configured backends to where they are dialled.
+// Backends are still named as configured everywhere else, e.g. in metrics.
+func parseBackendTargets(servers []string) map[string]backendTarget {
+	targets := make(map[string]backendTarget, len(servers))
+	for _, server := range servers {
+		addr, protocol, err := config.ParseBackend(server)
+		if err != nil {
+			addr, protocol = server, config.BackendProtocolDNS // Rejected by validation; fails when dialled
+		}
+		targets[server] = backendTarget{addr: addr, protocol: protocol}
+	}
+	return targets
 }
 
 func parseTimeout(timeoutStr string) time.Duration {
@@ -64,6 +126,7 @@ func NewServer(cfg *config.Config) (*Server, error) {
 		DefaultTTL:     300,
 		HealthEnabled:  true,
 		HealthPath:     "/health",
+		ReadyPath:      "/ready",
 		MetricsEnabled: true,
 		MetricsPath:    "/metrics",
 		LogLevel:       "info",
@@ -77,23 +140,63 @@ func NewServerWithRuntime(cfg *config.Config, runtimeCfg *config.RuntimeConfig)
 	loggingCfg := runtimeCfg.ToLoggingConfig()
 	log := logger.New(loggingCfg)
 
+	metrics.RecordBuildInfo(serverVersion(), serverCommit(), runtime.Version())
+	metrics.RecordRuntimeConfigInfo(runtimeCfg.LogLevel, runtimeCfg.DNSPort, runtimeCfg.MetricsEnabled, cmp.Or(normalizeMagicDNSSuffix(runtimeCfg.MagicDNSSuffix), defaultMagicDNSSuffix))
+
+	if err := checkZoneLimit(cfg, runtimeCfg); err != nil {
+		return nil, err
+	}
+	if err := runtimeCfg.ValidatePollIntervals(); err != nil {
+		return nil, err
+	}
+	if err := runtimeCfg.ValidateRateLimit(); err != nil {
+		return nil, err
+	}
+	if err := runtimeCfg.ValidateMultiQuestionPolicy(); err != nil {
+		return nil, err
+	}
+	if err := runtimeCfg.ValidateVia6ApexAnswer(); err != nil {
+		return nil, err
+	}
+	if err := runtimeCfg.ValidateVersionQueries(); err != nil {
+		return nil, err
+	}
+	if err := runtimeCfg.ValidateTSBindFamily(); err != nil {
+		return nil, err
+	}
+
 	via6Trans, err := via6.NewTranslator(cfg, log)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create 4via6 translator: %w", err)
 	}
 
 	// Initially create forwarder without TSNet (will be updated later if TSNet is available)
+	breaker := newCircuitBreaker(cfg.Global.CircuitBreaker)
 	forwarder := NewForwarder(cfg.Global.Backend, log)
+	forwarder.breaker = breaker
+	forwarder.doh = newDoHBackends()
+	forwarder.inflight = new(singleflight.Group)
 
 	// Initialize memory monitor
 	memoryLimits := memory.Limits{
-		MaxZoneCount:     100,             // Max 100 zones
+		MaxZoneCount:     runtimeCfg.ZoneLimit(),
 		MaxTotalMemory:   500 * 1024 * 1024, // 500MB total
 		MaxCachePerZone:  50 * 1024 * 1024,  // 50MB per zone cache
 		MaxBufferPerZone: 10 * 1024 * 1024,  // 10MB per zone buffer
 	}
 	memoryMonitor := memory.NewMonitor(log, memoryLimits)
 
+	// GeoIP is optional and fails open: without it every zone uses its default backend
+	var geoipResolver *geoip.Resolver
+	if cfg.Global.GeoIP != nil && cfg.Global.GeoIP.Database != "" {
+		geoipResolver, err = geoip.Open(cfg.Global.GeoIP.Database)
+		if err != nil {
+			log.Warn("GeoIP database unavailable, region backends disabled", "error", err)
+		} else {
+			log.Info("GeoIP database loaded", "path", cfg.Global.GeoIP.Database, "type", geoipResolver.DatabaseType())
+		}
+	}
+
 	// Initialize zone caches
 	zoneCaches := make(map[string]*cache.ZoneCache)
 	for zoneName, zone := range cfg.Zones {
@@ -108,15 +211,18 @@ func NewServerWithRuntime(cfg *config.Config, runtimeCfg *config.RuntimeConfig)
 		}
 
 		if zone.Cache != nil {
-			maxSize := zone.Cache.MaxSize
-			if maxSize == 0 {
-				maxSize = cfg.Global.Cache.MaxSize
-			}
+			maxSize := cfg.CacheMaxSize(zone)
 			ttl, _ := config.ParseCacheTTL(zone.Cache.TTL)
 			zoneCaches[zoneName] = cache.NewZoneCacheWithName(maxSize, ttl, zoneName)
+			zoneCaches[zoneName].SetStaleRetention(zone.StaleRetention())
+			zoneCaches[zoneName].SetNegativeTTL(zone.NegativeCacheTTL())
+			zoneCaches[zoneName].SetMinTTL(zone.CacheMinTTL())
+			zoneCaches[zoneName].SetPreserveAA(zone.CachePreservesAA())
+			zoneCaches[zoneName].SetMaxBytes(cacheByteLimit(memoryMonitor, zoneName))
 			log.ZoneInfo(zoneName, "Zone cache initialized", "maxSize", maxSize, "ttl", ttl)
 		}
 	}
+	memoryMonitor.SetCacheSources(cacheSources(zoneCaches))
 
 	handler := &TailscaleDNSHandler{
 		config:        cfg,
@@ -126,8 +232,22 @@ func NewServerWithRuntime(cfg *config.Config, runtimeCfg *config.RuntimeConfig)
 		tsnetServer:   nil,
 		zoneCaches:    zoneCaches,
 		memoryMonitor: memoryMonitor,
+		geoip:         geoipResolver,
+		breaker:       breaker,
+		doh:           forwarder.doh,
+		inflight:      forwarder.inflight,
+		rateLimiter:   newClientRateLimiter(runtimeCfg.PerClientQPS, runtimeCfg.RateLimitBurst()),
 		logger:        log,
 	}
+	metrics.UpdateCacheOnlyMode(runtimeCfg.CacheOnly)
+	if runtimeCfg.CacheOnly {
+		log.Warn("Cache-only mode enabled, backends will not be queried")
+	}
+
+	if runtimeCfg.AuditExternalAccess {
+		handler.audit = logger.NewAudit(runtimeCfg.AuditLogFile)
+		log.Info("External access audit logging enabled", "file", runtimeCfg.AuditLogFile)
+	}
 
 	server := &Server{
 		config:        cfg,
@@ -137,6 +257,8 @@ func NewServerWithRuntime(cfg *config.Config, runtimeCfg *config.RuntimeConfig)
 		handler:       handler,
 		zoneCaches:    zoneCaches,
 		memoryMonitor: memoryMonitor,
+		geoip:         geoipResolver,
+		tcpConns:      newTCPConnLimiter(runtimeCfg.MaxTCPConnections),
 		logger:        log,
 	}
 
@@ -154,8 +276,9 @@ func NewServerWithRuntime(cfg *config.Config, runtimeCfg *config.RuntimeConfig)
 	}
 
 	server.dnsServer = &dns.Server{
-		Net:     "udp",
-		Handler: handler,
+		Net:           "udp",
+		Handler:       handler,
+		MsgAcceptFunc: msgAcceptFunc(runtimeCfg.MultiQuestionPolicy),
 	}
 
 	// For standalone mode (no TSNet), set the address immediately
@@ -163,17 +286,30 @@ func NewServerWithRuntime(cfg *config.Config, runtimeCfg *config.RuntimeConfig)
 		bindAddr := fmt.Sprintf("%s:%d", runtimeCfg.BindAddress, runtimeCfg.DNSPort)
 		server.dnsServer.Addr = bindAddr
 	}
-	if runtimeCfg.HealthEnabled || runtimeCfg.MetricsEnabled {
+	if runtimeCfg.HealthEnabled || runtimeCfg.MetricsEnabled || runtimeCfg.AdminToken != "" || runtimeCfg.PprofEnabled {
 		mux := http.NewServeMux()
 
 		if runtimeCfg.HealthEnabled {
 			mux.HandleFunc(runtimeCfg.HealthPath, server.healthHandler)
+			if runtimeCfg.ReadyPath != "" {
+				mux.HandleFunc(runtimeCfg.ReadyPath, server.readyHandler)
+			}
 		}
 
 		if runtimeCfg.MetricsEnabled {
 			mux.HandleFunc(runtimeCfg.MetricsPath, server.metricsHandler)
 		}
 
+		if runtimeCfg.AdminToken != "" {
+			mux.HandleFunc(CacheFlushPath, server.cacheFlushHandler)
+			mux.HandleFunc(CacheDumpPath, server.cacheDumpHandler)
+		}
+
+		if runtimeCfg.PprofEnabled {
+			registerPprof(mux)
+			log.Warn("Profiling endpoints enabled", "path", PprofPath)
+		}
+
 		server.httpServer = &http.Server{
 			Addr:    fmt.Sprintf("%s:%d", runtimeCfg.BindAddress, runtimeCfg.HTTPPort),
 			Handler: mux,
@@ -186,6 +322,17 @@ func NewServerWithRuntime(cfg *config.Config, runtimeCfg *config.RuntimeConfig)
 func (s *Server) Start(ctx context.Context) error {
 	var err error
 
+	// Serve health checks from the start, so liveness holds while TSNet
+	// joins and readiness reports the wait
+	if s.httpServer != nil {
+		go func() {
+			s.logger.Info("HTTP server listening", "address", s.httpServer.Addr)
+			if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				s.logger.Error("HTTP server error", "error", err)
+			}
+		}()
+	}
+
 	if s.tsnetServer != nil {
 		if err = s.tsnetServer.Start(ctx); err != nil {
 			return fmt.Errorf("failed to start TSNet server: %w", err)
@@ -193,6 +340,7 @@ func (s *Server) Start(ctx context.Context) error {
 
 		if handler, ok := s.dnsServer.Handler.(*TailscaleDNSHandler); ok {
 			handler.tsnetServer = s.tsnetServer
+			handler.clientTags = newClientTags(whoIsTags(s.tsnetServer), clientTagTTL)
 			// Update forwarder with TSNet for subnet route support
 			handler.forwarder.tsnetServer = s.tsnetServer
 			s.logger.Info("TSNet subnet routing enabled for DNS forwarding")
@@ -215,59 +363,140 @@ func (s *Server) Start(ctx context.Context) error {
 		
 		// Start memory monitoring
 		if s.memoryMonitor != nil {
-			s.memoryMonitor.StartPeriodicCheck(30 * time.Second)
-			s.logger.Info("Memory monitoring started", "checkInterval", "30s")
+			s.memoryMonitor.StartPeriodicCheck(s.runtimeCfg.MemoryCheckEvery())
+			s.logger.Info("Memory monitoring started", "checkInterval", s.runtimeCfg.MemoryCheckEvery())
 		}
 
-		var bindAddr string
-		if ipv4 != nil {
-			bindAddr = fmt.Sprintf("%s:%d", ipv4.String(), s.runtimeCfg.DNSPort)
-			s.logger.Info("Using Tailscale IP address", "ip", ipv4.String(), "type", "IPv4")
-		} else {
-			bindAddr = fmt.Sprintf("[%s]:%d", ipv6.String(), s.runtimeCfg.DNSPort)
-			s.logger.Info("Using Tailscale IP address", "ip", ipv6.String(), "type", "IPv6")
+		s.logger.Info("Tailscale addresses available", "ipv4", ipString(ipv4), "ipv6", ipString(ipv6))
+
+		if s.runtimeCfg.TSAutoSplitDNS {
+			go s.configureSplitDNS(ctx)
+		}
+		s.detectMagicDNSSuffix(ctx)
+
+		// Backends may only be reachable through TSNet, so warm up once it is ready
+		s.warmup()
+
+		// Prefer IPv4 when both families are available unless configured otherwise
+		bindIP, secondaryIP := ipv4, ipv6
+		if s.runtimeCfg.TSBindFamily == config.TSBindIPv6 && ipv6 != nil {
+			bindIP, secondaryIP = ipv6, ipv4
 		}
+		if bindIP == nil {
+			bindIP, secondaryIP = secondaryIP, nil
+		}
+		family := ipFamily(bindIP)
+		bindAddr := net.JoinHostPort(bindIP.String(), strconv.Itoa(s.runtimeCfg.DNSPort))
 
-		pc, err := s.tsnetServer.ListenPacket("udp", bindAddr)
+		var pc net.PacketConn
+		pc, err = s.tsnetServer.ListenPacket("udp", bindAddr)
 		if err != nil {
 			return fmt.Errorf("failed to bind DNS server to Tailscale network: %w", err)
 		}
 
 		s.dnsServer.PacketConn = pc
-		s.logger.Info("DNS server listening on Tailscale network", "address", bindAddr)
+		s.tuneListener("tailscale", pc)
+		metrics.RecordListener("tailscale", "udp", family, bindAddr)
+		s.logger.Info("DNS server listening on Tailscale network", "address", bindAddr, "family", family)
 
-		// Also start regular DNS server for Kubernetes port forwarding
-		regularAddr := fmt.Sprintf("%s:%d", s.runtimeCfg.BindAddress, s.runtimeCfg.DNSPort)
-		go func() {
-			regularPC, err := net.ListenPacket("udp", regularAddr)
+		tcpAddr := net.JoinHostPort(bindIP.String(), strconv.Itoa(s.runtimeCfg.TCPPort()))
+		var ln net.Listener
+		ln, err = s.tsnetServer.Listen("tcp", tcpAddr)
+		if err != nil {
+			return fmt.Errorf("failed to bind TCP DNS server to Tailscale network: %w", err)
+		}
+		s.serveTCP("tailscale", family, ln)
+
+		// In dual mode, also serve on the other Tailscale address family
+		if s.runtimeCfg.TSBindFamily == config.TSBindDual && secondaryIP != nil {
+			secondaryAddr := net.JoinHostPort(secondaryIP.String(), strconv.Itoa(s.runtimeCfg.DNSPort))
+			var secondaryPC net.PacketConn
+			secondaryPC, err = s.tsnetServer.ListenPacket("udp", secondaryAddr)
 			if err != nil {
-				s.logger.Error("Failed to start regular DNS server", "error", err, "address", regularAddr)
-				return
+				return fmt.Errorf("failed to bind DNS server to Tailscale network: %w", err)
 			}
-			defer func() { _ = regularPC.Close() }()
+			s.tuneListener("tailscale", secondaryPC)
+			metrics.RecordListener("tailscale", "udp", ipFamily(secondaryIP), secondaryAddr)
+			s.logger.Info("DNS server listening on Tailscale network", "address", secondaryAddr, "family", ipFamily(secondaryIP))
+
+			secondaryTCPAddr := net.JoinHostPort(secondaryIP.String(), strconv.Itoa(s.runtimeCfg.TCPPort()))
+			var secondaryLn net.Listener
+			secondaryLn, err = s.tsnetServer.Listen("tcp", secondaryTCPAddr)
+			if err != nil {
+				return fmt.Errorf("failed to bind TCP DNS server to Tailscale network: %w", err)
+			}
+			s.serveTCP("tailscale", ipFamily(secondaryIP), secondaryLn)
 
-			regularServer := &dns.Server{
-				PacketConn: regularPC,
-				Handler:    s.dnsServer.Handler,
+			secondaryServer := &dns.Server{
+				PacketConn:    secondaryPC,
+				Handler:       s.dnsServer.Handler,
+				MsgAcceptFunc: s.dnsServer.MsgAcceptFunc,
 			}
-			s.logger.Info("Regular DNS server listening", "address", regularAddr)
+			s.udpServers = append(s.udpServers, secondaryServer)
+			go func() {
+				defer func() { _ = secondaryPC.Close() }()
+				if err := secondaryServer.ActivateAndServe(); err != nil {
+					s.logger.Error("Secondary Tailscale DNS server error", "error", err)
+				}
+			}()
+		}
+
+		// Also start regular DNS server for Kubernetes port forwarding. It is
+		// bound here so a bind failure stops startup instead of leaving the
+		// server half up.
+		regularAddr := fmt.Sprintf("%s:%d", s.runtimeCfg.BindAddress, s.runtimeCfg.DNSPort)
+		regularTCPAddr := fmt.Sprintf("%s:%d", s.runtimeCfg.BindAddress, s.runtimeCfg.TCPPort())
+		var regularPC net.PacketConn
+		regularPC, err = listenUDP(regularAddr, s.runtimeCfg.ReusePort)
+		if err != nil {
+			return bindError(regularAddr, err)
+		}
+		var regularLn net.Listener
+		regularLn, err = listenTCP(regularTCPAddr, s.runtimeCfg.TCPListenBacklog, s.runtimeCfg.ReusePort)
+		if err != nil {
+			_ = regularPC.Close()
+			return bindError(regularTCPAddr, err)
+		}
+		s.serveTCP("local", ipFamily(net.ParseIP(s.runtimeCfg.BindAddress)), regularLn)
+		s.tuneListener("local", regularPC)
+
+		regularServer := &dns.Server{
+			PacketConn:    regularPC,
+			Handler:       s.dnsServer.Handler,
+			MsgAcceptFunc: s.dnsServer.MsgAcceptFunc,
+		}
+		s.udpServers = append(s.udpServers, regularServer)
+		metrics.RecordListener("local", "udp", ipFamily(net.ParseIP(s.runtimeCfg.BindAddress)), regularAddr)
+		s.logger.Info("Regular DNS server listening", "address", regularAddr)
+		go func() {
+			defer func() { _ = regularPC.Close() }()
 			if err := regularServer.ActivateAndServe(); err != nil {
 				s.logger.Error("Regular DNS server error", "error", err)
 			}
 		}()
 
 	} else {
+		s.warmup()
+
 		// In standalone mode, address was already set in constructor
+		var pc net.PacketConn
+		pc, err = listenUDP(s.dnsServer.Addr, s.runtimeCfg.ReusePort)
+		if err != nil {
+			return bindError(s.dnsServer.Addr, err)
+		}
+		s.dnsServer.PacketConn = pc
+		s.tuneListener("standalone", pc)
+		metrics.RecordListener("standalone", "udp", ipFamily(net.ParseIP(s.runtimeCfg.BindAddress)), s.dnsServer.Addr)
 		s.logger.Info("DNS server listening", "address", s.dnsServer.Addr)
-	}
 
-	if s.httpServer != nil {
-		go func() {
-			s.logger.Info("HTTP server listening", "address", s.httpServer.Addr)
-			if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-				s.logger.Error("HTTP server error", "error", err)
-			}
-		}()
+		tcpAddr := fmt.Sprintf("%s:%d", s.runtimeCfg.BindAddress, s.runtimeCfg.TCPPort())
+		var ln net.Listener
+		ln, err = listenTCP(tcpAddr, s.runtimeCfg.TCPListenBacklog, s.runtimeCfg.ReusePort)
+		if err != nil {
+			_ = pc.Close()
+			return bindError(tcpAddr, err)
+		}
+		s.serveTCP("standalone", ipFamily(net.ParseIP(s.runtimeCfg.BindAddress)), ln)
 	}
 
 	go func() {
@@ -275,20 +504,133 @@ func (s *Server) Start(ctx context.Context) error {
 		s.Stop()
 	}()
 
-	// Use different methods based on whether we have TSNet or standalone
-	if s.tsnetServer != nil {
-		// TSNet mode: PacketConn is set, use ActivateAndServe
-		return s.dnsServer.ActivateAndServe()
-	} else {
-		// Standalone mode: Addr is set, use ListenAndServe
-		return s.dnsServer.ListenAndServe()
+	// PacketConn is set in both TSNet and standalone mode
+	s.dnsServer.NotifyStartedFunc = func() { s.dnsServing.Store(true) }
+	return s.dnsServer.ActivateAndServe()
+}
+
+// warmup starts the backend health checks and fills the caches with the
+// configured warmup names before any listener is bound
+func (s *Server) warmup() {
+	s.mu.Lock()
+	s.startHealthChecks()
+	s.mu.Unlock()
+
+	if handler, ok := s.dnsServer.Handler.(*TailscaleDNSHandler); ok {
+		handler.warmup()
+	}
+}
+
+// serveTCP serves DNS over TCP on ln with the same handler as the UDP server.
+// Connections count against the server-wide limit and are closed once idle
+// for the configured timeout. The server is shut down by Stop.
+func (s *Server) serveTCP(listener, family string, ln net.Listener) {
+	tcpServer := &dns.Server{
+		Listener:       s.tcpConns.listen(ln),
+		Net:            "tcp",
+		Handler:        s.dnsServer.Handler,
+		MsgAcceptFunc:  s.dnsServer.MsgAcceptFunc,
+		DecorateReader: limitTCPMessageSize(s.runtimeCfg.TCPMaxMessageSize, s.logger),
+	}
+	if idle := s.runtimeCfg.TCPIdleTimeout; idle > 0 {
+		tcpServer.IdleTimeout = func() time.Duration { return idle }
+	}
+	s.tcpServers = append(s.tcpServers, tcpServer)
+	metrics.RecordListener(listener, "tcp", family, ln.Addr().String())
+	s.logger.Info("DNS server listening on TCP", "listener", listener, "address", ln.Addr().String())
+
+	go func() {
+		if err := tcpServer.ActivateAndServe(); err != nil {
+			s.logger.Error("TCP DNS server error", "listener", listener, "error", err)
+		}
+	}()
+}
+
+// startHealthChecks replaces the running backend health checker with one for
+// the current zone configuration. The old checker keeps serving until the new
+// one, seeded with its results, is in place. Callers hold s.mu.
+func (s *Server) startHealthChecks() {
+	health := newHealthChecker(s.logger)
+	health.inherit(s.health, s.config)
+	health.Start(s.config, s.tsnetServer)
+
+	s.handler.reloadMu.Lock()
+	s.handler.health = health
+	s.handler.reloadMu.Unlock()
+
+	s.health.Stop()
+	s.health = health
+}
+
+// detectMagicDNSSuffix takes the tailnet's MagicDNS domain from this node's
+// name, so custom tailnet domains resolve via MagicDNS. On failure
+// defaultMagicDNSSuffix stays in use.
+func (s *Server) detectMagicDNSSuffix(ctx context.Context) {
+	localClient, err := s.tsnetServer.LocalClient()
+	if err != nil {
+		s.logger.Warn("Failed to detect MagicDNS suffix", "error", err, "suffix", defaultMagicDNSSuffix)
+		return
+	}
+	status, err := localClient.Status(ctx)
+	if err != nil {
+		s.logger.Warn("Failed to detect MagicDNS suffix", "error", err, "suffix", defaultMagicDNSSuffix)
+		return
+	}
+	if status.Self == nil {
+		return
+	}
+	suffix := tailnetDomain(status.Self.DNSName)
+	if suffix == "" {
+		return
+	}
+
+	s.handler.reloadMu.Lock()
+	s.handler.magicSuffix = suffix
+	s.handler.reloadMu.Unlock()
+	s.logger.Info("MagicDNS suffix detected", "suffix", suffix)
+}
+
+// configureSplitDNS routes the domains of every zone to this node through the
+// tailnet's split DNS settings
+func (s *Server) configureSplitDNS(ctx context.Context) {
+	s.mu.RLock()
+	var domains []string
+	for _, zone := range s.config.Zones {
+		domains = append(domains, zone.Domains...)
+	}
+	s.mu.RUnlock()
+	slices.Sort(domains)
+
+	if err := s.tsnetServer.ConfigureSplitDNS(ctx, domains); err != nil {
+		s.logger.Error("Failed to configure split DNS", "error", err)
+	}
+}
+
+// tuneListener applies the configured UDP socket buffer sizes to pc and logs
+// the sizes in effect
+func (s *Server) tuneListener(listener string, pc net.PacketConn) {
+	recvBufSize, sendBufSize, ok, err := tuneUDPConn(pc, s.runtimeCfg.UDPRecvBufSize, s.runtimeCfg.UDPSendBufSize)
+	switch {
+	case err != nil:
+		s.logger.Warn("Failed to set UDP socket buffer sizes", "listener", listener, "error", err)
+	case !ok:
+		if s.runtimeCfg.UDPRecvBufSize > 0 || s.runtimeCfg.UDPSendBufSize > 0 {
+			s.logger.Info("UDP socket buffer sizes not adjustable for listener", "listener", listener)
+		}
+	default:
+		s.logger.Info("UDP socket buffer sizes", "listener", listener, "recvBufSize", recvBufSize, "sendBufSize", sendBufSize)
 	}
 }
 
 func (s *Server) Stop() {
+	s.dnsServing.Store(false)
+
 	// Update Tailscale status metric
 	metrics.UpdateTailscaleStatus(false)
 
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	// Stop cache cleanup routines
 	for _, cache := range s.zoneCaches {
 		cache.Stop()
@@ -297,6 +639,20 @@ func (s *Server) Stop() {
 	if s.dnsServer != nil {
 		_ = s.dnsServer.Shutdown()
 	}
+	for _, udpServer := range s.udpServers {
+		_ = udpServer.Shutdown()
+	}
+	for _, tcpServer := range s.tcpServers {
+		_ = tcpServer.Shutdown()
+	}
+	_ = s.geoip.Close()
+	s.health.Stop()
+	if s.via6Trans != nil {
+		s.via6Trans.Stop()
+	}
+	if s.forwarder != nil {
+		s.forwarder.breaker.Stop()
+	}
 	if s.httpServer != nil {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
@@ -307,13 +663,40 @@ func (s *Server) Stop() {
 	}
 }
 
+func ipString(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+// ipFamily reports "ipv6" for IPv6 addresses and "ipv4" otherwise
+func ipFamily(ip net.IP) string {
+	if ip != nil && ip.To4() == nil {
+		return "ipv6"
+	}
+	return "ipv4"
+}
+
 // updateTailscaleMetrics periodically updates Tailscale connection metrics
 func (s *Server) updateTailscaleMetrics(ctx context.Context) {
 	if s.tsnetServer == nil {
 		return
 	}
 
-	ticker := time.NewTicker(30 * time.Second)
+	s.pollTailscaleMetrics(ctx, s.runtimeCfg.StatusPollEvery(), func(ctx context.Context) (*ipnstate.Status, error) {
+		localClient, err := s.tsnetServer.LocalClient()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get LocalClient: %w", err)
+		}
+		return localClient.Status(ctx)
+	})
+}
+
+// pollTailscaleMetrics records the Tailscale status returned by status every
+// interval until ctx is done
+func (s *Server) pollTailscaleMetrics(ctx context.Context, interval time.Duration, status func(context.Context) (*ipnstate.Status, error)) {
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	for {
@@ -321,38 +704,36 @@ func (s *Server) updateTailscaleMetrics(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			localClient, err := s.tsnetServer.LocalClient()
-			if err != nil {
-				s.logger.Error("Failed to get LocalClient for metrics", "error", err)
-				metrics.UpdateTailscaleStatus(false)
-				continue
-			}
-
-			status, err := localClient.Status(ctx)
+			st, err := status(ctx)
 			if err != nil {
 				s.logger.Error("Failed to get Tailscale status for metrics", "error", err)
 				metrics.UpdateTailscaleStatus(false)
 				continue
 			}
+			recordTailscaleStatus(st)
+		}
+	}
+}
 
-			// Update connection count
-			activeConnections := 0
-			if status.Peer != nil {
-				for _, peer := range status.Peer {
-					if peer.Online {
-						activeConnections++
-					}
-				}
-			}
-
-			metrics.UpdateTailscaleStatus(true)
+// recordTailscaleStatus updates the connection metrics from status: up, with
+// how many peers are known and how many of them are online
+func recordTailscaleStatus(status *ipnstate.Status) {
+	online := 0
+	for _, peer := range status.Peer {
+		if peer.Online {
+			online++
 		}
 	}
+
+	metrics.UpdateTailscaleStatus(true)
+	metrics.UpdateTailscalePeers(online)
+	metrics.UpdateTailscalePeersTotal(len(status.Peer))
 }
 
 // TailscaleDNSHandler handles DNS queries from Tailscale clients
 // Provides full functionality: 4via6, MagicDNS, and backend forwarding
 type TailscaleDNSHandler struct {
+	reloadMu      sync.RWMutex // Read-held while serving a query; ReloadConfig swaps the fields below under the write lock
 	config        *config.Config
 	runtimeCfg    *config.RuntimeConfig
 	via6Trans     *via6.Translator
@@ -360,6 +741,18 @@ type TailscaleDNSHandler struct {
 	tsnetServer   *tailscale.TSNetServer
 	zoneCaches    map[string]*cache.ZoneCache
 	memoryMonitor *memory.Monitor
+	geoip         *geoip.Resolver     // Optional, selects zone region backends
+	clientTags    *clientTags         // Optional, client ACL tags selecting zone tag backends
+	breaker       *circuitBreaker     // Optional, shared by all forwarders
+	doh           *dohBackends        // DNS over HTTPS connections, shared by all forwarders
+	inflight      *singleflight.Group // Identical queries being forwarded, shared by all forwarders
+	health        *healthChecker      // Optional, tracks backend health for zones with health checks
+	refreshing    sync.Map            // Cache entries being refreshed in the background
+	audit         *logger.Logger      // Optional, audit log of external-client queries
+	stats         queryStats          // Counters for diagnostics, kept across reloads
+	magicSuffix   string              // Tailnet MagicDNS domain detected at startup (empty = not yet known)
+	rotations     atomic.Uint64       // Queries answered by zones with rotateAnswers, the next rotation
+	rateLimiter   *clientRateLimiter  // Optional, per-client query rate limit
 	logger        *logger.Logger
 }
 
@@ -368,181 +761,1167 @@ type DNSHandler = TailscaleDNSHandler
 
 // TailscaleDNSHandler.ServeDNS provides DNS functionality with feature detection based on client source
 func (h *TailscaleDNSHandler) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	// A response held back by the zone's time floor is sent once the reload
+	// lock is released, so waiting out the floor never stalls a reload
+	floor := &timeFloorResponseWriter{ResponseWriter: w}
+	defer floor.flush()
+	w = floor
+
+	h.reloadMu.RLock()
+	defer h.reloadMu.RUnlock()
+
+	start := time.Now()
 	clientIP := h.getClientIP(w.RemoteAddr())
 	isTailscaleClient := h.isTailscaleClient(clientIP)
 
+	// Every line logged for this query carries its ID, to follow it through
+	// the cache, 4via6 translation and forwarding
+	log := h.logger.WithQuery(newQueryID(), clientIP.String())
+	ctx := logger.NewContext(context.Background(), log)
+
+	// Refuse clients over their rate before doing any work for them
+	if !h.rateLimiter.allow(clientIP) {
+		metrics.RecordRateLimited(isTailscaleClient)
+		msg := new(dns.Msg)
+		msg.SetRcode(r, dns.RcodeRefused)
+		_ = w.WriteMsg(msg)
+		return
+	}
+
+	if h.handleMultiQuestion(ctx, w, r) {
+		return
+	}
+	if len(r.Question) == 0 {
+		msg := new(dns.Msg)
+		msg.SetRcodeFormatError(r)
+		_ = w.WriteMsg(msg)
+		return
+	}
+
 	// Start recording DNS query metrics
 	var queryType string
 	var zoneName = "default"
+	var queryZone *config.Zone
 	if len(r.Question) > 0 {
 		queryType = dns.TypeToString[r.Question[0].Qtype]
 		// Try to determine zone for metrics
-		if zone := h.config.GetZone(r.Question[0].Name); zone != nil {
-			for name, z := range h.config.Zones {
-				if z == zone {
-					zoneName = name
-					break
-				}
-			}
+		if queryZone = h.config.GetZone(r.Question[0].Name); queryZone != nil {
+			zoneName = h.findZoneName(queryZone)
 		}
 	}
 
+	log = log.WithZone(zoneName)
+	ctx = logger.NewContext(ctx, log)
+
 	// Record query and start timer
 	done := metrics.RecordDNSQuery(zoneName, queryType)
 	defer done()
 
+	// Audit every external query once its outcome is known
+	auditDecision := auditAllowed
+	if h.audit != nil && !isTailscaleClient && len(r.Question) > 0 {
+		defer func() { h.auditExternalAccess(clientIP, zoneName, r.Question[0], auditDecision) }()
+	}
+
+	// Bound the whole query, so later backend retries get only the time left
+	if h.runtimeCfg.QueryDeadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.runtimeCfg.QueryDeadline)
+		defer cancel()
+	}
+
+	// Every query is tracked for the stats; slow ones are logged too
+	slow := &slowQueryWriter{ResponseWriter: w, start: start}
+	w = slow
+	defer h.stats.record(slow)
+	if h.runtimeCfg.SlowQueryThreshold > 0 {
+		defer h.logSlowQuery(ctx, slow, r, clientIP, zoneName)
+	}
+
+	// The zone's client lists apply to every client, tailnet or external.
+	// Trace queries report the refusal instead.
+	if queryZone != nil && !queryZone.AllowsClient(clientIP) && !h.isTraceQuery(r) {
+		log.ZoneDebug(zoneName, "Client refused by zone client lists", "client", clientIP.String(), "domain", r.Question[0].Name)
+		metrics.RecordDeniedClientQuery(zoneName, isTailscaleClient)
+		slow.setPath("denied")
+		auditDecision = auditBlocked
+		msg := new(dns.Msg)
+		msg.SetRcode(r, dns.RcodeRefused)
+		_ = w.WriteMsg(msg)
+		return
+	}
+
+	// Hide cache hits from timing observers by never answering faster than the floor
+	if queryZone != nil {
+		if d := queryZone.ResponseFloor(); d > 0 {
+			floor.notBefore = start.Add(d)
+		}
+	}
+
+	// Innermost, so truncation sees the response exactly as it will be sent
+	w = &ednsResponseWriter{ResponseWriter: w, req: r, udpSize: h.runtimeCfg.EDNSUDPSize(), nsid: h.nsid(isTailscaleClient)}
+	w = &compressResponseWriter{ResponseWriter: w, compress: h.config.CompressResponses(queryZone)}
+
+	if queryZone != nil && queryZone.FixedTTL != nil {
+		w = &fixedTTLResponseWriter{ResponseWriter: w, ttl: *queryZone.FixedTTL}
+	}
+	if queryZone != nil && queryZone.RotateAnswers {
+		w = &rotateResponseWriter{ResponseWriter: w, offset: h.rotations.Add(1) - 1}
+	}
+
+	// Route every response for this zone through its registered hook
+	if hook := getResponseHook(zoneName); !isNopResponseHook(hook) {
+		w = &hookResponseWriter{ResponseWriter: w, ctx: ctx, hook: hook, zone: zoneName, req: r, handler: h}
+	}
+
+	// Reflected responses, cached or fresh, come back under the reflected
+	// name and are renamed to the queried name before anything else sees them
+	if queryZone != nil && ((queryZone.ReflectAAAA && r.Question[0].Qtype == dns.TypeAAAA) || h.reflectsVia6Query(queryZone, r.Question[0].Qtype, isTailscaleClient)) {
+		w = &reflectResponseWriter{ResponseWriter: w, question: r.Question[0]}
+	}
+
 	if h.runtimeCfg.LogQueries {
 		for _, q := range r.Question {
 			clientType := "external"
 			if isTailscaleClient {
 				clientType = "tailscale"
 			}
-			h.logger.Info("DNS query", "name", q.Name, "type", dns.TypeToString[q.Qtype], "client", clientType)
+			log.Info("DNS query", "name", q.Name, "type", dns.TypeToString[q.Qtype], "client", clientType)
+		}
+	}
+
+	if h.runtimeCfg.AllowTraceQueries && len(r.Question) > 0 {
+		if target, ok := traceTarget(r.Question[0]); ok {
+			slow.setPath("trace")
+			h.handleTraceQuery(w, r, target, isTailscaleClient)
+			return
+		}
+	}
+
+	// DNS health probes are answered locally, so they don't depend on backends
+	if len(r.Question) > 0 && h.isHealthProbe(r.Question[0]) {
+		slow.setPath("health-probe")
+		h.handleHealthProbe(w, r, r.Question[0])
+		return
+	}
+
+	// CHAOS-class queries are about this server and never forwarded
+	if len(r.Question) > 0 && r.Question[0].Qclass == dns.ClassCHAOS {
+		slow.setPath("chaos")
+		h.handleChaosQuery(w, r, r.Question[0], isTailscaleClient)
+		return
+	}
+
+	// RFC 6761 special-use domains are answered locally and never forwarded
+	if len(r.Question) > 0 {
+		if policy := h.config.SpecialUsePolicy(r.Question[0].Name); policy != config.SpecialUseForward {
+			slow.setPath("special-use")
+			h.handleSpecialUseQuery(ctx, w, r, r.Question[0], policy)
+			return
 		}
 	}
 
-	for _, question := range r.Question {
-		// Check cache first if zone has caching enabled
-		if zoneCache, exists := h.zoneCaches[zoneName]; exists {
-			clientIP := h.getClientIP(w.RemoteAddr())
-			cacheKey := cache.CacheKey(question.Name, question.Qtype, clientIP.AsSlice())
+	// Zones that mandate TCP get an empty truncated answer over UDP, which
+	// makes clients retry the query over TCP
+	if queryZone != nil && queryZone.RequireTCP && isUDP(w) {
+		log.ZoneDebug(zoneName, "Requiring TCP for query", "domain", r.Question[0].Name)
+		slow.setPath("require-tcp")
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		msg.Truncated = true
+		_ = w.WriteMsg(msg)
+		return
+	}
+
+	// Past the multi-question policy a single question is left to answer
+	question := r.Question[0]
+
+	// External clients only reach zones that allow them. Checked before the
+	// cache, whose answers are shared with tailnet clients.
+	if !isTailscaleClient && queryZone != nil && !queryZone.AllowExternalClients {
+		slow.setPath("blocked")
+		auditDecision = auditBlocked
+		h.blockExternalClient(ctx, w, r, zoneName, clientIP)
+		return
+	}
+
+	// Check cache first if zone has caching enabled
+	if zoneCache, exists := h.zoneCaches[zoneName]; exists {
+		cacheKey := h.cacheKey(ctx, h.config.GetZone(question.Name), question, clientIP)
+
+		if cachedResponse, found := zoneCache.Get(cacheKey); found {
+			cachedResponse.Id = r.Id
+			metrics.RecordCacheHit(zoneName)
+			h.stats.cacheHits.Add(1)
+			metrics.UpdateCacheSize(zoneName, zoneCache.Size())
 			
-			if cachedResponse, found := zoneCache.Get(cacheKey); found {
-				metrics.RecordCacheHit(zoneName)
-				metrics.UpdateCacheSize(zoneName, zoneCache.Size())
-				
-				// Update memory monitoring
-				if h.memoryMonitor != nil {
-					if err := h.memoryMonitor.UpdateCacheUsage(zoneName, zoneCache.MemoryUsage()); err != nil {
-						h.logger.ZoneDebug(zoneName, "Failed to update cache usage", "error", err)
-					}
+			// Update memory monitoring
+			if h.memoryMonitor != nil {
+				if err := h.memoryMonitor.UpdateCacheUsage(zoneName, zoneCache.MemoryUsage()); err != nil {
+					log.ZoneDebug(zoneName, "Failed to update cache usage", "error", err)
+				}
+			}
+			
+			log.ZoneDebug(zoneName, "Cache hit", "domain", question.Name, "type", dns.TypeToString[question.Qtype])
+			slow.setPath("cache")
+			_ = w.WriteMsg(cachedResponse)
+			return
+		}
+		metrics.RecordCacheMiss(zoneName)
+		h.stats.cacheMisses.Add(1)
+
+		// Answer expired entries at once and bring them up to date afterwards
+		if zone := h.config.GetZone(question.Name); zone != nil && zone.CacheOnExpiry() == config.CacheOnExpiryServeStaleAsync {
+			if stale, found := zoneCache.GetStale(cacheKey); found {
+				stale.Id = r.Id
+				setEDE(stale, r, dns.ExtendedErrorCodeStaleAnswer, "refreshing in background")
+				metrics.RecordStaleResponse(zoneName, "refresh_async")
+				log.ZoneDebug(zoneName, "Serving stale response, refreshing in background", "domain", question.Name, "type", dns.TypeToString[question.Qtype])
+				slow.setPath("cache")
+				if !h.runtimeCfg.CacheOnly {
+					h.refreshAsync(ctx, w, r, question, zoneName, cacheKey, clientIP, isTailscaleClient)
 				}
-				
-				h.logger.ZoneDebug(zoneName, "Cache hit", "domain", question.Name, "type", dns.TypeToString[question.Qtype])
-				_ = w.WriteMsg(cachedResponse)
+				_ = w.WriteMsg(stale)
 				return
 			}
-			metrics.RecordCacheMiss(zoneName)
 		}
-		
-		// Priority 1: Check if it's a 4via6 zone (only for Tailscale clients)
-		if isTailscaleClient {
-			zone := h.config.GetZone(question.Name)
-			if zone != nil && zone.Has4via6() {
-				h.logger.ZoneDebug(zoneName, "4via6 translation triggered", "domain", question.Name)
-				h.handleZoneQuery(w, r, question, zone, zoneName)
+	}
+	
+	// In cache-only mode nothing beyond the cache is consulted
+	if h.runtimeCfg.CacheOnly {
+		slow.setPath("cache-only")
+		h.handleCacheOnlyMiss(ctx, w, r, question, zoneName, clientIP)
+		return
+	}
+
+	// A 4via6 network name has no host to answer for, whatever the type
+	if h.via6Trans != nil && h.via6Trans.IsVia6Apex(question.Name) {
+		slow.setPath("4via6-apex")
+		h.handleVia6ReverseNegative(ctx, w, r, question, h.via6ApexRcode())
+		return
+	}
+
+	// Reverse lookups of 4via6 addresses answer with the names that
+	// translate to them, closing the loop with 4via6 AAAA answers. No
+	// backend knows the 4via6 range, so the rest of it is answered here.
+	if question.Qtype == dns.TypePTR && h.via6Trans != nil {
+		switch h.via6Trans.MatchReverse(question.Name) {
+		case via6.ReverseUnknown:
+			slow.setPath("4via6-ptr")
+			h.handleVia6ReverseNegative(ctx, w, r, question, dns.RcodeNameError)
+			return
+		case via6.ReverseKnown:
+			if isTailscaleClient {
+				slow.setPath("4via6-ptr")
+				if via6IP, ok := via6.ParseReverseIPv6(question.Name); ok {
+					h.handleVia6PTRQuery(ctx, w, r, question, via6IP)
+				} else {
+					// Names above a full address have addresses below them
+					h.handleVia6ReverseNegative(ctx, w, r, question, dns.RcodeSuccess)
+				}
 				return
 			}
 		}
+	}
 
-		// Priority 2: Check if it's a MagicDNS domain (available for all clients)
-		if h.isMagicDNSDomain(question.Name) {
-			h.handleMagicDNSQuery(w, r, question)
+	// Priority 1: Check if it's a 4via6 zone (only for Tailscale clients).
+	// Types without 4via6 records are forwarded like any other zone's.
+	if isTailscaleClient && via6Synthesizes(question.Qtype) {
+		zone := h.config.GetZone(question.Name)
+		if zone != nil && zone.Has4via6() {
+			log.ZoneDebug(zoneName, "4via6 translation triggered", "domain", question.Name)
+			slow.setPath("4via6")
+			h.handleZoneQuery(ctx, w, r, question, zone, zoneName)
 			return
 		}
 	}
 
+	// Priority 2: Check if it's a MagicDNS domain (available for all clients)
+	if h.isMagicDNSDomain(question.Name) {
+		slow.setPath("magicdns")
+		h.handleMagicDNSQuery(ctx, w, r, question)
+		return
+	}
+
+	// Bare hostnames outside any zone are tried as MagicDNS short names,
+	// and forwarded as usual if no node has that name
+	if dns.CountLabel(question.Name) == 1 && h.config.GetZone(question.Name) == nil && h.handleMagicDNSShortName(ctx, w, r, question) {
+		slow.setPath("magicdns")
+		return
+	}
+
 	// Priority 3: Forward to backend DNS servers
 	// Check if there's a zone for this domain
 	zone := h.config.GetZone(r.Question[0].Name)
 	
-	// Check access permissions
-	if !isTailscaleClient && (zone == nil || !zone.AllowExternalClients) {
-		// External clients can only access zones that explicitly allow them
-		h.logger.Debug("External client blocked", "client", clientIP.String(), "zone", zoneName, "domain", r.Question[0].Name)
-		metrics.RecordExternalClientQuery(zoneName, "blocked")
-		msg := new(dns.Msg)
-		msg.SetRcode(r, dns.RcodeNameError)
-		_ = w.WriteMsg(msg)
+	// External clients get nothing outside the zones, MagicDNS aside
+	if !isTailscaleClient && zone == nil {
+		slow.setPath("blocked")
+		auditDecision = auditBlocked
+		h.blockExternalClient(ctx, w, r, zoneName, clientIP)
 		return
 	}
 	
 	// Forward the query
+	slow.setPath("forward")
 	if zone != nil {
 		// Log external access for security monitoring
 		if !isTailscaleClient && zone.AllowExternalClients {
-			h.logger.Info("External client accessing allowed zone", "client", clientIP.String(), "zone", zoneName, "domain", r.Question[0].Name)
+			log.Info("External client accessing allowed zone", "client", clientIP.String(), "domain", r.Question[0].Name)
 			metrics.RecordExternalClientQuery(zoneName, "allowed")
 		}
 		
-		// Use zone-specific backend with TSNet support (if available)
-		var zoneForwarder *Forwarder
-		if h.tsnetServer != nil && isTailscaleClient {
-			// Tailscale clients get TSNet routing for subnet access
-			zoneForwarder = NewForwarderWithTSNet(zone.Backend, h.logger, h.tsnetServer)
-		} else {
-			// External clients use standard DNS forwarding
-			zoneForwarder = NewForwarder(zone.Backend, h.logger)
+		if zone.ReflectAAAA && r.Question[0].Qtype == dns.TypeAAAA {
+			slow.setPath("reflect-aaaa")
+			h.handleReflectAAAAQuery(ctx, w, r, zone, zoneName, clientIP, isTailscaleClient)
+			return
+		}
+		if h.reflectsVia6Query(zone, r.Question[0].Qtype, isTailscaleClient) {
+			slow.setPath("reflect")
+			h.handleReflectedVia6Query(ctx, w, r, zone, zoneName, clientIP, isTailscaleClient)
+			return
 		}
-		zoneCache := h.zoneCaches[zoneName]
-		zoneForwarder.ForwardWithZoneAndCache(w, r, zoneName, zoneCache)
+		h.forwardToZone(ctx, w, r, zone, zoneName, h.cacheKey(ctx, zone, r.Question[0], clientIP), clientIP, isTailscaleClient)
 	} else {
 		// Use global backend (Tailscale clients only)
-		h.forwarder.ForwardWithZone(w, r, "global")
+		h.forwarder.ForwardContext(ctx, w, r, "global", nil, "")
 	}
 }
 
-func (h *TailscaleDNSHandler) handleZoneQuery(w dns.ResponseWriter, r *dns.Msg, question dns.Question, zone *config.Zone, zoneName string) {
+// blockExternalClient answers an external client's query for a name it may
+// not resolve with NXDOMAIN
+func (h *TailscaleDNSHandler) blockExternalClient(ctx context.Context, w dns.ResponseWriter, r *dns.Msg, zoneName string, clientIP netip.Addr) {
+	h.log(ctx).Debug("External client blocked", "client", clientIP.String(), "domain", r.Question[0].Name)
+	metrics.RecordExternalClientQuery(zoneName, "blocked")
 	msg := new(dns.Msg)
-	msg.SetReply(r)
-	msg.Authoritative = true
+	msg.SetRcode(r, dns.RcodeNameError)
+	_ = w.WriteMsg(msg)
+}
 
-	if question.Qtype == dns.TypeAAAA {
-		via6IP, err := h.via6Trans.TranslateToVia6(question.Name)
-		if err != nil {
-			h.logger.ZoneError(zoneName, "4via6 translation failed", "domain", question.Name, "error", err)
-			metrics.RecordVia6Error(zoneName, "translation_failed")
+// forwardToZone forwards r to the zone's backends, caching the response under cacheKey
+func (h *TailscaleDNSHandler) forwardToZone(ctx context.Context, w dns.ResponseWriter, r *dns.Msg, zone *config.Zone, zoneName, cacheKey string, clientIP netip.Addr, isTailscaleClient bool) {
+	// Use zone-specific backend with TSNet support (if available)
+	backend := h.zoneBackend(ctx, zone, zoneName, clientIP, isTailscaleClient)
+	var zoneForwarder *Forwarder
+	if h.tsnetServer != nil && isTailscaleClient {
+		// Tailscale clients get TSNet routing for subnet access
+		zoneForwarder = NewForwarderWithTSNet(backend, h.log(ctx), h.tsnetServer)
+	} else {
+		// External clients use standard DNS forwarding
+		zoneForwarder = NewForwarder(backend, h.log(ctx))
+	}
+	zoneForwarder.breaker = h.breaker
+	zoneForwarder.doh = h.doh
+	zoneForwarder.inflight = h.inflight
+	zoneForwarder.health = h.health
+	zoneForwarder.staleOnCircuitOpen = zone.ServeStale != nil && zone.ServeStale.OnCircuitOpen
+	zoneForwarder.staleOnFailure = zone.CacheOnExpiry() == config.CacheOnExpiryRefreshSync ||
+		(zone.ServeStale != nil && zone.ServeStale.OnFailure)
+	zoneForwarder.parallel = zone.BackendStrategy == config.BackendStrategyParallel
+	zoneCache := h.zoneCaches[zoneName]
+	zoneForwarder.ForwardContext(ctx, w, r, zoneName, zoneCache, cacheKey)
+}
+
+// via6Synthesizes reports whether 4via6 zones answer qtype themselves: the
+// address and service binding types 4via6 translates, A, which has no 4via6
+// form, the apex SOA and NS, and ANY
+func via6Synthesizes(qtype uint16) bool {
+	switch qtype {
+	case dns.TypeA, dns.TypeAAAA, dns.TypeHTTPS, dns.TypeSVCB, dns.TypeSOA, dns.TypeNS, dns.TypeANY:
+		return true
+	}
+	return false
+}
+
+// handleReflectAAAAQuery answers an AAAA query on a reflectAAAA zone with the
+// real AAAA records of the reflected name, for IPv6 backends 4via6 can't
+// translate. The response is cached under the queried name.
+func (h *TailscaleDNSHandler) handleReflectAAAAQuery(ctx context.Context, w dns.ResponseWriter, r *dns.Msg, zone *config.Zone, zoneName string, clientIP netip.Addr, isTailscaleClient bool) {
+	question := r.Question[0]
+
+	// A static IPv6 reflected address is answered directly
+	if ip := net.ParseIP(zone.ReflectedDomain); ip != nil {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		msg.Authoritative = true
+		msg.Answer = append(msg.Answer, &dns.AAAA{
+			Hdr:  dns.RR_Header{Name: question.Name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: zone.AnswerTTL(h.runtimeCfg.DefaultTTL)},
+			AAAA: ip,
+		})
+		_ = w.WriteMsg(msg)
+		return
+	}
+
+	req := r.Copy()
+	req.Question[0].Name = zone.MapName(question.Name, zone.ReflectedDomain)
+	h.log(ctx).ZoneDebug(zoneName, "Reflecting AAAA query", "domain", question.Name, "reflectedDomain", req.Question[0].Name)
+	h.forwardToZone(ctx, w, req, zone, zoneName, h.cacheKey(ctx, zone, question, clientIP), clientIP, isTailscaleClient)
+}
+
+// servesExternalVia6 reports whether a query on zone is from an external
+// client of a 4via6 zone, which is answered with the reflected domain's real
+// addresses rather than 4via6 ones it couldn't reach
+func (h *TailscaleDNSHandler) servesExternalVia6(zone *config.Zone, isTailscaleClient bool) bool {
+	return zone != nil && zone.Has4via6() && zone.AllowExternalClients && !isTailscaleClient
+}
+
+// reflectsVia6Query reports whether a query on zone is answered with the
+// backend's records for the reflected name: every query of an external client
+// of a 4via6 zone, and a Tailscale client's queries for types 4via6 doesn't
+// synthesize, whose records live under the reflected domain
+func (h *TailscaleDNSHandler) reflectsVia6Query(zone *config.Zone, qtype uint16, isTailscaleClient bool) bool {
+	if h.servesExternalVia6(zone, isTailscaleClient) {
+		return true
+	}
+	return zone != nil && zone.Has4via6() && isTailscaleClient && !via6Synthesizes(qtype) && net.ParseIP(zone.ReflectedDomain) == nil
+}
+
+// handleReflectedVia6Query answers a query on a 4via6 zone with the backend's
+// records for the reflected name. The response is cached under the queried
+// name, external clients' apart from the 4via6 answers for Tailscale clients.
+func (h *TailscaleDNSHandler) handleReflectedVia6Query(ctx context.Context, w dns.ResponseWriter, r *dns.Msg, zone *config.Zone, zoneName string, clientIP netip.Addr, isTailscaleClient bool) {
+	question := r.Question[0]
+
+	// A static reflected address is answered directly for its own family
+	if ip := net.ParseIP(zone.ReflectedDomain); ip != nil {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		msg.Authoritative = true
+		hdr := dns.RR_Header{Name: question.Name, Rrtype: question.Qtype, Class: dns.ClassINET, Ttl: zone.AnswerTTL(h.runtimeCfg.DefaultTTL)}
+		switch ip4 := ip.To4(); {
+		case question.Qtype == dns.TypeA && ip4 != nil:
+			msg.Answer = append(msg.Answer, &dns.A{Hdr: hdr, A: ip4})
+		case question.Qtype == dns.TypeAAAA && ip4 == nil:
+			msg.Answer = append(msg.Answer, &dns.AAAA{Hdr: hdr, AAAA: ip})
+		}
+		_ = w.WriteMsg(msg)
+		return
+	}
+
+	req := r.Copy()
+	req.Question[0].Name = zone.MapName(question.Name, zone.ReflectedDomain)
+	h.log(ctx).ZoneDebug(zoneName, "Reflecting 4via6 zone query", "domain", question.Name, "type", dns.TypeToString[question.Qtype], "reflectedDomain", req.Question[0].Name)
+	h.forwardToZone(ctx, w, req, zone, zoneName, h.cacheKey(ctx, zone, question, clientIP), clientIP, isTailscaleClient)
+}
+
+// reflectResponseWriter restores the queried name in responses to reflected
+// queries, which the backend answered for the reflected name
+type reflectResponseWriter struct {
+	dns.ResponseWriter
+	question dns.Question
+}
+
+func (w *reflectResponseWriter) WriteMsg(m *dns.Msg) error {
+	if len(m.Question) > 0 {
+		reflected := m.Question[0].Name
+		for _, rr := range m.Answer {
+			if strings.EqualFold(rr.Header().Name, reflected) {
+				rr.Header().Name = w.question.Name
+			}
+		}
+		m.Question[0] = w.question
+	}
+	return w.ResponseWriter.WriteMsg(m)
+}
+
+// refreshAsync re-resolves an expired cache entry in the background after it
+// was answered stale. Concurrent refreshes of the same entry are collapsed.
+// The zone is looked up again once the refresh runs, as a reload may have
+// replaced or removed it since.
+func (h *TailscaleDNSHandler) refreshAsync(ctx context.Context, w dns.ResponseWriter, r *dns.Msg, question dns.Question, zoneName, cacheKey string, clientIP netip.Addr, isTailscaleClient bool) {
+	refreshKey := zoneName + "|" + cacheKey
+	if _, busy := h.refreshing.LoadOrStore(refreshKey, struct{}{}); busy {
+		return
+	}
+
+	req := r.Copy()
+	rw := &discardResponseWriter{remoteAddr: w.RemoteAddr()}
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		defer h.refreshing.Delete(refreshKey)
+		h.reloadMu.RLock()
+		defer h.reloadMu.RUnlock()
+		zone := h.config.Zones[zoneName]
+		if zone == nil {
+			h.log(ctx).ZoneDebug(zoneName, "Zone removed, skipping cache refresh", "domain", question.Name, "type", dns.TypeToString[question.Qtype])
+			return
+		}
+		switch {
+		case isTailscaleClient && zone.Has4via6() && via6Synthesizes(question.Qtype):
+			h.handleZoneQuery(ctx, rw, req, question, zone, zoneName)
+		case zone.ReflectAAAA && question.Qtype == dns.TypeAAAA:
+			h.handleReflectAAAAQuery(ctx, rw, req, zone, zoneName, clientIP, isTailscaleClient)
+		case h.reflectsVia6Query(zone, question.Qtype, isTailscaleClient):
+			h.handleReflectedVia6Query(ctx, rw, req, zone, zoneName, clientIP, isTailscaleClient)
+		default:
+			h.forwardToZone(ctx, rw, req, zone, zoneName, cacheKey, clientIP, isTailscaleClient)
+		}
+		h.log(ctx).ZoneDebug(zoneName, "Cache entry refreshed", "domain", question.Name, "type", dns.TypeToString[question.Qtype])
+	}()
+}
+
+// handleCacheOnlyMiss answers a query missing from the cache in cache-only
+// mode with the zone's cacheOnlyResponse
+func (h *TailscaleDNSHandler) handleCacheOnlyMiss(ctx context.Context, w dns.ResponseWriter, r *dns.Msg, question dns.Question, zoneName string, clientIP netip.Addr) {
+	zone := h.config.GetZone(question.Name)
+	response := config.CacheOnlyServFail
+	if zone != nil && zone.CacheOnlyResponse != "" {
+		response = zone.CacheOnlyResponse
+	}
+	metrics.RecordCacheOnlyMiss(zoneName, response)
+	h.log(ctx).ZoneDebug(zoneName, "Cache miss in cache-only mode", "domain", question.Name, "type", dns.TypeToString[question.Qtype], "response", response)
+
+	switch response {
+	case config.CacheOnlyRefused:
+		msg := new(dns.Msg)
+		msg.SetRcode(r, dns.RcodeRefused)
+		_ = w.WriteMsg(msg)
+		return
+	case config.CacheOnlyStale:
+		if zoneCache, ok := h.zoneCaches[zoneName]; ok {
+			if stale, found := zoneCache.GetStale(h.cacheKey(ctx, zone, question, clientIP)); found {
+				stale.Id = r.Id
+				setEDE(stale, r, dns.ExtendedErrorCodeStaleAnswer, "cache-only mode")
+				metrics.RecordStaleResponse(zoneName, "cache_only")
+				_ = w.WriteMsg(stale)
+				return
+			}
+		}
+	}
+
+	msg := new(dns.Msg)
+	msg.SetRcode(r, dns.RcodeServerFailure)
+	setEDE(msg, r, dns.ExtendedErrorCodeNoReachableAuthority, "cache-only mode")
+	_ = w.WriteMsg(msg)
+}
+
+// discardResponseWriter drops responses from background refreshes, which only
+// exist to update the cache
+type discardResponseWriter struct {
+	dns.ResponseWriter
+	remoteAddr net.Addr
+}
+
+func (w *discardResponseWriter) RemoteAddr() net.Addr    { return w.remoteAddr }
+func (w *discardResponseWriter) WriteMsg(*dns.Msg) error { return nil }
+
+// setEDE attaches an Extended DNS Error (RFC 8914) to msg if the client sent
+// an OPT record
+func setEDE(msg, req *dns.Msg, code uint16, text string) {
+	reqOpt := req.IsEdns0()
+	if reqOpt == nil {
+		return
+	}
+	opt := msg.IsEdns0()
+	if opt == nil {
+		msg.SetEdns0(reqOpt.UDPSize(), reqOpt.Do())
+		opt = msg.IsEdns0()
+	}
+	opt.Option = append(opt.Option, &dns.EDNS0_EDE{InfoCode: code, ExtraText: text})
+}
+
+// compressResponseWriter applies the zone's name compression setting to every
+// message written, including responses relayed from backends
+type compressResponseWriter struct {
+	dns.ResponseWriter
+	compress bool
+}
+
+func (w *compressResponseWriter) WriteMsg(m *dns.Msg) error {
+	m.Compress = w.compress
+	return w.ResponseWriter.WriteMsg(m)
+}
+
+// fixedTTLResponseWriter sets the TTL of every answer record to the zone's
+// fixed TTL, for forwarded, cached and synthesized responses alike
+type fixedTTLResponseWriter struct {
+	dns.ResponseWriter
+	ttl uint32
+}
+
+func (w *fixedTTLResponseWriter) WriteMsg(m *dns.Msg) error {
+	for _, rr := range m.Answer {
+		rr.Header().Ttl = w.ttl
+	}
+	return w.ResponseWriter.WriteMsg(m)
+}
+
+// rotateResponseWriter rotates the answered records by offset positions, so
+// consecutive queries list each address first in turn
+type rotateResponseWriter struct {
+	dns.ResponseWriter
+	offset uint64
+}
+
+func (w *rotateResponseWriter) WriteMsg(m *dns.Msg) error {
+	if len(m.Question) > 0 {
+		rotateRecords(m.Answer, m.Question[0].Qtype, w.offset)
+	}
+	return w.ResponseWriter.WriteMsg(m)
+}
+
+// rotateRecords rotates the records of type qtype in answer left by offset
+// among their own positions. CNAMEs and other records stay where they are,
+// so a chain still leads to the records it names.
+func rotateRecords(answer []dns.RR, qtype uint16, offset uint64) {
+	var positions []int
+	for i, rr := range answer {
+		if rr.Header().Rrtype == qtype {
+			positions = append(positions, i)
+		}
+	}
+	if len(positions) < 2 {
+		return
+	}
+
+	records := make([]dns.RR, len(positions))
+	for i, pos := range positions {
+		records[i] = answer[pos]
+	}
+	shift := int(offset % uint64(len(records)))
+	for i, pos := range positions {
+		answer[pos] = records[(i+shift)%len(records)]
+	}
+}
+
+// timeFloorResponseWriter holds responses back until notBefore, so every
+// answer takes at least the zone's responseTimeFloor. A held response is
+// only sent by flush; without notBefore responses are written at once.
+type timeFloorResponseWriter struct {
+	dns.ResponseWriter
+	notBefore time.Time
+	held      *dns.Msg
+}
+
+func (w *timeFloorResponseWriter) WriteMsg(m *dns.Msg) error {
+	if w.notBefore.IsZero() {
+		return w.ResponseWriter.WriteMsg(m)
+	}
+	w.held = m.Copy()
+	return nil
+}
+
+// flush waits out the floor and sends the held response, if any
+func (w *timeFloorResponseWriter) flush() {
+	if w.held == nil {
+		return
+	}
+	time.Sleep(time.Until(w.notBefore))
+	_ = w.ResponseWriter.WriteMsg(w.held)
+}
+
+func (h *TailscaleDNSHandler) handleZoneQuery(ctx context.Context, w dns.ResponseWriter, r *dns.Msg, question dns.Question, zone *config.Zone, zoneName string) {
+	log := h.log(ctx)
+	trans := h.via6Trans.WithLogger(log)
+
+	msg := new(dns.Msg)
+	msg.SetReply(r)
+	msg.Authoritative = true
+	// 4via6 answers are synthesized and never signed, so they are never authenticated
+	msg.AuthenticatedData = false
+
+	// The zone apex is this server's own, whatever the reflected domain holds
+	if question.Qtype == dns.TypeSOA && strings.EqualFold(zone.Apex(question.Name), dns.Fqdn(question.Name)) {
+		msg.Answer = append(msg.Answer, h.zoneSOA(zone, question.Name))
+		log.ZoneDebug(zoneName, "Zone apex SOA answered", "domain", question.Name)
+		_ = w.WriteMsg(msg)
+		return
+	}
+	if question.Qtype == dns.TypeNS && strings.EqualFold(zone.Apex(question.Name), dns.Fqdn(question.Name)) {
+		msg.Answer = append(msg.Answer, h.zoneNS(zone, question.Name)...)
+		log.ZoneDebug(zoneName, "Zone apex NS answered", "domain", question.Name)
+		_ = w.WriteMsg(msg)
+		return
+	}
+
+	cnameTarget, hasCNAME := trans.MagicDNSTarget(question.Name)
+
+	nameNotFound := false
+	existenceKnown := false          // Whether a backend answer already told if the reflected name exists
+	var chainErr error               // Set when the reflected name's CNAME chain loops or runs too deep
+	resolvedTTL := time.Duration(-1) // How long the reflected A records stay valid, if resolved
+	if !hasCNAME || !zone.CNAMEOnly {
+		if question.Qtype == dns.TypeAAAA && zone.ReflectsDirectly() {
+			// The reflected name's own AAAA records, renamed to the queried name
+			answers, upstreamTTL, err := trans.ReflectAAAA(question.Name)
+			if errors.Is(err, via6.ErrNameNotFound) {
+				nameNotFound = true
+				log.ZoneDebug(zoneName, "Reflected name does not exist", "domain", question.Name)
+			} else if isCNAMEChainError(err) {
+				chainErr = err
+				log.ZoneWarn(zoneName, "Reflected name has a bad CNAME chain", "domain", question.Name, "error", err)
+				metrics.RecordVia6Error(zoneName, "cname_chain")
+			} else if err != nil {
+				log.ZoneError(zoneName, "Direct AAAA reflection failed", "domain", question.Name, "error", err)
+				metrics.RecordVia6Error(zoneName, "reflection_failed")
+			} else {
+				resolvedTTL = time.Duration(upstreamTTL) * time.Second
+				ttl := zone.AnswerTTL(h.runtimeCfg.DefaultTTL)
+				if zone.InheritUpstreamTTL {
+					ttl = min(upstreamTTL, ttl)
+				}
+				for _, rr := range answers {
+					rr.Header().Ttl = ttl
+				}
+				msg.Answer = append(msg.Answer, answers...)
+			}
+		} else if question.Qtype == dns.TypeAAAA {
+			// One AAAA per reflected A record, so clients can fail over between them
+			via6Addrs, upstreamTTL, err := trans.TranslateToVia6Addrs(question.Name)
+			if errors.Is(err, via6.ErrNameNotFound) {
+				nameNotFound = true
+				log.ZoneDebug(zoneName, "Reflected name does not exist", "domain", question.Name)
+			} else if isCNAMEChainError(err) {
+				chainErr = err
+				log.ZoneWarn(zoneName, "Reflected name has a bad CNAME chain", "domain", question.Name, "error", err)
+				metrics.RecordVia6Error(zoneName, "cname_chain")
+			} else if err != nil {
+				log.ZoneError(zoneName, "4via6 translation failed", "domain", question.Name, "error", err)
+				metrics.RecordVia6Error(zoneName, "translation_failed")
+			} else {
+				metrics.RecordVia6Translation(zoneName)
+				resolvedTTL = time.Duration(upstreamTTL) * time.Second
+				// Downstream caches follow the reflected A record's volatility when inherited
+				ttl := zone.AnswerTTL(h.runtimeCfg.DefaultTTL)
+				if zone.InheritUpstreamTTL {
+					ttl = min(upstreamTTL, ttl)
+				}
+				for _, via6IP := range via6Addrs {
+					msg.Answer = append(msg.Answer, &dns.AAAA{
+						Hdr:  dns.RR_Header{Name: question.Name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: ttl},
+						AAAA: via6IP,
+					})
+				}
+			}
+
+			if zone.PassthroughAAAA {
+				native, err := trans.ResolveAAAA(question.Name)
+				if err != nil {
+					log.ZoneWarn(zoneName, "Real AAAA lookup failed, answering 4via6 only", "domain", question.Name, "error", err)
+				} else if zone.Via6Order == config.Via6OrderLast {
+					msg.Answer = append(native, msg.Answer...)
+				} else {
+					msg.Answer = append(msg.Answer, native...)
+				}
+			}
+		} else if question.Qtype == dns.TypeHTTPS || question.Qtype == dns.TypeSVCB {
+			// Service bindings keep their parameters but carry 4via6 ipv6hint values
+			answers, err := trans.TranslateSVCB(question.Name, question.Qtype)
+			existenceKnown = err == nil || errors.Is(err, via6.ErrNameNotFound) || isCNAMEChainError(err)
+			if errors.Is(err, via6.ErrNameNotFound) {
+				nameNotFound = true
+				log.ZoneDebug(zoneName, "Reflected name does not exist", "domain", question.Name)
+			} else if isCNAMEChainError(err) {
+				chainErr = err
+				log.ZoneWarn(zoneName, "Reflected name has a bad CNAME chain", "domain", question.Name, "error", err)
+				metrics.RecordVia6Error(zoneName, "cname_chain")
+			} else if err != nil {
+				log.ZoneError(zoneName, "4via6 service binding translation failed", "domain", question.Name, "type", dns.TypeToString[question.Qtype], "error", err)
+				metrics.RecordVia6Error(zoneName, "svcb_translation_failed")
+			} else if len(answers) > 0 {
+				metrics.RecordVia6Translation(zoneName)
+				msg.Answer = append(msg.Answer, answers...)
+			}
+		}
+	}
+
+	// Without records of the queried type, tell NXDOMAIN from NODATA by whether
+	// the reflected name exists, and carry an SOA so resolvers cache either. A
+	// reflected name whose CNAME chain can't be followed is a server failure.
+	// The reflected name is only resolved here if no earlier lookup told.
+	if len(msg.Answer) == 0 {
+		if question.Qtype != dns.TypeAAAA && !existenceKnown {
+			_, _, err := trans.TranslateToVia6Addrs(question.Name)
+			nameNotFound = errors.Is(err, via6.ErrNameNotFound)
+			if isCNAMEChainError(err) {
+				chainErr = err
+			}
+		}
+		if chainErr != nil {
+			msg.Rcode = dns.RcodeServerFailure
+		} else if nameNotFound {
+			msg.Rcode = dns.RcodeNameError
+		}
+		if chainErr == nil {
+			msg.Ns = append(msg.Ns, h.zoneSOA(zone, question.Name))
+		}
+	}
+
+	// Alias the name to its MagicDNS name: clients that prefer native MagicDNS
+	// follow the CNAME, others use the 4via6 records now owned by the target
+	if hasCNAME {
+		for _, rr := range msg.Answer {
+			rr.Header().Name = cnameTarget
+		}
+		msg.Answer = append([]dns.RR{&dns.CNAME{
+			Hdr:    dns.RR_Header{Name: question.Name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: zone.AnswerTTL(h.runtimeCfg.DefaultTTL)},
+			Target: cnameTarget,
+		}}, msg.Answer...)
+	}
+
+	if zone.AuthorityNS && len(msg.Answer) > 0 {
+		msg.Ns = append(msg.Ns, h.zoneNS(zone, question.Name)...)
+	}
+
+	// Cache the response if zone has caching enabled (before sending)
+	if zoneCache, exists := h.zoneCaches[zoneName]; exists && chainErr == nil {
+		cacheKey := h.cacheKey(ctx, zone, question, h.getClientIP(w.RemoteAddr()))
+		// The answer is only as fresh as the A records it was built from, even
+		// when its own TTL is the default
+		if resolvedTTL >= 0 {
+			zoneCache.SetSynthesizedFor(cacheKey, msg, resolvedTTL)
 		} else {
-			metrics.RecordVia6Translation(zoneName)
+			zoneCache.SetSynthesized(cacheKey, msg)
+		}
+		metrics.UpdateCacheSize(zoneName, zoneCache.Size())
+		log.ZoneDebug(zoneName, "Response cached", "domain", question.Name, "type", dns.TypeToString[question.Qtype])
+	}
+
+	// Tell validating clients why RRSIGs are absent instead of leaving them to
+	// treat the answer as bogus. Added after caching since it depends on the DO bit.
+	if opt := r.IsEdns0(); opt != nil && opt.Do() && zone.SynthesizedEDE {
+		setEDE(msg, r, edeSynthesized, "4via6 answer synthesized by tsdnsreflector; zone is unsigned")
+	}
+	if chainErr != nil {
+		// Leave out the error itself, which names the reflected domain
+		text := "CNAME chain of reflected name exceeds maxCnameDepth"
+		if errors.Is(chainErr, via6.ErrCNAMELoop) {
+			text = "CNAME loop in reflected name"
+		}
+		setEDE(msg, r, dns.ExtendedErrorCodeOther, text)
+	}
+
+	_ = w.WriteMsg(msg)
+}
+
+// isCNAMEChainError reports whether err is a reflected name's CNAME chain
+// looping or running past the zone's maxCnameDepth
+func isCNAMEChainError(err error) bool {
+	return errors.Is(err, via6.ErrCNAMELoop) || errors.Is(err, via6.ErrCNAMEChainTooLong)
+}
+
+// zoneSOA returns the SOA record of the zone apex above name, answering SOA
+// queries and filling the authority section of negative 4via6 answers, whose
+// MINIMUM bounds how long resolvers cache them (RFC 2308)
+func (h *TailscaleDNSHandler) zoneSOA(zone *config.Zone, name string) *dns.SOA {
+	apex := zone.Apex(name)
+	if apex == "" {
+		apex = dns.Fqdn(name)
+	}
+	soa := &dns.SOA{
+		Hdr:     dns.RR_Header{Name: apex, Rrtype: dns.TypeSOA, Class: dns.ClassINET},
+		Ns:      "ns." + apex,
+		Mbox:    "hostmaster." + apex,
+		Serial:  1,
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  86400,
+		Minttl:  uint32(zone.NegativeCacheTTL() / time.Second),
+	}
+	if c := zone.SOA; c != nil {
+		if c.MName != "" {
+			soa.Ns = dns.Fqdn(c.MName)
+		}
+		if c.RName != "" {
+			soa.Mbox = dns.Fqdn(c.RName)
+		}
+		soa.Serial = cmp.Or(c.Serial, soa.Serial)
+		soa.Refresh = cmp.Or(c.Refresh, soa.Refresh)
+		soa.Retry = cmp.Or(c.Retry, soa.Retry)
+		soa.Expire = cmp.Or(c.Expire, soa.Expire)
+		soa.Minttl = cmp.Or(c.Minimum, soa.Minttl)
+	}
+	soa.Hdr.Ttl = min(soa.Minttl, zone.AnswerTTL(h.runtimeCfg.DefaultTTL))
+	return soa
+}
+
+// zoneNS returns the NS records of the zone apex above name: the zone's
+// nameservers, or this node's own MagicDNS name if it sets none
+func (h *TailscaleDNSHandler) zoneNS(zone *config.Zone, name string) []dns.RR {
+	apex := zone.Apex(name)
+	if apex == "" {
+		apex = dns.Fqdn(name)
+	}
+	targets := zone.Nameservers
+	if len(targets) == 0 {
+		hostname := cmp.Or(h.runtimeCfg.TSHostname, h.runtimeCfg.Hostname)
+		targets = []string{hostname + "." + h.magicDNSSuffix()}
+	}
+	records := make([]dns.RR, 0, len(targets))
+	for _, target := range targets {
+		records = append(records, &dns.NS{
+			Hdr: dns.RR_Header{Name: apex, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: zone.AnswerTTL(h.runtimeCfg.DefaultTTL)},
+			Ns:  dns.Fqdn(strings.ToLower(target)),
+		})
+	}
+	return records
+}
+
+// via6ApexRcode returns the rcode answering a 4via6 network name
+func (h *TailscaleDNSHandler) via6ApexRcode() int {
+	if h.runtimeCfg.Via6ApexAnswer == config.Via6ApexNoData {
+		return dns.RcodeSuccess
+	}
+	return dns.RcodeNameError
+}
+
+// handleVia6ReverseNegative answers a reverse query in the 4via6 range that
+// has no PTR record: NXDOMAIN for names no zone can own, NODATA for names
+// with zone addresses below them
+func (h *TailscaleDNSHandler) handleVia6ReverseNegative(ctx context.Context, w dns.ResponseWriter, r *dns.Msg, question dns.Question, rcode int) {
+	msg := new(dns.Msg)
+	msg.SetRcode(r, rcode)
+	msg.Authoritative = true
+	h.log(ctx).Debug("4via6 reverse query answered locally", "domain", question.Name, "rcode", dns.RcodeToString[rcode])
+	_ = w.WriteMsg(msg)
+}
+
+// handleVia6PTRQuery answers a PTR query for a 4via6 address with the zone
+// names whose AAAA translation is that address
+func (h *TailscaleDNSHandler) handleVia6PTRQuery(ctx context.Context, w dns.ResponseWriter, r *dns.Msg, question dns.Question, via6IP net.IP) {
+	names, upstreamTTL, err := h.via6Trans.WithLogger(h.log(ctx)).ReverseVia6(via6IP)
+	if err != nil {
+		h.log(ctx).Warn("4via6 reverse lookup failed", "domain", question.Name, "address", via6IP.String(), "error", err)
+		msg := new(dns.Msg)
+		msg.SetRcode(r, dns.RcodeServerFailure)
+		_ = w.WriteMsg(msg)
+		return
+	}
+
+	msg := new(dns.Msg)
+	msg.SetReply(r)
+	msg.Authoritative = true
+	if len(names) == 0 {
+		msg.Rcode = dns.RcodeNameError
+	}
+	ttl := min(upstreamTTL, h.runtimeCfg.DefaultTTL)
+	for _, name := range names {
+		msg.Answer = append(msg.Answer, &dns.PTR{
+			Hdr: dns.RR_Header{Name: question.Name, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: ttl},
+			Ptr: name,
+		})
+	}
+	h.log(ctx).Debug("4via6 reverse lookup", "domain", question.Name, "address", via6IP.String(), "names", names)
+	_ = w.WriteMsg(msg)
+}
+
+// handleSpecialUseQuery answers RFC 6761 special-use domains without contacting any backend
+func (h *TailscaleDNSHandler) handleSpecialUseQuery(ctx context.Context, w dns.ResponseWriter, r *dns.Msg, question dns.Question, policy string) {
+	msg := new(dns.Msg)
+	msg.SetReply(r)
+	msg.Authoritative = true
+
+	switch policy {
+	case config.SpecialUseLoopback:
+		if question.Qtype == dns.TypeA {
+			msg.Answer = append(msg.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: question.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: h.runtimeCfg.DefaultTTL},
+				A:   net.IPv4(127, 0, 0, 1),
+			})
+		} else if question.Qtype == dns.TypeAAAA {
 			msg.Answer = append(msg.Answer, &dns.AAAA{
 				Hdr:  dns.RR_Header{Name: question.Name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: h.runtimeCfg.DefaultTTL},
-				AAAA: via6IP,
+				AAAA: net.IPv6loopback,
 			})
 		}
+	default:
+		msg.Rcode = dns.RcodeNameError
 	}
-	// For A queries on 4via6 domains, return NODATA (empty answer)
 
-	// Cache the response if zone has caching enabled (before sending)
-	if zoneCache, exists := h.zoneCaches[zoneName]; exists {
-		cacheKey := cache.CacheKey(question.Name, question.Qtype, nil) // Remove client IP for better cache efficiency
-		zoneCache.Set(cacheKey, msg)
-		metrics.UpdateCacheSize(zoneName, zoneCache.Size())
-		h.logger.ZoneDebug(zoneName, "Response cached", "domain", question.Name, "type", dns.TypeToString[question.Qtype])
-	}
-	
+	h.log(ctx).Debug("Special-use domain answered locally", "domain", question.Name, "policy", policy)
 	_ = w.WriteMsg(msg)
 }
 
+// findZoneName returns the configured name of zone
+func (h *TailscaleDNSHandler) findZoneName(zone *config.Zone) string {
+	for name, z := range h.config.Zones {
+		if z == zone {
+			return name
+		}
+	}
+	return "default"
+}
+
+// cacheKey builds the zone cache key. Answers are shared between clients for
+// better cache efficiency unless the zone opts into per-client entries.
+// External clients of a 4via6 zone get real addresses, so their answers are
+// kept apart from the 4via6 ones, as are the answers of each tag and region
+// backend.
+func (h *TailscaleDNSHandler) cacheKey(ctx context.Context, zone *config.Zone, question dns.Question, clientIP netip.Addr) string {
+	if zone != nil && zone.CachePerClient && clientIP.IsValid() {
+		return cache.CacheKey(question.Name, question.Qtype, clientIP.AsSlice())
+	}
+	key := cache.CacheKey(question.Name, question.Qtype, nil)
+	isTailscaleClient := h.isTailscaleClient(clientIP)
+	if h.servesExternalVia6(zone, isTailscaleClient) {
+		key += ":external"
+	}
+	if zone != nil && (len(zone.BackendByTag) > 0 || len(zone.RegionBackends) > 0) {
+		choice := h.chooseZoneBackend(ctx, zone, clientIP, isTailscaleClient)
+		if choice.tag != "" {
+			key += ":tag=" + choice.tag
+		}
+		if choice.region != "" {
+			key += ":region=" + choice.region
+		}
+	}
+	return key
+}
+
+// zoneBackend returns the backend for clientIP: the zone's tag backend for
+// one of a tailnet client's ACL tags, else its most specific region backend
+// matching the client's GeoIP location, else the zone's default backend
+func (h *TailscaleDNSHandler) zoneBackend(ctx context.Context, zone *config.Zone, zoneName string, clientIP netip.Addr, isTailscaleClient bool) config.BackendConfig {
+	choice := h.chooseZoneBackend(ctx, zone, clientIP, isTailscaleClient)
+
+	if choice.tagChecked {
+		if choice.tagErr != nil {
+			h.log(ctx).ZoneDebug(zoneName, "Client tag lookup failed", "client", clientIP.String(), "error", choice.tagErr)
+		}
+		if choice.tag != "" {
+			h.log(ctx).ZoneDebug(zoneName, "Selected tag backend", "client", clientIP.String(), "tag", choice.tag)
+			metrics.RecordTagBackendSelection(zoneName, choice.tag)
+			return choice.backend
+		}
+		metrics.RecordTagBackendSelection(zoneName, "default")
+	}
+
+	if choice.regionChecked {
+		if choice.regionErr != nil {
+			h.log(ctx).ZoneDebug(zoneName, "GeoIP lookup failed, using default backend", "client", clientIP.String(), "error", choice.regionErr)
+		}
+		if choice.region != "" {
+			h.log(ctx).ZoneDebug(zoneName, "Selected region backend", "client", clientIP.String(), "region", choice.region)
+			metrics.RecordRegionBackendSelection(zoneName, choice.region)
+			return choice.backend
+		}
+		metrics.RecordRegionBackendSelection(zoneName, "default")
+	}
+	return choice.backend
+}
+
+// backendChoice is the backend a zone query goes to and how it was chosen
+type backendChoice struct {
+	backend config.BackendConfig
+
+	tagChecked bool   // Whether the client's ACL tags were looked up
+	tag        string // Tag the backend was selected by, if any
+	tagErr     error
+
+	regionChecked bool   // Whether the client's GeoIP location was looked up
+	region        string // Region the backend was selected by, if any
+	regionErr     error
+}
+
+// chooseZoneBackend selects the backend for clientIP as zoneBackend does, but
+// without logging or metrics, so trace queries can report it too
+func (h *TailscaleDNSHandler) chooseZoneBackend(ctx context.Context, zone *config.Zone, clientIP netip.Addr, isTailscaleClient bool) backendChoice {
+	choice := backendChoice{backend: zone.Backend}
+
+	if len(zone.BackendByTag) > 0 && isTailscaleClient && h.clientTags != nil {
+		choice.tagChecked = true
+		tags, err := h.clientTags.Get(ctx, clientIP)
+		if err != nil {
+			choice.tagErr = err
+		} else if tag, backend, ok := selectTagBackend(zone.BackendByTag, tags); ok {
+			choice.tag, choice.backend = tag, backend
+			return choice
+		}
+	}
+
+	if len(zone.RegionBackends) == 0 || h.geoip == nil {
+		return choice
+	}
+
+	choice.regionChecked = true
+	location, err := h.geoip.Lookup(clientIP)
+	if err != nil {
+		choice.regionErr = err
+		return choice
+	}
+	if region, backend, ok := selectRegionBackend(zone.RegionBackends, location.Regions()); ok {
+		choice.region, choice.backend = region, backend
+	}
+	return choice
+}
+
+// selectTagBackend returns the backend for the client tag that sorts first
+// among those with one, so clients with several tags always get the same backend
+func selectTagBackend(tagBackends map[string]config.BackendConfig, tags []string) (string, config.BackendConfig, bool) {
+	var selected string
+	for _, tag := range tags {
+		if _, ok := tagBackends[tag]; ok && (selected == "" || tag < selected) {
+			selected = tag
+		}
+	}
+	if selected == "" {
+		return "", config.BackendConfig{}, false
+	}
+	return selected, tagBackends[selected], true
+}
+
+// selectRegionBackend returns the backend for the first of regions (ordered
+// most specific first) that has one
+func selectRegionBackend(regionBackends map[string]config.BackendConfig, regions []string) (string, config.BackendConfig, bool) {
+	for _, region := range regions {
+		if backend, ok := regionBackends[strings.ToUpper(region)]; ok {
+			return region, backend, true
+		}
+	}
+	return "", config.BackendConfig{}, false
+}
 
 // isMagicDNSDomain checks if domain should be resolved via MagicDNS
 func (h *TailscaleDNSHandler) isMagicDNSDomain(domain string) bool {
 	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
-	return strings.HasSuffix(domain, ".ts.net")
+	return strings.HasSuffix(domain, "."+h.magicDNSSuffix())
+}
+
+// magicDNSSuffix returns the domain resolved via MagicDNS: the configured
+// override, else the tailnet's detected domain, else defaultMagicDNSSuffix
+func (h *TailscaleDNSHandler) magicDNSSuffix() string {
+	if suffix := normalizeMagicDNSSuffix(h.runtimeCfg.MagicDNSSuffix); suffix != "" {
+		return suffix
+	}
+	return cmp.Or(h.magicSuffix, defaultMagicDNSSuffix)
+}
+
+// normalizeMagicDNSSuffix lowercases suffix and strips its surrounding dots
+func normalizeMagicDNSSuffix(suffix string) string {
+	return strings.ToLower(strings.Trim(suffix, "."))
+}
+
+// tailnetDomain returns the tailnet portion of a node's MagicDNS name, e.g.
+// tail1234.ts.net for host.tail1234.ts.net., or empty if it has none
+func tailnetDomain(dnsName string) string {
+	_, domain, ok := strings.Cut(normalizeMagicDNSSuffix(dnsName), ".")
+	if !ok || !strings.Contains(domain, ".") {
+		return ""
+	}
+	return domain
 }
 
 // handleMagicDNSQuery resolves MagicDNS domains using TSNet's LocalClient.Status()
-func (h *TailscaleDNSHandler) handleMagicDNSQuery(w dns.ResponseWriter, r *dns.Msg, question dns.Question) {
+func (h *TailscaleDNSHandler) handleMagicDNSQuery(ctx context.Context, w dns.ResponseWriter, r *dns.Msg, question dns.Question) {
 
 	if h.tsnetServer == nil {
-		h.logger.Warn("TSNet server not available for MagicDNS query", "domain", question.Name)
+		h.log(ctx).Warn("TSNet server not available for MagicDNS query", "domain", question.Name)
 		h.forwarder.Forward(w, r)
 		return
 	}
 
-	ctx := context.Background()
-
 	localClient, err := h.tsnetServer.LocalClient()
 	if err != nil {
-		h.logger.Error("Failed to get LocalClient for MagicDNS", "error", err)
+		h.log(ctx).Error("Failed to get LocalClient for MagicDNS", "error", err)
 		h.forwarder.Forward(w, r)
 		return
 	}
 
 	// Use LocalClient.Status() to resolve hostname from peer list
 	domain := strings.TrimSuffix(question.Name, ".")
-	ip, _, err := h.resolveHostname(ctx, localClient, domain)
+	addrs, _, err := h.resolveHostname(ctx, localClient, domain)
 	if err != nil {
-		h.logger.Debug("MagicDNS resolution failed", "domain", question.Name, "error", err)
+		h.log(ctx).Debug("MagicDNS resolution failed", "domain", question.Name, "error", err)
 
 		// Return NXDOMAIN - hostname not found in tailnet
 		msg := new(dns.Msg)
@@ -550,30 +1929,46 @@ func (h *TailscaleDNSHandler) handleMagicDNSQuery(w dns.ResponseWriter, r *dns.M
 		_ = w.WriteMsg(msg)
 		return
 	}
+	h.writeMagicDNSAnswer(ctx, w, r, question, addrs)
+}
 
-	msg := new(dns.Msg)
-	msg.SetReply(r)
-	msg.Authoritative = true
-
-	if question.Qtype == dns.TypeA && ip.Is4() {
-		msg.Answer = append(msg.Answer, &dns.A{
-			Hdr: dns.RR_Header{Name: question.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: h.runtimeCfg.DefaultTTL},
-			A:   ip.AsSlice(),
-		})
-	} else if question.Qtype == dns.TypeAAAA && ip.Is6() {
-		msg.Answer = append(msg.Answer, &dns.AAAA{
-			Hdr:  dns.RR_Header{Name: question.Name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: h.runtimeCfg.DefaultTTL},
-			AAAA: ip.AsSlice(),
-		})
+// handleMagicDNSShortName answers a bare hostname query with the Tailscale
+// addresses of the node whose name starts with it. It reports whether it
+// answered; names no node has are left to the caller.
+func (h *TailscaleDNSHandler) handleMagicDNSShortName(ctx context.Context, w dns.ResponseWriter, r *dns.Msg, question dns.Question) bool {
+	if h.tsnetServer == nil {
+		return false
+	}
+	localClient, err := h.tsnetServer.LocalClient()
+	if err != nil {
+		return false
 	}
 
-	if len(msg.Answer) == 0 {
-		// No appropriate record type found
-		msg.Rcode = dns.RcodeNameError
+	addrs, _, err := h.resolveHostname(ctx, localClient, question.Name)
+	switch {
+	case errors.Is(err, errAmbiguousHostname):
+		h.log(ctx).Debug("MagicDNS short name is ambiguous", "domain", question.Name, "error", err)
+		msg := new(dns.Msg)
+		msg.SetRcode(r, dns.RcodeNameError)
+		_ = w.WriteMsg(msg)
+		return true
+	case err != nil:
+		return false
 	}
+	h.writeMagicDNSAnswer(ctx, w, r, question, addrs)
+	return true
+}
+
+// writeMagicDNSAnswer answers question with a node's Tailscale addresses
+func (h *TailscaleDNSHandler) writeMagicDNSAnswer(ctx context.Context, w dns.ResponseWriter, r *dns.Msg, question dns.Question, addrs []netip.Addr) {
+	msg := new(dns.Msg)
+	msg.SetReply(r)
+	msg.Authoritative = true
+	// The name exists, so without addresses of the queried family this is NODATA
+	msg.Answer = magicDNSAnswer(question, addrs, h.runtimeCfg.DefaultTTL)
 
 	if h.runtimeCfg.LogQueries {
-		h.logger.Info("MagicDNS resolved", "name", question.Name, "ip", ip.String())
+		h.log(ctx).Info("MagicDNS resolved", "name", question.Name, "ips", addrs)
 	}
 
 	// Record DNS response
@@ -581,35 +1976,126 @@ func (h *TailscaleDNSHandler) handleMagicDNSQuery(w dns.ResponseWriter, r *dns.M
 	_ = w.WriteMsg(msg)
 }
 
-// resolveHostname resolves a hostname using TSNet's LocalClient.Status()
-func (h *TailscaleDNSHandler) resolveHostname(ctx context.Context, localClient *local.Client, hostname string) (netip.Addr, string, error) {
+// magicDNSAnswer returns a record for each of a node's Tailscale addresses
+// matching the question's type: A for IPv4, AAAA for IPv6
+func magicDNSAnswer(question dns.Question, addrs []netip.Addr, ttl uint32) []dns.RR {
+	var answer []dns.RR
+	for _, ip := range addrs {
+		switch {
+		case question.Qtype == dns.TypeA && ip.Is4():
+			answer = append(answer, &dns.A{
+				Hdr: dns.RR_Header{Name: question.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+				A:   ip.AsSlice(),
+			})
+		case question.Qtype == dns.TypeAAAA && ip.Is6():
+			answer = append(answer, &dns.AAAA{
+				Hdr:  dns.RR_Header{Name: question.Name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: ttl},
+				AAAA: ip.AsSlice(),
+			})
+		}
+	}
+	return answer
+}
+
+// resolveHostname resolves a hostname to its Tailscale addresses using TSNet's
+// LocalClient.Status()
+func (h *TailscaleDNSHandler) resolveHostname(ctx context.Context, localClient *local.Client, hostname string) ([]netip.Addr, string, error) {
 	status, err := localClient.Status(ctx)
 	if err != nil {
-		return netip.Addr{}, "", fmt.Errorf("failed to get Tailscale status: %w", err)
+		return nil, "", fmt.Errorf("failed to get Tailscale status: %w", err)
 	}
 
-	hostname = strings.ToLower(strings.TrimSuffix(hostname, "."))
+	return h.lookupPeer(status, hostname)
+}
 
-	// Check self
-	if status.Self != nil && len(status.Self.TailscaleIPs) > 0 {
-		selfDNS := strings.ToLower(strings.TrimSuffix(status.Self.DNSName, "."))
-		if hostname == selfDNS || strings.HasPrefix(selfDNS, hostname+".") {
-			return status.Self.TailscaleIPs[0], status.Self.DNSName, nil
+// errAmbiguousHostname is returned in strict mode for a short hostname shared
+// by several nodes
+var errAmbiguousHostname = errors.New("hostname matches several nodes")
+
+// lookupPeer finds hostname among the node itself and its peers and returns
+// all of its Tailscale addresses. A short hostname shared by several nodes
+// resolves to the first of them by name, or fails in strict mode.
+func (h *TailscaleDNSHandler) lookupPeer(status *ipnstate.Status, hostname string) ([]netip.Addr, string, error) {
+	matches := matchPeers(status, hostname, h.runtimeCfg.MagicDNSTagList())
+	if len(matches) == 0 {
+		return nil, "", fmt.Errorf("hostname %q not found", hostname)
+	}
+	if len(matches) > 1 {
+		names := make([]string, len(matches))
+		for i, peer := range matches {
+			names[i] = peer.DNSName
+		}
+		if h.runtimeCfg.MagicDNSStrictShort {
+			return nil, "", fmt.Errorf("%w: %q matches %v", errAmbiguousHostname, hostname, names)
 		}
+		h.logger.Warn("MagicDNS hostname matches several nodes, answering the first", "hostname", hostname, "matches", names)
 	}
+	return slices.Clone(matches[0].TailscaleIPs), matches[0].DNSName, nil
+}
 
-	// Check peers
+// matchPeers returns the nodes, the node itself among them, whose MagicDNS
+// name is hostname or starts with it, ordered by name. A full name match is
+// returned alone. With tags, only nodes carrying at least one of them match.
+func matchPeers(status *ipnstate.Status, hostname string, tags []string) []*ipnstate.PeerStatus {
+	hostname = strings.ToLower(strings.TrimSuffix(hostname, "."))
+
+	nodes := make([]*ipnstate.PeerStatus, 0, len(status.Peer)+1)
+	if status.Self != nil {
+		nodes = append(nodes, status.Self)
+	}
 	for _, peer := range status.Peer {
-		if len(peer.TailscaleIPs) == 0 {
+		nodes = append(nodes, peer)
+	}
+
+	var matches []*ipnstate.PeerStatus
+	for _, node := range nodes {
+		if len(node.TailscaleIPs) == 0 || !hasAnyTag(node, tags) {
 			continue
 		}
-		peerDNS := strings.ToLower(strings.TrimSuffix(peer.DNSName, "."))
-		if hostname == peerDNS || strings.HasPrefix(peerDNS, hostname+".") {
-			return peer.TailscaleIPs[0], peer.DNSName, nil
+		nodeDNS := strings.ToLower(strings.TrimSuffix(node.DNSName, "."))
+		if hostname == nodeDNS {
+			return []*ipnstate.PeerStatus{node}
+		}
+		if strings.HasPrefix(nodeDNS, hostname+".") {
+			matches = append(matches, node)
 		}
 	}
+	slices.SortFunc(matches, func(a, b *ipnstate.PeerStatus) int {
+		return strings.Compare(a.DNSName, b.DNSName)
+	})
+	return matches
+}
+
+// hasAnyTag reports whether peer carries one of tags; every peer matches no tags
+func hasAnyTag(peer *ipnstate.PeerStatus, tags []string) bool {
+	if len(tags) == 0 {
+		return true
+	}
+	if peer.Tags == nil {
+		return false
+	}
+	for i := 0; i < peer.Tags.Len(); i++ {
+		if slices.Contains(tags, peer.Tags.At(i)) {
+			return true
+		}
+	}
+	return false
+}
 
-	return netip.Addr{}, "", fmt.Errorf("hostname %q not found", hostname)
+// isUDP reports whether the query arrived over UDP
+func isUDP(w dns.ResponseWriter) bool {
+	addr := w.RemoteAddr()
+	return addr != nil && strings.HasPrefix(addr.Network(), "udp")
+}
+
+// newQueryID returns a short random ID correlating the log lines of one query
+func newQueryID() string {
+	return fmt.Sprintf("%08x", rand.Uint32())
+}
+
+// log returns the logger of the query ctx belongs to, or the handler's
+func (h *TailscaleDNSHandler) log(ctx context.Context) *logger.Logger {
+	return logger.FromContext(ctx, h.logger)
 }
 
 // getClientIP extracts the IP address from a remote address
@@ -624,7 +2110,8 @@ func (h *TailscaleDNSHandler) getClientIP(remoteAddr net.Addr) netip.Addr {
 		return netip.Addr{}
 	}
 
-	return ip
+	// Dual-stack sockets report IPv4 clients as ::ffff:a.b.c.d; classify them as IPv4
+	return ip.Unmap()
 }
 
 // isTailscaleClient determines if the client IP is from the Tailscale network
@@ -653,10 +2140,13 @@ func (h *TailscaleDNSHandler) isTailscaleClient(clientIP netip.Addr) bool {
 
 func NewForwarder(cfg config.BackendConfig, log *logger.Logger) *Forwarder {
 	return &Forwarder{
-		backends: cfg.DNSServers,
-		timeout:  parseTimeout(cfg.Timeout),
-		retries:  cfg.Retries,
-		logger:   log,
+		backends:      cfg.DNSServers,
+		timeout:       parseTimeout(cfg.Timeout),
+		retries:       cfg.Retries,
+		sourceAddr:    net.ParseIP(cfg.SourceAddress),
+		logger:        log,
+		targets:       parseBackendTargets(cfg.DNSServers),
+		tlsServerName: cfg.TLSServerName,
 	}
 }
 
@@ -665,8 +2155,12 @@ func NewForwarderWithTSNet(cfg config.BackendConfig, log *logger.Logger, tsnetSe
 		backends:    cfg.DNSServers,
 		timeout:     parseTimeout(cfg.Timeout),
 		retries:     cfg.Retries,
+		sourceAddr:  net.ParseIP(cfg.SourceAddress),
 		logger:      log,
 		tsnetServer: tsnetServer,
+
+		targets:       parseBackendTargets(cfg.DNSServers),
+		tlsServerName: cfg.TLSServerName,
 	}
 }
 
@@ -674,65 +2168,394 @@ func (f *Forwarder) Forward(w dns.ResponseWriter, r *dns.Msg) {
 	f.ForwardWithZone(w, r, "default")
 }
 
-// queryBackend queries a DNS backend, using TSNet if available
-func (f *Forwarder) queryBackend(r *dns.Msg, backend, zoneName string) (*dns.Msg, error) {
+// log returns the logger of the query ctx belongs to, or the forwarder's
+func (f *Forwarder) log(ctx context.Context) *logger.Logger {
+	return logger.FromContext(ctx, f.logger)
+}
+
+// queryBackend queries a DNS backend, using TSNet if available. The backend
+// timeout is cut short to ctx's deadline.
+func (f *Forwarder) queryBackend(ctx context.Context, r *dns.Msg, backend, zoneName string) (*dns.Msg, error) {
+	start := time.Now()
+	resp, err := f.exchange(ctx, r, backend)
+	elapsed := time.Since(start)
+	// Queries cancelled because another backend answered first say nothing about this one
+	if !errors.Is(err, context.Canceled) {
+		metrics.RecordBackendLatency(zoneName, backend, elapsed)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Defense in depth: never accept a response for a different query
+	if resp.Id != r.Id {
+		metrics.RecordBackendIDMismatch(zoneName, backend)
+		f.log(ctx).ZoneWarn(zoneName, "Dropping backend response with mismatched ID", "backend", backend, "queryID", r.Id, "responseID", resp.Id)
+		return nil, fmt.Errorf("response ID %d does not match query ID %d", resp.Id, r.Id)
+	}
+
+	metrics.RecordBackendDuration(zoneName, backend, elapsed)
+	return resp, nil
+}
+
+func (f *Forwarder) exchange(ctx context.Context, r *dns.Msg, backend string) (*dns.Msg, error) {
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+
+	target, ok := f.targets[backend]
+	if !ok {
+		target = parseBackendTargets([]string{backend})[backend]
+	}
+	switch target.protocol {
+	case config.BackendProtocolTLS:
+		return f.exchangeNet(ctx, r, target.addr, "tcp-tls")
+	case config.BackendProtocolHTTPS:
+		return f.doh.get(f, target.addr).exchange(ctx, r)
+	}
+
+	resp, err := f.exchangeNet(ctx, r, target.addr, "udp")
+	// Fetch the whole answer, so it is truncated against the client's buffer
+	// rather than ours, and TCP clients get it at all
+	if err == nil && resp.Truncated {
+		return f.exchangeNet(ctx, r, target.addr, "tcp")
+	}
+	return resp, err
+}
+
+// tlsConfig returns the TLS settings for a DNS over TLS backend at addr
+func (f *Forwarder) tlsConfig(addr string) *tls.Config {
+	serverName := f.tlsServerName
+	if serverName == "" {
+		serverName, _, _ = net.SplitHostPort(addr)
+	}
+	return &tls.Config{ServerName: serverName, RootCAs: f.tlsRoots, MinVersion: tls.VersionTLS12}
+}
+
+func (f *Forwarder) exchangeNet(ctx context.Context, r *dns.Msg, backend, network string) (*dns.Msg, error) {
 	if f.tsnetServer != nil {
-		ctx, cancel := context.WithTimeout(context.Background(), f.timeout)
-		defer cancel()
-		
-		conn, err := f.tsnetServer.Dial(ctx, "udp", backend)
+		dialNetwork := network
+		if network == "tcp-tls" {
+			dialNetwork = "tcp"
+		}
+		conn, err := f.tsnetServer.Dial(ctx, dialNetwork, backend)
 		if err != nil {
 			return nil, err
 		}
 		defer func() { _ = conn.Close() }()
+
+		// TLS runs over the tailnet connection like plain TCP would
+		if network == "tcp-tls" {
+			tlsConn := tls.Client(conn, f.tlsConfig(backend))
+			if err := tlsConn.HandshakeContext(ctx); err != nil {
+				return nil, err
+			}
+			conn = tlsConn
+		}
 		
 		dnsConn := &dns.Conn{Conn: conn}
-		client := &dns.Client{Timeout: f.timeout}
-		resp, _, err := client.ExchangeWithConn(r, dnsConn)
+		client := &dns.Client{Net: network, Timeout: f.timeout}
+		resp, _, err := client.ExchangeWithConnContext(ctx, r, dnsConn)
 		return resp, err
 	}
 	
-	client := &dns.Client{Timeout: f.timeout}
-	resp, _, err := client.Exchange(r, backend)
+	client := &dns.Client{Net: network, Timeout: f.timeout}
+	if network == "tcp-tls" {
+		client.TLSConfig = f.tlsConfig(backend)
+	}
+	if f.sourceAddr != nil {
+		// Bind the local side so multi-homed hosts egress from the configured IP
+		var localAddr net.Addr = &net.UDPAddr{IP: f.sourceAddr}
+		if network != "udp" {
+			localAddr = &net.TCPAddr{IP: f.sourceAddr}
+		}
+		client.Dialer = &net.Dialer{
+			Timeout:   f.timeout,
+			LocalAddr: localAddr,
+		}
+	}
+	resp, _, err := client.ExchangeContext(ctx, r, backend)
 	return resp, err
 }
 
+// healthyBackends returns the backends that passed their last health check, or
+// all backends if none did so queries still have somewhere to go
+func (f *Forwarder) healthyBackends(zoneName string) []string {
+	if f.health == nil {
+		return f.backends
+	}
+
+	healthy := make([]string, 0, len(f.backends))
+	for _, backend := range f.backends {
+		if f.health.Healthy(zoneName, backend) {
+			healthy = append(healthy, backend)
+		}
+	}
+	if len(healthy) == 0 {
+		return f.backends
+	}
+	return healthy
+}
+
 func (f *Forwarder) ForwardWithZone(w dns.ResponseWriter, r *dns.Msg, zoneName string) {
-	f.ForwardWithZoneAndCache(w, r, zoneName, nil)
+	f.ForwardWithZoneAndCache(w, r, zoneName, nil, "")
 }
 
-func (f *Forwarder) ForwardWithZoneAndCache(w dns.ResponseWriter, r *dns.Msg, zoneName string, zoneCache *cache.ZoneCache) {
+// ForwardWithZoneAndCache forwards r and stores a successful response in
+// zoneCache under cacheKey (derived from the question when empty)
+func (f *Forwarder) ForwardWithZoneAndCache(w dns.ResponseWriter, r *dns.Msg, zoneName string, zoneCache *cache.ZoneCache, cacheKey string) {
+	// ServeDNS wraps its writer already; direct callers get the same EDNS0
+	// negotiation and UDP truncation
+	w = &ednsResponseWriter{ResponseWriter: w, req: r, udpSize: config.DefaultEDNSBufferSize}
+	f.ForwardContext(context.Background(), w, r, zoneName, zoneCache, cacheKey)
+}
+
+// ForwardContext is ForwardWithZoneAndCache bounded by ctx: each backend
+// attempt gets at most the time left before ctx's deadline, and no attempt is
+// started after it. Identical queries forwarded at the same time share one
+// backend exchange.
+func (f *Forwarder) ForwardContext(ctx context.Context, w dns.ResponseWriter, r *dns.Msg, zoneName string, zoneCache *cache.ZoneCache, cacheKey string) {
+	if cacheKey == "" && len(r.Question) > 0 {
+		cacheKey = cache.CacheKey(r.Question[0].Name, r.Question[0].Qtype, nil)
+	}
+	if f.inflight == nil || len(r.Question) == 0 {
+		_ = w.WriteMsg(f.resolve(ctx, r, zoneName, zoneCache, cacheKey))
+		return
+	}
+
+	leader := false
+	v, _, shared := f.inflight.Do(f.inflightKey(r, zoneName, cacheKey), func() (any, error) {
+		leader = true
+		return f.resolve(ctx, r, zoneName, zoneCache, cacheKey), nil
+	})
+	resp := v.(*dns.Msg)
+	if shared {
+		// Response writers may change the message, so each query sends its own
+		resp = resp.Copy()
+		resp.Id = r.Id
+		if !leader {
+			metrics.RecordSingleflightShared(zoneName, "forward")
+			f.log(ctx).ZoneDebug(zoneName, "Answered by an identical query in flight", "domain", r.Question[0].Name)
+		}
+	}
+	_ = w.WriteMsg(resp)
+}
+
+// inflightKey identifies a forwarded query by everything that determines its
+// answer: the zone, question, DNSSEC flags, cache key and where it is sent
+func (f *Forwarder) inflightKey(r *dns.Msg, zoneName, cacheKey string) string {
+	q := r.Question[0]
+	flags := ""
+	if r.CheckingDisabled {
+		flags += "cd"
+	}
+	if opt := r.IsEdns0(); opt != nil {
+		flags += "edns"
+		if opt.Do() {
+			flags += "do"
+		}
+	}
+	route := "direct"
+	if f.tsnetServer != nil {
+		route = "tsnet"
+	}
+	return strings.Join([]string{
+		zoneName,
+		q.Name,
+		strconv.Itoa(int(q.Qtype)),
+		strconv.Itoa(int(q.Qclass)),
+		flags,
+		cacheKey,
+		strings.Join(f.backends, ","),
+		route,
+	}, "|")
+}
+
+// resolve queries the backends for r, caching a successful response, and
+// returns the answer to send: the backend's, a stale cached one, or SERVFAIL
+func (f *Forwarder) resolve(ctx context.Context, r *dns.Msg, zoneName string, zoneCache *cache.ZoneCache, cacheKey string) *dns.Msg {
+	backends := f.healthyBackends(zoneName)
+
 	var lastErr error
+	attempted := false
+retries:
 	for i := 0; i < f.retries; i++ {
-		for _, backend := range f.backends {
+		if f.parallel {
+			if ctx.Err() != nil {
+				lastErr = fmt.Errorf("query deadline exceeded: %w", ctx.Err())
+				break retries
+			}
+			resp, tried, err := f.queryParallel(ctx, r, backends, zoneName)
+			attempted = attempted || tried
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			return f.answer(r, resp, zoneName, zoneCache, cacheKey)
+		}
+
+		for _, backend := range backends {
+			if ctx.Err() != nil {
+				lastErr = fmt.Errorf("query deadline exceeded: %w", ctx.Err())
+				break retries
+			}
+			if !f.breaker.Allow(backend) {
+				continue
+			}
+			attempted = true
 			metrics.RecordBackendQuery(zoneName, backend)
 			
-			resp, err := f.queryBackend(r, backend, zoneName)
+			resp, err := f.queryBackend(ctx, r, backend, zoneName)
 			if err != nil {
 				lastErr = err
 				metrics.RecordBackendError(zoneName, backend)
+				f.recordFailure(zoneName, backend)
 				continue
 			}
+			f.breaker.RecordSuccess(backend)
+			return f.answer(r, resp, zoneName, zoneCache, cacheKey)
+		}
+	}
 
-			// Cache the response if cache is provided (before sending)
-			if zoneCache != nil && len(r.Question) > 0 {
-				cacheKey := cache.CacheKey(r.Question[0].Name, r.Question[0].Qtype, nil) // Remove client IP for better cache efficiency
-				zoneCache.Set(cacheKey, resp)
-				metrics.UpdateCacheSize(zoneName, zoneCache.Size())
+	if !attempted && ctx.Err() == nil {
+		// Every circuit is open, so answer now instead of waiting out the cooldown
+		if f.staleOnCircuitOpen && zoneCache != nil {
+			if stale, found := zoneCache.GetStale(cacheKey); found {
+				stale.Id = r.Id
+				setEDE(stale, r, dns.ExtendedErrorCodeStaleAnswer, "backend circuit open")
+				metrics.RecordStaleResponse(zoneName, "circuit_open")
+				f.log(ctx).ZoneDebug(zoneName, "Serving stale response, all backend circuits open", "domain", r.Question[0].Name)
+				return stale
+			}
+		}
+		f.log(ctx).ZoneWarn(zoneName, "All backend circuits open", "backends", f.backends)
+	} else {
+		f.log(ctx).ZoneError(zoneName, "All backend DNS servers failed", "retries", f.retries, "error", lastErr)
+		if f.staleOnFailure && zoneCache != nil {
+			if stale, found := zoneCache.GetStale(cacheKey); found {
+				stale.Id = r.Id
+				setEDE(stale, r, dns.ExtendedErrorCodeStaleAnswer, "backend refresh failed")
+				metrics.RecordStaleResponse(zoneName, "refresh_failed")
+				return stale
 			}
-
-			_ = w.WriteMsg(resp)
-			return
 		}
 	}
 
-	f.logger.ZoneError(zoneName, "All backend DNS servers failed", "retries", f.retries, "error", lastErr)
-
 	msg := new(dns.Msg)
 	msg.SetReply(r)
 	msg.Rcode = dns.RcodeServerFailure
+	return msg
+}
 
-	_ = w.WriteMsg(msg)
+// answer caches a backend response, if a cache is provided, and returns it
+func (f *Forwarder) answer(r, resp *dns.Msg, zoneName string, zoneCache *cache.ZoneCache, cacheKey string) *dns.Msg {
+	if zoneCache != nil && len(r.Question) > 0 {
+		zoneCache.Set(cacheKey, resp)
+		metrics.UpdateCacheSize(zoneName, zoneCache.Size())
+	}
+	return resp
+}
+
+// recordFailure counts a failed query against backend's circuit. When that
+// opens it, the backend is probed in the background until it answers again.
+func (f *Forwarder) recordFailure(zoneName, backend string) {
+	if !f.breaker.RecordFailure(zoneName, backend) {
+		return
+	}
+	f.logger.ZoneWarn(zoneName, "Backend circuit opened", "backend", backend)
+	go f.breaker.probe(backend, func() bool {
+		probe := new(dns.Msg)
+		probe.SetQuestion(".", dns.TypeNS)
+		_, err := f.queryBackend(context.Background(), probe, backend, zoneName)
+		if err == nil {
+			f.logger.ZoneInfo(zoneName, "Backend circuit closed after probe", "backend", backend)
+		}
+		return err == nil
+	})
+}
+
+// queryParallel queries every backend whose circuit allows it at once and
+// returns the first answer that isn't SERVFAIL, cancelling the others. If
+// every backend answers SERVFAIL, one of those answers is returned. tried
+// reports whether any backend was queried.
+func (f *Forwarder) queryParallel(ctx context.Context, r *dns.Msg, backends []string, zoneName string) (resp *dns.Msg, tried bool, err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		backend string
+		resp    *dns.Msg
+		err     error
+	}
+	// Buffered, so backends still answering after the winner don't block
+	results := make(chan result, len(backends))
+	started := 0
+	for _, backend := range backends {
+		if !f.breaker.Allow(backend) {
+			continue
+		}
+		started++
+		metrics.RecordBackendQuery(zoneName, backend)
+		req := r.Copy()
+		go func() {
+			resp, err := f.queryBackend(ctx, req, backend, zoneName)
+			results <- result{backend: backend, resp: resp, err: err}
+		}()
+	}
+
+	var servFail *dns.Msg
+	var lastErr error
+	for i := 0; i < started; i++ {
+		res := <-results
+		if res.err != nil {
+			lastErr = res.err
+			metrics.RecordBackendError(zoneName, res.backend)
+			f.recordFailure(zoneName, res.backend)
+			continue
+		}
+		f.breaker.RecordSuccess(res.backend)
+		if res.resp.Rcode == dns.RcodeServerFailure {
+			servFail = res.resp
+			continue
+		}
+		metrics.RecordBackendWin(zoneName, res.backend)
+		f.log(ctx).ZoneDebug(zoneName, "Parallel backend query answered", "backend", res.backend)
+		return res.resp, true, nil
+	}
+
+	if servFail != nil {
+		return servFail, true, nil
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no backend available")
+	}
+	return nil, started > 0, lastErr
+}
+
+// checkZoneLimit rejects configurations with more zones than the memory
+// monitor tracks
+func checkZoneLimit(cfg *config.Config, runtimeCfg *config.RuntimeConfig) error {
+	if limit := runtimeCfg.ZoneLimit(); len(cfg.Zones) > limit {
+		return fmt.Errorf("%d zones configured, more than the limit of %d (TSDNS_MAX_ZONES)", len(cfg.Zones), limit)
+	}
+	return nil
+}
+
+// cacheByteLimit returns the cache memory budget of zoneName, or 0 if the
+// zone isn't monitored
+func cacheByteLimit(m *memory.Monitor, zoneName string) int64 {
+	if usage, ok := m.GetZoneUsage(zoneName); ok {
+		return usage.MaxCacheSize
+	}
+	return 0
+}
+
+// cacheSources returns the memory usage reporters of zoneCaches for the memory monitor
+func cacheSources(zoneCaches map[string]*cache.ZoneCache) map[string]func() int64 {
+	sources := make(map[string]func() int64, len(zoneCaches))
+	for zoneName, zoneCache := range zoneCaches {
+		sources[zoneName] = zoneCache.MemoryUsage
+	}
+	return sources
 }
 
 // HTTP handlers for health and metrics endpoints
@@ -741,9 +2564,81 @@ func (s *Server) healthHandler(w http.ResponseWriter, r *http.Request) {
 	// Simple health check - if we can respond, we're healthy
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	if s.runtimeCfg.CacheOnly {
+		_, _ = w.Write([]byte(`{"status":"ok","service":"tsdnsreflector","mode":"cache-only"}`))
+		return
+	}
 	_, _ = w.Write([]byte(`{"status":"ok","service":"tsdnsreflector"}`))
 }
 
+// readyHandler reports ready once the DNS listener is serving, the TSNet node
+// has joined the tailnet, and every health-checked zone has its minimum number
+// of healthy backends. Unlike healthHandler, it fails while starting up.
+func (s *Server) readyHandler(w http.ResponseWriter, r *http.Request) {
+	var tailscaleIPs func() (net.IP, net.IP)
+	if s.tsnetServer != nil {
+		tailscaleIPs = s.tsnetServer.TailscaleIPs
+	}
+
+	resp := readyResponse{Status: "not ready", Service: "tsdnsreflector"}
+	status := http.StatusServiceUnavailable
+	if resp.Reason = s.notReadyReason(tailscaleIPs); resp.Reason == "" {
+		if resp.Zones = s.unreadyZones(); len(resp.Zones) == 0 {
+			resp.Status = "ready"
+			status = http.StatusOK
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// readyResponse is the readiness endpoint's body
+type readyResponse struct {
+	Status  string   `json:"status"`
+	Service string   `json:"service"`
+	Reason  string   `json:"reason,omitempty"` // Why the server can't take queries yet
+	Zones   []string `json:"zones,omitempty"`  // Zones short of healthy backends
+}
+
+// notReadyReason returns why the server can't take queries yet, or "" once
+// it can. tailscaleIPs is nil outside TSNet mode.
+func (s *Server) notReadyReason(tailscaleIPs func() (net.IP, net.IP)) string {
+	if tailscaleIPs != nil {
+		if ipv4, ipv6 := tailscaleIPs(); ipv4 == nil && ipv6 == nil {
+			return "no Tailscale address"
+		}
+	}
+	if !s.dnsServing.Load() {
+		return "DNS listener not started"
+	}
+	return ""
+}
+
+// unreadyZones returns the health-checked zones with fewer healthy backends
+// than required. The runtime minimum is capped at a zone's backend count.
+func (s *Server) unreadyZones() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var unready []string
+	for zoneName, zone := range s.config.Zones {
+		if zone.HealthCheck == nil {
+			continue
+		}
+		required := zone.HealthCheck.MinHealthy
+		if required == 0 {
+			required = min(s.runtimeCfg.MinHealthyBackends, len(zone.Backend.DNSServers))
+		}
+		if required > 0 && s.health.HealthyCount(zoneName) < required {
+			unready = append(unready, zoneName)
+		}
+	}
+	slices.Sort(unready)
+	return unready
+}
+
 func (s *Server) metricsHandler(w http.ResponseWriter, r *http.Request) {
 	// Redirect to the main metrics endpoint
 	w.Header().Set("Location", "/metrics")
@@ -751,12 +2646,37 @@ func (s *Server) metricsHandler(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write([]byte("Metrics available at /metrics\n"))
 }
 
-// ReloadConfig applies hot-reloadable configuration changes
+// UpdateLogging applies a new log level and query logging setting to the
+// running server. The log format and file only change on restart.
+func (s *Server) UpdateLogging(cfg config.LoggingConfig) {
+	s.logger.SetLevel(cfg.Level)
+
+	// Queries being served read the runtime config under the handler's lock
+	s.handler.reloadMu.Lock()
+	defer s.handler.reloadMu.Unlock()
+	s.runtimeCfg.LogLevel = cfg.Level
+	s.runtimeCfg.LogQueries = cfg.LogQueries
+
+	suffix := s.handler.magicDNSSuffix()
+	metrics.RecordRuntimeConfigInfo(cfg.Level, s.runtimeCfg.DNSPort, s.runtimeCfg.MetricsEnabled, suffix)
+	s.logger.Info("Logging configuration updated", "logLevel", cfg.Level, "logQueries", cfg.LogQueries)
+}
+
+// ReloadConfig applies hot-reloadable configuration changes. The new
+// components are swapped in once queries already being served have finished.
 func (s *Server) ReloadConfig(newCfg *config.Config) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	if err := newCfg.ValidateZones(); err != nil {
 		return fmt.Errorf("zone validation failed: %w", err)
 	}
 
+	// Reject the whole reload rather than run zones without memory monitoring
+	if err := checkZoneLimit(newCfg, s.runtimeCfg); err != nil {
+		return err
+	}
+
 	// Logging config now comes from runtime, not from config file
 
 	// Update 4via6 translator with new zones
@@ -769,10 +2689,7 @@ func (s *Server) ReloadConfig(newCfg *config.Config) error {
 	newZoneCaches := make(map[string]*cache.ZoneCache)
 	for zoneName, zone := range newCfg.Zones {
 		if zone.Cache != nil {
-			maxSize := zone.Cache.MaxSize
-			if maxSize == 0 {
-				maxSize = newCfg.Global.Cache.MaxSize
-			}
+			maxSize := newCfg.CacheMaxSize(zone)
 			ttl, _ := config.ParseCacheTTL(zone.Cache.TTL)
 			// Reuse existing cache if configuration unchanged
 			if existingCache, exists := s.zoneCaches[zoneName]; exists {
@@ -782,7 +2699,41 @@ func (s *Server) ReloadConfig(newCfg *config.Config) error {
 				newZoneCaches[zoneName] = cache.NewZoneCache(maxSize, ttl)
 				s.logger.ZoneInfo(zoneName, "Zone cache created during reload", "maxSize", maxSize, "ttl", ttl)
 			}
+			newZoneCaches[zoneName].SetStaleRetention(zone.StaleRetention())
+			newZoneCaches[zoneName].SetNegativeTTL(zone.NegativeCacheTTL())
+			newZoneCaches[zoneName].SetMinTTL(zone.CacheMinTTL())
+			newZoneCaches[zoneName].SetPreserveAA(zone.CachePreservesAA())
+		}
+	}
+
+	if s.memoryMonitor != nil {
+		// Removed zones free their places first, so the new set fits the limit
+		for zoneName := range s.config.Zones {
+			if _, kept := newCfg.Zones[zoneName]; !kept {
+				s.memoryMonitor.UnregisterZone(zoneName)
+			}
 		}
+		for zoneName := range newCfg.Zones {
+			if _, registered := s.memoryMonitor.GetZoneUsage(zoneName); !registered {
+				if err := s.memoryMonitor.RegisterZone(zoneName); err != nil {
+					s.logger.ZoneWarn(zoneName, "Failed to register zone for memory monitoring", "error", err)
+				}
+			}
+		}
+		for zoneName, zoneCache := range newZoneCaches {
+			zoneCache.SetMaxBytes(cacheByteLimit(s.memoryMonitor, zoneName))
+		}
+		s.memoryMonitor.SetCacheSources(cacheSources(newZoneCaches))
+	}
+
+	// Circuit state is reset on reload since backends may have changed
+	breaker := newCircuitBreaker(newCfg.Global.CircuitBreaker)
+	s.forwarder.breaker.Stop()
+
+	// Drop the old translator's resolutions, which may be stale under the new backends
+	if s.via6Trans != nil {
+		s.via6Trans.ClearResolutionCache()
+		s.via6Trans.Stop()
 	}
 
 	// Update components
@@ -794,16 +2745,23 @@ func (s *Server) ReloadConfig(newCfg *config.Config) error {
 	} else {
 		s.forwarder = NewForwarder(newCfg.Global.Backend, s.logger)
 	}
+	s.forwarder.breaker = breaker
 	s.zoneCaches = newZoneCaches
 
 	// Update handler
 	if handler, ok := s.dnsServer.Handler.(*TailscaleDNSHandler); ok {
+		handler.reloadMu.Lock()
 		handler.config = newCfg
 		handler.via6Trans = newTranslator
+		s.forwarder.doh = handler.doh
+		s.forwarder.inflight = handler.inflight
 		handler.forwarder = s.forwarder
 		handler.zoneCaches = s.zoneCaches
+		handler.breaker = breaker
 		handler.logger = s.logger
+		handler.reloadMu.Unlock()
 	}
+	s.startHealthChecks()
 
 	// Count zones with 4via6
 	enabledZones := 0
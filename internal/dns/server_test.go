@@ -1,15 +1,50 @@
 package dns
 
 import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"net/netip"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	via6 "github.com/rajsingh/tsdnsreflector/internal/4via6"
 	"github.com/rajsingh/tsdnsreflector/internal/cache"
 	"github.com/rajsingh/tsdnsreflector/internal/config"
 	"github.com/rajsingh/tsdnsreflector/internal/logger"
+	"github.com/rajsingh/tsdnsreflector/internal/metrics"
+	"golang.org/x/sync/singleflight"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/types/key"
+	"tailscale.com/types/views"
 )
 
 func TestNewServer(t *testing.T) {
@@ -91,6 +126,264 @@ func TestNewServerWithRuntime(t *testing.T) {
 	}
 }
 
+func TestNewServerWithRuntime_InfoMetrics(t *testing.T) {
+	cfg := &config.Config{
+		Global: config.GlobalConfig{Backend: config.BackendConfig{DNSServers: []string{"8.8.8.8:53"}, Timeout: "5s", Retries: 3}},
+		Zones:  map[string]*config.Zone{},
+	}
+	runtimeCfg := &config.RuntimeConfig{DNSPort: 5353, LogLevel: "debug", MetricsEnabled: true, MetricsPath: "/metrics", DefaultTTL: 300}
+
+	if _, err := NewServerWithRuntime(cfg, runtimeCfg); err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	if got := testutil.ToFloat64(metrics.BuildInfo.WithLabelValues(serverVersion(), serverCommit(), runtime.Version())); got != 1 {
+		t.Errorf("Expected build info gauge 1, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.RuntimeConfigInfo.WithLabelValues("debug", "5353", "true", "ts.net")); got != 1 {
+		t.Errorf("Expected runtime config info gauge 1, got %v", got)
+	}
+	if n := testutil.CollectAndCount(metrics.RuntimeConfigInfo); n != 1 {
+		t.Errorf("Expected a single runtime config info series, got %d", n)
+	}
+}
+
+func TestNewServerWithRuntime_Pprof(t *testing.T) {
+	cfg := &config.Config{
+		Global: config.GlobalConfig{Backend: config.BackendConfig{DNSServers: []string{"8.8.8.8:53"}, Timeout: "5s", Retries: 3}},
+		Zones:  map[string]*config.Zone{},
+	}
+
+	for _, enabled := range []bool{true, false} {
+		runtimeCfg := &config.RuntimeConfig{
+			DNSPort:        5353,
+			DefaultTTL:     300,
+			HealthEnabled:  true,
+			HealthPath:     "/health",
+			MetricsEnabled: true,
+			MetricsPath:    "/metrics",
+			PprofEnabled:   enabled,
+		}
+		server, err := NewServerWithRuntime(cfg, runtimeCfg)
+		if err != nil {
+			t.Fatalf("Failed to create server: %v", err)
+		}
+
+		get := func(path string) int {
+			rec := httptest.NewRecorder()
+			server.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+			return rec.Code
+		}
+
+		want := http.StatusNotFound
+		if enabled {
+			want = http.StatusOK
+		}
+		if code := get(PprofPath + "heap"); code != want {
+			t.Errorf("pprof enabled=%v: expected %d for the heap profile, got %d", enabled, want, code)
+		}
+		// Profiling shares the port with health and metrics
+		if code := get("/health"); code != http.StatusOK {
+			t.Errorf("pprof enabled=%v: expected health 200, got %d", enabled, code)
+		}
+		if code := get("/metrics"); code != http.StatusMovedPermanently {
+			t.Errorf("pprof enabled=%v: expected metrics redirect, got %d", enabled, code)
+		}
+	}
+}
+
+func TestServer_UpdateLogging(t *testing.T) {
+	backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		_ = w.WriteMsg(msg)
+	})
+	cfg := &config.Config{
+		Global: config.GlobalConfig{Backend: config.BackendConfig{DNSServers: []string{backend}, Timeout: "1s", Retries: 1}},
+		Zones:  map[string]*config.Zone{},
+	}
+	logFile := filepath.Join(t.TempDir(), "tsdnsreflector.log")
+	runtimeCfg := &config.RuntimeConfig{DNSPort: 5353, DefaultTTL: 300, LogLevel: "info", LogFormat: "json", LogFile: logFile}
+
+	server, err := NewServerWithRuntime(cfg, runtimeCfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	zoneLog := server.logger.WithZone("corp")
+
+	// logged reports whether the debug and query lines of one query show up
+	round := 0
+	logged := func() (debug, query bool) {
+		round++
+		marker := fmt.Sprintf("round-%d", round)
+		zoneLog.Debug("Debug probe", "marker", marker)
+
+		req := new(dns.Msg)
+		req.SetQuestion(marker+".example.com.", dns.TypeA)
+		server.handler.ServeDNS(&testResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53}}, req)
+
+		data, err := os.ReadFile(logFile)
+		if err != nil {
+			t.Fatalf("Failed to read log: %v", err)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			debug = debug || strings.Contains(line, `"Debug probe"`) && strings.Contains(line, marker)
+			query = query || strings.Contains(line, `"DNS query"`) && strings.Contains(line, marker)
+		}
+		return debug, query
+	}
+
+	if debug, query := logged(); debug || query {
+		t.Errorf("Expected no debug or query lines at info level, got debug=%v query=%v", debug, query)
+	}
+
+	t.Setenv("TSDNS_LOG_LEVEL", "debug")
+	t.Setenv("TSDNS_LOG_QUERIES", "true")
+	loggingCfg := config.ReloadLogging(runtimeCfg.ToLoggingConfig())
+	server.UpdateLogging(loggingCfg)
+	if debug, query := logged(); !debug || !query {
+		t.Errorf("Expected debug and query lines after switching to debug, got debug=%v query=%v", debug, query)
+	}
+
+	t.Setenv("TSDNS_LOG_LEVEL", "warn")
+	t.Setenv("TSDNS_LOG_QUERIES", "false")
+	server.UpdateLogging(config.ReloadLogging(loggingCfg))
+	if debug, query := logged(); debug || query {
+		t.Errorf("Expected debug and query lines to stop at warn, got debug=%v query=%v", debug, query)
+	}
+}
+
+func TestNewServerWithRuntime_PollIntervals(t *testing.T) {
+	cfg := &config.Config{
+		Global: config.GlobalConfig{Backend: config.BackendConfig{DNSServers: []string{"8.8.8.8:53"}, Timeout: "5s", Retries: 3}},
+		Zones:  map[string]*config.Zone{},
+	}
+
+	runtimeCfg := &config.RuntimeConfig{DNSPort: 5353, DefaultTTL: 300, StatusPollInterval: 10 * time.Second, MemoryCheckInterval: 2 * time.Minute}
+	server, err := NewServerWithRuntime(cfg, runtimeCfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	if got := server.runtimeCfg.StatusPollEvery(); got != 10*time.Second {
+		t.Errorf("Expected status poll interval 10s, got %s", got)
+	}
+	if got := server.runtimeCfg.MemoryCheckEvery(); got != 2*time.Minute {
+		t.Errorf("Expected memory check interval 2m, got %s", got)
+	}
+
+	runtimeCfg = &config.RuntimeConfig{DNSPort: 5353, DefaultTTL: 300, StatusPollInterval: 100 * time.Millisecond}
+	if _, err := NewServerWithRuntime(cfg, runtimeCfg); err == nil {
+		t.Error("Expected a status poll interval under 1s to be rejected")
+	}
+}
+
+func TestServer_StartServesTCP(t *testing.T) {
+	backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		msg.Answer = append(msg.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.IPv4(10, 0, 0, 1),
+		})
+		_ = w.WriteMsg(msg)
+	})
+
+	// Find a free port for both UDP and TCP
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	port := probe.Addr().(*net.TCPAddr).Port
+	_ = probe.Close()
+
+	backendCfg := config.BackendConfig{DNSServers: []string{backend}, Timeout: "1s", Retries: 1}
+	cfg := &config.Config{
+		Global: config.GlobalConfig{Backend: backendCfg},
+		Zones: map[string]*config.Zone{
+			"large": {
+				Domains:              []string{"*.large.local"},
+				Backend:              backendCfg,
+				AllowExternalClients: true,
+				RequireTCP:           true,
+			},
+		},
+	}
+	runtimeCfg := &config.RuntimeConfig{BindAddress: "127.0.0.1", DNSPort: port, DefaultTTL: 300}
+
+	server, err := NewServerWithRuntime(cfg, runtimeCfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- server.Start(ctx) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	req := new(dns.Msg)
+	req.SetQuestion("app.large.local.", dns.TypeA)
+
+	exchange := func(network string) *dns.Msg {
+		t.Helper()
+		client := &dns.Client{Net: network, Timeout: time.Second}
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			resp, _, err := client.Exchange(req, addr)
+			if err == nil {
+				return resp
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s query failed: %v", network, err)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	// The zone requires TCP, so UDP gets a truncated answer and TCP the real one
+	if resp := exchange("udp"); !resp.Truncated {
+		t.Errorf("Expected truncated UDP response, got %v", resp)
+	}
+	resp := exchange("tcp")
+	if resp.Truncated || len(resp.Answer) != 1 {
+		t.Errorf("Expected one answer over TCP, got %v", resp)
+	}
+}
+
+func TestServer_StartFailsWhenPortTaken(t *testing.T) {
+	taken, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer func() { _ = taken.Close() }()
+	port := taken.LocalAddr().(*net.UDPAddr).Port
+
+	runtimeCfg := &config.RuntimeConfig{BindAddress: "127.0.0.1", DNSPort: port, DefaultTTL: 300}
+	server, err := NewServerWithRuntime(&config.Config{Zones: map[string]*config.Zone{}}, runtimeCfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- server.Start(context.Background()) }()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "failed to bind DNS server on 127.0.0.1:"+strconv.Itoa(port)) {
+			t.Errorf("Expected a bind error naming the address, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		server.Stop()
+		t.Fatal("Expected Start to fail when the DNS port is taken")
+	}
+
+	err = bindError("0.0.0.0:53", &net.OpError{Op: "listen", Net: "udp", Err: os.NewSyscallError("bind", syscall.EACCES)})
+	if !errors.Is(err, os.ErrPermission) || !strings.Contains(err.Error(), "CAP_NET_BIND_SERVICE") {
+		t.Errorf("Expected a permission error with a privileges hint, got %v", err)
+	}
+}
+
 func TestNewServerWithInvalidVia6Config(t *testing.T) {
 	cfg := &config.Config{
 		Global: config.GlobalConfig{
@@ -281,6 +574,309 @@ func TestDNSHandler_ServeDNS_NonTailscaleClient(t *testing.T) {
 	}
 }
 
+func TestDNSHandler_ServeDNS_SpecialUseDomains(t *testing.T) {
+	cfg := &config.Config{
+		Global: config.GlobalConfig{
+			Backend: config.BackendConfig{
+				DNSServers: []string{"192.0.2.1:53"}, // Unreachable, must never be queried
+				Timeout:    "100ms",
+				Retries:    1,
+			},
+			SpecialUseDomains: config.DefaultSpecialUseDomains(),
+		},
+		Zones: map[string]*config.Zone{},
+	}
+
+	runtimeCfg := &config.RuntimeConfig{
+		DefaultTTL: 300,
+	}
+	log := logger.New(runtimeCfg.ToLoggingConfig())
+
+	handler := &TailscaleDNSHandler{
+		config:     cfg,
+		runtimeCfg: runtimeCfg,
+		forwarder:  NewForwarder(cfg.Global.Backend, log),
+		logger:     log,
+		zoneCaches: make(map[string]*cache.ZoneCache),
+	}
+
+	tests := []struct {
+		name      string
+		qname     string
+		qtype     uint16
+		wantRcode int
+		wantIP    net.IP
+	}{
+		{"invalid is NXDOMAIN", "foo.invalid.", dns.TypeA, dns.RcodeNameError, nil},
+		{"test is NXDOMAIN", "app.test.", dns.TypeAAAA, dns.RcodeNameError, nil},
+		{"localhost A", "localhost.", dns.TypeA, dns.RcodeSuccess, net.IPv4(127, 0, 0, 1)},
+		{"localhost AAAA", "app.localhost.", dns.TypeAAAA, dns.RcodeSuccess, net.IPv6loopback},
+		{"localhost TXT is NODATA", "localhost.", dns.TypeTXT, dns.RcodeSuccess, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := new(dns.Msg)
+			req.SetQuestion(tt.qname, tt.qtype)
+
+			w := &testResponseWriter{
+				remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53},
+			}
+			handler.ServeDNS(w, req)
+
+			if w.msg == nil {
+				t.Fatal("Expected response message")
+			}
+			if w.msg.Rcode != tt.wantRcode {
+				t.Errorf("Expected rcode %s, got %s", dns.RcodeToString[tt.wantRcode], dns.RcodeToString[w.msg.Rcode])
+			}
+			if tt.wantIP == nil {
+				if len(w.msg.Answer) != 0 {
+					t.Errorf("Expected no answers, got %d", len(w.msg.Answer))
+				}
+				return
+			}
+			if len(w.msg.Answer) != 1 {
+				t.Fatalf("Expected 1 answer, got %d", len(w.msg.Answer))
+			}
+			var got net.IP
+			switch rr := w.msg.Answer[0].(type) {
+			case *dns.A:
+				got = rr.A
+			case *dns.AAAA:
+				got = rr.AAAA
+			}
+			if !got.Equal(tt.wantIP) {
+				t.Errorf("Expected %v, got %v", tt.wantIP, got)
+			}
+		})
+	}
+}
+
+func TestForwarder_SourceAddress(t *testing.T) {
+	// Binding 127.0.0.2 needs the whole of 127/8 on loopback, as on Linux.
+	// Queries go out from 127.0.0.1 by default, so only the configured
+	// source address explains a query from 127.0.0.2.
+	probe, err := net.ListenPacket("udp", "127.0.0.2:0")
+	if err != nil {
+		t.Skipf("127.0.0.2 not usable as a source address: %v", err)
+	}
+	_ = probe.Close()
+
+	seen := make(chan net.Addr, 1)
+	backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		seen <- w.RemoteAddr()
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		_ = w.WriteMsg(msg)
+	})
+
+	forwarder := NewForwarder(config.BackendConfig{
+		DNSServers:    []string{backend},
+		Timeout:       "1s",
+		Retries:       1,
+		SourceAddress: "127.0.0.2",
+	}, logger.Default())
+
+	if !forwarder.sourceAddr.Equal(net.ParseIP("127.0.0.2")) {
+		t.Fatalf("Expected sourceAddr 127.0.0.2, got %v", forwarder.sourceAddr)
+	}
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeA)
+	if _, err := forwarder.queryBackend(context.Background(), req, backend, "test"); err != nil {
+		t.Fatalf("queryBackend failed: %v", err)
+	}
+
+	from := <-seen
+	udpAddr, ok := from.(*net.UDPAddr)
+	if !ok || !udpAddr.IP.Equal(net.ParseIP("127.0.0.2")) {
+		t.Errorf("Expected query from 127.0.0.2, got %v", from)
+	}
+}
+
+func TestForwarder_RejectsMismatchedID(t *testing.T) {
+	backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		msg.Id = r.Id + 1
+		_ = w.WriteMsg(msg)
+	})
+
+	forwarder := NewForwarder(config.BackendConfig{
+		DNSServers: []string{backend},
+		Timeout:    "200ms",
+		Retries:    1,
+	}, logger.Default())
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeA)
+	if resp, err := forwarder.queryBackend(context.Background(), req, backend, "test"); err == nil {
+		t.Errorf("Expected error for mismatched response ID, got %v", resp)
+	}
+}
+
+func TestDNSHandler_CachePerClient(t *testing.T) {
+	for _, perClient := range []bool{false, true} {
+		t.Run(fmt.Sprintf("cachePerClient=%v", perClient), func(t *testing.T) {
+			var queries atomic.Int32
+			backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+				queries.Add(1)
+				msg := new(dns.Msg)
+				msg.SetReply(r)
+				msg.Answer = append(msg.Answer, &dns.A{
+					Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+					A:   net.ParseIP("10.1.2.3"),
+				})
+				_ = w.WriteMsg(msg)
+			})
+
+			backendCfg := config.BackendConfig{DNSServers: []string{backend}, Timeout: "1s", Retries: 1}
+			cfg := &config.Config{
+				Global: config.GlobalConfig{Backend: backendCfg},
+				Zones: map[string]*config.Zone{
+					"views": {
+						Domains:        []string{"*.views.local"},
+						Backend:        backendCfg,
+						CachePerClient: perClient,
+					},
+				},
+			}
+
+			runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+			log := logger.New(runtimeCfg.ToLoggingConfig())
+			zoneCache := cache.NewZoneCache(100, time.Minute)
+			defer zoneCache.Stop()
+
+			handler := &TailscaleDNSHandler{
+				config:     cfg,
+				runtimeCfg: runtimeCfg,
+				forwarder:  NewForwarder(backendCfg, log),
+				logger:     log,
+				zoneCaches: map[string]*cache.ZoneCache{"views": zoneCache},
+			}
+
+			for i, client := range []string{"100.64.0.1", "100.64.0.2", "100.64.0.1"} {
+				req := new(dns.Msg)
+				req.SetQuestion("app.views.local.", dns.TypeA)
+				w := &testResponseWriter{
+					remoteAddr: &net.UDPAddr{IP: net.ParseIP(client), Port: 53},
+				}
+				handler.ServeDNS(w, req)
+				if w.msg == nil || len(w.msg.Answer) != 1 {
+					t.Fatalf("query %d: expected 1 answer, got %v", i, w.msg)
+				}
+				if w.msg.Id != req.Id {
+					t.Errorf("query %d: response ID %d does not match query ID %d", i, w.msg.Id, req.Id)
+				}
+			}
+
+			want := int32(1)
+			if perClient {
+				want = 2
+			}
+			if got := queries.Load(); got != want {
+				t.Errorf("Expected %d backend queries, got %d", want, got)
+			}
+		})
+	}
+}
+
+// startTestBackend runs a UDP DNS server on localhost and returns its address
+func startTestBackend(t *testing.T, handler dns.HandlerFunc) string {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	started := make(chan struct{})
+	server := &dns.Server{
+		PacketConn:        pc,
+		Handler:           handler,
+		NotifyStartedFunc: func() { close(started) },
+	}
+	go func() { _ = server.ActivateAndServe() }()
+	<-started
+	t.Cleanup(func() { _ = server.Shutdown() })
+
+	return pc.LocalAddr().String()
+}
+
+// startTestBackendUDPTCP runs a DNS server on localhost serving handler over
+// both UDP and TCP on the same port and returns its address
+func startTestBackendUDPTCP(t *testing.T, handler dns.HandlerFunc) string {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	ln, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		_ = pc.Close()
+		t.Fatalf("Failed to listen on TCP: %v", err)
+	}
+
+	for _, server := range []*dns.Server{{PacketConn: pc, Handler: handler}, {Listener: ln, Handler: handler}} {
+		started := make(chan struct{})
+		server.NotifyStartedFunc = func() { close(started) }
+		go func() { _ = server.ActivateAndServe() }()
+		<-started
+		t.Cleanup(func() { _ = server.Shutdown() })
+	}
+
+	return pc.LocalAddr().String()
+}
+
+// startTestTLSBackend runs a DNS over TLS server on localhost with a
+// self-signed certificate for 127.0.0.1, returning its tls:// address and a
+// pool trusting the certificate
+func startTestTLSBackend(t *testing.T, handler dns.HandlerFunc) (string, *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "tsdnsreflector test"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	server := &dns.Server{Listener: ln, Net: "tcp-tls", Handler: handler}
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+	go func() { _ = server.ActivateAndServe() }()
+	<-started
+	t.Cleanup(func() { _ = server.Shutdown() })
+
+	return config.BackendTLSScheme + ln.Addr().String(), roots
+}
+
 // testResponseWriter implements dns.ResponseWriter for testing
 type testResponseWriter struct {
 	msg        *dns.Msg
@@ -328,26 +924,5451 @@ func TestClientDetection(t *testing.T) {
 	}
 }
 
-func TestForwarder_ExchangeViaTSNet(t *testing.T) {
-	// This test would require a mock TSNet server
-	// For now, we'll test that the forwarder can be created with TSNet
-	cfg := config.BackendConfig{
-		DNSServers: []string{"10.0.0.10:53"},
-		Timeout:    "5s",
-		Retries:    3,
+func TestGetClientIP_IPv4Mapped(t *testing.T) {
+	handler := &TailscaleDNSHandler{}
+
+	tests := []struct {
+		addr            string
+		want            string
+		expectTailscale bool
+	}{
+		{"[::ffff:100.64.0.1]:53", "100.64.0.1", true},
+		{"[::ffff:8.8.8.8]:53", "8.8.8.8", false},
+		{"[fd7a:115c:a1e0::1]:53", "fd7a:115c:a1e0::1", true},
+		{"100.64.0.1:53", "100.64.0.1", true},
 	}
 
-	logger := logger.Default()
-	forwarder := NewForwarder(cfg, logger)
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			ip := handler.getClientIP(stringAddr(tt.addr))
+			if ip.String() != tt.want {
+				t.Errorf("getClientIP(%s) = %s, want %s", tt.addr, ip, tt.want)
+			}
+			if got := handler.isTailscaleClient(ip); got != tt.expectTailscale {
+				t.Errorf("isTailscaleClient(%s) = %v, want %v", ip, got, tt.expectTailscale)
+			}
+		})
+	}
+}
 
-	if forwarder.tsnetServer != nil {
-		t.Error("Expected nil tsnetServer without TSNet")
+func TestMagicDNSSuffix(t *testing.T) {
+	handler := &TailscaleDNSHandler{runtimeCfg: &config.RuntimeConfig{}}
+
+	// Until the tailnet's domain is known, any ts.net name is MagicDNS
+	if !handler.isMagicDNSDomain("peer.tail1234.ts.net.") || handler.isMagicDNSDomain("peer.corp.example.") {
+		t.Errorf("Expected the default %s suffix, got %s", defaultMagicDNSSuffix, handler.magicDNSSuffix())
 	}
 
-	// Test with TSNet (would need mock)
-	// mockTSNet := &mockTSNetServer{}
-	// forwarderWithTSNet := NewForwarderWithTSNet(cfg, logger, mockTSNet)
-	// if forwarderWithTSNet.tsnetServer == nil {
-	//     t.Error("Expected TSNet server to be set")
-	// }
-}
\ No newline at end of file
+	handler.magicSuffix = tailnetDomain("self.Tail1234.ts.net.")
+	if got := handler.magicDNSSuffix(); got != "tail1234.ts.net" {
+		t.Errorf("Expected the detected tailnet domain, got %s", got)
+	}
+	if handler.isMagicDNSDomain("peer.other.ts.net.") {
+		t.Error("Expected names outside the detected tailnet not to be MagicDNS")
+	}
+
+	// The override wins over detection
+	handler.runtimeCfg.MagicDNSSuffix = ".Corp.Example."
+	if !handler.isMagicDNSDomain("peer.corp.example.") || handler.isMagicDNSDomain("peer.tail1234.ts.net.") {
+		t.Errorf("Expected the corp.example override, got %s", handler.magicDNSSuffix())
+	}
+
+	for _, name := range []string{"", "self.", "localhost"} {
+		if got := tailnetDomain(name); got != "" {
+			t.Errorf("tailnetDomain(%q) = %q, want none", name, got)
+		}
+	}
+}
+
+// stringAddr is a net.Addr with a fixed string form, as reported by
+// dual-stack sockets
+type stringAddr string
+
+func (a stringAddr) Network() string { return "udp" }
+func (a stringAddr) String() string  { return string(a) }
+
+func TestLookupPeer_Tags(t *testing.T) {
+	tagged := views.SliceOf([]string{"tag:service"})
+	status := &ipnstate.Status{
+		Self: &ipnstate.PeerStatus{
+			DNSName:      "reflector.tail1234.ts.net.",
+			TailscaleIPs: []netip.Addr{netip.MustParseAddr("100.64.0.1")},
+		},
+		Peer: map[key.NodePublic]*ipnstate.PeerStatus{
+			key.NewNode().Public(): {
+				DNSName:      "api.tail1234.ts.net.",
+				TailscaleIPs: []netip.Addr{netip.MustParseAddr("100.64.0.2")},
+				Tags:         &tagged,
+			},
+			key.NewNode().Public(): {
+				DNSName:      "laptop.tail1234.ts.net.",
+				TailscaleIPs: []netip.Addr{netip.MustParseAddr("100.64.0.3")},
+			},
+		},
+	}
+
+	tests := []struct {
+		hostname string
+		tags     []string
+		want     string // Empty if not resolvable
+	}{
+		{"api.tail1234.ts.net", nil, "100.64.0.2"},
+		{"laptop.tail1234.ts.net", nil, "100.64.0.3"},
+		{"reflector.tail1234.ts.net", nil, "100.64.0.1"},
+		{"api.tail1234.ts.net", []string{"tag:service"}, "100.64.0.2"},
+		{"laptop.tail1234.ts.net", []string{"tag:service"}, ""},
+		{"reflector.tail1234.ts.net", []string{"tag:service"}, ""},
+		{"api.tail1234.ts.net", []string{"tag:other"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/%v", tt.hostname, tt.tags), func(t *testing.T) {
+			runtimeCfg := &config.RuntimeConfig{MagicDNSTags: strings.Join(tt.tags, ",")}
+			handler := &TailscaleDNSHandler{runtimeCfg: runtimeCfg, logger: logger.New(runtimeCfg.ToLoggingConfig())}
+			addrs, _, err := handler.lookupPeer(status, tt.hostname)
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("Expected %s to be unresolvable, got %v", tt.hostname, addrs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("lookupPeer failed: %v", err)
+			}
+			if len(addrs) != 1 || addrs[0].String() != tt.want {
+				t.Errorf("Expected %s, got %v", tt.want, addrs)
+			}
+		})
+	}
+}
+
+func TestLookupPeer_DualStack(t *testing.T) {
+	status := &ipnstate.Status{
+		Self: &ipnstate.PeerStatus{
+			DNSName:      "reflector.tail1234.ts.net.",
+			TailscaleIPs: []netip.Addr{netip.MustParseAddr("100.64.0.1"), netip.MustParseAddr("fd7a:115c:a1e0::1")},
+		},
+		Peer: map[key.NodePublic]*ipnstate.PeerStatus{
+			key.NewNode().Public(): {
+				DNSName:      "api.tail1234.ts.net.",
+				TailscaleIPs: []netip.Addr{netip.MustParseAddr("fd7a:115c:a1e0::2"), netip.MustParseAddr("100.64.0.2")},
+			},
+			key.NewNode().Public(): {
+				DNSName:      "v6only.tail1234.ts.net.",
+				TailscaleIPs: []netip.Addr{netip.MustParseAddr("fd7a:115c:a1e0::3")},
+			},
+		},
+	}
+
+	tests := []struct {
+		hostname string
+		qtype    uint16
+		want     []string
+	}{
+		{"reflector.tail1234.ts.net.", dns.TypeA, []string{"100.64.0.1"}},
+		{"reflector.tail1234.ts.net.", dns.TypeAAAA, []string{"fd7a:115c:a1e0::1"}},
+		// An IPv6-first peer still answers A queries
+		{"api.tail1234.ts.net.", dns.TypeA, []string{"100.64.0.2"}},
+		{"api.tail1234.ts.net.", dns.TypeAAAA, []string{"fd7a:115c:a1e0::2"}},
+		{"v6only.tail1234.ts.net.", dns.TypeA, nil},
+		{"v6only.tail1234.ts.net.", dns.TypeAAAA, []string{"fd7a:115c:a1e0::3"}},
+	}
+
+	runtimeCfg := &config.RuntimeConfig{}
+	handler := &TailscaleDNSHandler{runtimeCfg: runtimeCfg, logger: logger.New(runtimeCfg.ToLoggingConfig())}
+
+	for _, tt := range tests {
+		t.Run(tt.hostname+dns.TypeToString[tt.qtype], func(t *testing.T) {
+			addrs, _, err := handler.lookupPeer(status, tt.hostname)
+			if err != nil {
+				t.Fatalf("lookupPeer failed: %v", err)
+			}
+			answer := magicDNSAnswer(dns.Question{Name: tt.hostname, Qtype: tt.qtype, Qclass: dns.ClassINET}, addrs, 300)
+
+			var got []string
+			for _, rr := range answer {
+				if rr.Header().Rrtype != tt.qtype || rr.Header().Name != tt.hostname {
+					t.Errorf("Unexpected record %v", rr)
+				}
+				switch rr := rr.(type) {
+				case *dns.A:
+					got = append(got, rr.A.String())
+				case *dns.AAAA:
+					got = append(got, rr.AAAA.String())
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+
+func TestLookupPeer_ShortNames(t *testing.T) {
+	status := &ipnstate.Status{
+		Self: &ipnstate.PeerStatus{
+			DNSName:      "reflector.tail1234.ts.net.",
+			TailscaleIPs: []netip.Addr{netip.MustParseAddr("100.64.0.1")},
+		},
+		Peer: map[key.NodePublic]*ipnstate.PeerStatus{
+			key.NewNode().Public(): {
+				DNSName:      "db.tail1234.ts.net.",
+				TailscaleIPs: []netip.Addr{netip.MustParseAddr("100.64.0.2")},
+			},
+			// Shares the first label of db.tail1234.ts.net.
+			key.NewNode().Public(): {
+				DNSName:      "db.eu.tail1234.ts.net.",
+				TailscaleIPs: []netip.Addr{netip.MustParseAddr("100.64.0.3")},
+			},
+			key.NewNode().Public(): {
+				DNSName:      "web.tail1234.ts.net.",
+				TailscaleIPs: []netip.Addr{netip.MustParseAddr("100.64.0.4")},
+			},
+		},
+	}
+
+	tests := []struct {
+		hostname string
+		strict   bool
+		want     string // Empty if not resolvable
+	}{
+		{"web", false, "100.64.0.4"},
+		{"WEB.", true, "100.64.0.4"},
+		{"reflector", false, "100.64.0.1"},
+		{"we", false, ""},
+		{"mail", false, ""},
+		// The collision resolves to the first node by name unless strict
+		{"db", false, "100.64.0.3"},
+		{"db", true, ""},
+		// A full name is never ambiguous
+		{"db.tail1234.ts.net.", true, "100.64.0.2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/strict=%v", tt.hostname, tt.strict), func(t *testing.T) {
+			runtimeCfg := &config.RuntimeConfig{MagicDNSStrictShort: tt.strict}
+			handler := &TailscaleDNSHandler{runtimeCfg: runtimeCfg, logger: logger.New(runtimeCfg.ToLoggingConfig())}
+
+			addrs, _, err := handler.lookupPeer(status, tt.hostname)
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("Expected %s to be unresolvable, got %v", tt.hostname, addrs)
+				}
+				if tt.strict != errors.Is(err, errAmbiguousHostname) {
+					t.Errorf("Expected ambiguity error only in strict mode, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("lookupPeer failed: %v", err)
+			}
+			if len(addrs) != 1 || addrs[0].String() != tt.want {
+				t.Errorf("Expected %s, got %v", tt.want, addrs)
+			}
+		})
+	}
+}
+func TestIPFamily(t *testing.T) {
+	tests := []struct {
+		ip   net.IP
+		want string
+	}{
+		{net.ParseIP("100.64.0.1"), "ipv4"},
+		{net.ParseIP("0.0.0.0"), "ipv4"},
+		{net.ParseIP("fd7a:115c:a1e0::1"), "ipv6"},
+		{net.ParseIP("::"), "ipv6"},
+		{nil, "ipv4"},
+	}
+
+	for _, tt := range tests {
+		if got := ipFamily(tt.ip); got != tt.want {
+			t.Errorf("ipFamily(%v) = %s, want %s", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestForwarder_ExchangeViaTSNet(t *testing.T) {
+	// This test would require a mock TSNet server
+	// For now, we'll test that the forwarder can be created with TSNet
+	cfg := config.BackendConfig{
+		DNSServers: []string{"10.0.0.10:53"},
+		Timeout:    "5s",
+		Retries:    3,
+	}
+
+	logger := logger.Default()
+	forwarder := NewForwarder(cfg, logger)
+
+	if forwarder.tsnetServer != nil {
+		t.Error("Expected nil tsnetServer without TSNet")
+	}
+
+	// Test with TSNet (would need mock)
+	// mockTSNet := &mockTSNetServer{}
+	// forwarderWithTSNet := NewForwarderWithTSNet(cfg, logger, mockTSNet)
+	// if forwarderWithTSNet.tsnetServer == nil {
+	//     t.Error("Expected TSNet server to be set")
+	// }
+}
+type rewriteTTLHook struct{ ttl uint32 }
+
+func (h rewriteTTLHook) Process(_ context.Context, _ string, _, resp *dns.Msg) (*dns.Msg, error) {
+	for _, rr := range resp.Answer {
+		rr.Header().Ttl = h.ttl
+	}
+	return resp, nil
+}
+
+func TestDNSHandler_ResponseHook(t *testing.T) {
+	cfg := &config.Config{
+		Global: config.GlobalConfig{
+			Backend: config.BackendConfig{
+				DNSServers: []string{"8.8.8.8:53"},
+				Timeout:    "5s",
+				Retries:    3,
+			},
+		},
+		Zones: map[string]*config.Zone{
+			"cluster": {
+				Domains:         []string{"*.cluster.local"},
+				ReflectedDomain: "127.0.0.1",
+				TranslateID:     func() *uint16 { v := uint16(7); return &v }(),
+				Backend: config.BackendConfig{
+					DNSServers: []string{"8.8.8.8:53"},
+					Timeout:    "5s",
+					Retries:    3,
+				},
+			},
+		},
+	}
+
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+	log := logger.New(runtimeCfg.ToLoggingConfig())
+
+	via6Trans, err := via6.NewTranslator(cfg, log)
+	if err != nil {
+		t.Fatalf("Failed to create translator: %v", err)
+	}
+
+	handler := &TailscaleDNSHandler{
+		config:     cfg,
+		runtimeCfg: runtimeCfg,
+		via6Trans:  via6Trans,
+		forwarder:  NewForwarder(cfg.Global.Backend, log),
+		logger:     log,
+		zoneCaches: make(map[string]*cache.ZoneCache),
+	}
+
+	RegisterResponseHook("cluster", rewriteTTLHook{ttl: 5})
+	defer UnregisterResponseHook("cluster")
+
+	req := new(dns.Msg)
+	req.SetQuestion("app.cluster.local.", dns.TypeAAAA)
+	w := &testResponseWriter{
+		remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53},
+	}
+	handler.ServeDNS(w, req)
+
+	if w.msg == nil || len(w.msg.Answer) != 1 {
+		t.Fatalf("Expected 1 answer, got %v", w.msg)
+	}
+	if ttl := w.msg.Answer[0].Header().Ttl; ttl != 5 {
+		t.Errorf("Expected hook to rewrite TTL to 5, got %d", ttl)
+	}
+
+	// A hook returning nil drops the response entirely
+	RegisterResponseHook("cluster", dropHook{})
+	w = &testResponseWriter{
+		remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53},
+	}
+	handler.ServeDNS(w, req)
+	if w.msg != nil {
+		t.Errorf("Expected response to be dropped, got %v", w.msg)
+	}
+
+	// Hooks get the query's context, carrying its logger and deadline
+	runtimeCfg.QueryDeadline = time.Second
+	hook := &ctxHook{}
+	RegisterResponseHook("cluster", hook)
+	w = &testResponseWriter{
+		remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53},
+	}
+	handler.ServeDNS(w, req)
+	if hook.ctx == nil || logger.FromContext(hook.ctx, nil) == nil {
+		t.Error("Expected hook context to carry the query logger")
+	} else if _, ok := hook.ctx.Deadline(); !ok {
+		t.Error("Expected hook context to carry the query deadline")
+	}
+}
+
+type dropHook struct{}
+
+func (dropHook) Process(context.Context, string, *dns.Msg, *dns.Msg) (*dns.Msg, error) {
+	return nil, nil
+}
+
+type ctxHook struct{ ctx context.Context }
+
+func (h *ctxHook) Process(ctx context.Context, _ string, _, resp *dns.Msg) (*dns.Msg, error) {
+	h.ctx = ctx
+	return resp, nil
+}
+
+func TestDNSHandler_SynthesizedEDE(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+		do      bool
+		wantEDE bool
+	}{
+		{"enabled with DO", true, true, true},
+		{"enabled without DO", true, false, false},
+		{"disabled with DO", false, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backendCfg := config.BackendConfig{DNSServers: []string{"8.8.8.8:53"}, Timeout: "5s", Retries: 3}
+			cfg := &config.Config{
+				Global: config.GlobalConfig{Backend: backendCfg},
+				Zones: map[string]*config.Zone{
+					"cluster": {
+						Domains:         []string{"*.cluster.local"},
+						ReflectedDomain: "127.0.0.1",
+						TranslateID:     func() *uint16 { v := uint16(7); return &v }(),
+						Backend:         backendCfg,
+						SynthesizedEDE:  tt.enabled,
+					},
+				},
+			}
+
+			runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+			log := logger.New(runtimeCfg.ToLoggingConfig())
+
+			via6Trans, err := via6.NewTranslator(cfg, log)
+			if err != nil {
+				t.Fatalf("Failed to create translator: %v", err)
+			}
+
+			handler := &TailscaleDNSHandler{
+				config:     cfg,
+				runtimeCfg: runtimeCfg,
+				via6Trans:  via6Trans,
+				forwarder:  NewForwarder(backendCfg, log),
+				logger:     log,
+				zoneCaches: make(map[string]*cache.ZoneCache),
+			}
+
+			req := new(dns.Msg)
+			req.SetQuestion("app.cluster.local.", dns.TypeAAAA)
+			req.AuthenticatedData = true
+			req.CheckingDisabled = true
+			req.SetEdns0(1232, tt.do)
+			w := &testResponseWriter{
+				remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53},
+			}
+			handler.ServeDNS(w, req)
+
+			if w.msg == nil || len(w.msg.Answer) != 1 {
+				t.Fatalf("Expected 1 answer, got %v", w.msg)
+			}
+			if w.msg.AuthenticatedData {
+				t.Error("Expected AD bit to be cleared on synthesized answer")
+			}
+			if !w.msg.CheckingDisabled {
+				t.Error("Expected CD bit to be echoed from the query")
+			}
+
+			gotEDE := false
+			if opt := w.msg.IsEdns0(); opt != nil {
+				for _, o := range opt.Option {
+					if ede, ok := o.(*dns.EDNS0_EDE); ok && ede.InfoCode == edeSynthesized {
+						gotEDE = true
+					}
+				}
+			}
+			if gotEDE != tt.wantEDE {
+				t.Errorf("Expected EDE present=%v, got %v", tt.wantEDE, gotEDE)
+			}
+		})
+	}
+}
+
+func TestDNSHandler_Via6Order(t *testing.T) {
+	backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		hdr := dns.RR_Header{Name: r.Question[0].Name, Class: dns.ClassINET, Ttl: 60}
+		switch r.Question[0].Qtype {
+		case dns.TypeA:
+			hdr.Rrtype = dns.TypeA
+			msg.Answer = append(msg.Answer, &dns.A{Hdr: hdr, A: net.ParseIP("10.0.0.5").To4()})
+		case dns.TypeAAAA:
+			hdr.Rrtype = dns.TypeAAAA
+			msg.Answer = append(msg.Answer, &dns.AAAA{Hdr: hdr, AAAA: net.ParseIP("2001:db8::5")})
+		}
+		_ = w.WriteMsg(msg)
+	})
+
+	tests := []struct {
+		name        string
+		passthrough bool
+		order       string
+		want        []string
+	}{
+		{"4via6 only", false, config.Via6OrderFirst, []string{"fd7a:115c:a1e0:b1a:0:7:a00:5"}},
+		{"4via6 first", true, config.Via6OrderFirst, []string{"fd7a:115c:a1e0:b1a:0:7:a00:5", "2001:db8::5"}},
+		{"4via6 last", true, config.Via6OrderLast, []string{"2001:db8::5", "fd7a:115c:a1e0:b1a:0:7:a00:5"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backendCfg := config.BackendConfig{DNSServers: []string{backend}, Timeout: "2s", Retries: 1}
+			cfg := &config.Config{
+				Global: config.GlobalConfig{Backend: backendCfg},
+				Zones: map[string]*config.Zone{
+					"cluster": {
+						Domains:         []string{"*.cluster.local"},
+						ReflectedDomain: "prod.internal",
+						TranslateID:     func() *uint16 { v := uint16(7); return &v }(),
+						Backend:         backendCfg,
+						PassthroughAAAA: tt.passthrough,
+						Via6Order:       tt.order,
+					},
+				},
+			}
+
+			runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+			log := logger.New(runtimeCfg.ToLoggingConfig())
+
+			via6Trans, err := via6.NewTranslator(cfg, log)
+			if err != nil {
+				t.Fatalf("Failed to create translator: %v", err)
+			}
+
+			handler := &TailscaleDNSHandler{
+				config:     cfg,
+				runtimeCfg: runtimeCfg,
+				via6Trans:  via6Trans,
+				forwarder:  NewForwarder(backendCfg, log),
+				logger:     log,
+				zoneCaches: make(map[string]*cache.ZoneCache),
+			}
+
+			req := new(dns.Msg)
+			req.SetQuestion("app.cluster.local.", dns.TypeAAAA)
+			w := &testResponseWriter{
+				remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53},
+			}
+			handler.ServeDNS(w, req)
+
+			if w.msg == nil || len(w.msg.Answer) != len(tt.want) {
+				t.Fatalf("Expected %d answers, got %v", len(tt.want), w.msg)
+			}
+			for i, want := range tt.want {
+				aaaa, ok := w.msg.Answer[i].(*dns.AAAA)
+				if !ok {
+					t.Fatalf("Answer %d is not AAAA: %v", i, w.msg.Answer[i])
+				}
+				if aaaa.Hdr.Name != "app.cluster.local." {
+					t.Errorf("Answer %d owner = %s, want app.cluster.local.", i, aaaa.Hdr.Name)
+				}
+				if !aaaa.AAAA.Equal(net.ParseIP(want)) {
+					t.Errorf("Answer %d = %s, want %s", i, aaaa.AAAA, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDNSHandler_MagicDNSCNAME(t *testing.T) {
+	tests := []struct {
+		name      string
+		cnameOnly bool
+		wantTypes []uint16
+	}{
+		{"CNAME with 4via6", false, []uint16{dns.TypeCNAME, dns.TypeAAAA}},
+		{"CNAME only", true, []uint16{dns.TypeCNAME}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backendCfg := config.BackendConfig{DNSServers: []string{"8.8.8.8:53"}, Timeout: "5s", Retries: 3}
+			cfg := &config.Config{
+				Global: config.GlobalConfig{Backend: backendCfg},
+				Zones: map[string]*config.Zone{
+					"cluster": {
+						Domains:         []string{"*.cluster.local"},
+						ReflectedDomain: "10.0.0.5",
+						TranslateID:     func() *uint16 { v := uint16(7); return &v }(),
+						Backend:         backendCfg,
+						MagicDNSName:    "tail1234.ts.net",
+						CNAMEOnly:       tt.cnameOnly,
+					},
+				},
+			}
+
+			runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+			log := logger.New(runtimeCfg.ToLoggingConfig())
+
+			via6Trans, err := via6.NewTranslator(cfg, log)
+			if err != nil {
+				t.Fatalf("Failed to create translator: %v", err)
+			}
+
+			handler := &TailscaleDNSHandler{
+				config:     cfg,
+				runtimeCfg: runtimeCfg,
+				via6Trans:  via6Trans,
+				forwarder:  NewForwarder(backendCfg, log),
+				logger:     log,
+				zoneCaches: make(map[string]*cache.ZoneCache),
+			}
+
+			req := new(dns.Msg)
+			req.SetQuestion("app.cluster.local.", dns.TypeAAAA)
+			w := &testResponseWriter{
+				remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53},
+			}
+			handler.ServeDNS(w, req)
+
+			if w.msg == nil || len(w.msg.Answer) != len(tt.wantTypes) {
+				t.Fatalf("Expected %d answers, got %v", len(tt.wantTypes), w.msg)
+			}
+			for i, want := range tt.wantTypes {
+				if got := w.msg.Answer[i].Header().Rrtype; got != want {
+					t.Errorf("Answer %d: expected %s, got %s", i, dns.TypeToString[want], dns.TypeToString[got])
+				}
+			}
+
+			cname := w.msg.Answer[0].(*dns.CNAME)
+			if cname.Hdr.Name != "app.cluster.local." || cname.Target != "app.tail1234.ts.net." {
+				t.Errorf("Unexpected CNAME %v", cname)
+			}
+			if !tt.cnameOnly {
+				aaaa := w.msg.Answer[1].(*dns.AAAA)
+				if aaaa.Hdr.Name != "app.tail1234.ts.net." {
+					t.Errorf("Expected AAAA owned by the CNAME target, got %s", aaaa.Hdr.Name)
+				}
+				if !aaaa.AAAA.Equal(net.ParseIP("fd7a:115c:a1e0:b1a:0:7:a00:5")) {
+					t.Errorf("Expected 4via6 address, got %s", aaaa.AAAA)
+				}
+			}
+		})
+	}
+}
+
+func TestDNSHandler_ReflectAAAA(t *testing.T) {
+	var queried []string
+	var mu sync.Mutex
+	backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		mu.Lock()
+		queried = append(queried, r.Question[0].Name)
+		mu.Unlock()
+
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		if r.Question[0].Qtype == dns.TypeAAAA {
+			msg.Answer = append(msg.Answer, &dns.AAAA{
+				Hdr:  dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: 60},
+				AAAA: net.ParseIP("fd00::10"),
+			})
+		}
+		_ = w.WriteMsg(msg)
+	})
+
+	backendCfg := config.BackendConfig{DNSServers: []string{backend}, Timeout: "1s", Retries: 1}
+	cfg := &config.Config{
+		Global: config.GlobalConfig{Backend: backendCfg},
+		Zones: map[string]*config.Zone{
+			"v6": {
+				Domains:         []string{"*.v6.local"},
+				Backend:         backendCfg,
+				ReflectedDomain: "cluster.internal",
+				ReflectAAAA:     true,
+			},
+			"static": {
+				Domains:         []string{"*.static.local"},
+				Backend:         backendCfg,
+				ReflectedDomain: "fd00::20",
+				ReflectAAAA:     true,
+			},
+		},
+	}
+
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+	log := logger.New(runtimeCfg.ToLoggingConfig())
+	handler := &TailscaleDNSHandler{
+		config:     cfg,
+		runtimeCfg: runtimeCfg,
+		forwarder:  NewForwarder(backendCfg, log),
+		logger:     log,
+		zoneCaches: map[string]*cache.ZoneCache{"v6": cache.NewZoneCache(100, time.Minute)},
+	}
+
+	query := func(name string) *dns.Msg {
+		t.Helper()
+		req := new(dns.Msg)
+		req.SetQuestion(name, dns.TypeAAAA)
+		w := &testResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53}}
+		handler.ServeDNS(w, req)
+		if w.msg == nil {
+			t.Fatalf("Expected a response for %s", name)
+		}
+		return w.msg
+	}
+
+	// Forwarded under the reflected name, answered under the queried name,
+	// then served from the cache without another backend query
+	for i := 0; i < 2; i++ {
+		resp := query("app.v6.local.")
+		if resp.Question[0].Name != "app.v6.local." {
+			t.Errorf("Expected question app.v6.local., got %s", resp.Question[0].Name)
+		}
+		if len(resp.Answer) != 1 {
+			t.Fatalf("Expected 1 answer, got %v", resp.Answer)
+		}
+		aaaa, ok := resp.Answer[0].(*dns.AAAA)
+		if !ok || aaaa.Hdr.Name != "app.v6.local." || !aaaa.AAAA.Equal(net.ParseIP("fd00::10")) {
+			t.Errorf("Expected app.v6.local. AAAA fd00::10, got %v", resp.Answer[0])
+		}
+	}
+
+	mu.Lock()
+	if len(queried) != 1 || queried[0] != "app.cluster.internal." {
+		t.Errorf("Expected one backend query for app.cluster.internal., got %v", queried)
+	}
+	mu.Unlock()
+
+	resp := query("app.static.local.")
+	if len(resp.Answer) != 1 {
+		t.Fatalf("Expected 1 answer, got %v", resp.Answer)
+	}
+	if aaaa, ok := resp.Answer[0].(*dns.AAAA); !ok || !aaaa.AAAA.Equal(net.ParseIP("fd00::20")) {
+		t.Errorf("Expected static AAAA fd00::20, got %v", resp.Answer[0])
+	}
+}
+
+func TestDNSHandler_Via6ExternalClients(t *testing.T) {
+	var queried atomic.Int32
+	backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		queried.Add(1)
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		if r.Question[0].Qtype == dns.TypeA {
+			msg.Answer = append(msg.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   net.IPv4(10, 0, 0, 5),
+			})
+		}
+		_ = w.WriteMsg(msg)
+	})
+
+	backendCfg := config.BackendConfig{DNSServers: []string{backend}, Timeout: "1s", Retries: 1}
+	cfg := &config.Config{
+		Global: config.GlobalConfig{Backend: backendCfg},
+		Zones: map[string]*config.Zone{
+			"cluster": {
+				Domains:              []string{"*.cluster1.local"},
+				ReflectedDomain:      "cluster.local",
+				TranslateID:          func() *uint16 { v := uint16(7); return &v }(),
+				Backend:              backendCfg,
+				AllowExternalClients: true,
+			},
+		},
+	}
+	if err := cfg.ValidateZones(); err != nil {
+		t.Fatalf("Expected external clients allowed on a 4via6 zone, got %v", err)
+	}
+
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+	log := logger.New(runtimeCfg.ToLoggingConfig())
+	via6Trans, err := via6.NewTranslator(cfg, log)
+	if err != nil {
+		t.Fatalf("Failed to create translator: %v", err)
+	}
+	zoneCache := cache.NewZoneCache(100, 300*time.Second)
+	defer zoneCache.Stop()
+
+	handler := &TailscaleDNSHandler{
+		config:     cfg,
+		runtimeCfg: runtimeCfg,
+		via6Trans:  via6Trans,
+		forwarder:  NewForwarder(backendCfg, log),
+		logger:     log,
+		zoneCaches: map[string]*cache.ZoneCache{"cluster": zoneCache},
+	}
+	query := func(client string, qtype uint16) *dns.Msg {
+		t.Helper()
+		req := new(dns.Msg)
+		req.SetQuestion("web.cluster1.local.", qtype)
+		w := &testResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP(client), Port: 53}}
+		handler.ServeDNS(w, req)
+		if w.msg == nil {
+			t.Fatalf("Expected a response for %s", client)
+		}
+		return w.msg
+	}
+
+	// Tailscale clients get the 4via6 address, which also lands in the cache
+	resp := query("100.64.0.1", dns.TypeAAAA)
+	if len(resp.Answer) != 1 {
+		t.Fatalf("Expected one 4via6 answer, got %v", resp.Answer)
+	}
+	aaaa, ok := resp.Answer[0].(*dns.AAAA)
+	if !ok {
+		t.Fatalf("Expected an AAAA answer for the Tailscale client, got %v", resp.Answer[0])
+	}
+	if _, _, err := via6Trans.TranslateFromVia6(aaaa.AAAA); err != nil {
+		t.Errorf("Expected a 4via6 address for the Tailscale client, got %s: %v", aaaa.AAAA, err)
+	}
+
+	// External clients get the backend's records under the queried name,
+	// never the cached 4via6 answer, and have their own cache entries
+	for i := 0; i < 2; i++ {
+		resp = query("10.1.2.3", dns.TypeA)
+		if resp.Question[0].Name != "web.cluster1.local." {
+			t.Errorf("Expected question web.cluster1.local., got %s", resp.Question[0].Name)
+		}
+		if len(resp.Answer) != 1 {
+			t.Fatalf("Expected one answer, got %v", resp.Answer)
+		}
+		a, ok := resp.Answer[0].(*dns.A)
+		if !ok || a.Hdr.Name != "web.cluster1.local." || !a.A.Equal(net.IPv4(10, 0, 0, 5)) {
+			t.Errorf("Expected web.cluster1.local. A 10.0.0.5, got %v", resp.Answer[0])
+		}
+	}
+	if n := queried.Load(); n != 2 {
+		t.Errorf("Expected one backend query per client kind, got %d", n)
+	}
+
+	resp = query("10.1.2.3", dns.TypeAAAA)
+	if len(resp.Answer) != 0 {
+		t.Errorf("Expected no 4via6 answer for the external client, got %v", resp.Answer)
+	}
+}
+
+func TestDNSHandler_ReflectModeDirect(t *testing.T) {
+	// The reflected domain only has IPv6 addresses
+	backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		if r.Question[0].Qtype == dns.TypeAAAA {
+			msg.Answer = append(msg.Answer, &dns.AAAA{
+				Hdr:  dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: 60},
+				AAAA: net.ParseIP("fd00::10"),
+			})
+		}
+		_ = w.WriteMsg(msg)
+	})
+
+	backendCfg := config.BackendConfig{DNSServers: []string{backend}, Timeout: "1s", Retries: 1}
+	cfg := &config.Config{
+		Global: config.GlobalConfig{Backend: backendCfg},
+		Zones: map[string]*config.Zone{
+			"cluster": {
+				Domains:         []string{"*.cluster1.local"},
+				ReflectedDomain: "cluster.local",
+				TranslateID:     func() *uint16 { v := uint16(7); return &v }(),
+				ReflectMode:     config.ReflectModeDirect,
+				Backend:         backendCfg,
+			},
+		},
+	}
+
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+	log := logger.New(runtimeCfg.ToLoggingConfig())
+	via6Trans, err := via6.NewTranslator(cfg, log)
+	if err != nil {
+		t.Fatalf("Failed to create translator: %v", err)
+	}
+	handler := &TailscaleDNSHandler{
+		config:     cfg,
+		runtimeCfg: runtimeCfg,
+		via6Trans:  via6Trans,
+		forwarder:  NewForwarder(backendCfg, log),
+		logger:     log,
+		zoneCaches: map[string]*cache.ZoneCache{},
+	}
+	query := func(qtype uint16) *dns.Msg {
+		t.Helper()
+		req := new(dns.Msg)
+		req.SetQuestion("web.cluster1.local.", qtype)
+		w := &testResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53}}
+		handler.ServeDNS(w, req)
+		if w.msg == nil {
+			t.Fatal("Expected a response")
+		}
+		return w.msg
+	}
+
+	resp := query(dns.TypeAAAA)
+	if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 1 {
+		t.Fatalf("Expected one AAAA answer, got %v", resp)
+	}
+	aaaa, ok := resp.Answer[0].(*dns.AAAA)
+	if !ok || aaaa.Hdr.Name != "web.cluster1.local." || !aaaa.AAAA.Equal(net.ParseIP("fd00::10")) {
+		t.Errorf("Expected web.cluster1.local. AAAA fd00::10, got %v", resp.Answer[0])
+	}
+	if aaaa.Hdr.Ttl != 300 {
+		t.Errorf("Expected default TTL 300, got %d", aaaa.Hdr.Ttl)
+	}
+
+	// Without A records there is nothing to answer A queries with
+	resp = query(dns.TypeA)
+	if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 0 {
+		t.Errorf("Expected NODATA for A, got %v", resp)
+	}
+}
+
+func TestDNSHandler_InheritUpstreamTTL(t *testing.T) {
+	tests := []struct {
+		name        string
+		inherit     bool
+		upstreamTTL uint32
+		wantTTL     uint32
+	}{
+		{"default TTL", false, 42, 300},
+		{"upstream TTL", true, 42, 42},
+		{"capped at default TTL", true, 3600, 300},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+				msg := new(dns.Msg)
+				msg.SetReply(r)
+				msg.Answer = append(msg.Answer, &dns.A{
+					Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: tt.upstreamTTL},
+					A:   net.IPv4(10, 0, 0, 5),
+				})
+				_ = w.WriteMsg(msg)
+			})
+
+			backendCfg := config.BackendConfig{DNSServers: []string{backend}, Timeout: "1s", Retries: 1}
+			cfg := &config.Config{
+				Global: config.GlobalConfig{Backend: backendCfg},
+				Zones: map[string]*config.Zone{
+					"cluster": {
+						Domains:            []string{"*.cluster1.local"},
+						ReflectedDomain:    "cluster.local",
+						TranslateID:        func() *uint16 { v := uint16(7); return &v }(),
+						Backend:            backendCfg,
+						InheritUpstreamTTL: tt.inherit,
+					},
+				},
+			}
+
+			runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+			log := logger.New(runtimeCfg.ToLoggingConfig())
+			via6Trans, err := via6.NewTranslator(cfg, log)
+			if err != nil {
+				t.Fatalf("Failed to create translator: %v", err)
+			}
+
+			handler := &TailscaleDNSHandler{
+				config:     cfg,
+				runtimeCfg: runtimeCfg,
+				via6Trans:  via6Trans,
+				forwarder:  NewForwarder(backendCfg, log),
+				logger:     log,
+				zoneCaches: make(map[string]*cache.ZoneCache),
+			}
+
+			req := new(dns.Msg)
+			req.SetQuestion("web.cluster1.local.", dns.TypeAAAA)
+			w := &testResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53}}
+			handler.ServeDNS(w, req)
+
+			if w.msg == nil || len(w.msg.Answer) != 1 {
+				t.Fatalf("Expected one 4via6 answer, got %v", w.msg)
+			}
+			if ttl := w.msg.Answer[0].Header().Ttl; ttl != tt.wantTTL {
+				t.Errorf("Expected TTL %d, got %d", tt.wantTTL, ttl)
+			}
+		})
+	}
+}
+
+func TestDNSHandler_Via6CNAMELoop(t *testing.T) {
+	backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		msg.Answer = append(msg.Answer, &dns.CNAME{
+			Hdr:    dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 60},
+			Target: r.Question[0].Name,
+		})
+		_ = w.WriteMsg(msg)
+	})
+
+	backendCfg := config.BackendConfig{DNSServers: []string{backend}, Timeout: "1s", Retries: 1}
+	cfg := &config.Config{
+		Global: config.GlobalConfig{Backend: backendCfg},
+		Zones: map[string]*config.Zone{
+			"cluster": {
+				Domains:         []string{"*.cluster1.local"},
+				ReflectedDomain: "cluster.local",
+				TranslateID:     func() *uint16 { v := uint16(7); return &v }(),
+				Backend:         backendCfg,
+				Cache:           &config.CacheConfig{MaxSize: 100, TTL: "300s"},
+			},
+		},
+	}
+
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+	log := logger.New(runtimeCfg.ToLoggingConfig())
+	via6Trans, err := via6.NewTranslator(cfg, log)
+	if err != nil {
+		t.Fatalf("Failed to create translator: %v", err)
+	}
+	zoneCache := cache.NewZoneCache(100, 300*time.Second)
+	defer zoneCache.Stop()
+
+	handler := &TailscaleDNSHandler{
+		config:     cfg,
+		runtimeCfg: runtimeCfg,
+		via6Trans:  via6Trans,
+		forwarder:  NewForwarder(backendCfg, log),
+		logger:     log,
+		zoneCaches: map[string]*cache.ZoneCache{"cluster": zoneCache},
+	}
+
+	for _, qtype := range []uint16{dns.TypeAAAA, dns.TypeHTTPS} {
+		req := new(dns.Msg)
+		req.SetQuestion("web.cluster1.local.", qtype)
+		req.SetEdns0(1232, false)
+		w := &testResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53}}
+		handler.ServeDNS(w, req)
+
+		if w.msg == nil || w.msg.Rcode != dns.RcodeServerFailure {
+			t.Fatalf("Expected SERVFAIL for %s, got %v", dns.TypeToString[qtype], w.msg)
+		}
+		var ede *dns.EDNS0_EDE
+		if opt := w.msg.IsEdns0(); opt != nil {
+			for _, o := range opt.Option {
+				if e, ok := o.(*dns.EDNS0_EDE); ok {
+					ede = e
+				}
+			}
+		}
+		if ede == nil || !strings.Contains(ede.ExtraText, "CNAME loop") {
+			t.Errorf("Expected a CNAME loop EDE for %s, got %v", dns.TypeToString[qtype], ede)
+		}
+	}
+
+	if zoneCache.Size() != 0 {
+		t.Errorf("Expected server failures not to be cached, got %d entries", zoneCache.Size())
+	}
+}
+
+func TestDNSHandler_Via6CacheFollowsReflectedTTL(t *testing.T) {
+	var backendIP atomic.Uint32
+	backendIP.Store(1)
+	backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		msg.Answer = append(msg.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 1},
+			A:   net.IPv4(10, 0, 0, byte(backendIP.Load())),
+		})
+		_ = w.WriteMsg(msg)
+	})
+
+	backendCfg := config.BackendConfig{DNSServers: []string{backend}, Timeout: "1s", Retries: 1}
+	cfg := &config.Config{
+		Global: config.GlobalConfig{Backend: backendCfg},
+		Zones: map[string]*config.Zone{
+			"cluster": {
+				Domains:         []string{"*.cluster1.local"},
+				ReflectedDomain: "cluster.local",
+				TranslateID:     func() *uint16 { v := uint16(7); return &v }(),
+				Backend:         backendCfg,
+				Cache:           &config.CacheConfig{MaxSize: 100, TTL: "300s"},
+			},
+		},
+	}
+
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+	log := logger.New(runtimeCfg.ToLoggingConfig())
+	via6Trans, err := via6.NewTranslator(cfg, log)
+	if err != nil {
+		t.Fatalf("Failed to create translator: %v", err)
+	}
+	zoneCache := cache.NewZoneCache(100, 300*time.Second)
+	defer zoneCache.Stop()
+
+	handler := &TailscaleDNSHandler{
+		config:     cfg,
+		runtimeCfg: runtimeCfg,
+		via6Trans:  via6Trans,
+		forwarder:  NewForwarder(backendCfg, log),
+		logger:     log,
+		zoneCaches: map[string]*cache.ZoneCache{"cluster": zoneCache},
+	}
+	query := func() *dns.AAAA {
+		req := new(dns.Msg)
+		req.SetQuestion("web.cluster1.local.", dns.TypeAAAA)
+		w := &testResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53}}
+		handler.ServeDNS(w, req)
+		if w.msg == nil || len(w.msg.Answer) != 1 {
+			t.Fatalf("Expected one 4via6 answer, got %v", w.msg)
+		}
+		return w.msg.Answer[0].(*dns.AAAA)
+	}
+
+	first := query()
+	// Clients still get the default TTL, but the cached answer expires with the A record
+	if first.Hdr.Ttl != 300 {
+		t.Errorf("Expected default TTL 300, got %d", first.Hdr.Ttl)
+	}
+
+	backendIP.Store(2)
+	time.Sleep(1100 * time.Millisecond)
+
+	second := query()
+	if second.AAAA.Equal(first.AAAA) {
+		t.Errorf("Expected 4via6 answer to follow the backend's new address, still got %s", second.AAAA)
+	}
+	if got := second.AAAA.To16()[15]; got != 2 {
+		t.Errorf("Expected address embedding 10.0.0.2, got %s", second.AAAA)
+	}
+}
+
+func TestDNSHandler_Via6NegativeAnswers(t *testing.T) {
+	var queries atomic.Int32
+	backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		queries.Add(1)
+		msg := new(dns.Msg)
+		if r.Question[0].Name != "web.cluster.local." {
+			msg.SetRcode(r, dns.RcodeNameError)
+			_ = w.WriteMsg(msg)
+			return
+		}
+		msg.SetReply(r)
+		if r.Question[0].Qtype == dns.TypeA {
+			msg.Answer = append(msg.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   net.IPv4(10, 0, 0, 1),
+			})
+		}
+		_ = w.WriteMsg(msg)
+	})
+
+	backendCfg := config.BackendConfig{DNSServers: []string{backend}, Timeout: "1s", Retries: 1}
+	cfg := &config.Config{
+		Global: config.GlobalConfig{Backend: backendCfg},
+		Zones: map[string]*config.Zone{
+			"cluster": {
+				Domains:         []string{"*.cluster1.local"},
+				ReflectedDomain: "cluster.local",
+				TranslateID:     func() *uint16 { v := uint16(7); return &v }(),
+				Backend:         backendCfg,
+			},
+		},
+	}
+
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+	log := logger.New(runtimeCfg.ToLoggingConfig())
+	via6Trans, err := via6.NewTranslator(cfg, log)
+	if err != nil {
+		t.Fatalf("Failed to create translator: %v", err)
+	}
+
+	handler := &TailscaleDNSHandler{
+		config:     cfg,
+		runtimeCfg: runtimeCfg,
+		via6Trans:  via6Trans,
+		forwarder:  NewForwarder(backendCfg, log),
+		logger:     log,
+		zoneCaches: make(map[string]*cache.ZoneCache),
+	}
+
+	tests := []struct {
+		name      string
+		qname     string
+		qtype     uint16
+		wantRcode int
+	}{
+		{"missing name AAAA", "gone.cluster1.local.", dns.TypeAAAA, dns.RcodeNameError},
+		{"missing name A", "gone.cluster1.local.", dns.TypeA, dns.RcodeNameError},
+		{"existing name A", "web.cluster1.local.", dns.TypeA, dns.RcodeSuccess},
+		{"missing name HTTPS", "gone.cluster1.local.", dns.TypeHTTPS, dns.RcodeNameError},
+		{"existing name HTTPS", "web.cluster1.local.", dns.TypeHTTPS, dns.RcodeSuccess},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := new(dns.Msg)
+			req.SetQuestion(tt.qname, tt.qtype)
+			w := &testResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53}}
+			queries.Store(0)
+			handler.ServeDNS(w, req)
+
+			if w.msg == nil {
+				t.Fatal("Expected a response")
+			}
+			// Whether the name exists comes from the one lookup already made
+			if got := queries.Load(); got != 1 {
+				t.Errorf("Expected 1 backend query, got %d", got)
+			}
+			if w.msg.Rcode != tt.wantRcode || len(w.msg.Answer) != 0 {
+				t.Fatalf("Expected %s with no answers, got %v", dns.RcodeToString[tt.wantRcode], w.msg)
+			}
+			if len(w.msg.Ns) != 1 {
+				t.Fatalf("Expected an SOA in the authority section, got %v", w.msg.Ns)
+			}
+			soa, ok := w.msg.Ns[0].(*dns.SOA)
+			if !ok || soa.Hdr.Name != "cluster1.local." {
+				t.Errorf("Expected SOA for cluster1.local., got %v", w.msg.Ns[0])
+			}
+			if ok && soa.Minttl != 60 {
+				t.Errorf("Expected SOA minimum 60, got %d", soa.Minttl)
+			}
+		})
+	}
+}
+
+func TestDNSHandler_Via6MultipleAnswers(t *testing.T) {
+	upstream := []net.IP{net.IPv4(10, 0, 0, 3), net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)}
+	backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		for _, ip := range upstream {
+			msg.Answer = append(msg.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   ip,
+			})
+		}
+		_ = w.WriteMsg(msg)
+	})
+
+	backendCfg := config.BackendConfig{DNSServers: []string{backend}, Timeout: "1s", Retries: 1}
+	cfg := &config.Config{
+		Global: config.GlobalConfig{Backend: backendCfg},
+		Zones: map[string]*config.Zone{
+			"cluster": {
+				Domains:         []string{"*.cluster1.local"},
+				ReflectedDomain: "cluster.local",
+				TranslateID:     func() *uint16 { v := uint16(7); return &v }(),
+				Backend:         backendCfg,
+			},
+		},
+	}
+
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+	log := logger.New(runtimeCfg.ToLoggingConfig())
+	via6Trans, err := via6.NewTranslator(cfg, log)
+	if err != nil {
+		t.Fatalf("Failed to create translator: %v", err)
+	}
+
+	handler := &TailscaleDNSHandler{
+		config:     cfg,
+		runtimeCfg: runtimeCfg,
+		via6Trans:  via6Trans,
+		forwarder:  NewForwarder(backendCfg, log),
+		logger:     log,
+		zoneCaches: make(map[string]*cache.ZoneCache),
+	}
+
+	req := new(dns.Msg)
+	req.SetQuestion("web.cluster1.local.", dns.TypeAAAA)
+	w := &testResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53}}
+	handler.ServeDNS(w, req)
+
+	if w.msg == nil || len(w.msg.Answer) != len(upstream) {
+		t.Fatalf("Expected %d 4via6 answers, got %v", len(upstream), w.msg)
+	}
+	for i, rr := range w.msg.Answer {
+		aaaa, ok := rr.(*dns.AAAA)
+		if !ok {
+			t.Fatalf("Answer %d is not AAAA: %v", i, rr)
+		}
+		if aaaa.AAAA[10] != 0 || aaaa.AAAA[11] != 7 {
+			t.Errorf("Answer %d has wrong translateID bytes: %s", i, aaaa.AAAA)
+		}
+		if !net.IP(aaaa.AAAA[12:16]).Equal(upstream[i]) {
+			t.Errorf("Answer %d embeds %s, expected %s", i, net.IP(aaaa.AAAA[12:16]), upstream[i])
+		}
+	}
+}
+
+func TestDNSHandler_ServeStaleOnFailure(t *testing.T) {
+	backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		msg.Answer = append(msg.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 0},
+			A:   net.IPv4(10, 0, 0, 1),
+		})
+		_ = w.WriteMsg(msg)
+	})
+
+	for _, onFailure := range []bool{true, false} {
+		t.Run(fmt.Sprintf("onFailure=%v", onFailure), func(t *testing.T) {
+			backendCfg := config.BackendConfig{DNSServers: []string{backend}, Timeout: "200ms", Retries: 1}
+			zone := &config.Zone{
+				Domains:    []string{"*.stale.local"},
+				Backend:    backendCfg,
+				ServeStale: &config.ServeStale{OnFailure: onFailure, MaxStale: "1h"},
+			}
+			cfg := &config.Config{
+				Global: config.GlobalConfig{Backend: backendCfg},
+				Zones:  map[string]*config.Zone{"stale": zone},
+			}
+
+			zoneCache := cache.NewZoneCache(100, time.Minute)
+			defer zoneCache.Stop()
+			zoneCache.SetStaleRetention(zone.StaleRetention())
+
+			runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+			log := logger.New(runtimeCfg.ToLoggingConfig())
+			handler := &TailscaleDNSHandler{
+				config:     cfg,
+				runtimeCfg: runtimeCfg,
+				forwarder:  NewForwarder(backendCfg, log),
+				logger:     log,
+				zoneCaches: map[string]*cache.ZoneCache{"stale": zoneCache},
+			}
+
+			query := func() *dns.Msg {
+				req := new(dns.Msg)
+				req.SetQuestion("app.stale.local.", dns.TypeA)
+				w := &testResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53}}
+				handler.ServeDNS(w, req)
+				if w.msg == nil {
+					t.Fatal("Expected a response")
+				}
+				return w.msg
+			}
+
+			// Prime the cache with an answer that expires at once, then lose the backend
+			if resp := query(); len(resp.Answer) != 1 {
+				t.Fatalf("Expected answer from backend, got %v", resp)
+			}
+			zone.Backend.DNSServers = []string{"127.0.0.1:1"}
+
+			resp := query()
+			if !onFailure {
+				if resp.Rcode != dns.RcodeServerFailure {
+					t.Errorf("Expected SERVFAIL, got %s", dns.RcodeToString[resp.Rcode])
+				}
+				return
+			}
+			if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 1 {
+				t.Fatalf("Expected stale answer, got %v", resp)
+			}
+			if ttl := resp.Answer[0].Header().Ttl; ttl != 30 {
+				t.Errorf("Expected stale TTL 30, got %d", ttl)
+			}
+		})
+	}
+}
+
+func TestDNSHandler_CacheOnly(t *testing.T) {
+	var backendQueries atomic.Int32
+	backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		backendQueries.Add(1)
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		_ = w.WriteMsg(msg)
+	})
+
+	backendCfg := config.BackendConfig{DNSServers: []string{backend}, Timeout: "1s", Retries: 1}
+	cfg := &config.Config{
+		Global: config.GlobalConfig{Backend: backendCfg},
+		Zones: map[string]*config.Zone{
+			"fail":    {Domains: []string{"*.fail.local"}, Backend: backendCfg},
+			"refused": {Domains: []string{"*.refused.local"}, Backend: backendCfg, CacheOnlyResponse: config.CacheOnlyRefused},
+			"stale":   {Domains: []string{"*.stale.local"}, Backend: backendCfg, CacheOnlyResponse: config.CacheOnlyStale},
+		},
+	}
+
+	answer := func(name string, ttl uint32) *dns.Msg {
+		msg := new(dns.Msg)
+		msg.SetQuestion(name, dns.TypeA)
+		msg.Response = true
+		msg.Answer = append(msg.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+			A:   net.IPv4(10, 0, 0, 1),
+		})
+		return msg
+	}
+
+	failCache := cache.NewZoneCache(100, time.Minute)
+	failCache.Set(cache.CacheKey("cached.fail.local.", dns.TypeA, nil), answer("cached.fail.local.", 60))
+	staleCache := cache.NewZoneCache(100, time.Minute)
+	staleCache.SetStaleRetention(time.Hour)
+	staleCache.Set(cache.CacheKey("expired.stale.local.", dns.TypeA, nil), answer("expired.stale.local.", 0))
+
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300, CacheOnly: true}
+	log := logger.New(runtimeCfg.ToLoggingConfig())
+	handler := &TailscaleDNSHandler{
+		config:     cfg,
+		runtimeCfg: runtimeCfg,
+		forwarder:  NewForwarder(backendCfg, log),
+		logger:     log,
+		zoneCaches: map[string]*cache.ZoneCache{"fail": failCache, "stale": staleCache},
+	}
+
+	tests := []struct {
+		name        string
+		qname       string
+		wantRcode   int
+		wantAnswers int
+		wantEDE     uint16
+	}{
+		{"cache hit", "cached.fail.local.", dns.RcodeSuccess, 1, 0},
+		{"miss answers SERVFAIL", "other.fail.local.", dns.RcodeServerFailure, 0, dns.ExtendedErrorCodeNoReachableAuthority},
+		{"miss answers REFUSED", "app.refused.local.", dns.RcodeRefused, 0, 0},
+		{"miss answers stale", "expired.stale.local.", dns.RcodeSuccess, 1, dns.ExtendedErrorCodeStaleAnswer},
+		{"stale falls back to SERVFAIL", "other.stale.local.", dns.RcodeServerFailure, 0, dns.ExtendedErrorCodeNoReachableAuthority},
+		{"no zone", "example.com.", dns.RcodeServerFailure, 0, dns.ExtendedErrorCodeNoReachableAuthority},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := new(dns.Msg)
+			req.SetQuestion(tt.qname, dns.TypeA)
+			req.SetEdns0(1232, false)
+			w := &testResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53}}
+			handler.ServeDNS(w, req)
+
+			if w.msg == nil {
+				t.Fatal("Expected a response")
+			}
+			if w.msg.Rcode != tt.wantRcode {
+				t.Errorf("Expected rcode %s, got %s", dns.RcodeToString[tt.wantRcode], dns.RcodeToString[w.msg.Rcode])
+			}
+			if len(w.msg.Answer) != tt.wantAnswers {
+				t.Errorf("Expected %d answers, got %v", tt.wantAnswers, w.msg.Answer)
+			}
+			var gotEDE uint16
+			if opt := w.msg.IsEdns0(); opt != nil {
+				for _, o := range opt.Option {
+					if ede, ok := o.(*dns.EDNS0_EDE); ok {
+						gotEDE = ede.InfoCode
+					}
+				}
+			}
+			if gotEDE != tt.wantEDE {
+				t.Errorf("Expected EDE %d, got %d", tt.wantEDE, gotEDE)
+			}
+		})
+	}
+
+	if n := backendQueries.Load(); n != 0 {
+		t.Errorf("Expected no backend queries in cache-only mode, got %d", n)
+	}
+}
+
+func TestDNSHandler_FixedTTL(t *testing.T) {
+	backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		for i, ttl := range []uint32{30, 3600} {
+			msg.Answer = append(msg.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+				A:   net.IPv4(10, 0, 0, byte(i+1)),
+			})
+		}
+		_ = w.WriteMsg(msg)
+	})
+
+	fixedTTL := uint32(120)
+	backendCfg := config.BackendConfig{DNSServers: []string{backend}, Timeout: "1s", Retries: 1}
+	cfg := &config.Config{
+		Global: config.GlobalConfig{Backend: backendCfg},
+		Zones: map[string]*config.Zone{
+			"forwarded": {
+				Domains:  []string{"*.fwd.local"},
+				Backend:  backendCfg,
+				FixedTTL: &fixedTTL,
+			},
+			"translated": {
+				Domains:         []string{"*.via6.local"},
+				ReflectedDomain: "10.0.0.5",
+				TranslateID:     func() *uint16 { v := uint16(7); return &v }(),
+				Backend:         backendCfg,
+				FixedTTL:        &fixedTTL,
+			},
+		},
+	}
+
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+	log := logger.New(runtimeCfg.ToLoggingConfig())
+	via6Trans, err := via6.NewTranslator(cfg, log)
+	if err != nil {
+		t.Fatalf("Failed to create translator: %v", err)
+	}
+
+	handler := &TailscaleDNSHandler{
+		config:     cfg,
+		runtimeCfg: runtimeCfg,
+		via6Trans:  via6Trans,
+		forwarder:  NewForwarder(backendCfg, log),
+		logger:     log,
+		zoneCaches: make(map[string]*cache.ZoneCache),
+	}
+
+	tests := []struct {
+		name    string
+		qname   string
+		qtype   uint16
+		answers int
+	}{
+		{"forwarded", "app.fwd.local.", dns.TypeA, 2},
+		{"translated", "app.via6.local.", dns.TypeAAAA, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := new(dns.Msg)
+			req.SetQuestion(tt.qname, tt.qtype)
+			w := &testResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53}}
+			handler.ServeDNS(w, req)
+
+			if w.msg == nil {
+				t.Fatal("Expected a response")
+			}
+			if len(w.msg.Answer) != tt.answers {
+				t.Fatalf("Expected %d answers, got %v", tt.answers, w.msg.Answer)
+			}
+			for _, rr := range w.msg.Answer {
+				if rr.Header().Ttl != fixedTTL {
+					t.Errorf("Expected TTL %d, got %d for %s", fixedTTL, rr.Header().Ttl, rr)
+				}
+			}
+		})
+	}
+}
+
+func TestDNSHandler_QueryIDLogging(t *testing.T) {
+	backendCfg := config.BackendConfig{DNSServers: []string{"127.0.0.1:53"}, Timeout: "1s", Retries: 1}
+	cfg := &config.Config{
+		Global: config.GlobalConfig{Backend: backendCfg},
+		Zones: map[string]*config.Zone{
+			"corp": {
+				Domains:         []string{"*.corp.local"},
+				ReflectedDomain: "10.0.0.5",
+				TranslateID:     func() *uint16 { v := uint16(7); return &v }(),
+				Backend:         backendCfg,
+			},
+		},
+	}
+
+	logFile := filepath.Join(t.TempDir(), "tsdnsreflector.log")
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300, LogLevel: "debug", LogFormat: "json", LogFile: logFile}
+	log := logger.New(runtimeCfg.ToLoggingConfig())
+	via6Trans, err := via6.NewTranslator(cfg, log)
+	if err != nil {
+		t.Fatalf("Failed to create translator: %v", err)
+	}
+	handler := &TailscaleDNSHandler{
+		config:     cfg,
+		runtimeCfg: runtimeCfg,
+		via6Trans:  via6Trans,
+		forwarder:  NewForwarder(backendCfg, log),
+		logger:     log,
+		zoneCaches: map[string]*cache.ZoneCache{"corp": cache.NewZoneCache(100, 5*time.Minute)},
+	}
+
+	// query answers one query and returns the log lines it wrote
+	startup, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+	logged := strings.Count(string(startup), "\n")
+	query := func(name string) []string {
+		t.Helper()
+		req := new(dns.Msg)
+		req.SetQuestion(name, dns.TypeAAAA)
+		handler.ServeDNS(&testResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53}}, req)
+
+		data, err := os.ReadFile(logFile)
+		if err != nil {
+			t.Fatalf("Failed to read log: %v", err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		lines, logged = lines[logged:], len(lines)
+		return lines
+	}
+
+	// queryID returns the query ID shared by every line, failing if any differs
+	queryID := func(lines []string) string {
+		t.Helper()
+		var qid string
+		for _, line := range lines {
+			var record struct {
+				Msg      string `json:"msg"`
+				QID      string `json:"qid"`
+				ClientIP string `json:"clientIP"`
+				Zone     string `json:"zone"`
+			}
+			if err := json.Unmarshal([]byte(line), &record); err != nil {
+				t.Fatalf("Failed to parse log line %q: %v", line, err)
+			}
+			if record.QID == "" || (qid != "" && record.QID != qid) {
+				t.Errorf("Expected every line to carry query ID %q, got %q on %q", qid, record.QID, record.Msg)
+			}
+			if record.ClientIP != "100.64.0.1" || record.Zone != "corp" {
+				t.Errorf("Expected client 100.64.0.1 and zone corp on %q, got %q and %q", record.Msg, record.ClientIP, record.Zone)
+			}
+			if n := strings.Count(line, `"zone":`); n != 1 {
+				t.Errorf("Expected the zone logged once on %q, got %d times", record.Msg, n)
+			}
+			qid = cmp.Or(qid, record.QID)
+		}
+		return qid
+	}
+
+	lines := query("app.corp.local.")
+	var handlerLine, translatorLine bool
+	for _, line := range lines {
+		handlerLine = handlerLine || strings.Contains(line, "4via6 translation triggered")
+		translatorLine = translatorLine || strings.Contains(line, "Resolving reflected domain")
+	}
+	if !handlerLine || !translatorLine {
+		t.Fatalf("Expected handler and translator debug lines, got %q", lines)
+	}
+	first := queryID(lines)
+
+	if second := queryID(query("db.corp.local.")); second == first {
+		t.Errorf("Expected each query to get its own ID, both got %q", first)
+	}
+}
+
+func TestDNSHandler_ZoneTTL(t *testing.T) {
+	shortTTL := uint32(30)
+	backendCfg := config.BackendConfig{DNSServers: []string{"127.0.0.1:53"}, Timeout: "1s", Retries: 1}
+	cfg := &config.Config{
+		Global: config.GlobalConfig{Backend: backendCfg},
+		Zones: map[string]*config.Zone{
+			"volatile": {
+				Domains:         []string{"*.volatile.local"},
+				ReflectedDomain: "10.0.0.5",
+				TranslateID:     func() *uint16 { v := uint16(7); return &v }(),
+				Backend:         backendCfg,
+				TTL:             &shortTTL,
+			},
+			"stable": {
+				Domains:         []string{"*.stable.local"},
+				ReflectedDomain: "10.0.0.6",
+				TranslateID:     func() *uint16 { v := uint16(8); return &v }(),
+				Backend:         backendCfg,
+			},
+		},
+	}
+
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+	log := logger.New(runtimeCfg.ToLoggingConfig())
+	via6Trans, err := via6.NewTranslator(cfg, log)
+	if err != nil {
+		t.Fatalf("Failed to create translator: %v", err)
+	}
+
+	handler := &TailscaleDNSHandler{
+		config:     cfg,
+		runtimeCfg: runtimeCfg,
+		via6Trans:  via6Trans,
+		forwarder:  NewForwarder(backendCfg, log),
+		logger:     log,
+		zoneCaches: make(map[string]*cache.ZoneCache),
+	}
+
+	tests := []struct {
+		qname   string
+		wantTTL uint32
+	}{
+		{"app.volatile.local.", shortTTL},
+		{"app.stable.local.", 300},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.qname, func(t *testing.T) {
+			req := new(dns.Msg)
+			req.SetQuestion(tt.qname, dns.TypeAAAA)
+			w := &testResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53}}
+			handler.ServeDNS(w, req)
+
+			if w.msg == nil || len(w.msg.Answer) != 1 {
+				t.Fatalf("Expected one 4via6 answer, got %v", w.msg)
+			}
+			if ttl := w.msg.Answer[0].Header().Ttl; ttl != tt.wantTTL {
+				t.Errorf("Expected TTL %d, got %d", tt.wantTTL, ttl)
+			}
+		})
+	}
+}
+
+func TestDNSHandler_Via6ZoneSOA(t *testing.T) {
+	backendCfg := config.BackendConfig{DNSServers: []string{"127.0.0.1:53"}, Timeout: "1s", Retries: 1}
+	cfg := &config.Config{
+		Global: config.GlobalConfig{Backend: backendCfg},
+		Zones: map[string]*config.Zone{
+			"cluster": {
+				Domains:         []string{"cluster1.local"},
+				ReflectedDomain: "10.0.0.5",
+				TranslateID:     func() *uint16 { v := uint16(7); return &v }(),
+				Backend:         backendCfg,
+				SOA:             &config.SOAConfig{MName: "ns1.example.net", Serial: 2026101601},
+			},
+		},
+	}
+
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+	log := logger.New(runtimeCfg.ToLoggingConfig())
+	via6Trans, err := via6.NewTranslator(cfg, log)
+	if err != nil {
+		t.Fatalf("Failed to create translator: %v", err)
+	}
+
+	handler := &TailscaleDNSHandler{
+		config:     cfg,
+		runtimeCfg: runtimeCfg,
+		via6Trans:  via6Trans,
+		forwarder:  NewForwarder(backendCfg, log),
+		logger:     log,
+		zoneCaches: make(map[string]*cache.ZoneCache),
+	}
+
+	query := func(name string, qtype uint16) *dns.Msg {
+		req := new(dns.Msg)
+		req.SetQuestion(name, qtype)
+		w := &testResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53}}
+		handler.ServeDNS(w, req)
+		if w.msg == nil {
+			t.Fatalf("Expected a response for %s", name)
+		}
+		return w.msg
+	}
+
+	resp := query("cluster1.local.", dns.TypeSOA)
+	if resp.Rcode != dns.RcodeSuccess || !resp.Authoritative || len(resp.Answer) != 1 {
+		t.Fatalf("Expected an authoritative SOA answer, got %v", resp)
+	}
+	soa, ok := resp.Answer[0].(*dns.SOA)
+	if !ok || soa.Hdr.Name != "cluster1.local." {
+		t.Fatalf("Expected SOA for cluster1.local., got %v", resp.Answer[0])
+	}
+	if soa.Ns != "ns1.example.net." || soa.Mbox != "hostmaster.cluster1.local." || soa.Serial != 2026101601 || soa.Refresh != 3600 {
+		t.Errorf("Expected configured mname and serial with default rname and refresh, got %v", soa)
+	}
+
+	// NODATA below the apex carries the same SOA for negative caching
+	resp = query("web.cluster1.local.", dns.TypeA)
+	if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 0 || len(resp.Ns) != 1 {
+		t.Fatalf("Expected NODATA with an SOA in the authority section, got %v", resp)
+	}
+	if soa, ok := resp.Ns[0].(*dns.SOA); !ok || soa.Serial != 2026101601 || soa.Minttl != 60 {
+		t.Errorf("Expected the zone SOA with minimum 60, got %v", resp.Ns[0])
+	}
+}
+
+func TestDNSHandler_Via6ZoneNS(t *testing.T) {
+	backendCfg := config.BackendConfig{DNSServers: []string{"127.0.0.1:53"}, Timeout: "1s", Retries: 1}
+	cfg := &config.Config{
+		Global: config.GlobalConfig{Backend: backendCfg},
+		Zones: map[string]*config.Zone{
+			"default": {
+				Domains:         []string{"cluster1.local", "*.cluster1.local"},
+				ReflectedDomain: "10.0.0.5",
+				TranslateID:     func() *uint16 { v := uint16(7); return &v }(),
+				Backend:         backendCfg,
+			},
+			"configured": {
+				Domains:         []string{"cluster2.local", "*.cluster2.local"},
+				ReflectedDomain: "10.0.0.6",
+				TranslateID:     func() *uint16 { v := uint16(8); return &v }(),
+				Backend:         backendCfg,
+				Nameservers:     []string{"ns1.example.net", "ns2.example.net."},
+				AuthorityNS:     true,
+			},
+		},
+	}
+
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300, Hostname: "reflector", MagicDNSSuffix: "tail1234.ts.net"}
+	log := logger.New(runtimeCfg.ToLoggingConfig())
+	via6Trans, err := via6.NewTranslator(cfg, log)
+	if err != nil {
+		t.Fatalf("Failed to create translator: %v", err)
+	}
+
+	handler := &TailscaleDNSHandler{
+		config:     cfg,
+		runtimeCfg: runtimeCfg,
+		via6Trans:  via6Trans,
+		forwarder:  NewForwarder(backendCfg, log),
+		logger:     log,
+		zoneCaches: make(map[string]*cache.ZoneCache),
+	}
+
+	query := func(name string, qtype uint16) *dns.Msg {
+		req := new(dns.Msg)
+		req.SetQuestion(name, qtype)
+		w := &testResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53}}
+		handler.ServeDNS(w, req)
+		if w.msg == nil {
+			t.Fatalf("Expected a response for %s", name)
+		}
+		return w.msg
+	}
+	targets := func(rrs []dns.RR) []string {
+		var names []string
+		for _, rr := range rrs {
+			if ns, ok := rr.(*dns.NS); ok {
+				names = append(names, ns.Ns)
+			}
+		}
+		return names
+	}
+
+	tests := []struct {
+		qname string
+		want  []string
+	}{
+		{"cluster1.local.", []string{"reflector.tail1234.ts.net."}},
+		{"cluster2.local.", []string{"ns1.example.net.", "ns2.example.net."}},
+	}
+	for _, tt := range tests {
+		resp := query(tt.qname, dns.TypeNS)
+		if resp.Rcode != dns.RcodeSuccess || !resp.Authoritative {
+			t.Fatalf("Expected an authoritative answer for %s, got %v", tt.qname, resp)
+		}
+		if got := targets(resp.Answer); !slices.Equal(got, tt.want) {
+			t.Errorf("NS %s: expected %v, got %v", tt.qname, tt.want, got)
+		}
+	}
+
+	// Positive answers carry the apex NS records only where the zone asks for them
+	if got := targets(query("web.cluster2.local.", dns.TypeAAAA).Ns); !slices.Equal(got, tests[1].want) {
+		t.Errorf("Expected authority NS %v, got %v", tests[1].want, got)
+	}
+	if got := query("web.cluster1.local.", dns.TypeAAAA).Ns; len(got) != 0 {
+		t.Errorf("Expected no authority records, got %v", got)
+	}
+}
+
+func TestDNSHandler_RotateAnswers(t *testing.T) {
+	backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		name := r.Question[0].Name
+		if name == "app.fwd.local." {
+			msg.Answer = append(msg.Answer, &dns.CNAME{
+				Hdr:    dns.RR_Header{Name: name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 60},
+				Target: "pool.fwd.local.",
+			})
+			name = "pool.fwd.local."
+		}
+		if r.Question[0].Qtype == dns.TypeA {
+			for i := 1; i <= 3; i++ {
+				msg.Answer = append(msg.Answer, &dns.A{
+					Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+					A:   net.IPv4(10, 0, 0, byte(i)),
+				})
+			}
+		}
+		_ = w.WriteMsg(msg)
+	})
+
+	backendCfg := config.BackendConfig{DNSServers: []string{backend}, Timeout: "1s", Retries: 1}
+	cfg := &config.Config{
+		Global: config.GlobalConfig{Backend: backendCfg},
+		Zones: map[string]*config.Zone{
+			"forwarded": {
+				Domains:       []string{"*.fwd.local"},
+				Backend:       backendCfg,
+				RotateAnswers: true,
+			},
+			"translated": {
+				Domains:         []string{"*.via6.local"},
+				ReflectedDomain: "backend.local",
+				TranslateID:     func() *uint16 { v := uint16(7); return &v }(),
+				Backend:         backendCfg,
+				RotateAnswers:   true,
+			},
+			"plain": {
+				Domains: []string{"*.plain.local"},
+				Backend: backendCfg,
+			},
+		},
+	}
+
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+	log := logger.New(runtimeCfg.ToLoggingConfig())
+	via6Trans, err := via6.NewTranslator(cfg, log)
+	if err != nil {
+		t.Fatalf("Failed to create translator: %v", err)
+	}
+
+	handler := &TailscaleDNSHandler{
+		config:     cfg,
+		runtimeCfg: runtimeCfg,
+		via6Trans:  via6Trans,
+		forwarder:  NewForwarder(backendCfg, log),
+		logger:     log,
+		zoneCaches: make(map[string]*cache.ZoneCache),
+	}
+
+	// first returns the first record of the queried type, after checking
+	// that the CNAME, if any, still leads the answer
+	first := func(qname string, qtype uint16) string {
+		req := new(dns.Msg)
+		req.SetQuestion(qname, qtype)
+		w := &testResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53}}
+		handler.ServeDNS(w, req)
+		if w.msg == nil || len(w.msg.Answer) < 3 {
+			t.Fatalf("Expected three addresses for %s, got %v", qname, w.msg)
+		}
+		answer := w.msg.Answer
+		if _, ok := answer[0].(*dns.CNAME); ok {
+			answer = answer[1:]
+		} else if qname == "app.fwd.local." {
+			t.Errorf("Expected the CNAME first, got %v", w.msg.Answer)
+		}
+		switch rr := answer[0].(type) {
+		case *dns.A:
+			return rr.A.String()
+		case *dns.AAAA:
+			return rr.AAAA.String()
+		}
+		t.Fatalf("Unexpected record %v", answer[0])
+		return ""
+	}
+
+	// The rotation is the handler's query counter, injected here
+	handler.rotations.Store(0)
+	var got []string
+	for i := 0; i < 4; i++ {
+		got = append(got, first("app.fwd.local.", dns.TypeA))
+	}
+	if want := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.1"}; !slices.Equal(got, want) {
+		t.Errorf("Expected the first forwarded record to cycle %v, got %v", want, got)
+	}
+
+	handler.rotations.Store(1)
+	got = nil
+	for i := 0; i < 3; i++ {
+		got = append(got, first("app.via6.local.", dns.TypeAAAA))
+	}
+	want := []string{"fd7a:115c:a1e0:b1a:0:7:a00:2", "fd7a:115c:a1e0:b1a:0:7:a00:3", "fd7a:115c:a1e0:b1a:0:7:a00:1"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected the first 4via6 record to cycle %v, got %v", want, got)
+	}
+
+	// Zones without rotateAnswers keep the backend's order
+	for i := 0; i < 2; i++ {
+		if got := first("app.plain.local.", dns.TypeA); got != "10.0.0.1" {
+			t.Errorf("Expected unrotated answers, got %s first", got)
+		}
+	}
+}
+
+func TestDNSHandler_RequireTCP(t *testing.T) {
+	backendCfg := config.BackendConfig{DNSServers: []string{"8.8.8.8:53"}, Timeout: "5s", Retries: 3}
+	cfg := &config.Config{
+		Global: config.GlobalConfig{Backend: backendCfg},
+		Zones: map[string]*config.Zone{
+			"large": {
+				Domains:         []string{"*.large.local"},
+				ReflectedDomain: "10.0.0.5",
+				TranslateID:     func() *uint16 { v := uint16(7); return &v }(),
+				Backend:         backendCfg,
+				RequireTCP:      true,
+			},
+		},
+	}
+
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+	log := logger.New(runtimeCfg.ToLoggingConfig())
+	via6Trans, err := via6.NewTranslator(cfg, log)
+	if err != nil {
+		t.Fatalf("Failed to create translator: %v", err)
+	}
+
+	handler := &TailscaleDNSHandler{
+		config:     cfg,
+		runtimeCfg: runtimeCfg,
+		via6Trans:  via6Trans,
+		forwarder:  NewForwarder(backendCfg, log),
+		logger:     log,
+		zoneCaches: make(map[string]*cache.ZoneCache),
+	}
+
+	tests := []struct {
+		name          string
+		remoteAddr    net.Addr
+		wantTruncated bool
+	}{
+		{"UDP", &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53}, true},
+		{"TCP", &net.TCPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := new(dns.Msg)
+			req.SetQuestion("app.large.local.", dns.TypeAAAA)
+			w := &testResponseWriter{remoteAddr: tt.remoteAddr}
+			handler.ServeDNS(w, req)
+
+			if w.msg == nil {
+				t.Fatal("Expected a response")
+			}
+			if w.msg.Truncated != tt.wantTruncated {
+				t.Errorf("Expected TC=%v, got %v", tt.wantTruncated, w.msg.Truncated)
+			}
+			if tt.wantTruncated && len(w.msg.Answer) != 0 {
+				t.Errorf("Expected no answers in truncated response, got %v", w.msg.Answer)
+			}
+			if !tt.wantTruncated && len(w.msg.Answer) != 1 {
+				t.Errorf("Expected 1 answer over TCP, got %v", w.msg.Answer)
+			}
+		})
+	}
+}
+
+func TestDNSHandler_AuditExternalAccess(t *testing.T) {
+	backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		_ = w.WriteMsg(msg)
+	})
+
+	backendCfg := config.BackendConfig{DNSServers: []string{backend}, Timeout: "1s", Retries: 1}
+	cfg := &config.Config{
+		Global: config.GlobalConfig{Backend: backendCfg},
+		Zones: map[string]*config.Zone{
+			"public":  {Domains: []string{"*.public.local"}, Backend: backendCfg, AllowExternalClients: true},
+			"private": {Domains: []string{"*.private.local"}, Backend: backendCfg},
+		},
+	}
+
+	var buf bytes.Buffer
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300, AuditRetention: 90 * 24 * time.Hour}
+	log := logger.New(runtimeCfg.ToLoggingConfig())
+	handler := &TailscaleDNSHandler{
+		config:     cfg,
+		runtimeCfg: runtimeCfg,
+		forwarder:  NewForwarder(backendCfg, log),
+		logger:     log,
+		zoneCaches: make(map[string]*cache.ZoneCache),
+		audit:      &logger.Logger{Logger: slog.New(slog.NewJSONHandler(&buf, nil))},
+	}
+
+	query := func(name, client string) {
+		req := new(dns.Msg)
+		req.SetQuestion(name, dns.TypeA)
+		handler.ServeDNS(&testResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP(client), Port: 53}}, req)
+	}
+	query("app.public.local.", "203.0.113.7")
+	query("app.private.local.", "203.0.113.7")
+	query("app.private.local.", "100.64.0.1") // Tailscale clients are not audited
+
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("Audit record is not JSON: %q", line)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 audit records, got %d: %s", len(records), buf.String())
+	}
+
+	want := []map[string]any{
+		{"msg": "external_access", "client": "203.0.113.7", "zone": "public", "name": "app.public.local.", "type": "A", "decision": "allowed", "retention": "2160h0m0s"},
+		{"msg": "external_access", "client": "203.0.113.7", "zone": "private", "name": "app.private.local.", "type": "A", "decision": "blocked", "retention": "2160h0m0s"},
+	}
+	for i, fields := range want {
+		for key, value := range fields {
+			if records[i][key] != value {
+				t.Errorf("Record %d: expected %s=%v, got %v", i, key, value, records[i][key])
+			}
+		}
+	}
+}
+
+func TestDNSHandler_ExternalClientSkipsCache(t *testing.T) {
+	backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		msg.Answer = append(msg.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+			A:   net.ParseIP("10.0.0.1"),
+		})
+		_ = w.WriteMsg(msg)
+	})
+
+	backendCfg := config.BackendConfig{DNSServers: []string{backend}, Timeout: "1s", Retries: 1}
+	cfg := &config.Config{
+		Global: config.GlobalConfig{Backend: backendCfg},
+		Zones: map[string]*config.Zone{
+			"private": {Domains: []string{"*.private.local"}, Backend: backendCfg},
+		},
+	}
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+	log := logger.New(runtimeCfg.ToLoggingConfig())
+	zoneCache := cache.NewZoneCache(100, time.Minute)
+	defer zoneCache.Stop()
+	handler := &TailscaleDNSHandler{
+		config:     cfg,
+		runtimeCfg: runtimeCfg,
+		forwarder:  NewForwarder(backendCfg, log),
+		logger:     log,
+		zoneCaches: map[string]*cache.ZoneCache{"private": zoneCache},
+	}
+	query := func(client string) *dns.Msg {
+		req := new(dns.Msg)
+		req.SetQuestion("app.private.local.", dns.TypeA)
+		w := &testResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP(client), Port: 53}}
+		handler.ServeDNS(w, req)
+		if w.msg == nil {
+			t.Fatalf("Expected a response for %s", client)
+		}
+		return w.msg
+	}
+
+	// A tailnet client caches the answer
+	if resp := query("100.64.0.1"); len(resp.Answer) != 1 {
+		t.Fatalf("Expected tailnet client answered, got %v", resp)
+	}
+	if zoneCache.Size() != 1 {
+		t.Fatalf("Expected the answer cached, cache holds %d entries", zoneCache.Size())
+	}
+
+	// An external client is still blocked, the cached answer notwithstanding
+	if resp := query("203.0.113.7"); resp.Rcode != dns.RcodeNameError || len(resp.Answer) != 0 {
+		t.Errorf("Expected external client blocked with NXDOMAIN, got %v", resp)
+	}
+}
+
+func TestDNSHandler_ZoneClientLists(t *testing.T) {
+	backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		_ = w.WriteMsg(msg)
+	})
+
+	backendCfg := config.BackendConfig{DNSServers: []string{backend}, Timeout: "1s", Retries: 1}
+	cfg := &config.Config{
+		Global: config.GlobalConfig{Backend: backendCfg},
+		Zones: map[string]*config.Zone{
+			"lab": {
+				Domains:              []string{"*.lab.local"},
+				Backend:              backendCfg,
+				AllowExternalClients: true,
+				AllowedClients:       []string{"192.168.10.0/24", "100.64.0.0/10"},
+				DeniedClients:        []string{"192.168.10.66", "100.64.0.9/32"},
+			},
+		},
+	}
+	if err := cfg.ValidateZones(); err != nil {
+		t.Fatalf("ValidateZones failed: %v", err)
+	}
+
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+	log := logger.New(runtimeCfg.ToLoggingConfig())
+	handler := &TailscaleDNSHandler{
+		config:     cfg,
+		runtimeCfg: runtimeCfg,
+		forwarder:  NewForwarder(backendCfg, log),
+		logger:     log,
+		zoneCaches: make(map[string]*cache.ZoneCache),
+	}
+
+	tests := []struct {
+		name      string
+		client    string
+		wantRcode int
+	}{
+		{"allowed subnet", "192.168.10.5", dns.RcodeSuccess},
+		{"allowed tailnet client", "100.64.0.1", dns.RcodeSuccess},
+		{"outside allowed clients", "203.0.113.7", dns.RcodeRefused},
+		{"denied host in allowed subnet", "192.168.10.66", dns.RcodeRefused},
+		{"denied tailnet client", "100.64.0.9", dns.RcodeRefused},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := new(dns.Msg)
+			req.SetQuestion("app.lab.local.", dns.TypeA)
+			w := &testResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP(tt.client), Port: 53}}
+			handler.ServeDNS(w, req)
+
+			if w.msg == nil {
+				t.Fatal("Expected a response")
+			}
+			if w.msg.Rcode != tt.wantRcode {
+				t.Errorf("Expected rcode %s, got %s", dns.RcodeToString[tt.wantRcode], dns.RcodeToString[w.msg.Rcode])
+			}
+		})
+	}
+}
+
+func TestDNSHandler_Stats(t *testing.T) {
+	backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		msg.Answer = append(msg.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.IPv4(10, 0, 0, 1),
+		})
+		_ = w.WriteMsg(msg)
+	})
+
+	backendCfg := config.BackendConfig{DNSServers: []string{backend}, Timeout: "1s", Retries: 1}
+	deadCfg := config.BackendConfig{DNSServers: []string{"127.0.0.1:1"}, Timeout: "200ms", Retries: 1}
+	cfg := &config.Config{
+		Global: config.GlobalConfig{Backend: backendCfg, SpecialUseDomains: config.DefaultSpecialUseDomains()},
+		Zones: map[string]*config.Zone{
+			"test": {Domains: []string{"*.test.local"}, Backend: backendCfg},
+			"dead": {Domains: []string{"*.dead.local"}, Backend: deadCfg},
+			"lab":  {Domains: []string{"*.lab.local"}, Backend: backendCfg, DeniedClients: []string{"100.64.0.9/32"}},
+		},
+	}
+	if err := cfg.ValidateZones(); err != nil {
+		t.Fatalf("ValidateZones failed: %v", err)
+	}
+
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300, DNSHealthProbeName: "probe.tsdns.local"}
+	log := logger.New(runtimeCfg.ToLoggingConfig())
+	handler := &TailscaleDNSHandler{
+		config:     cfg,
+		runtimeCfg: runtimeCfg,
+		forwarder:  NewForwarder(backendCfg, log),
+		logger:     log,
+		zoneCaches: map[string]*cache.ZoneCache{"test": cache.NewZoneCache(100, time.Minute)},
+	}
+
+	query := func(name, client string) {
+		req := new(dns.Msg)
+		req.SetQuestion(name, dns.TypeA)
+		w := &testResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP(client), Port: 53}}
+		handler.ServeDNS(w, req)
+	}
+
+	// Concurrent queries for the same name: one or more misses, the rest hits
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			query("app.test.local.", "100.64.0.1")
+		}()
+	}
+	wg.Wait()
+	query("foo.invalid.", "100.64.0.1")
+	query("other.test.local.", "203.0.113.1")
+	query("app.dead.local.", "100.64.0.1")
+	query("app.lab.local.", "100.64.0.9")
+	query("probe.tsdns.local.", "100.64.0.1")
+
+	stats := handler.Stats()
+	if stats.Queries != 25 {
+		t.Errorf("Expected 25 queries, got %d", stats.Queries)
+	}
+	// The blocked external client never reaches the cache
+	if stats.CacheHits+stats.CacheMisses != 20 || stats.CacheMisses < 1 {
+		t.Errorf("Expected 20 cache lookups including a miss, got %d hits and %d misses", stats.CacheHits, stats.CacheMisses)
+	}
+	if stats.Paths["cache"] != stats.CacheHits || stats.Paths["forward"] != stats.CacheMisses+1 {
+		t.Errorf("Expected cache hits and forwards, plus the uncached zone's, to match the lookups, got paths %v", stats.Paths)
+	}
+	if stats.Paths["special-use"] != 1 || stats.Paths["blocked"] != 1 || stats.Paths["denied"] != 1 {
+		t.Errorf("Expected one special-use, one blocked and one denied query, got paths %v", stats.Paths)
+	}
+	if stats.Paths["health-probe"] != 1 {
+		t.Errorf("Expected one health probe, got paths %v", stats.Paths)
+	}
+	if stats.Errors != 1 {
+		t.Errorf("Expected the failed forward to count as an error, got %d", stats.Errors)
+	}
+}
+
+func TestDNSHandler_SlowQueryLog(t *testing.T) {
+	backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		time.Sleep(20 * time.Millisecond)
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		msg.Answer = append(msg.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+			A:   net.ParseIP("10.1.2.3"),
+		})
+		_ = w.WriteMsg(msg)
+	})
+
+	tests := []struct {
+		name      string
+		threshold time.Duration
+		wantLog   bool
+	}{
+		{"disabled", 0, false},
+		{"under threshold", time.Hour, false},
+		{"over threshold", 5 * time.Millisecond, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backendCfg := config.BackendConfig{DNSServers: []string{backend}, Timeout: "1s", Retries: 1}
+			cfg := &config.Config{
+				Global: config.GlobalConfig{Backend: backendCfg},
+				Zones: map[string]*config.Zone{
+					"slow": {Domains: []string{"*.slow.local"}, Backend: backendCfg},
+				},
+			}
+
+			var buf bytes.Buffer
+			log := &logger.Logger{Logger: slog.New(slog.NewJSONHandler(&buf, nil))}
+			runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300, SlowQueryThreshold: tt.threshold}
+
+			handler := &TailscaleDNSHandler{
+				config:     cfg,
+				runtimeCfg: runtimeCfg,
+				forwarder:  NewForwarder(backendCfg, log),
+				logger:     log,
+				zoneCaches: make(map[string]*cache.ZoneCache),
+			}
+
+			req := new(dns.Msg)
+			req.SetQuestion("app.slow.local.", dns.TypeA)
+			w := &testResponseWriter{
+				remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53},
+			}
+			handler.ServeDNS(w, req)
+			if w.msg == nil || len(w.msg.Answer) != 1 {
+				t.Fatalf("Expected 1 answer, got %v", w.msg)
+			}
+
+			logged := strings.Contains(buf.String(), "Slow DNS query")
+			if logged != tt.wantLog {
+				t.Fatalf("Expected slow query log=%v, got log %q", tt.wantLog, buf.String())
+			}
+			if logged {
+				for _, want := range []string{`"path":"forward"`, `"rcode":"NOERROR"`, `"name":"app.slow.local."`, `"resolve":`} {
+					if !strings.Contains(buf.String(), want) {
+						t.Errorf("Expected %s in slow query log %q", want, buf.String())
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestTuneUDPConn(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer func() { _ = pc.Close() }()
+
+	recvBufSize, sendBufSize, ok, err := tuneUDPConn(pc, 256*1024, 128*1024)
+	if err != nil {
+		t.Fatalf("Failed to tune UDP conn: %v", err)
+	}
+	if !ok {
+		t.Fatal("Expected *net.UDPConn to expose socket buffers")
+	}
+	if recvBufSize < 0 || sendBufSize < 0 {
+		t.Errorf("Unexpected effective buffer sizes: recv=%d send=%d", recvBufSize, sendBufSize)
+	}
+
+	// Conns without socket buffers are left untouched
+	if _, _, ok, err := tuneUDPConn(nonBufferedConn{pc}, 256*1024, 0); ok || err != nil {
+		t.Errorf("Expected ok=false and no error, got ok=%v err=%v", ok, err)
+	}
+}
+
+func TestListenTCPBacklog(t *testing.T) {
+	ln, err := listenTCP("127.0.0.1:0", 16, false)
+	if err != nil {
+		t.Fatalf("Failed to listen with backlog: %v", err)
+	}
+	defer func() { _ = ln.Close() }()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	accepted, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Failed to accept: %v", err)
+	}
+	_ = accepted.Close()
+}
+
+func TestListenReusePort(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("SO_REUSEPORT is not supported on Windows")
+	}
+
+	first, err := listenUDP("127.0.0.1:0", true)
+	if err != nil {
+		t.Fatalf("Failed to listen with SO_REUSEPORT: %v", err)
+	}
+	defer func() { _ = first.Close() }()
+
+	// A second instance can bind the same port while the first is still open
+	second, err := listenUDP(first.LocalAddr().String(), true)
+	if err != nil {
+		t.Fatalf("Failed to bind second UDP socket to the same port: %v", err)
+	}
+	_ = second.Close()
+
+	ln, err := listenTCP("127.0.0.1:0", 0, true)
+	if err != nil {
+		t.Fatalf("Failed to listen on TCP with SO_REUSEPORT: %v", err)
+	}
+	defer func() { _ = ln.Close() }()
+
+	ln2, err := listenTCP(ln.Addr().String(), 0, true)
+	if err != nil {
+		t.Fatalf("Failed to bind second TCP listener to the same port: %v", err)
+	}
+	_ = ln2.Close()
+
+	// Without SO_REUSEPORT the port stays exclusive
+	if pc, err := listenUDP(first.LocalAddr().String(), false); err == nil {
+		_ = pc.Close()
+		t.Error("Expected binding without SO_REUSEPORT to fail")
+	}
+}
+
+// nonBufferedConn hides the socket buffer setters of the wrapped conn, like
+// netstack conns returned by TSNet
+type nonBufferedConn struct {
+	net.PacketConn
+}
+
+func TestSelectRegionBackend(t *testing.T) {
+	regionBackends := map[string]config.BackendConfig{
+		"AS13335": {DNSServers: []string{"10.0.0.1:53"}},
+		"DE":      {DNSServers: []string{"10.0.0.2:53"}},
+		"EU":      {DNSServers: []string{"10.0.0.3:53"}},
+	}
+
+	tests := []struct {
+		name       string
+		regions    []string
+		wantRegion string
+		wantOK     bool
+	}{
+		{"asn wins", []string{"AS13335", "DE", "EU"}, "AS13335", true},
+		{"country before continent", []string{"AS64500", "DE", "EU"}, "DE", true},
+		{"continent fallback", []string{"FR", "EU"}, "EU", true},
+		{"no match", []string{"US", "NA"}, "", false},
+		{"no location", nil, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			region, backend, ok := selectRegionBackend(regionBackends, tt.regions)
+			if ok != tt.wantOK || region != tt.wantRegion {
+				t.Fatalf("Expected region %q (ok=%v), got %q (ok=%v)", tt.wantRegion, tt.wantOK, region, ok)
+			}
+			if ok && backend.DNSServers[0] != regionBackends[region].DNSServers[0] {
+				t.Errorf("Expected backend for %s, got %v", region, backend.DNSServers)
+			}
+		})
+	}
+}
+
+func TestDNSHandler_ZoneBackendWithoutGeoIP(t *testing.T) {
+	zone := &config.Zone{
+		Domains: []string{"*.geo.local"},
+		Backend: config.BackendConfig{DNSServers: []string{"10.0.0.10:53"}},
+		RegionBackends: map[string]config.BackendConfig{
+			"EU": {DNSServers: []string{"10.0.0.20:53"}},
+		},
+	}
+
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+	handler := &TailscaleDNSHandler{
+		runtimeCfg: runtimeCfg,
+		logger:     logger.New(runtimeCfg.ToLoggingConfig()),
+	}
+
+	// Without a GeoIP database every client gets the default backend
+	backend := handler.zoneBackend(context.Background(), zone, "geo", netip.MustParseAddr("203.0.113.1"), false)
+	if backend.DNSServers[0] != "10.0.0.10:53" {
+		t.Errorf("Expected default backend, got %v", backend.DNSServers)
+	}
+}
+
+func TestDNSHandler_ZoneBackendByTag(t *testing.T) {
+	zone := &config.Zone{
+		Domains: []string{"*.tagged.local"},
+		Backend: config.BackendConfig{DNSServers: []string{"10.0.0.10:53"}},
+		BackendByTag: map[string]config.BackendConfig{
+			"tag:prod":    {DNSServers: []string{"10.0.0.20:53"}},
+			"tag:staging": {DNSServers: []string{"10.0.0.30:53"}},
+		},
+	}
+
+	lookups := 0
+	nodeTags := map[netip.Addr][]string{
+		netip.MustParseAddr("100.64.0.1"): {"tag:staging", "tag:prod"},
+		netip.MustParseAddr("100.64.0.2"): {"tag:staging"},
+		netip.MustParseAddr("100.64.0.3"): {"tag:other"},
+	}
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+	handler := &TailscaleDNSHandler{
+		runtimeCfg: runtimeCfg,
+		clientTags: newClientTags(func(ctx context.Context, ip netip.Addr) ([]string, error) {
+			lookups++
+			tags, ok := nodeTags[ip]
+			if !ok {
+				return nil, fmt.Errorf("no peer with IP %s", ip)
+			}
+			return tags, nil
+		}, time.Minute),
+		logger: logger.New(runtimeCfg.ToLoggingConfig()),
+	}
+
+	tests := []struct {
+		name      string
+		client    string
+		tailscale bool
+		want      string
+	}{
+		{"several tags pick the first sorted", "100.64.0.1", true, "10.0.0.20:53"},
+		{"single tag", "100.64.0.2", true, "10.0.0.30:53"},
+		{"unmatched tag", "100.64.0.3", true, "10.0.0.10:53"},
+		{"lookup failure", "100.64.0.4", true, "10.0.0.10:53"},
+		{"external client", "203.0.113.1", false, "10.0.0.10:53"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := handler.zoneBackend(context.Background(), zone, "tagged", netip.MustParseAddr(tt.client), tt.tailscale)
+			if backend.DNSServers[0] != tt.want {
+				t.Errorf("Expected backend %s, got %v", tt.want, backend.DNSServers)
+			}
+		})
+	}
+
+	// Successful lookups are cached, failed ones retried
+	before := lookups
+	handler.zoneBackend(context.Background(), zone, "tagged", netip.MustParseAddr("100.64.0.1"), true)
+	handler.zoneBackend(context.Background(), zone, "tagged", netip.MustParseAddr("100.64.0.4"), true)
+	if lookups != before+1 {
+		t.Errorf("Expected only the failed lookup to be repeated, got %d lookups", lookups-before)
+	}
+}
+
+func TestDNSHandler_TagBackendsCachedApart(t *testing.T) {
+	backendAnswering := func(ip string) string {
+		return startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+			msg := new(dns.Msg)
+			msg.SetReply(r)
+			msg.Answer = append(msg.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+				A:   net.ParseIP(ip),
+			})
+			_ = w.WriteMsg(msg)
+		})
+	}
+	backendCfg := func(ip string) config.BackendConfig {
+		return config.BackendConfig{DNSServers: []string{backendAnswering(ip)}, Timeout: "1s", Retries: 1}
+	}
+
+	defaultCfg := backendCfg("10.0.0.10")
+	cfg := &config.Config{
+		Global: config.GlobalConfig{Backend: defaultCfg},
+		Zones: map[string]*config.Zone{
+			"tagged": {
+				Domains: []string{"*.tagged.local"},
+				Backend: defaultCfg,
+				BackendByTag: map[string]config.BackendConfig{
+					"tag:prod": backendCfg("10.0.0.20"),
+					"tag:dev":  backendCfg("10.0.0.30"),
+				},
+			},
+		},
+	}
+	nodeTags := map[netip.Addr][]string{
+		netip.MustParseAddr("100.64.0.1"): {"tag:prod"},
+		netip.MustParseAddr("100.64.0.2"): {"tag:dev"},
+		netip.MustParseAddr("100.64.0.3"): nil,
+	}
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+	log := logger.New(runtimeCfg.ToLoggingConfig())
+	zoneCache := cache.NewZoneCache(100, time.Minute)
+	defer zoneCache.Stop()
+	handler := &TailscaleDNSHandler{
+		config:     cfg,
+		runtimeCfg: runtimeCfg,
+		forwarder:  NewForwarder(defaultCfg, log),
+		logger:     log,
+		zoneCaches: map[string]*cache.ZoneCache{"tagged": zoneCache},
+		clientTags: newClientTags(func(ctx context.Context, ip netip.Addr) ([]string, error) {
+			return nodeTags[ip], nil
+		}, time.Minute),
+	}
+
+	// Each client is answered by its own backend, the cache notwithstanding
+	for _, round := range []string{"uncached", "cached"} {
+		for client, want := range map[string]string{"100.64.0.1": "10.0.0.20", "100.64.0.2": "10.0.0.30", "100.64.0.3": "10.0.0.10"} {
+			req := new(dns.Msg)
+			req.SetQuestion("app.tagged.local.", dns.TypeA)
+			w := &testResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP(client), Port: 53}}
+			handler.ServeDNS(w, req)
+			if w.msg == nil || len(w.msg.Answer) != 1 {
+				t.Fatalf("%s %s: expected 1 answer, got %v", round, client, w.msg)
+			}
+			if got := w.msg.Answer[0].(*dns.A).A.String(); got != want {
+				t.Errorf("%s %s: expected %s, got %s", round, client, want, got)
+			}
+		}
+	}
+	if zoneCache.Size() != 3 {
+		t.Errorf("Expected one cache entry per backend, got %d", zoneCache.Size())
+	}
+}
+
+func TestDNSHandler_Compress(t *testing.T) {
+	disabled := false
+	backendCfg := config.BackendConfig{DNSServers: []string{"8.8.8.8:53"}, Timeout: "5s", Retries: 3}
+	cfg := &config.Config{
+		Global: config.GlobalConfig{Backend: backendCfg},
+		Zones: map[string]*config.Zone{
+			"legacy": {
+				Domains:         []string{"*.legacy.local"},
+				ReflectedDomain: "127.0.0.1",
+				TranslateID:     func() *uint16 { v := uint16(7); return &v }(),
+				Backend:         backendCfg,
+				Compress:        &disabled,
+			},
+			"modern": {
+				Domains:         []string{"*.modern.local"},
+				ReflectedDomain: "127.0.0.1",
+				TranslateID:     func() *uint16 { v := uint16(8); return &v }(),
+				Backend:         backendCfg,
+			},
+		},
+	}
+
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+	log := logger.New(runtimeCfg.ToLoggingConfig())
+
+	via6Trans, err := via6.NewTranslator(cfg, log)
+	if err != nil {
+		t.Fatalf("Failed to create translator: %v", err)
+	}
+
+	handler := &TailscaleDNSHandler{
+		config:     cfg,
+		runtimeCfg: runtimeCfg,
+		via6Trans:  via6Trans,
+		forwarder:  NewForwarder(backendCfg, log),
+		logger:     log,
+		zoneCaches: make(map[string]*cache.ZoneCache),
+	}
+
+	tests := []struct {
+		domain       string
+		wantCompress bool
+	}{
+		{"app.legacy.local.", false},
+		{"app.modern.local.", true},
+		{"invalid.", true}, // No zone: global default
+	}
+
+	for _, tt := range tests {
+		req := new(dns.Msg)
+		req.SetQuestion(tt.domain, dns.TypeAAAA)
+		w := &testResponseWriter{
+			remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53},
+		}
+		handler.ServeDNS(w, req)
+
+		if w.msg == nil {
+			t.Fatalf("%s: expected a response", tt.domain)
+		}
+		if w.msg.Compress != tt.wantCompress {
+			t.Errorf("%s: expected Compress=%v, got %v", tt.domain, tt.wantCompress, w.msg.Compress)
+		}
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	cb := newCircuitBreaker(&config.CircuitBreaker{FailureThreshold: 2, Cooldown: "50ms"})
+
+	defer cb.Stop()
+	backend := "10.0.0.1:53"
+
+	if cb.RecordFailure("corp", backend) || !cb.Allow(backend) {
+		t.Fatal("Expected circuit closed below threshold")
+	}
+	if !cb.RecordFailure("corp", backend) || cb.Allow(backend) {
+		t.Fatal("Expected circuit open at threshold")
+	}
+	if cb.RecordFailure("corp", backend) {
+		t.Error("Expected an open circuit not to be reported opened again")
+	}
+	if got := testutil.ToFloat64(metrics.BackendHealthy.WithLabelValues("corp", backend)); got != 0 {
+		t.Errorf("Expected backend unhealthy while its circuit is open, got %v", got)
+	}
+
+	// Client queries never reach an open circuit; failed probes keep it open
+	var probes atomic.Int32
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cb.probe(backend, func() bool { return probes.Add(1) == 3 })
+	}()
+	time.Sleep(75 * time.Millisecond)
+	if cb.Allow(backend) {
+		t.Fatal("Expected circuit to stay open after the cooldown")
+	}
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected probing to end once the backend passed")
+	}
+	if got := probes.Load(); got != 3 {
+		t.Errorf("Expected probing until the third probe passed, got %d probes", got)
+	}
+	if !cb.Allow(backend) {
+		t.Error("Expected circuit closed after successful probe")
+	}
+	if got := testutil.ToFloat64(metrics.BackendHealthy.WithLabelValues("corp", backend)); got != 1 {
+		t.Errorf("Expected backend healthy once its circuit closed, got %v", got)
+	}
+
+	// Probing stops with the breaker
+	cb.RecordFailure("corp", backend)
+	cb.RecordFailure("corp", backend)
+	cb.Stop()
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		cb.probe(backend, func() bool { return false })
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Error("Expected probing to end when the breaker stops")
+	}
+
+	// A nil breaker never trips
+	var disabled *circuitBreaker
+	disabled.RecordFailure("corp", backend)
+	if !disabled.Allow(backend) {
+		t.Error("Expected nil breaker to allow every backend")
+	}
+}
+
+func TestForwarder_TLSBackend(t *testing.T) {
+	var queries atomic.Int32
+	backend, roots := startTestTLSBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		queries.Add(1)
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		msg.Answer = append(msg.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.IPv4(10, 0, 0, 1),
+		})
+		_ = w.WriteMsg(msg)
+	})
+
+	backendCfg := config.BackendConfig{DNSServers: []string{backend}, Timeout: "1s", Retries: 1}
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+	log := logger.New(runtimeCfg.ToLoggingConfig())
+
+	forward := func(forwarder *Forwarder) *dns.Msg {
+		req := new(dns.Msg)
+		req.SetQuestion("app.tls.local.", dns.TypeA)
+		w := &testResponseWriter{}
+		forwarder.ForwardWithZoneAndCache(w, req, "tls", nil, "")
+		if w.msg == nil {
+			t.Fatal("Expected a response")
+		}
+		return w.msg
+	}
+
+	// The certificate isn't trusted by the system, so the handshake fails
+	if resp := forward(NewForwarder(backendCfg, log)); resp.Rcode != dns.RcodeServerFailure {
+		t.Errorf("Expected SERVFAIL for untrusted certificate, got %s", dns.RcodeToString[resp.Rcode])
+	}
+	if got := queries.Load(); got != 0 {
+		t.Errorf("Expected no query over an unverified connection, got %d", got)
+	}
+
+	forwarder := NewForwarder(backendCfg, log)
+	forwarder.tlsRoots = roots
+	resp := forward(forwarder)
+	if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 1 {
+		t.Fatalf("Expected answer over TLS, got %v", resp)
+	}
+	if got := queries.Load(); got != 1 {
+		t.Errorf("Expected one query over TLS, got %d", got)
+	}
+
+	// A wrong server name fails verification like an untrusted certificate
+	backendCfg.TLSServerName = "dns.example.com"
+	forwarder = NewForwarder(backendCfg, log)
+	forwarder.tlsRoots = roots
+	if resp := forward(forwarder); resp.Rcode != dns.RcodeServerFailure {
+		t.Errorf("Expected SERVFAIL for mismatched server name, got %s", dns.RcodeToString[resp.Rcode])
+	}
+}
+
+func TestForwarder_DoHBackend(t *testing.T) {
+	var queries atomic.Int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		query := new(dns.Msg)
+		if r.Method != http.MethodPost || query.Unpack(body) != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		msg := new(dns.Msg)
+		msg.SetReply(query)
+		msg.Answer = append(msg.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: query.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.IPv4(10, 0, 0, 1),
+		})
+		packed, _ := msg.Pack()
+		w.Header().Set("Content-Type", "application/dns-message")
+		_, _ = w.Write(packed)
+	}))
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	backend := server.URL + "/dns-query"
+
+	backendCfg := config.BackendConfig{DNSServers: []string{backend}, Timeout: "1s", Retries: 1}
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+	log := logger.New(runtimeCfg.ToLoggingConfig())
+	zoneCache := cache.NewZoneCache(100, 5*time.Minute)
+	doh := newDoHBackends()
+
+	forward := func(name string) *dns.Msg {
+		forwarder := NewForwarder(backendCfg, log)
+		forwarder.tlsRoots = roots
+		forwarder.doh = doh
+		req := new(dns.Msg)
+		req.SetQuestion(name, dns.TypeA)
+		w := &testResponseWriter{}
+		forwarder.ForwardWithZoneAndCache(w, req, "doh", zoneCache, name)
+		if w.msg == nil {
+			t.Fatal("Expected a response")
+		}
+		return w.msg
+	}
+
+	resp := forward("app.doh.local.")
+	if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 1 {
+		t.Fatalf("Expected answer over HTTPS, got %v", resp)
+	}
+	if a, ok := resp.Answer[0].(*dns.A); !ok || a.A.String() != "10.0.0.1" {
+		t.Errorf("Expected 10.0.0.1, got %v", resp.Answer[0])
+	}
+
+	// Answers are cached like any other backend's
+	if _, ok := zoneCache.Get("app.doh.local."); !ok {
+		t.Error("Expected DoH answer to be cached")
+	}
+
+	// Forwarders created per query share one client per backend
+	forward("db.doh.local.")
+	if got := queries.Load(); got != 2 {
+		t.Errorf("Expected 2 backend queries, got %d", got)
+	}
+	if len(doh.backends) != 1 {
+		t.Errorf("Expected one shared DoH backend, got %d", len(doh.backends))
+	}
+
+	// The test certificate isn't trusted by the system
+	forwarder := NewForwarder(backendCfg, log)
+	req := new(dns.Msg)
+	req.SetQuestion("web.doh.local.", dns.TypeA)
+	w := &testResponseWriter{}
+	forwarder.ForwardWithZoneAndCache(w, req, "doh", nil, "")
+	if w.msg == nil || w.msg.Rcode != dns.RcodeServerFailure {
+		t.Errorf("Expected SERVFAIL for untrusted certificate, got %v", w.msg)
+	}
+}
+
+func TestForwarder_PublicTLSBackend(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping network test in short mode")
+	}
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 2 * time.Second}, "tcp", "1.1.1.1:853", &tls.Config{ServerName: "1.1.1.1"})
+	if err != nil {
+		t.Skipf("DNS over TLS resolver unreachable: %v", err)
+	}
+	_ = conn.Close()
+
+	backendCfg := config.BackendConfig{DNSServers: []string{"tls://1.1.1.1"}, Timeout: "5s", Retries: 1}
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+	forwarder := NewForwarder(backendCfg, logger.New(runtimeCfg.ToLoggingConfig()))
+
+	req := new(dns.Msg)
+	req.SetQuestion("one.one.one.one.", dns.TypeA)
+	w := &testResponseWriter{}
+	forwarder.ForwardWithZoneAndCache(w, req, "dot", nil, "")
+
+	if w.msg == nil || w.msg.Rcode != dns.RcodeSuccess || len(w.msg.Answer) == 0 {
+		t.Fatalf("Expected answer from 1.1.1.1 over TLS, got %v", w.msg)
+	}
+}
+
+func TestForwarder_ParallelBackends(t *testing.T) {
+	// A backend that never answers, listed first so sequential querying waits out its timeout
+	dead := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {})
+	live := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		msg.Answer = append(msg.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.IPv4(10, 0, 0, 1),
+		})
+		_ = w.WriteMsg(msg)
+	})
+
+	backendCfg := config.BackendConfig{DNSServers: []string{dead, live}, Timeout: "1s", Retries: 1}
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+	forwarder := NewForwarder(backendCfg, logger.New(runtimeCfg.ToLoggingConfig()))
+	forwarder.parallel = true
+
+	req := new(dns.Msg)
+	req.SetQuestion("app.parallel.local.", dns.TypeA)
+	w := &testResponseWriter{}
+
+	start := time.Now()
+	forwarder.ForwardWithZoneAndCache(w, req, "parallel", nil, "")
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected the live backend to answer well within the timeout, took %v", elapsed)
+	}
+
+	if w.msg == nil || w.msg.Rcode != dns.RcodeSuccess || len(w.msg.Answer) != 1 {
+		t.Fatalf("Expected answer from the live backend, got %v", w.msg)
+	}
+	if w.msg.Id != req.Id {
+		t.Errorf("Expected response ID %d, got %d", req.Id, w.msg.Id)
+	}
+}
+
+func TestForwarder_ParallelAllServFail(t *testing.T) {
+	var queries atomic.Int32
+	servFail := func(w dns.ResponseWriter, r *dns.Msg) {
+		queries.Add(1)
+		msg := new(dns.Msg)
+		msg.SetRcode(r, dns.RcodeServerFailure)
+		_ = w.WriteMsg(msg)
+	}
+	backends := []string{startTestBackend(t, servFail), startTestBackend(t, servFail)}
+
+	backendCfg := config.BackendConfig{DNSServers: backends, Timeout: "1s", Retries: 1}
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+	forwarder := NewForwarder(backendCfg, logger.New(runtimeCfg.ToLoggingConfig()))
+	forwarder.parallel = true
+
+	zoneCache := cache.NewZoneCache(100, time.Minute)
+	defer zoneCache.Stop()
+
+	// A SERVFAIL from every backend is passed on but not cached, so the next
+	// query tries the backends again
+	for i := 1; i <= 2; i++ {
+		req := new(dns.Msg)
+		req.SetQuestion("app.parallel.local.", dns.TypeA)
+		w := &testResponseWriter{}
+		forwarder.ForwardWithZoneAndCache(w, req, "parallel", zoneCache, "")
+
+		if w.msg == nil || w.msg.Rcode != dns.RcodeServerFailure {
+			t.Fatalf("Expected SERVFAIL, got %v", w.msg)
+		}
+		if got := queries.Load(); got != int32(2*i) {
+			t.Errorf("Expected query %d to reach both backends, got %d backend queries", i, got)
+		}
+	}
+	if zoneCache.Size() != 0 {
+		t.Errorf("Expected SERVFAIL not to be cached, got %d entries", zoneCache.Size())
+	}
+}
+
+func TestForwarder_EjectsFailingBackend(t *testing.T) {
+	var down atomic.Bool
+	var queries atomic.Int32
+	backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		queries.Add(1)
+		if down.Load() {
+			return // Never answer, so the query times out
+		}
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		_ = w.WriteMsg(msg)
+	})
+
+	backendCfg := config.BackendConfig{DNSServers: []string{backend}, Timeout: "100ms", Retries: 1}
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+	forwarder := NewForwarder(backendCfg, logger.New(runtimeCfg.ToLoggingConfig()))
+	forwarder.breaker = newCircuitBreaker(&config.CircuitBreaker{FailureThreshold: 2, Cooldown: "200ms"})
+	defer forwarder.breaker.Stop()
+
+	forward := func() *dns.Msg {
+		req := new(dns.Msg)
+		req.SetQuestion("app.eject.local.", dns.TypeA)
+		w := &testResponseWriter{}
+		forwarder.ForwardWithZoneAndCache(w, req, "eject", nil, "")
+		if w.msg == nil {
+			t.Fatal("Expected a response")
+		}
+		return w.msg
+	}
+
+	// Failures up to the threshold still reach the backend
+	down.Store(true)
+	for i := 0; i < 2; i++ {
+		if resp := forward(); resp.Rcode != dns.RcodeServerFailure {
+			t.Fatalf("Expected SERVFAIL from failing backend, got %s", dns.RcodeToString[resp.Rcode])
+		}
+	}
+	if got := queries.Load(); got != 2 {
+		t.Fatalf("Expected 2 backend queries before ejection, got %d", got)
+	}
+
+	// Ejected: answered at once without a query
+	skipped := func() {
+		t.Helper()
+		before := queries.Load()
+		start := time.Now()
+		if resp := forward(); resp.Rcode != dns.RcodeServerFailure {
+			t.Errorf("Expected SERVFAIL while ejected, got %s", dns.RcodeToString[resp.Rcode])
+		}
+		if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+			t.Errorf("Expected ejected backend to be skipped, took %v", elapsed)
+		}
+		if got := queries.Load(); got != before {
+			t.Errorf("Expected no client query to the ejected backend, got %d", got-before)
+		}
+	}
+	skipped()
+	if got := testutil.ToFloat64(metrics.BackendHealthy.WithLabelValues("eject", backend)); got != 0 {
+		t.Errorf("Expected ejected backend reported unhealthy, got %v", got)
+	}
+
+	// Past the cooldown a still-failing backend is probed in the background,
+	// so clients keep being answered at once
+	time.Sleep(250 * time.Millisecond)
+	skipped()
+	if got := queries.Load(); got < 3 {
+		t.Errorf("Expected a background probe after the cooldown, got %d queries", got)
+	}
+
+	// A passing probe readmits the recovered backend
+	down.Store(false)
+	deadline := time.Now().Add(2 * time.Second)
+	for !forwarder.breaker.Allow(backend) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	before := queries.Load()
+	for i := 0; i < 2; i++ {
+		if resp := forward(); resp.Rcode != dns.RcodeSuccess {
+			t.Fatalf("Expected answer after recovery, got %s", dns.RcodeToString[resp.Rcode])
+		}
+	}
+	if got := queries.Load() - before; got != 2 {
+		t.Errorf("Expected recovered backend to take every query, got %d queries", got)
+	}
+	if got := testutil.ToFloat64(metrics.BackendHealthy.WithLabelValues("eject", backend)); got != 1 {
+		t.Errorf("Expected recovered backend reported healthy, got %v", got)
+	}
+}
+
+func TestForwarder_StaleOnCircuitOpen(t *testing.T) {
+	for _, serveStale := range []bool{true, false} {
+		t.Run(fmt.Sprintf("onCircuitOpen=%v", serveStale), func(t *testing.T) {
+			backendCfg := config.BackendConfig{DNSServers: []string{"192.0.2.1:53"}, Timeout: "1s", Retries: 1}
+			runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+			log := logger.New(runtimeCfg.ToLoggingConfig())
+
+			zoneCache := cache.NewZoneCache(100, time.Minute)
+			defer zoneCache.Stop()
+			zoneCache.SetStaleRetention(time.Hour)
+
+			// Cache an answer that has already expired
+			cached := new(dns.Msg)
+			cached.SetQuestion("app.stale.local.", dns.TypeA)
+			cached.Response = true
+			cached.Answer = append(cached.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: "app.stale.local.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 0},
+				A:   net.ParseIP("10.1.2.3"),
+			})
+			key := cache.CacheKey("app.stale.local.", dns.TypeA, nil)
+			zoneCache.Set(key, cached)
+			time.Sleep(10 * time.Millisecond)
+
+			forwarder := NewForwarder(backendCfg, log)
+			forwarder.breaker = newCircuitBreaker(&config.CircuitBreaker{FailureThreshold: 1, Cooldown: "1m"})
+			forwarder.breaker.RecordFailure("stale", "192.0.2.1:53")
+			forwarder.staleOnCircuitOpen = serveStale
+
+			req := new(dns.Msg)
+			req.SetQuestion("app.stale.local.", dns.TypeA)
+			req.SetEdns0(1232, false)
+			w := &testResponseWriter{}
+
+			start := time.Now()
+			forwarder.ForwardWithZoneAndCache(w, req, "stale", zoneCache, key)
+			if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+				t.Errorf("Expected immediate answer with open circuit, took %v", elapsed)
+			}
+
+			if w.msg == nil {
+				t.Fatal("Expected a response")
+			}
+			if !serveStale {
+				if w.msg.Rcode != dns.RcodeServerFailure {
+					t.Errorf("Expected SERVFAIL, got %s", dns.RcodeToString[w.msg.Rcode])
+				}
+				return
+			}
+
+			if len(w.msg.Answer) != 1 || w.msg.Id != req.Id {
+				t.Fatalf("Expected stale answer for query %d, got %v", req.Id, w.msg)
+			}
+			gotEDE := false
+			if opt := w.msg.IsEdns0(); opt != nil {
+				for _, o := range opt.Option {
+					if ede, ok := o.(*dns.EDNS0_EDE); ok && ede.InfoCode == dns.ExtendedErrorCodeStaleAnswer {
+						gotEDE = true
+					}
+				}
+			}
+			if !gotEDE {
+				t.Error("Expected Stale Answer EDE on stale response")
+			}
+		})
+	}
+}
+
+func TestDNSHandler_CacheOnExpiry(t *testing.T) {
+	var queries atomic.Int32
+	live := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		queries.Add(1)
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		msg.Answer = append(msg.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+			A:   net.ParseIP("10.0.0.2"),
+		})
+		_ = w.WriteMsg(msg)
+	})
+	// A closed port, so queries fail instead of timing out
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	dead := pc.LocalAddr().String()
+	_ = pc.Close()
+
+	tests := []struct {
+		name      string
+		onExpiry  string
+		backend   string
+		wantIP    string // Empty for SERVFAIL
+		wantStale bool
+	}{
+		{"evict fails without backend", config.CacheOnExpiryEvict, dead, "", false},
+		{"refresh-sync waits for backend", config.CacheOnExpiryRefreshSync, live, "10.0.0.2", false},
+		{"refresh-sync answers stale on failure", config.CacheOnExpiryRefreshSync, dead, "10.0.0.1", true},
+		{"serve-stale-refresh-async answers stale", config.CacheOnExpiryServeStaleAsync, live, "10.0.0.1", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backendCfg := config.BackendConfig{DNSServers: []string{tt.backend}, Timeout: "1s", Retries: 1}
+			zone := &config.Zone{
+				Domains: []string{"*.stable.local"},
+				Backend: backendCfg,
+				Cache:   &config.CacheConfig{MaxSize: 100, TTL: "1m", OnExpiry: tt.onExpiry},
+			}
+			cfg := &config.Config{
+				Global: config.GlobalConfig{Backend: backendCfg},
+				Zones:  map[string]*config.Zone{"stable": zone},
+			}
+
+			runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+			log := logger.New(runtimeCfg.ToLoggingConfig())
+			zoneCache := cache.NewZoneCache(100, time.Minute)
+			defer zoneCache.Stop()
+			zoneCache.SetStaleRetention(zone.StaleRetention())
+
+			// Cache an answer that has already expired
+			key := cache.CacheKey("app.stable.local.", dns.TypeA, nil)
+			cached := new(dns.Msg)
+			cached.SetQuestion("app.stable.local.", dns.TypeA)
+			cached.Response = true
+			cached.Answer = append(cached.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: "app.stable.local.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 0},
+				A:   net.ParseIP("10.0.0.1"),
+			})
+			zoneCache.Set(key, cached)
+			time.Sleep(10 * time.Millisecond)
+
+			handler := &TailscaleDNSHandler{
+				config:     cfg,
+				runtimeCfg: runtimeCfg,
+				forwarder:  NewForwarder(backendCfg, log),
+				logger:     log,
+				zoneCaches: map[string]*cache.ZoneCache{"stable": zoneCache},
+			}
+
+			queries.Store(0)
+			req := new(dns.Msg)
+			req.SetQuestion("app.stable.local.", dns.TypeA)
+			req.SetEdns0(1232, false)
+			w := &testResponseWriter{
+				remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53},
+			}
+			handler.ServeDNS(w, req)
+
+			if w.msg == nil {
+				t.Fatal("Expected a response")
+			}
+			if tt.wantIP == "" {
+				if w.msg.Rcode != dns.RcodeServerFailure {
+					t.Errorf("Expected SERVFAIL, got %s", dns.RcodeToString[w.msg.Rcode])
+				}
+				return
+			}
+			if len(w.msg.Answer) != 1 {
+				t.Fatalf("Expected 1 answer, got %v", w.msg)
+			}
+			a := w.msg.Answer[0].(*dns.A)
+			if !a.A.Equal(net.ParseIP(tt.wantIP)) {
+				t.Errorf("Expected %s, got %s", tt.wantIP, a.A)
+			}
+			gotEDE := false
+			if opt := w.msg.IsEdns0(); opt != nil {
+				for _, o := range opt.Option {
+					if ede, ok := o.(*dns.EDNS0_EDE); ok && ede.InfoCode == dns.ExtendedErrorCodeStaleAnswer {
+						gotEDE = true
+					}
+				}
+			}
+			if gotEDE != tt.wantStale {
+				t.Errorf("Expected Stale Answer EDE=%v, got %v", tt.wantStale, gotEDE)
+			}
+
+			if tt.onExpiry != config.CacheOnExpiryServeStaleAsync {
+				return
+			}
+			// The background refresh replaces the stale entry
+			deadline := time.Now().Add(2 * time.Second)
+			for {
+				if resp, found := zoneCache.Get(key); found {
+					if got := resp.Answer[0].(*dns.A).A; !got.Equal(net.ParseIP("10.0.0.2")) {
+						t.Errorf("Expected refreshed answer 10.0.0.2, got %s", got)
+					}
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatal("Cache entry was not refreshed in the background")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			if got := queries.Load(); got != 1 {
+				t.Errorf("Expected 1 background backend query, got %d", got)
+			}
+		})
+	}
+}
+
+func TestDNSHandler_RefreshAsyncZoneRemoved(t *testing.T) {
+	var queries atomic.Int32
+	backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		queries.Add(1)
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		_ = w.WriteMsg(msg)
+	})
+	backendCfg := config.BackendConfig{DNSServers: []string{backend}, Timeout: "1s", Retries: 1}
+	cfg := &config.Config{
+		Global: config.GlobalConfig{Backend: backendCfg},
+		Zones: map[string]*config.Zone{
+			"stable": {Domains: []string{"*.stable.local"}, Backend: backendCfg},
+		},
+	}
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+	log := logger.New(runtimeCfg.ToLoggingConfig())
+	handler := &TailscaleDNSHandler{
+		config:     cfg,
+		runtimeCfg: runtimeCfg,
+		forwarder:  NewForwarder(backendCfg, log),
+		logger:     log,
+		zoneCaches: make(map[string]*cache.ZoneCache),
+	}
+
+	req := new(dns.Msg)
+	req.SetQuestion("app.stable.local.", dns.TypeA)
+	w := &testResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53}}
+	key := cache.CacheKey("app.stable.local.", dns.TypeA, nil)
+
+	// A reload drops the zone before the refresh gets to run
+	handler.reloadMu.Lock()
+	handler.refreshAsync(context.Background(), w, req, req.Question[0], "stable", key, netip.MustParseAddr("100.64.0.1"), true)
+	handler.config = &config.Config{Global: cfg.Global, Zones: map[string]*config.Zone{}}
+	handler.reloadMu.Unlock()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, busy := handler.refreshing.Load("stable|" + key); !busy {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Refresh did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := queries.Load(); got != 0 {
+		t.Errorf("Expected no backend query for a removed zone, got %d", got)
+	}
+}
+
+func TestForwarder_QueryDeadline(t *testing.T) {
+	var queries atomic.Int32
+	backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		queries.Add(1)
+		time.Sleep(300 * time.Millisecond)
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		_ = w.WriteMsg(msg)
+	})
+
+	backendCfg := config.BackendConfig{DNSServers: []string{backend}, Timeout: "2s", Retries: 3}
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+	log := logger.New(runtimeCfg.ToLoggingConfig())
+	forwarder := NewForwarder(backendCfg, log)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	req := new(dns.Msg)
+	req.SetQuestion("app.slow.local.", dns.TypeA)
+	w := &testResponseWriter{}
+
+	start := time.Now()
+	forwarder.ForwardContext(ctx, w, req, "slow", nil, "")
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected forwarding to stop at the query deadline, took %v", elapsed)
+	}
+	if w.msg == nil || w.msg.Rcode != dns.RcodeServerFailure {
+		t.Fatalf("Expected SERVFAIL after the deadline, got %v", w.msg)
+	}
+	if got := queries.Load(); got != 1 {
+		t.Errorf("Expected no retries after the deadline, got %d backend queries", got)
+	}
+}
+
+func TestForwarder_SharesConcurrentQueries(t *testing.T) {
+	var queries atomic.Int32
+	release := make(chan struct{})
+	backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		queries.Add(1)
+		<-release
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		msg.Answer = append(msg.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.ParseIP("10.0.0.1"),
+		})
+		_ = w.WriteMsg(msg)
+	})
+
+	backendCfg := config.BackendConfig{DNSServers: []string{backend}, Timeout: "5s", Retries: 1}
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+	log := logger.New(runtimeCfg.ToLoggingConfig())
+	forwarder := NewForwarder(backendCfg, log)
+	forwarder.inflight = new(singleflight.Group)
+	zoneCache := cache.NewZoneCache(100, 5*time.Minute)
+
+	shared := testutil.ToFloat64(metrics.SingleflightShared.WithLabelValues("cold", "forward"))
+
+	const clients = 50
+	writers := make([]*testResponseWriter, clients)
+	ids := make([]uint16, clients)
+	var wg sync.WaitGroup
+	for i := range clients {
+		writers[i] = &testResponseWriter{}
+		req := new(dns.Msg)
+		req.SetQuestion("app.cold.local.", dns.TypeA)
+		ids[i] = req.Id
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			forwarder.ForwardContext(context.Background(), writers[i], req, "cold", zoneCache, "")
+		}()
+	}
+
+	// Hold the first backend query until every client has joined it
+	deadline := time.Now().Add(2 * time.Second)
+	for queries.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(200 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if got := queries.Load(); got != 1 {
+		t.Errorf("Expected 1 backend query for %d identical queries, got %d", clients, got)
+	}
+	for i, w := range writers {
+		if w.msg == nil || w.msg.Rcode != dns.RcodeSuccess || len(w.msg.Answer) != 1 {
+			t.Fatalf("Expected client %d answered, got %v", i, w.msg)
+		}
+		if w.msg.Id != ids[i] {
+			t.Errorf("Expected client %d answered with its query ID %d, got %d", i, ids[i], w.msg.Id)
+		}
+	}
+	if got := testutil.ToFloat64(metrics.SingleflightShared.WithLabelValues("cold", "forward")) - shared; got != clients-1 {
+		t.Errorf("Expected %d shared answers recorded, got %v", clients-1, got)
+	}
+
+	// Different questions are not shared
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		req := new(dns.Msg)
+		req.SetQuestion("other.cold.local.", qtype)
+		forwarder.ForwardContext(context.Background(), &testResponseWriter{}, req, "cold", nil, "")
+	}
+	if got := queries.Load(); got != 3 {
+		t.Errorf("Expected each distinct question to query the backend, got %d backend queries", got)
+	}
+}
+
+// histogramCount returns how many values the named histogram observed for
+// the series with labels
+func histogramCount(t *testing.T, name string, labels map[string]string) uint64 {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("Failed to gather metrics: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	series:
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if labels[label.GetName()] != label.GetValue() {
+					continue series
+				}
+			}
+			return m.GetHistogram().GetSampleCount()
+		}
+	}
+	return 0
+}
+
+func TestDNSHandler_LatencyHistograms(t *testing.T) {
+	backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		if r.Question[0].Qtype == dns.TypeA {
+			msg.Answer = append(msg.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   net.ParseIP("10.0.0.1"),
+			})
+		}
+		_ = w.WriteMsg(msg)
+	})
+	unreachable := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {})
+
+	backendCfg := config.BackendConfig{DNSServers: []string{backend}, Timeout: "1s", Retries: 1}
+	cfg := &config.Config{
+		Global: config.GlobalConfig{Backend: backendCfg},
+		Zones: map[string]*config.Zone{
+			"timed": {
+				Domains:         []string{"*.timed.local"},
+				ReflectedDomain: "cluster.local",
+				TranslateID:     func() *uint16 { v := uint16(7); return &v }(),
+				Backend:         backendCfg,
+			},
+		},
+	}
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+	log := logger.New(runtimeCfg.ToLoggingConfig())
+	via6Trans, err := via6.NewTranslator(cfg, log)
+	if err != nil {
+		t.Fatalf("Failed to create translator: %v", err)
+	}
+	handler := &TailscaleDNSHandler{
+		config:     cfg,
+		runtimeCfg: runtimeCfg,
+		via6Trans:  via6Trans,
+		forwarder:  NewForwarder(backendCfg, log),
+		logger:     log,
+		zoneCaches: make(map[string]*cache.ZoneCache),
+	}
+	query := func(name string, qtype uint16) {
+		t.Helper()
+		req := new(dns.Msg)
+		req.SetQuestion(name, qtype)
+		w := &testResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53}}
+		handler.ServeDNS(w, req)
+		if w.msg == nil || w.msg.Rcode != dns.RcodeSuccess {
+			t.Fatalf("Expected %s answered, got %v", name, w.msg)
+		}
+	}
+
+	// A forwarded query times its backend
+	backendLabels := map[string]string{"zone": "timed", "backend": backend}
+	query("web.timed.local.", dns.TypeTXT)
+	if got := histogramCount(t, "tsdnsreflector_backend_duration_seconds", backendLabels); got != 1 {
+		t.Errorf("Expected 1 backend duration observed, got %d", got)
+	}
+
+	// A 4via6 answer times the reflected-domain resolution
+	query("web.timed.local.", dns.TypeAAAA)
+	if got := histogramCount(t, "tsdnsreflector_via6_resolution_duration_seconds", map[string]string{"zone": "timed"}); got != 1 {
+		t.Errorf("Expected 1 resolution duration observed, got %d", got)
+	}
+
+	// Failed attempts count as backend queries but not as answered ones
+	forwarder := NewForwarder(config.BackendConfig{DNSServers: []string{unreachable}, Timeout: "50ms", Retries: 1}, log)
+	req := new(dns.Msg)
+	req.SetQuestion("web.timed.local.", dns.TypeA)
+	forwarder.ForwardContext(context.Background(), &testResponseWriter{}, req, "timed", nil, "")
+	failedLabels := map[string]string{"zone": "timed", "backend": unreachable}
+	if got := histogramCount(t, "tsdnsreflector_backend_query_duration_seconds", failedLabels); got != 1 {
+		t.Errorf("Expected the failed attempt timed, got %d observations", got)
+	}
+	if got := histogramCount(t, "tsdnsreflector_backend_duration_seconds", failedLabels); got != 0 {
+		t.Errorf("Expected no answered duration for the failed attempt, got %d observations", got)
+	}
+}
+
+func TestLimitTCPMessageSize(t *testing.T) {
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+	log := logger.New(runtimeCfg.ToLoggingConfig())
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	started := make(chan struct{})
+	server := &dns.Server{
+		Listener: ln,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			msg := new(dns.Msg)
+			msg.SetReply(r)
+			_ = w.WriteMsg(msg)
+		}),
+		DecorateReader:    limitTCPMessageSize(128, log),
+		NotifyStartedFunc: func() { close(started) },
+	}
+	go func() { _ = server.ActivateAndServe() }()
+	<-started
+	defer func() { _ = server.Shutdown() }()
+
+	client := &dns.Client{Net: "tcp", Timeout: 2 * time.Second}
+
+	small := new(dns.Msg)
+	small.SetQuestion("app.cluster.local.", dns.TypeA)
+	resp, _, err := client.Exchange(small, ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Small query failed: %v", err)
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		t.Errorf("Expected NOERROR for small query, got %s", dns.RcodeToString[resp.Rcode])
+	}
+
+	large := new(dns.Msg)
+	large.SetQuestion("app.cluster.local.", dns.TypeA)
+	large.SetEdns0(1232, false)
+	large.IsEdns0().Option = append(large.IsEdns0().Option, &dns.EDNS0_PADDING{Padding: make([]byte, 256)})
+	resp, _, err = client.Exchange(large, ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Oversized query got no response: %v", err)
+	}
+	if resp.Rcode != dns.RcodeFormatError || resp.Id != large.Id {
+		t.Errorf("Expected FORMERR for query %d, got %s for %d", large.Id, dns.RcodeToString[resp.Rcode], resp.Id)
+	}
+}
+
+func TestTCPConnLimiter(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	started := make(chan struct{})
+	server := &dns.Server{
+		Listener: newTCPConnLimiter(2).listen(ln),
+		Net:      "tcp",
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			msg := new(dns.Msg)
+			msg.SetReply(r)
+			_ = w.WriteMsg(msg)
+		}),
+		IdleTimeout:       func() time.Duration { return 200 * time.Millisecond },
+		NotifyStartedFunc: func() { close(started) },
+	}
+	go func() { _ = server.ActivateAndServe() }()
+	<-started
+	defer func() { _ = server.Shutdown() }()
+
+	openConns := testutil.ToFloat64(metrics.TCPConnections)
+	rejected := testutil.ToFloat64(metrics.TCPConnectionsRejected)
+
+	// query sends a query on a new connection, leaving it open
+	query := func() (*dns.Conn, error) {
+		conn, err := dns.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			return nil, err
+		}
+		req := new(dns.Msg)
+		req.SetQuestion("app.cluster.local.", dns.TypeA)
+		_ = conn.SetDeadline(time.Now().Add(time.Second))
+		if err := conn.WriteMsg(req); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		if _, err := conn.ReadMsg(); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+
+	first, err := query()
+	if err != nil {
+		t.Fatalf("First connection failed: %v", err)
+	}
+	second, err := query()
+	if err != nil {
+		t.Fatalf("Second connection failed: %v", err)
+	}
+	defer func() { _ = second.Close() }()
+	if got := testutil.ToFloat64(metrics.TCPConnections) - openConns; got != 2 {
+		t.Errorf("Expected 2 open connections, got %v", got)
+	}
+
+	if conn, err := query(); err == nil {
+		_ = conn.Close()
+		t.Error("Expected connection over the limit to be closed")
+	}
+	if got := testutil.ToFloat64(metrics.TCPConnectionsRejected) - rejected; got != 1 {
+		t.Errorf("Expected 1 rejected connection, got %v", got)
+	}
+
+	// Idle connections are closed by the server, freeing their slots
+	_ = first.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := first.ReadMsg(); err == nil {
+		t.Error("Expected idle connection to be closed")
+	}
+	_ = first.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for testutil.ToFloat64(metrics.TCPConnections)-openConns != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	conn, err := query()
+	if err != nil {
+		t.Fatalf("Expected a connection once idle ones were closed: %v", err)
+	}
+	_ = conn.Close()
+}
+
+func TestDNSHandler_RateLimit(t *testing.T) {
+	backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		_ = w.WriteMsg(msg)
+	})
+	backendCfg := config.BackendConfig{DNSServers: []string{backend}, Timeout: "1s", Retries: 1}
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300, PerClientQPS: 1, PerClientBurst: 2}
+	log := logger.New(runtimeCfg.ToLoggingConfig())
+	now := time.Now()
+	limiter := newClientRateLimiter(runtimeCfg.PerClientQPS, runtimeCfg.RateLimitBurst())
+	limiter.now = func() time.Time { return now }
+	handler := &TailscaleDNSHandler{
+		config:      &config.Config{Global: config.GlobalConfig{Backend: backendCfg}, Zones: map[string]*config.Zone{}},
+		runtimeCfg:  runtimeCfg,
+		forwarder:   NewForwarder(backendCfg, log),
+		rateLimiter: limiter,
+		logger:      log,
+	}
+
+	query := func(client string) int {
+		t.Helper()
+		req := new(dns.Msg)
+		req.SetQuestion("app.cluster.local.", dns.TypeA)
+		w := &testResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP(client), Port: 53}}
+		handler.ServeDNS(w, req)
+		if w.msg == nil {
+			t.Fatalf("Expected a response for %s", client)
+		}
+		return w.msg.Rcode
+	}
+
+	limited := testutil.ToFloat64(metrics.RateLimitedQueries.WithLabelValues("tailscale"))
+
+	// The burst is answered, then the client is refused
+	for i := range 2 {
+		if rcode := query("100.64.0.1"); rcode != dns.RcodeSuccess {
+			t.Fatalf("Expected query %d within the burst answered, got %s", i, dns.RcodeToString[rcode])
+		}
+	}
+	if rcode := query("100.64.0.1"); rcode != dns.RcodeRefused {
+		t.Fatalf("Expected REFUSED over the limit, got %s", dns.RcodeToString[rcode])
+	}
+	if got := testutil.ToFloat64(metrics.RateLimitedQueries.WithLabelValues("tailscale")) - limited; got != 1 {
+		t.Errorf("Expected 1 rate-limited query recorded, got %v", got)
+	}
+
+	// Other clients have their own bucket
+	if rcode := query("100.64.0.2"); rcode != dns.RcodeSuccess {
+		t.Errorf("Expected another client answered, got %s", dns.RcodeToString[rcode])
+	}
+
+	// One token refills per second
+	now = now.Add(time.Second)
+	if rcode := query("100.64.0.1"); rcode != dns.RcodeSuccess {
+		t.Errorf("Expected the client answered after the refill, got %s", dns.RcodeToString[rcode])
+	}
+	if rcode := query("100.64.0.1"); rcode != dns.RcodeRefused {
+		t.Errorf("Expected REFUSED once the refilled token is spent, got %s", dns.RcodeToString[rcode])
+	}
+}
+
+func TestClientRateLimiter_Bounded(t *testing.T) {
+	if newClientRateLimiter(0, 1) != nil {
+		t.Error("Expected no limiter without a QPS")
+	}
+
+	now := time.Now()
+	limiter := newClientRateLimiter(10, 10)
+	limiter.now = func() time.Time { return now }
+
+	// Far more clients than the limiter tracks, each spending a token so its bucket isn't idle
+	for i := range 2 * rateLimitShards * rateLimitShardSize {
+		ip := netip.AddrFrom4([4]byte{10, byte(i >> 16), byte(i >> 8), byte(i)})
+		limiter.allow(ip)
+	}
+	for i := range limiter.shards {
+		if n := len(limiter.shards[i].buckets); n > rateLimitShardSize {
+			t.Fatalf("Expected shard %d bounded at %d clients, got %d", i, rateLimitShardSize, n)
+		}
+	}
+
+	// Refilled buckets are dropped by the next sweep
+	now = now.Add(rateLimitSweepEvery)
+	for i := range limiter.shards {
+		limiter.sweep(&limiter.shards[i], now)
+		if n := len(limiter.shards[i].buckets); n != 0 {
+			t.Errorf("Expected shard %d empty after a sweep, got %d clients", i, n)
+		}
+	}
+}
+
+func TestDNSHandler_TraceQuery(t *testing.T) {
+	backendCfg := config.BackendConfig{DNSServers: []string{"192.0.2.53:53"}, Timeout: "1s", Retries: 1}
+	cfg := &config.Config{
+		Global: config.GlobalConfig{Backend: backendCfg, SpecialUseDomains: config.DefaultSpecialUseDomains()},
+		Zones: map[string]*config.Zone{
+			"cluster": {
+				Domains:         []string{"*.cluster.local"},
+				ReflectedDomain: "127.0.0.1",
+				TranslateID:     func() *uint16 { v := uint16(7); return &v }(),
+				Backend:         backendCfg,
+			},
+			"corp": {
+				Domains: []string{"*.corp.local"},
+				Backend: config.BackendConfig{DNSServers: []string{"192.0.2.10:53"}, Timeout: "1s", Retries: 1},
+				BackendByTag: map[string]config.BackendConfig{
+					"tag:prod": {DNSServers: []string{"192.0.2.20:53"}, Timeout: "1s", Retries: 1},
+				},
+			},
+			"lab": {
+				Domains:       []string{"db.lab.local"},
+				Backend:       backendCfg,
+				DeniedClients: []string{"100.64.0.9/32"},
+			},
+		},
+	}
+	if err := cfg.ValidateZones(); err != nil {
+		t.Fatalf("ValidateZones failed: %v", err)
+	}
+
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300, AllowTraceQueries: true}
+	log := logger.New(runtimeCfg.ToLoggingConfig())
+
+	via6Trans, err := via6.NewTranslator(cfg, log)
+	if err != nil {
+		t.Fatalf("Failed to create translator: %v", err)
+	}
+
+	// Room for two entries, so an LRU change from tracing would show
+	corpCache := cache.NewZoneCache(2, time.Minute)
+	defer corpCache.Stop()
+
+	handler := &TailscaleDNSHandler{
+		config:     cfg,
+		runtimeCfg: runtimeCfg,
+		via6Trans:  via6Trans,
+		forwarder:  NewForwarder(backendCfg, log),
+		logger:     log,
+		zoneCaches: map[string]*cache.ZoneCache{"corp": corpCache},
+		clientTags: newClientTags(func(ctx context.Context, ip netip.Addr) ([]string, error) {
+			if ip == netip.MustParseAddr("100.64.0.2") {
+				return []string{"tag:prod"}, nil
+			}
+			return nil, nil
+		}, time.Minute),
+	}
+
+	cached := new(dns.Msg)
+	cached.SetQuestion("db.corp.local.", dns.TypeA)
+	cached.Answer = append(cached.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: "db.corp.local.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+		A:   net.ParseIP("10.0.0.5"),
+	})
+	corpCache.Set(cache.CacheKey("db.corp.local.", dns.TypeA, nil), cached)
+	corpCache.Set(cache.CacheKey("web.corp.local.", dns.TypeA, nil), cached)
+
+	tests := []struct {
+		name   string
+		query  string
+		client string
+		want   []string
+	}{
+		{"4via6", "_trace.app.cluster.local.", "100.64.0.1", []string{"zone=cluster", "path=4via6", "translateid=7", "cache=disabled"}},
+		{"forward", "_TRACE.db.corp.local.", "100.64.0.1", []string{"zone=corp", "tag=default", "path=forward", "backends=192.0.2.10:53", "cache.A=hit", "cache.AAAA=miss"}},
+		{"tag backend", "_trace.db.corp.local.", "100.64.0.2", []string{"zone=corp", "tag=tag:prod", "path=forward", "backends=192.0.2.20:53"}},
+		{"no zone", "_trace.example.com.", "100.64.0.1", []string{"zone=default", "path=forward", "backends=192.0.2.53:53"}},
+		{"blocked", "_trace.db.corp.local.", "203.0.113.1", []string{"client=external", "path=blocked"}},
+		{"denied", "_trace.db.lab.local.", "100.64.0.9", []string{"zone=lab", "path=denied"}},
+		{"special-use", "_trace.foo.invalid.", "100.64.0.1", []string{"path=special-use", "policy=nxdomain"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := new(dns.Msg)
+			req.SetQuestion(tt.query, dns.TypeTXT)
+			w := &testResponseWriter{
+				remoteAddr: &net.UDPAddr{IP: net.ParseIP(tt.client), Port: 53},
+			}
+			handler.ServeDNS(w, req)
+
+			if w.msg == nil || len(w.msg.Answer) != 1 {
+				t.Fatalf("Expected 1 TXT answer, got %v", w.msg)
+			}
+			txt, ok := w.msg.Answer[0].(*dns.TXT)
+			if !ok {
+				t.Fatalf("Expected TXT record, got %T", w.msg.Answer[0])
+			}
+			for _, want := range tt.want {
+				found := false
+				for _, s := range txt.Txt {
+					if s == want {
+						found = true
+					}
+				}
+				if !found {
+					t.Errorf("Expected %q in trace %v", want, txt.Txt)
+				}
+			}
+		})
+	}
+
+	// Tracing never marks entries used: db is still the least recently used
+	corpCache.Set(cache.CacheKey("api.corp.local.", dns.TypeA, nil), cached)
+	if _, found := corpCache.Peek(cache.CacheKey("db.corp.local.", dns.TypeA, nil)); found {
+		t.Error("Expected traced entry to be evicted as least recently used")
+	}
+
+	// Trace queries are resolved normally unless enabled
+	runtimeCfg.AllowTraceQueries = false
+	req := new(dns.Msg)
+	req.SetQuestion("_trace.app.cluster.local.", dns.TypeTXT)
+	w := &testResponseWriter{
+		remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53},
+	}
+	handler.ServeDNS(w, req)
+	if w.msg != nil && len(w.msg.Answer) > 0 {
+		t.Errorf("Expected no trace answer when disabled, got %v", w.msg.Answer)
+	}
+}
+
+func TestDNSHandler_ChaosVersion(t *testing.T) {
+	origVersion := Version
+	Version = "v1.2.3"
+	defer func() { Version = origVersion }()
+
+	tests := []struct {
+		name       string
+		policy     string
+		obfuscated string
+		client     string
+		qname      string
+		want       string // empty: no answer
+	}{
+		{"tailscale client sees version", "", "", "100.64.0.1", "version.bind.", "v1.2.3"},
+		{"external client hidden", "", "", "203.0.113.1", "version.bind.", ""},
+		{"external client obfuscated", "tailscale", "none of your business", "203.0.113.1", "VERSION.BIND.", "none of your business"},
+		{"all reveals to external", "all", "", "203.0.113.1", "version.server.", "v1.2.3"},
+		{"none hides from tailscale", "none", "hidden", "100.64.0.1", "version.bind.", "hidden"},
+		{"hostname", "", "", "100.64.0.1", "hostname.bind.", "tsdns-test"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runtimeCfg := &config.RuntimeConfig{
+				Hostname:          "tsdns-test",
+				VersionQueries:    tt.policy,
+				VersionObfuscated: tt.obfuscated,
+			}
+			handler := &TailscaleDNSHandler{
+				config:     &config.Config{},
+				runtimeCfg: runtimeCfg,
+				logger:     logger.New(runtimeCfg.ToLoggingConfig()),
+				zoneCaches: make(map[string]*cache.ZoneCache),
+			}
+
+			req := new(dns.Msg)
+			req.SetQuestion(tt.qname, dns.TypeTXT)
+			req.Question[0].Qclass = dns.ClassCHAOS
+			w := &testResponseWriter{
+				remoteAddr: &net.UDPAddr{IP: net.ParseIP(tt.client), Port: 53},
+			}
+			handler.ServeDNS(w, req)
+
+			if w.msg == nil || w.msg.Rcode != dns.RcodeSuccess {
+				t.Fatalf("Expected NOERROR, got %v", w.msg)
+			}
+			if tt.want == "" {
+				if len(w.msg.Answer) != 0 {
+					t.Errorf("Expected no answer, got %v", w.msg.Answer)
+				}
+				return
+			}
+			if len(w.msg.Answer) != 1 {
+				t.Fatalf("Expected 1 answer, got %v", w.msg.Answer)
+			}
+			txt := w.msg.Answer[0].(*dns.TXT)
+			if txt.Hdr.Class != dns.ClassCHAOS || txt.Txt[0] != tt.want {
+				t.Errorf("Expected CH TXT %q, got %v", tt.want, txt)
+			}
+		})
+	}
+
+	// Unknown CHAOS names are refused rather than forwarded
+	runtimeCfg := &config.RuntimeConfig{}
+	handler := &TailscaleDNSHandler{
+		config:     &config.Config{},
+		runtimeCfg: runtimeCfg,
+		logger:     logger.New(runtimeCfg.ToLoggingConfig()),
+	}
+	req := new(dns.Msg)
+	req.SetQuestion("authors.bind.", dns.TypeTXT)
+	req.Question[0].Qclass = dns.ClassCHAOS
+	w := &testResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53}}
+	handler.ServeDNS(w, req)
+	if w.msg == nil || w.msg.Rcode != dns.RcodeRefused {
+		t.Errorf("Expected REFUSED for unknown CHAOS name, got %v", w.msg)
+	}
+}
+
+func TestDNSHandler_HealthProbe(t *testing.T) {
+	var queries atomic.Int32
+	backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		queries.Add(1)
+		msg := new(dns.Msg)
+		msg.SetRcode(r, dns.RcodeServerFailure)
+		_ = w.WriteMsg(msg)
+	})
+	backendCfg := config.BackendConfig{DNSServers: []string{backend}, Timeout: "1s", Retries: 1}
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300, DNSHealthProbeName: "kubernetes.default.svc.cluster.local"}
+	log := logger.New(runtimeCfg.ToLoggingConfig())
+	handler := &TailscaleDNSHandler{
+		config:     &config.Config{Global: config.GlobalConfig{Backend: backendCfg}, Zones: map[string]*config.Zone{}},
+		runtimeCfg: runtimeCfg,
+		forwarder:  NewForwarder(backendCfg, log),
+		logger:     log,
+	}
+
+	query := func(name string, qtype uint16) *dns.Msg {
+		t.Helper()
+		req := new(dns.Msg)
+		req.SetQuestion(name, qtype)
+		// Kubelet probes come from outside the tailnet
+		w := &testResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP("10.1.2.3"), Port: 53}}
+		handler.ServeDNS(w, req)
+		if w.msg == nil {
+			t.Fatalf("Expected a response for %s", name)
+		}
+		return w.msg
+	}
+
+	resp := query("Kubernetes.Default.svc.cluster.local.", dns.TypeA)
+	if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 1 {
+		t.Fatalf("Expected one A record for the probe name, got %v", resp)
+	}
+	if a, ok := resp.Answer[0].(*dns.A); !ok || !a.A.Equal(net.IPv4(127, 0, 0, 1)) || a.Hdr.Ttl != 0 {
+		t.Errorf("Expected 127.0.0.1 with TTL 0, got %v", resp.Answer[0])
+	}
+	if resp := query("kubernetes.default.svc.cluster.local.", dns.TypeAAAA); len(resp.Answer) != 1 {
+		t.Errorf("Expected one AAAA record for the probe name, got %v", resp)
+	}
+	if resp := query("kubernetes.default.svc.cluster.local.", dns.TypeTXT); resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 0 {
+		t.Errorf("Expected an empty answer for other types, got %v", resp)
+	}
+	if got := queries.Load(); got != 0 {
+		t.Errorf("Expected probes answered without the backend, got %d backend queries", got)
+	}
+
+	// Other names still take the normal path
+	req := new(dns.Msg)
+	req.SetQuestion("other.svc.cluster.local.", dns.TypeA)
+	handler.ServeDNS(&testResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53}}, req)
+	if got := queries.Load(); got != 1 {
+		t.Errorf("Expected other names forwarded, got %d backend queries", got)
+	}
+}
+
+func TestDNSHandler_MultiQuestion(t *testing.T) {
+	var questions atomic.Int32
+	backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		questions.Store(int32(len(r.Question)))
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		msg.Answer = append(msg.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.IPv4(10, 0, 0, 5),
+		})
+		_ = w.WriteMsg(msg)
+	})
+	backendCfg := config.BackendConfig{DNSServers: []string{backend}, Timeout: "1s", Retries: 1}
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300, MultiQuestionPolicy: config.MultiQuestionFormErr}
+	log := logger.New(runtimeCfg.ToLoggingConfig())
+	handler := &TailscaleDNSHandler{
+		config:     &config.Config{Global: config.GlobalConfig{Backend: backendCfg}, Zones: map[string]*config.Zone{}},
+		runtimeCfg: runtimeCfg,
+		forwarder:  NewForwarder(backendCfg, log),
+		logger:     log,
+	}
+
+	query := func() *dns.Msg {
+		t.Helper()
+		req := new(dns.Msg)
+		req.SetQuestion("first.example.com.", dns.TypeA)
+		req.Question = append(req.Question, dns.Question{Name: "second.example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET})
+		w := &testResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53}}
+		handler.ServeDNS(w, req)
+		if w.msg == nil {
+			t.Fatal("Expected a response")
+		}
+		return w.msg
+	}
+
+	if resp := query(); resp.Rcode != dns.RcodeFormatError || len(resp.Answer) != 0 {
+		t.Errorf("Expected FORMERR, got %v", resp)
+	}
+	if got := questions.Load(); got != 0 {
+		t.Errorf("Expected a rejected query not forwarded, backend saw %d questions", got)
+	}
+
+	runtimeCfg.MultiQuestionPolicy = config.MultiQuestionFirstOnly
+	resp := query()
+	if resp.Rcode != dns.RcodeSuccess || len(resp.Question) != 1 || len(resp.Answer) != 1 {
+		t.Fatalf("Expected the first question answered, got %v", resp)
+	}
+	if resp.Question[0].Name != "first.example.com." {
+		t.Errorf("Expected question first.example.com., got %s", resp.Question[0].Name)
+	}
+	if got := questions.Load(); got != 1 {
+		t.Errorf("Expected only the first question forwarded, backend saw %d", got)
+	}
+
+	// The library rejects such queries before ServeDNS unless told otherwise
+	header := dns.Header{Qdcount: 2}
+	if accept := msgAcceptFunc(config.MultiQuestionFirstOnly); accept(header) != dns.MsgAccept {
+		t.Error("Expected firstonly to accept several questions")
+	}
+	if accept := msgAcceptFunc(config.MultiQuestionFormErr); accept != nil {
+		t.Error("Expected formerr to keep the library default")
+	}
+	if accept := msgAcceptFunc(config.MultiQuestionFirstOnly); accept(dns.Header{}) == dns.MsgAccept {
+		t.Error("Expected queries without a question still rejected")
+	}
+}
+
+func TestDNSHandler_MultiQuestionDefaultPolicy(t *testing.T) {
+	backendCfg := config.BackendConfig{DNSServers: []string{"127.0.0.1:1"}, Timeout: "200ms", Retries: 1}
+	cfg := &config.Config{
+		Global: config.GlobalConfig{Backend: backendCfg},
+		Zones: map[string]*config.Zone{
+			"cluster": {
+				Domains:         []string{"*.cluster1.local"},
+				ReflectedDomain: "10.0.0.5",
+				TranslateID:     func() *uint16 { v := uint16(7); return &v }(),
+				Backend:         backendCfg,
+			},
+		},
+	}
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+	log := logger.New(runtimeCfg.ToLoggingConfig())
+	via6Trans, err := via6.NewTranslator(cfg, log)
+	if err != nil {
+		t.Fatalf("Failed to create translator: %v", err)
+	}
+	handler := &TailscaleDNSHandler{
+		config:     cfg,
+		runtimeCfg: runtimeCfg,
+		via6Trans:  via6Trans,
+		forwarder:  NewForwarder(backendCfg, log),
+		logger:     log,
+		zoneCaches: make(map[string]*cache.ZoneCache),
+	}
+
+	// Without a policy set, a second question is never silently dropped
+	req := new(dns.Msg)
+	req.SetQuestion("web.cluster1.local.", dns.TypeAAAA)
+	req.Question = append(req.Question, dns.Question{Name: "db.cluster1.local.", Qtype: dns.TypeAAAA, Qclass: dns.ClassINET})
+	w := &testResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53}}
+	handler.ServeDNS(w, req)
+	if w.msg == nil || w.msg.Rcode != dns.RcodeFormatError || len(w.msg.Answer) != 0 {
+		t.Errorf("Expected FORMERR without answers, got %v", w.msg)
+	}
+
+	// Nor is a query without any question answered as if it had one
+	req = new(dns.Msg)
+	req.Id = dns.Id()
+	w = &testResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53}}
+	handler.ServeDNS(w, req)
+	if w.msg == nil || w.msg.Rcode != dns.RcodeFormatError {
+		t.Errorf("Expected FORMERR for a query without questions, got %v", w.msg)
+	}
+}
+
+func TestDNSHandler_Via6ForwardsOtherTypes(t *testing.T) {
+	var forwarded atomic.Pointer[dns.Question]
+	backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		forwarded.Store(&r.Question[0])
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		if r.Question[0].Qtype == dns.TypeTXT {
+			msg.Answer = append(msg.Answer, &dns.TXT{
+				Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 60},
+				Txt: []string{"v=spf1 -all"},
+			})
+		}
+		_ = w.WriteMsg(msg)
+	})
+
+	backendCfg := config.BackendConfig{DNSServers: []string{backend}, Timeout: "1s", Retries: 1}
+	cfg := &config.Config{
+		Global: config.GlobalConfig{Backend: backendCfg},
+		Zones: map[string]*config.Zone{
+			"cluster": {
+				Domains:         []string{"*.cluster1.local"},
+				ReflectedDomain: "10.0.0.5",
+				TranslateID:     func() *uint16 { v := uint16(7); return &v }(),
+				Backend:         backendCfg,
+			},
+		},
+	}
+
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+	log := logger.New(runtimeCfg.ToLoggingConfig())
+	via6Trans, err := via6.NewTranslator(cfg, log)
+	if err != nil {
+		t.Fatalf("Failed to create translator: %v", err)
+	}
+
+	handler := &TailscaleDNSHandler{
+		config:     cfg,
+		runtimeCfg: runtimeCfg,
+		via6Trans:  via6Trans,
+		forwarder:  NewForwarder(backendCfg, log),
+		logger:     log,
+		zoneCaches: make(map[string]*cache.ZoneCache),
+	}
+
+	req := new(dns.Msg)
+	req.SetQuestion("web.cluster1.local.", dns.TypeTXT)
+	w := &testResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53}}
+	handler.ServeDNS(w, req)
+
+	if q := forwarded.Load(); q == nil || q.Qtype != dns.TypeTXT {
+		t.Fatalf("Expected the TXT query forwarded to the zone backend, backend saw %v", forwarded.Load())
+	}
+	if w.msg == nil || w.msg.Rcode != dns.RcodeSuccess || len(w.msg.Answer) != 1 {
+		t.Fatalf("Expected the backend's TXT answer, got %v", w.msg)
+	}
+	if _, ok := w.msg.Answer[0].(*dns.TXT); !ok {
+		t.Errorf("Expected a TXT record, got %v", w.msg.Answer[0])
+	}
+
+	// Address queries are still synthesized without asking the backend
+	forwarded.Store(nil)
+	req = new(dns.Msg)
+	req.SetQuestion("web.cluster1.local.", dns.TypeAAAA)
+	w = &testResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53}}
+	handler.ServeDNS(w, req)
+	if w.msg == nil || len(w.msg.Answer) != 1 {
+		t.Fatalf("Expected one 4via6 answer, got %v", w.msg)
+	}
+	if q := forwarded.Load(); q != nil {
+		t.Errorf("Expected no backend query for AAAA, backend saw %v", q)
+	}
+}
+
+func TestDNSHandler_Via6ReflectsOtherTypes(t *testing.T) {
+	var queries atomic.Int32
+	backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		queries.Add(1)
+		q := r.Question[0]
+		msg := new(dns.Msg)
+		if q.Name != "web.cluster.local." {
+			msg.SetRcode(r, dns.RcodeNameError)
+			_ = w.WriteMsg(msg)
+			return
+		}
+		msg.SetReply(r)
+		hdr := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: 60}
+		switch q.Qtype {
+		case dns.TypeTXT:
+			msg.Answer = append(msg.Answer, &dns.TXT{Hdr: hdr, Txt: []string{"owner=platform"}})
+		case dns.TypeMX:
+			msg.Answer = append(msg.Answer, &dns.MX{Hdr: hdr, Preference: 10, Mx: "mail.cluster.local."})
+		case dns.TypeSRV:
+			msg.Answer = append(msg.Answer, &dns.SRV{Hdr: hdr, Priority: 1, Weight: 1, Port: 443, Target: "web.cluster.local."})
+		}
+		_ = w.WriteMsg(msg)
+	})
+
+	backendCfg := config.BackendConfig{DNSServers: []string{backend}, Timeout: "1s", Retries: 1}
+	cfg := &config.Config{
+		Global: config.GlobalConfig{Backend: backendCfg},
+		Zones: map[string]*config.Zone{
+			"cluster": {
+				Domains:         []string{"*.cluster1.local"},
+				ReflectedDomain: "cluster.local",
+				TranslateID:     func() *uint16 { v := uint16(7); return &v }(),
+				Backend:         backendCfg,
+			},
+		},
+	}
+
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+	log := logger.New(runtimeCfg.ToLoggingConfig())
+	via6Trans, err := via6.NewTranslator(cfg, log)
+	if err != nil {
+		t.Fatalf("Failed to create translator: %v", err)
+	}
+
+	handler := &TailscaleDNSHandler{
+		config:     cfg,
+		runtimeCfg: runtimeCfg,
+		via6Trans:  via6Trans,
+		forwarder:  NewForwarder(backendCfg, log),
+		logger:     log,
+		zoneCaches: map[string]*cache.ZoneCache{"cluster": cache.NewZoneCache(100, time.Minute)},
+	}
+
+	for _, qtype := range []uint16{dns.TypeTXT, dns.TypeMX, dns.TypeSRV} {
+		t.Run(dns.TypeToString[qtype], func(t *testing.T) {
+			queries.Store(0)
+			// The second query is answered from the cache, renamed the same way
+			for i := 0; i < 2; i++ {
+				req := new(dns.Msg)
+				req.SetQuestion("web.cluster1.local.", qtype)
+				w := &testResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53}}
+				handler.ServeDNS(w, req)
+
+				if w.msg == nil || w.msg.Rcode != dns.RcodeSuccess || len(w.msg.Answer) != 1 {
+					t.Fatalf("Query %d: expected the reflected name's record, got %v", i, w.msg)
+				}
+				if w.msg.Question[0].Name != "web.cluster1.local." {
+					t.Errorf("Query %d: expected the queried name in the question, got %s", i, w.msg.Question[0].Name)
+				}
+				if rr := w.msg.Answer[0]; rr.Header().Name != "web.cluster1.local." || rr.Header().Rrtype != qtype {
+					t.Errorf("Query %d: expected a %s record owned by the queried name, got %v", i, dns.TypeToString[qtype], rr)
+				}
+			}
+			if got := queries.Load(); got != 1 {
+				t.Errorf("Expected 1 backend query, got %d", got)
+			}
+		})
+	}
+
+	// Names missing under the reflected domain are missing in the zone too
+	req := new(dns.Msg)
+	req.SetQuestion("gone.cluster1.local.", dns.TypeTXT)
+	w := &testResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53}}
+	handler.ServeDNS(w, req)
+	if w.msg == nil || w.msg.Rcode != dns.RcodeNameError {
+		t.Errorf("Expected NXDOMAIN, got %v", w.msg)
+	}
+
+	// Every query not answered from the cache was reflected
+	if paths := handler.Stats().Paths; paths["reflect"] != 4 || paths["cache"] != 3 {
+		t.Errorf("Expected 4 reflected and 3 cached queries, got paths %v", paths)
+	}
+}
+
+func TestHealthChecker(t *testing.T) {
+	reply := func(rcode int) dns.HandlerFunc {
+		return func(w dns.ResponseWriter, r *dns.Msg) {
+			msg := new(dns.Msg)
+			msg.SetRcode(r, rcode)
+			_ = w.WriteMsg(msg)
+		}
+	}
+	up := startTestBackend(t, reply(dns.RcodeNameError))
+	down := startTestBackend(t, reply(dns.RcodeServerFailure))
+
+	backendCfg := config.BackendConfig{DNSServers: []string{down, up}, Timeout: "1s", Retries: 1}
+	cfg := &config.Config{
+		Zones: map[string]*config.Zone{
+			"checked": {
+				Domains:     []string{"*.checked.local"},
+				Backend:     backendCfg,
+				HealthCheck: &config.HealthCheck{Name: "cluster.local", Type: "SOA", Interval: "50ms"},
+			},
+		},
+	}
+
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+	log := logger.New(runtimeCfg.ToLoggingConfig())
+
+	hc := newHealthChecker(log)
+	hc.Start(cfg, nil)
+	defer hc.Stop()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for hc.Healthy("checked", down) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if hc.Healthy("checked", down) {
+		t.Fatal("Expected backend answering SERVFAIL to be marked down")
+	}
+	if !hc.Healthy("checked", up) {
+		t.Error("Expected backend answering NXDOMAIN to be healthy")
+	}
+
+	forwarder := NewForwarder(backendCfg, log)
+	forwarder.health = hc
+	if got := forwarder.healthyBackends("checked"); len(got) != 1 || got[0] != up {
+		t.Errorf("Expected only %s to be used, got %v", up, got)
+	}
+
+	// Zones without health checks use every backend
+	if got := forwarder.healthyBackends("unchecked"); len(got) != 2 {
+		t.Errorf("Expected all backends for unchecked zone, got %v", got)
+	}
+
+	hc.Stop() // Stopping twice is safe
+}
+
+func TestServer_HealthChecksAcrossReload(t *testing.T) {
+	up := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetRcode(r, dns.RcodeNameError)
+		_ = w.WriteMsg(msg)
+	})
+	newCfg := func(servers ...string) *config.Config {
+		backendCfg := config.BackendConfig{DNSServers: servers, Timeout: "1s", Retries: 1}
+		return &config.Config{
+			Global: config.GlobalConfig{Backend: backendCfg},
+			Zones: map[string]*config.Zone{
+				"checked": {
+					Domains:     []string{"*.checked.local"},
+					Backend:     backendCfg,
+					HealthCheck: &config.HealthCheck{Name: "cluster.local", Type: "SOA", Interval: "1h"},
+				},
+			},
+		}
+	}
+	runtimeCfg := &config.RuntimeConfig{DNSPort: 5353, BindAddress: "127.0.0.1", DefaultTTL: 300, MinHealthyBackends: 1, LogLevel: "error"}
+	server, err := NewServerWithRuntime(newCfg(up), runtimeCfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	defer server.Stop()
+
+	// Health checks run before the first query is served
+	server.warmup()
+	if server.handler.health == nil || server.handler.health != server.health {
+		t.Fatal("Expected the handler to use the server's health checker before serving")
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(server.unreadyZones()) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if unready := server.unreadyZones(); len(unready) > 0 {
+		t.Fatalf("Expected the zone ready after its first probe, got unready %v", unready)
+	}
+
+	// Results for kept backends carry over; removed backends are forgotten
+	previous := server.health
+	if err := server.ReloadConfig(newCfg(up, "127.0.0.1:1")); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if server.health == previous || server.handler.health != server.health {
+		t.Fatal("Expected the handler to use the new health checker after reload")
+	}
+	server.health.mu.RLock()
+	healthy, known := server.health.status["checked"][up]
+	server.health.mu.RUnlock()
+	if !known || !healthy {
+		t.Errorf("Expected %s still healthy right after reload", up)
+	}
+
+	carried := newHealthChecker(server.logger)
+	carried.inherit(server.health, newCfg("127.0.0.1:1"))
+	if _, known := carried.status["checked"][up]; known {
+		t.Errorf("Expected no result carried over for removed backend %s", up)
+	}
+}
+
+func TestServer_CacheFlush(t *testing.T) {
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300, AdminToken: "s3cret"}
+	fill := func(zoneCache *cache.ZoneCache, names ...string) {
+		for _, name := range names {
+			msg := new(dns.Msg)
+			msg.SetQuestion(name, dns.TypeA)
+			zoneCache.Set(cache.CacheKey(name, dns.TypeA, nil), msg)
+		}
+	}
+	corp := cache.NewZoneCache(100, time.Minute)
+	defer corp.Stop()
+	prod := cache.NewZoneCache(100, time.Minute)
+	defer prod.Stop()
+	fill(corp, "a.corp.local.", "b.corp.local.")
+	fill(prod, "a.prod.local.")
+
+	server := &Server{
+		runtimeCfg: runtimeCfg,
+		zoneCaches: map[string]*cache.ZoneCache{"corp": corp, "prod": prod},
+		logger:     logger.New(runtimeCfg.ToLoggingConfig()),
+	}
+	flush := func(method, target, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		server.cacheFlushHandler(rec, req)
+		return rec
+	}
+	cleared := func(rec *httptest.ResponseRecorder) map[string]int {
+		var resp cacheFlushResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Bad flush response %q: %v", rec.Body.String(), err)
+		}
+		return resp.Cleared
+	}
+
+	if rec := flush(http.MethodPost, CacheFlushPath, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a token, got %d", rec.Code)
+	}
+	if rec := flush(http.MethodPost, CacheFlushPath, "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a wrong token, got %d", rec.Code)
+	}
+	if rec := flush(http.MethodGet, CacheFlushPath, "s3cret"); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for GET, got %d", rec.Code)
+	}
+	if rec := flush(http.MethodPost, CacheFlushPath+"?zone=missing", "s3cret"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown zone, got %d", rec.Code)
+	}
+	if corp.Size() != 2 || prod.Size() != 1 {
+		t.Fatal("Expected rejected requests to leave caches alone")
+	}
+
+	rec := flush(http.MethodPost, CacheFlushPath+"?zone=corp", "s3cret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 for a zone flush, got %d", rec.Code)
+	}
+	if got := cleared(rec); len(got) != 1 || got["corp"] != 2 {
+		t.Errorf("Expected 2 entries cleared from corp only, got %v", got)
+	}
+	if corp.Size() != 0 || prod.Size() != 1 {
+		t.Errorf("Expected only corp flushed, got sizes %d and %d", corp.Size(), prod.Size())
+	}
+
+	fill(corp, "c.corp.local.")
+	rec = flush(http.MethodPost, CacheFlushPath, "s3cret")
+	if got := cleared(rec); len(got) != 2 || got["corp"] != 1 || got["prod"] != 1 {
+		t.Errorf("Expected every zone flushed, got %v", got)
+	}
+	if corp.Size() != 0 || prod.Size() != 0 {
+		t.Errorf("Expected all caches empty, got sizes %d and %d", corp.Size(), prod.Size())
+	}
+}
+
+func TestServer_CacheDump(t *testing.T) {
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300, AdminToken: "s3cret", CacheDumpMaxEntries: 2}
+	corp := cache.NewZoneCache(100, time.Minute)
+	defer corp.Stop()
+	prod := cache.NewZoneCache(100, time.Minute)
+	defer prod.Stop()
+	for i, name := range []string{"a.corp.local.", "b.corp.local.", "c.corp.local."} {
+		msg := new(dns.Msg)
+		msg.SetQuestion(name, dns.TypeA)
+		msg.Answer = append(msg.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: uint32(30 * (i + 1))},
+			A:   net.IPv4(10, 0, 0, byte(i)),
+		})
+		corp.Set(cache.CacheKey(name, dns.TypeA, nil), msg)
+	}
+
+	server := &Server{
+		runtimeCfg: runtimeCfg,
+		zoneCaches: map[string]*cache.ZoneCache{"corp": corp, "prod": prod},
+		logger:     logger.New(runtimeCfg.ToLoggingConfig()),
+	}
+	dump := func(method, target, token string) (*httptest.ResponseRecorder, cacheDumpResponse) {
+		req := httptest.NewRequest(method, target, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		server.cacheDumpHandler(rec, req)
+		var resp cacheDumpResponse
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Bad dump response %q: %v", rec.Body.String(), err)
+			}
+		}
+		return rec, resp
+	}
+
+	if rec, _ := dump(http.MethodGet, CacheDumpPath, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a token, got %d", rec.Code)
+	}
+	if rec, _ := dump(http.MethodPost, CacheDumpPath, "s3cret"); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for POST, got %d", rec.Code)
+	}
+
+	rec, resp := dump(http.MethodGet, CacheDumpPath+"?zone=corp", "s3cret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	zone, ok := resp.Zones["corp"]
+	if !ok || len(resp.Zones) != 1 {
+		t.Fatalf("Expected only corp in the dump, got %v", resp.Zones)
+	}
+	if zone.Total != 3 || !zone.Truncated || len(zone.Entries) != 2 {
+		t.Errorf("Expected 2 of 3 entries listed, got %d of %d (truncated=%v)", len(zone.Entries), zone.Total, zone.Truncated)
+	}
+	if zone.MemoryBytes != corp.MemoryUsage() {
+		t.Errorf("Expected memory %d, got %d", corp.MemoryUsage(), zone.MemoryBytes)
+	}
+	for i, entry := range zone.Entries {
+		wantTTL := int64(30 * (i + 1))
+		if entry.TTL > wantTTL || entry.TTL < wantTTL-2 || entry.Stale || entry.Size <= 0 {
+			t.Errorf("Expected entry %s with about %ds left, got %+v", entry.Key, wantTTL, entry)
+		}
+	}
+
+	_, resp = dump(http.MethodGet, CacheDumpPath, "s3cret")
+	if len(resp.Zones) != 2 || resp.Zones["prod"].Total != 0 || resp.Zones["prod"].Entries == nil {
+		t.Errorf("Expected every zone in the dump, got %v", resp.Zones)
+	}
+}
+
+func TestServer_Readiness(t *testing.T) {
+	backendCfg := config.BackendConfig{DNSServers: []string{"10.0.0.1:53", "10.0.0.2:53"}}
+	zone := &config.Zone{
+		Domains:     []string{"*.checked.local"},
+		Backend:     backendCfg,
+		HealthCheck: &config.HealthCheck{},
+	}
+	cfg := &config.Config{
+		Zones: map[string]*config.Zone{
+			`che"ck\ed`: zone,
+			"unchecked": {Domains: []string{"*.unchecked.local"}, Backend: backendCfg},
+		},
+	}
+
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+	hc := newHealthChecker(logger.New(runtimeCfg.ToLoggingConfig()))
+	server := &Server{config: cfg, runtimeCfg: runtimeCfg, health: hc}
+	server.dnsServing.Store(true)
+
+	ready := func() int {
+		rec := httptest.NewRecorder()
+		server.readyHandler(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+		return rec.Code
+	}
+
+	// Without a minimum the server is ready before any probe
+	if code := ready(); code != http.StatusOK {
+		t.Errorf("Expected 200 without a minimum, got %d", code)
+	}
+
+	// Backends not probed yet don't count towards the minimum
+	runtimeCfg.MinHealthyBackends = 2
+	if code := ready(); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 before any probe, got %d", code)
+	}
+
+	hc.status[`che"ck\ed`] = map[string]bool{"10.0.0.1:53": true, "10.0.0.2:53": false}
+	if code := ready(); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 with 1 of 2 backends healthy, got %d", code)
+	}
+
+	// Zone names are escaped in the JSON body
+	rec := httptest.NewRecorder()
+	server.readyHandler(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	var body readyResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Expected a JSON body, got %s: %v", rec.Body, err)
+	}
+	if body.Status != "not ready" || !slices.Equal(body.Zones, []string{`che"ck\ed`}) {
+		t.Errorf("Expected the unready zone listed, got %+v", body)
+	}
+
+	// The zone minimum overrides the runtime one
+	zone.HealthCheck.MinHealthy = 1
+	if code := ready(); code != http.StatusOK {
+		t.Errorf("Expected 200 with zone minimum 1, got %d", code)
+	}
+	zone.HealthCheck.MinHealthy = 0
+
+	// The runtime minimum is capped at the zone's backend count
+	runtimeCfg.MinHealthyBackends = 5
+	hc.status[`che"ck\ed`]["10.0.0.2:53"] = true
+	if code := ready(); code != http.StatusOK {
+		t.Errorf("Expected 200 with every backend healthy, got %d", code)
+	}
+}
+
+func TestServer_ReadinessStartup(t *testing.T) {
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+	server := &Server{config: &config.Config{}, runtimeCfg: runtimeCfg}
+
+	probe := func(handler http.HandlerFunc, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	// Before the DNS listener serves, only liveness passes
+	if rec := probe(server.readyHandler, "/ready"); rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "DNS listener") {
+		t.Errorf("Expected 503 before the listener is up, got %d %s", rec.Code, rec.Body)
+	}
+	if rec := probe(server.healthHandler, "/health"); rec.Code != http.StatusOK {
+		t.Errorf("Expected liveness 200 while starting, got %d", rec.Code)
+	}
+
+	server.dnsServing.Store(true)
+	if rec := probe(server.readyHandler, "/ready"); rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 once the listener is up, got %d %s", rec.Code, rec.Body)
+	}
+
+	// In TSNet mode the node must also have joined the tailnet
+	noIPs := func() (net.IP, net.IP) { return nil, nil }
+	if reason := server.notReadyReason(noIPs); reason == "" {
+		t.Error("Expected not ready without a Tailscale address")
+	}
+	v6Only := func() (net.IP, net.IP) { return nil, net.ParseIP("fd7a:115c:a1e0::1") }
+	if reason := server.notReadyReason(v6Only); reason != "" {
+		t.Errorf("Expected ready with a Tailscale address, got %q", reason)
+	}
+
+	server.Stop()
+	if rec := probe(server.readyHandler, "/ready"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 after stopping, got %d", rec.Code)
+	}
+}
+
+func TestDNSHandler_Warmup(t *testing.T) {
+	var backendQueries atomic.Int32
+	backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		backendQueries.Add(1)
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		if r.Question[0].Qtype == dns.TypeA {
+			msg.Answer = append(msg.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+				A:   net.IPv4(10, 0, 0, 1),
+			})
+		}
+		_ = w.WriteMsg(msg)
+	})
+
+	backendCfg := config.BackendConfig{DNSServers: []string{backend}, Timeout: "1s", Retries: 1}
+	cfg := &config.Config{
+		Global: config.GlobalConfig{Backend: backendCfg, Warmup: []string{"api.test.local"}},
+		Zones: map[string]*config.Zone{
+			"test": {
+				Domains: []string{"*.test.local"},
+				Backend: backendCfg,
+				Warmup:  []string{"api.test.local.", "db.test.local"},
+			},
+		},
+	}
+
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+	log := logger.New(runtimeCfg.ToLoggingConfig())
+	handler := &TailscaleDNSHandler{
+		config:     cfg,
+		runtimeCfg: runtimeCfg,
+		forwarder:  NewForwarder(backendCfg, log),
+		logger:     log,
+		zoneCaches: map[string]*cache.ZoneCache{"test": cache.NewZoneCache(100, time.Minute)},
+	}
+
+	handler.warmup()
+
+	// Two names, each warmed for A and AAAA
+	if got := backendQueries.Load(); got != 4 {
+		t.Fatalf("Expected 4 warmup queries, got %d", got)
+	}
+
+	req := new(dns.Msg)
+	req.SetQuestion("db.test.local.", dns.TypeA)
+	w := &testResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53}}
+	handler.ServeDNS(w, req)
+
+	if w.msg == nil || len(w.msg.Answer) != 1 {
+		t.Fatalf("Expected cached answer, got %v", w.msg)
+	}
+	if got := backendQueries.Load(); got != 4 {
+		t.Errorf("Expected query to be answered from the warmed cache, backend saw %d queries", got)
+	}
+}
+
+func TestDNSHandler_NegativeCaching(t *testing.T) {
+	var backendQueries atomic.Int32
+	backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		backendQueries.Add(1)
+		msg := new(dns.Msg)
+		msg.SetRcode(r, dns.RcodeNameError)
+		msg.Ns = append(msg.Ns, &dns.SOA{
+			Hdr:    dns.RR_Header{Name: "test.local.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 300},
+			Ns:     "ns.test.local.",
+			Mbox:   "admin.test.local.",
+			Minttl: 30,
+		})
+		_ = w.WriteMsg(msg)
+	})
+
+	backendCfg := config.BackendConfig{DNSServers: []string{backend}, Timeout: "1s", Retries: 1}
+	cfg := &config.Config{
+		Global: config.GlobalConfig{Backend: backendCfg},
+		Zones: map[string]*config.Zone{
+			"test": {Domains: []string{"*.test.local"}, Backend: backendCfg},
+		},
+	}
+
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+	log := logger.New(runtimeCfg.ToLoggingConfig())
+	handler := &TailscaleDNSHandler{
+		config:     cfg,
+		runtimeCfg: runtimeCfg,
+		forwarder:  NewForwarder(backendCfg, log),
+		logger:     log,
+		zoneCaches: map[string]*cache.ZoneCache{"test": cache.NewZoneCache(100, time.Hour)},
+	}
+
+	for i := 0; i < 2; i++ {
+		req := new(dns.Msg)
+		req.SetQuestion("missing.test.local.", dns.TypeA)
+		w := &testResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53}}
+		handler.ServeDNS(w, req)
+
+		if w.msg == nil || w.msg.Rcode != dns.RcodeNameError {
+			t.Fatalf("Query %d: expected NXDOMAIN, got %v", i+1, w.msg)
+		}
+	}
+
+	if got := backendQueries.Load(); got != 1 {
+		t.Errorf("Expected second NXDOMAIN to be served from cache, backend saw %d queries", got)
+	}
+}
+
+func TestDNSHandler_Via6PTR(t *testing.T) {
+	var ip6Queries atomic.Int32
+	backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		q := r.Question[0]
+		if strings.HasSuffix(q.Name, ".ip6.arpa.") {
+			ip6Queries.Add(1)
+		}
+		switch q.Qtype {
+		case dns.TypeA:
+			msg.Answer = append(msg.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   net.IPv4(10, 0, 0, 5),
+			})
+		case dns.TypePTR:
+			msg.Answer = append(msg.Answer, &dns.PTR{
+				Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: 60},
+				Ptr: "web.cluster.local.",
+			})
+		}
+		_ = w.WriteMsg(msg)
+	})
+
+	backendCfg := config.BackendConfig{DNSServers: []string{backend}, Timeout: "1s", Retries: 1}
+	cfg := &config.Config{
+		Global: config.GlobalConfig{Backend: backendCfg},
+		Zones: map[string]*config.Zone{
+			"cluster": {
+				Domains:         []string{"*.cluster1.local"},
+				ReflectedDomain: "cluster.local",
+				TranslateID:     func() *uint16 { v := uint16(7); return &v }(),
+				Backend:         backendCfg,
+			},
+		},
+	}
+
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+	log := logger.New(runtimeCfg.ToLoggingConfig())
+	via6Trans, err := via6.NewTranslator(cfg, log)
+	if err != nil {
+		t.Fatalf("Failed to create translator: %v", err)
+	}
+
+	handler := &TailscaleDNSHandler{
+		config:     cfg,
+		runtimeCfg: runtimeCfg,
+		via6Trans:  via6Trans,
+		forwarder:  NewForwarder(backendCfg, log),
+		logger:     log,
+		zoneCaches: make(map[string]*cache.ZoneCache),
+	}
+	query := func(name string, qtype uint16) *dns.Msg {
+		req := new(dns.Msg)
+		req.SetQuestion(name, qtype)
+		w := &testResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53}}
+		handler.ServeDNS(w, req)
+		if w.msg == nil {
+			t.Fatalf("No response for %s", name)
+		}
+		return w.msg
+	}
+
+	forward := query("web.cluster1.local.", dns.TypeAAAA)
+	if len(forward.Answer) != 1 {
+		t.Fatalf("Expected one AAAA, got %v", forward.Answer)
+	}
+	via6IP := forward.Answer[0].(*dns.AAAA).AAAA
+
+	reverseName, _ := dns.ReverseAddr(via6IP.String())
+	reverse := query(reverseName, dns.TypePTR)
+	if len(reverse.Answer) != 1 {
+		t.Fatalf("Expected one PTR, got %v", reverse)
+	}
+	ptr, ok := reverse.Answer[0].(*dns.PTR)
+	if !ok || ptr.Ptr != "web.cluster1.local." {
+		t.Fatalf("Expected PTR to web.cluster1.local., got %v", reverse.Answer[0])
+	}
+
+	again := query(ptr.Ptr, dns.TypeAAAA)
+	if len(again.Answer) != 1 || !again.Answer[0].(*dns.AAAA).AAAA.Equal(via6IP) {
+		t.Errorf("Expected %s to translate back to %s, got %v", ptr.Ptr, via6IP, again.Answer)
+	}
+
+	// The rest of the 4via6 range is answered locally, never forwarded
+	unknownName, _ := dns.ReverseAddr("fd7a:115c:a1e0:b1a:0:63:a00:5")
+	knownNetwork := strings.SplitN(reverseName, ".", 9)[8] // The translateID 7 /96
+	knownParent := strings.SplitN(reverseName, ".", 10)[9]
+	networkAddr, _ := dns.ReverseAddr("fd7a:115c:a1e0:b1a:0:7:0:0")
+	negatives := []struct {
+		name      string
+		qtype     uint16
+		wantRcode int
+	}{
+		{unknownName, dns.TypePTR, dns.RcodeNameError},
+		{"3.6.0.0.0.0.0.0.a.1.b.0.0.e.1.a.c.5.1.1.a.7.d.f.ip6.arpa.", dns.TypePTR, dns.RcodeNameError},
+		{knownParent, dns.TypePTR, dns.RcodeSuccess},
+		// A zone's network has no host to answer for, whatever the type
+		{knownNetwork, dns.TypePTR, dns.RcodeNameError},
+		{networkAddr, dns.TypePTR, dns.RcodeNameError},
+		{networkAddr, dns.TypeTXT, dns.RcodeNameError},
+	}
+	for _, tt := range negatives {
+		resp := query(tt.name, tt.qtype)
+		if resp.Rcode != tt.wantRcode || len(resp.Answer) != 0 {
+			t.Errorf("%s: expected %s with no answers, got %v", tt.name, dns.RcodeToString[tt.wantRcode], resp)
+		}
+	}
+
+	runtimeCfg.Via6ApexAnswer = config.Via6ApexNoData
+	for _, name := range []string{knownNetwork, networkAddr} {
+		if resp := query(name, dns.TypePTR); resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 0 {
+			t.Errorf("%s: expected NODATA under the nodata answer, got %v", name, resp)
+		}
+	}
+	if got := ip6Queries.Load(); got != 0 {
+		t.Errorf("Expected no ip6.arpa queries to reach the backend, got %d", got)
+	}
+	if got := handler.Stats().Paths["4via6-apex"]; got != 5 {
+		t.Errorf("Expected 5 queries counted under 4via6-apex, got %d", got)
+	}
+
+	// Trace queries report the network name's answer
+	runtimeCfg.AllowTraceQueries = true
+	trace := query("_trace."+networkAddr, dns.TypeTXT)
+	if len(trace.Answer) != 1 || !slices.Contains(trace.Answer[0].(*dns.TXT).Txt, "path=4via6-apex") || !slices.Contains(trace.Answer[0].(*dns.TXT).Txt, "answer=nodata") {
+		t.Errorf("Expected trace of the 4via6 apex with the nodata answer, got %v", trace)
+	}
+}
+
+func TestDNSHandler_EDNS0(t *testing.T) {
+	backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		msg.Answer = append(msg.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.IPv4(10, 0, 0, 1),
+		})
+		if r.IsEdns0() != nil {
+			msg.SetEdns0(1400, false)
+		}
+		_ = w.WriteMsg(msg)
+	})
+
+	backendCfg := config.BackendConfig{DNSServers: []string{backend}, Timeout: "1s", Retries: 1}
+	cfg := &config.Config{
+		Global: config.GlobalConfig{Backend: backendCfg},
+		Zones: map[string]*config.Zone{
+			"test": {Domains: []string{"*.test.local"}, Backend: backendCfg},
+		},
+	}
+
+	// An answer too large for a plain UDP client, cached as if for an EDNS client
+	big := new(dns.Msg)
+	big.SetQuestion("big.test.local.", dns.TypeA)
+	big.Response = true
+	for i := 0; i < 100; i++ {
+		big.Answer = append(big.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: "big.test.local.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+			A:   net.IPv4(10, 0, 1, byte(i)),
+		})
+	}
+	big.SetEdns0(4096, false)
+	zoneCache := cache.NewZoneCache(100, time.Minute)
+	zoneCache.Set(cache.CacheKey("big.test.local.", dns.TypeA, nil), big)
+
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+	log := logger.New(runtimeCfg.ToLoggingConfig())
+	handler := &TailscaleDNSHandler{
+		config:     cfg,
+		runtimeCfg: runtimeCfg,
+		forwarder:  NewForwarder(backendCfg, log),
+		logger:     log,
+		zoneCaches: map[string]*cache.ZoneCache{"test": zoneCache},
+	}
+	query := func(name string, udpSize uint16, remoteAddr net.Addr) *dns.Msg {
+		req := new(dns.Msg)
+		req.SetQuestion(name, dns.TypeA)
+		if udpSize > 0 {
+			req.SetEdns0(udpSize, false)
+		}
+		w := &testResponseWriter{remoteAddr: remoteAddr}
+		handler.ServeDNS(w, req)
+		if w.msg == nil {
+			t.Fatalf("No response for %s", name)
+		}
+		return w.msg
+	}
+	udpClient := &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53}
+
+	t.Run("4096-byte buffer", func(t *testing.T) {
+		resp := query("big.test.local.", 4096, udpClient)
+		if resp.Truncated || len(resp.Answer) != 100 {
+			t.Fatalf("Expected all 100 answers untruncated, got %d (TC=%v)", len(resp.Answer), resp.Truncated)
+		}
+		opt := resp.IsEdns0()
+		if opt == nil {
+			t.Fatal("Expected OPT record in response to EDNS client")
+		}
+		if opt.UDPSize() != 4096 {
+			t.Errorf("Expected cached OPT to be kept, got UDP size %d", opt.UDPSize())
+		}
+	})
+
+	t.Run("512-byte client", func(t *testing.T) {
+		resp := query("big.test.local.", 0, udpClient)
+		if !resp.Truncated {
+			t.Error("Expected TC bit for a response exceeding 512 bytes")
+		}
+		if resp.IsEdns0() != nil {
+			t.Error("Expected no OPT record for a client without EDNS")
+		}
+		if size := resp.Len(); size > dns.MinMsgSize {
+			t.Errorf("Expected response within 512 bytes, got %d", size)
+		}
+	})
+
+	t.Run("no truncation over TCP", func(t *testing.T) {
+		resp := query("big.test.local.", 0, &net.TCPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53})
+		if resp.Truncated || len(resp.Answer) != 100 {
+			t.Errorf("Expected all 100 answers over TCP, got %d (TC=%v)", len(resp.Answer), resp.Truncated)
+		}
+	})
+
+	t.Run("forwarded OPT", func(t *testing.T) {
+		resp := query("small.test.local.", 4096, udpClient)
+		opt := resp.IsEdns0()
+		if opt == nil {
+			t.Fatal("Expected OPT record in response to EDNS client")
+		}
+		if opt.UDPSize() != 1400 {
+			t.Errorf("Expected upstream OPT UDP size 1400 to be preserved, got %d", opt.UDPSize())
+		}
+
+		// Served from the cache entry stored for the EDNS client above
+		resp = query("small.test.local.", 0, udpClient)
+		if resp.IsEdns0() != nil {
+			t.Error("Expected no OPT record for a client without EDNS")
+		}
+	})
+}
+
+func TestDNSHandler_NSID(t *testing.T) {
+	backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		msg.Answer = append(msg.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.IPv4(10, 0, 0, 1),
+		})
+		// Backends identify themselves too when the NSID request is forwarded
+		if opt := r.IsEdns0(); opt != nil {
+			msg.SetEdns0(1400, false)
+			if hasNSID(opt) {
+				msg.IsEdns0().Option = append(msg.IsEdns0().Option, &dns.EDNS0_NSID{Code: dns.EDNS0NSID, Nsid: hex.EncodeToString([]byte("backend-1"))})
+			}
+		}
+		_ = w.WriteMsg(msg)
+	})
+
+	backendCfg := config.BackendConfig{DNSServers: []string{backend}, Timeout: "1s", Retries: 1}
+	cfg := &config.Config{
+		Global: config.GlobalConfig{Backend: backendCfg},
+		Zones: map[string]*config.Zone{
+			"test": {Domains: []string{"*.test.local"}, Backend: backendCfg},
+		},
+	}
+
+	query := func(policy string, requestNSID bool) *dns.Msg {
+		runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300, NSID: "tsdns-pod-0", VersionQueries: policy}
+		log := logger.New(runtimeCfg.ToLoggingConfig())
+		handler := &TailscaleDNSHandler{
+			config:     cfg,
+			runtimeCfg: runtimeCfg,
+			forwarder:  NewForwarder(backendCfg, log),
+			logger:     log,
+			zoneCaches: make(map[string]*cache.ZoneCache),
+		}
+		req := new(dns.Msg)
+		req.SetQuestion("app.test.local.", dns.TypeA)
+		req.SetEdns0(4096, false)
+		if requestNSID {
+			req.IsEdns0().Option = append(req.IsEdns0().Option, &dns.EDNS0_NSID{Code: dns.EDNS0NSID})
+		}
+		w := &testResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53}}
+		handler.ServeDNS(w, req)
+		if w.msg == nil || w.msg.IsEdns0() == nil {
+			t.Fatalf("Expected response with OPT record, got %v", w.msg)
+		}
+		return w.msg
+	}
+	nsids := func(resp *dns.Msg) []string {
+		var ids []string
+		for _, option := range resp.IsEdns0().Option {
+			if nsid, ok := option.(*dns.EDNS0_NSID); ok {
+				id, _ := hex.DecodeString(nsid.Nsid)
+				ids = append(ids, string(id))
+			}
+		}
+		return ids
+	}
+
+	if got := nsids(query("", true)); len(got) != 1 || got[0] != "tsdns-pod-0" {
+		t.Errorf("Expected our NSID in place of the backend's, got %q", got)
+	}
+	if got := nsids(query("", false)); len(got) != 0 {
+		t.Errorf("Expected no NSID without a request, got %q", got)
+	}
+	// Hidden like hostname.bind, without leaking the backend's either
+	if got := nsids(query(config.VersionQueriesNone, true)); len(got) != 0 {
+		t.Errorf("Expected no NSID when identity is hidden, got %q", got)
+	}
+}
+
+func TestEDNSResponseWriter_DefaultSize(t *testing.T) {
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeA)
+	req.SetEdns0(4096, true)
+
+	inner := &testResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53}}
+	w := &ednsResponseWriter{ResponseWriter: inner, req: req, udpSize: (&config.RuntimeConfig{}).EDNSUDPSize()}
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	_ = w.WriteMsg(resp)
+
+	opt := inner.msg.IsEdns0()
+	if opt == nil {
+		t.Fatal("Expected OPT record in response")
+	}
+	if opt.UDPSize() != config.DefaultEDNSBufferSize {
+		t.Errorf("Expected advertised UDP size %d, got %d", config.DefaultEDNSBufferSize, opt.UDPSize())
+	}
+	if !opt.Do() {
+		t.Error("Expected DO bit to be echoed")
+	}
+}
+
+func TestDNSHandler_ResponseTimeFloor(t *testing.T) {
+	cfg := &config.Config{
+		Zones: map[string]*config.Zone{
+			"secure": {Domains: []string{"*.secure.local"}, ResponseTimeFloor: "50ms"},
+			"fast":   {Domains: []string{"*.fast.local"}},
+		},
+	}
+
+	answer := func(name string) *dns.Msg {
+		msg := new(dns.Msg)
+		msg.SetQuestion(name, dns.TypeA)
+		msg.Response = true
+		msg.Answer = append(msg.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+			A:   net.IPv4(10, 0, 0, 1),
+		})
+		return msg
+	}
+	zoneCaches := map[string]*cache.ZoneCache{
+		"secure": cache.NewZoneCache(100, time.Minute),
+		"fast":   cache.NewZoneCache(100, time.Minute),
+	}
+	zoneCaches["secure"].Set(cache.CacheKey("api.secure.local.", dns.TypeA, nil), answer("api.secure.local."))
+	zoneCaches["fast"].Set(cache.CacheKey("api.fast.local.", dns.TypeA, nil), answer("api.fast.local."))
+
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+	handler := &TailscaleDNSHandler{
+		config:     cfg,
+		runtimeCfg: runtimeCfg,
+		logger:     logger.New(runtimeCfg.ToLoggingConfig()),
+		zoneCaches: zoneCaches,
+	}
+	query := func(name string) time.Duration {
+		req := new(dns.Msg)
+		req.SetQuestion(name, dns.TypeA)
+		w := &testResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53}}
+		start := time.Now()
+		handler.ServeDNS(w, req)
+		elapsed := time.Since(start)
+		if w.msg == nil || len(w.msg.Answer) != 1 {
+			t.Fatalf("Expected cached answer for %s, got %v", name, w.msg)
+		}
+		return elapsed
+	}
+
+	if elapsed := query("api.secure.local."); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected cache hit to be delayed to the 50ms floor, took %v", elapsed)
+	}
+	if elapsed := query("api.fast.local."); elapsed >= 50*time.Millisecond {
+		t.Errorf("Expected zone without floor to answer at once, took %v", elapsed)
+	}
+
+	// The floor is waited out after the reload lock is released
+	w := &reloadProbeWriter{testResponseWriter: testResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53}}, handler: handler}
+	req := new(dns.Msg)
+	req.SetQuestion("api.secure.local.", dns.TypeA)
+	handler.ServeDNS(w, req)
+	if w.msg == nil || !w.unlocked {
+		t.Error("Expected the floored response to be written outside the reload lock")
+	}
+}
+
+// reloadProbeWriter records whether a reload could have taken the lock when
+// the response was written
+type reloadProbeWriter struct {
+	testResponseWriter
+	handler  *TailscaleDNSHandler
+	unlocked bool
+}
+
+func (w *reloadProbeWriter) WriteMsg(m *dns.Msg) error {
+	if w.handler.reloadMu.TryLock() {
+		w.unlocked = true
+		w.handler.reloadMu.Unlock()
+	}
+	return w.testResponseWriter.WriteMsg(m)
+}
+
+func TestDNSHandler_TruncatesOversizedUDP(t *testing.T) {
+	// Like a real server, the backend truncates UDP answers that don't fit and
+	// sends the whole answer over TCP
+	backend := startTestBackendUDPTCP(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		for i := 0; i < 100; i++ {
+			msg.Answer = append(msg.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   net.IPv4(10, 0, 1, byte(i)),
+			})
+		}
+		if isUDP(w) {
+			msg.Truncate(dns.MinMsgSize)
+		}
+		_ = w.WriteMsg(msg)
+	})
+
+	backendCfg := config.BackendConfig{DNSServers: []string{backend}, Timeout: "1s", Retries: 1}
+	cfg := &config.Config{
+		Global: config.GlobalConfig{Backend: backendCfg},
+		Zones: map[string]*config.Zone{
+			"test": {Domains: []string{"*.test.local"}, Backend: backendCfg},
+		},
+	}
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+	log := logger.New(runtimeCfg.ToLoggingConfig())
+	handler := &TailscaleDNSHandler{
+		config:     cfg,
+		runtimeCfg: runtimeCfg,
+		forwarder:  NewForwarder(backendCfg, log),
+		logger:     log,
+		zoneCaches: map[string]*cache.ZoneCache{"test": cache.NewZoneCache(100, time.Minute)},
+	}
+
+	for _, tt := range []struct {
+		name        string
+		remoteAddr  net.Addr
+		wantTC      bool
+		wantAnswers int
+	}{
+		// The first query fills the cache; the rest are served from it
+		{"tcp", &net.TCPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53}, false, 100},
+		{"udp", &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53}, true, -1},
+		{"tcp again", &net.TCPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53}, false, 100},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := new(dns.Msg)
+			req.SetQuestion("big.test.local.", dns.TypeA)
+			w := &testResponseWriter{remoteAddr: tt.remoteAddr}
+			handler.ServeDNS(w, req)
+
+			if w.msg == nil {
+				t.Fatal("No response")
+			}
+			if w.msg.Truncated != tt.wantTC {
+				t.Errorf("Expected TC=%v, got %v", tt.wantTC, w.msg.Truncated)
+			}
+			if tt.wantAnswers >= 0 && len(w.msg.Answer) != tt.wantAnswers {
+				t.Errorf("Expected %d answers, got %d", tt.wantAnswers, len(w.msg.Answer))
+			}
+			if packed, err := w.msg.Pack(); err != nil {
+				t.Errorf("Failed to pack response: %v", err)
+			} else if tt.wantTC && len(packed) > dns.MinMsgSize {
+				t.Errorf("Expected UDP response within %d bytes, got %d", dns.MinMsgSize, len(packed))
+			}
+		})
+	}
+}
+
+func TestForwarder_TruncatesForDirectCallers(t *testing.T) {
+	backend := startTestBackendUDPTCP(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		for i := 0; i < 100; i++ {
+			msg.Answer = append(msg.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   net.IPv4(10, 0, 1, byte(i)),
+			})
+		}
+		if isUDP(w) {
+			msg.Truncate(dns.MinMsgSize)
+		}
+		_ = w.WriteMsg(msg)
+	})
+
+	backendCfg := config.BackendConfig{DNSServers: []string{backend}, Timeout: "1s", Retries: 1}
+	forwarder := NewForwarder(backendCfg, logger.New(config.LoggingConfig{Level: "error"}))
+
+	req := new(dns.Msg)
+	req.SetQuestion("big.test.local.", dns.TypeA)
+	w := &testResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53}}
+	forwarder.Forward(w, req)
+
+	if w.msg == nil || !w.msg.Truncated {
+		t.Fatalf("Expected truncated response, got %v", w.msg)
+	}
+	if size := w.msg.Len(); size > dns.MinMsgSize {
+		t.Errorf("Expected response within %d bytes, got %d", dns.MinMsgSize, size)
+	}
+}
+
+func TestMaxZones(t *testing.T) {
+	backendCfg := config.BackendConfig{DNSServers: []string{"10.0.0.10:53"}, Timeout: "1s", Retries: 1}
+	zones := func(names ...string) *config.Config {
+		cfg := &config.Config{
+			Global: config.GlobalConfig{Backend: backendCfg},
+			Zones:  make(map[string]*config.Zone),
+		}
+		for _, name := range names {
+			cfg.Zones[name] = &config.Zone{Domains: []string{"*." + name + ".local"}, Backend: backendCfg}
+		}
+		return cfg
+	}
+	runtimeCfg := &config.RuntimeConfig{DNSPort: 5353, BindAddress: "127.0.0.1", DefaultTTL: 300, MaxZones: 2}
+
+	if _, err := NewServerWithRuntime(zones("a", "b", "c"), runtimeCfg); err == nil {
+		t.Fatal("Expected startup to fail with more zones than the limit")
+	}
+
+	server, err := NewServerWithRuntime(zones("a", "b"), runtimeCfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	if err := server.ReloadConfig(zones("a", "b", "c")); err == nil {
+		t.Fatal("Expected reload to fail with more zones than the limit")
+	}
+	if len(server.config.Zones) != 2 {
+		t.Errorf("Expected rejected reload to leave the 2 zones in place, got %d", len(server.config.Zones))
+	}
+
+	// Replacing a zone frees its place under the limit
+	if err := server.ReloadConfig(zones("a", "c")); err != nil {
+		t.Fatalf("Reload replacing a zone failed: %v", err)
+	}
+	for name, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, registered := server.memoryMonitor.GetZoneUsage(name); registered != want {
+			t.Errorf("Zone %s monitored = %v, want %v", name, registered, want)
+		}
+	}
+}
+
+func TestServer_ReloadDuringQueries(t *testing.T) {
+	backend := startTestBackend(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		msg.Answer = append(msg.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+			A:   net.ParseIP("10.0.0.1"),
+		})
+		_ = w.WriteMsg(msg)
+	})
+	backendCfg := config.BackendConfig{DNSServers: []string{backend}, Timeout: "1s", Retries: 1}
+	newCfg := func(ttl string) *config.Config {
+		return &config.Config{
+			Global: config.GlobalConfig{Backend: backendCfg},
+			Zones: map[string]*config.Zone{
+				"corp": {
+					Domains: []string{"*.corp.local"},
+					Backend: backendCfg,
+					Cache:   &config.CacheConfig{MaxSize: 100, TTL: ttl},
+				},
+			},
+		}
+	}
+	runtimeCfg := &config.RuntimeConfig{DNSPort: 5353, BindAddress: "127.0.0.1", DefaultTTL: 300, AdminToken: "s3cret", LogLevel: "error"}
+	server, err := NewServerWithRuntime(newCfg("1m"), runtimeCfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	defer server.Stop()
+
+	// Run with -race: reloads swap what queries and admin requests read
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				req := new(dns.Msg)
+				req.SetQuestion(fmt.Sprintf("host%d.corp.local.", j), dns.TypeA)
+				w := &testResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53}}
+				server.handler.ServeDNS(w, req)
+				if w.msg == nil || w.msg.Rcode != dns.RcodeSuccess {
+					t.Errorf("Expected an answer during reloads, got %v", w.msg)
+					return
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for j := 0; j < 20; j++ {
+			req := httptest.NewRequest(http.MethodGet, CacheDumpPath, nil)
+			req.Header.Set("Authorization", "Bearer s3cret")
+			server.cacheDumpHandler(httptest.NewRecorder(), req)
+			_ = server.unreadyZones()
+		}
+	}()
+	for _, ttl := range []string{"2m", "3m", "4m"} {
+		if err := server.ReloadConfig(newCfg(ttl)); err != nil {
+			t.Errorf("Reload failed: %v", err)
+		}
+	}
+	wg.Wait()
+}
+
+func TestServer_TailscalePeerMetrics(t *testing.T) {
+	metrics.UpdateTailscalePeers(7)
+	if got := testutil.ToFloat64(metrics.TailscalePeersOnline); got != 7 {
+		t.Errorf("Expected online peers gauge 7, got %v", got)
+	}
+
+	status := &ipnstate.Status{
+		Peer: map[key.NodePublic]*ipnstate.PeerStatus{
+			key.NewNode().Public(): {HostName: "web", Online: true},
+			key.NewNode().Public(): {HostName: "db", Online: true},
+			key.NewNode().Public(): {HostName: "laptop", Online: false},
+		},
+	}
+	var fail atomic.Bool
+	getStatus := func(context.Context) (*ipnstate.Status, error) {
+		if fail.Load() {
+			return nil, fmt.Errorf("tailscaled unavailable")
+		}
+		return status, nil
+	}
+
+	runtimeCfg := &config.RuntimeConfig{DefaultTTL: 300}
+	s := &Server{logger: logger.New(runtimeCfg.ToLoggingConfig())}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.pollTailscaleMetrics(ctx, 5*time.Millisecond, getStatus)
+		close(done)
+	}()
+
+	waitFor := func(cond func() bool) bool {
+		deadline := time.Now().Add(2 * time.Second)
+		for !cond() && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		return cond()
+	}
+	if !waitFor(func() bool { return testutil.ToFloat64(metrics.TailscalePeersOnline) == 2 }) {
+		t.Errorf("Expected online peers gauge 2, got %v", testutil.ToFloat64(metrics.TailscalePeersOnline))
+	}
+	if got := testutil.ToFloat64(metrics.TailscalePeersTotal); got != 3 {
+		t.Errorf("Expected total peers gauge 3, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.TailscaleStatus); got != 1 {
+		t.Errorf("Expected Tailscale status up, got %v", got)
+	}
+
+	// A failed status lookup marks Tailscale down
+	fail.Store(true)
+	if !waitFor(func() bool { return testutil.ToFloat64(metrics.TailscaleStatus) == 0 }) {
+		t.Error("Expected Tailscale status down after a failed lookup")
+	}
+
+	cancel()
+	<-done
+}
@@ -0,0 +1,60 @@
+package dns
+
+import (
+	"context"
+	"net/netip"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// slowQueryWriter records when and how a query was answered so queries over
+// the slow query threshold can be logged with a latency breakdown
+type slowQueryWriter struct {
+	dns.ResponseWriter
+	start   time.Time
+	path    string        // How the query was answered, as in trace queries
+	resolve time.Duration // Time until the response was ready to write
+	write   time.Duration // Time spent writing the response
+	rcode   int
+	answers int
+	written bool
+}
+
+func (w *slowQueryWriter) WriteMsg(m *dns.Msg) error {
+	ready := time.Now()
+	err := w.ResponseWriter.WriteMsg(m)
+	if !w.written {
+		w.resolve = ready.Sub(w.start)
+		w.write = time.Since(ready)
+		w.rcode = m.Rcode
+		w.answers = len(m.Answer)
+		w.written = true
+	}
+	return err
+}
+
+// setPath records how the query is answered
+func (w *slowQueryWriter) setPath(path string) {
+	w.path = path
+}
+
+// logSlowQuery logs the query if it took longer than the slow query threshold
+func (h *TailscaleDNSHandler) logSlowQuery(ctx context.Context, w *slowQueryWriter, r *dns.Msg, clientIP netip.Addr, zoneName string) {
+	total := time.Since(w.start)
+	if total < h.runtimeCfg.SlowQueryThreshold {
+		return
+	}
+
+	args := []any{"client", clientIP.String(), "path", w.path, "total", total}
+	if len(r.Question) > 0 {
+		args = append(args, "name", r.Question[0].Name, "type", dns.TypeToString[r.Question[0].Qtype])
+	}
+	if w.written {
+		args = append(args, "resolve", w.resolve, "write", w.write,
+			"rcode", dns.RcodeToString[w.rcode], "answers", w.answers)
+	} else {
+		args = append(args, "rcode", "none")
+	}
+	h.log(ctx).ZoneWarn(zoneName, "Slow DNS query", args...)
+}
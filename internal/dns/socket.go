@@ -0,0 +1,82 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+)
+
+// bufferedConn is implemented by packet conns whose socket buffers can be sized
+// (*net.UDPConn; netstack conns created by TSNet are not)
+type bufferedConn interface {
+	SetReadBuffer(bytes int) error
+	SetWriteBuffer(bytes int) error
+}
+
+// tuneUDPConn applies the configured socket buffer sizes to pc (0 keeps the OS
+// default) and reports the effective sizes, which are 0 when unknown. It
+// returns false if pc does not expose socket buffers.
+func tuneUDPConn(pc net.PacketConn, recvBufSize, sendBufSize int) (effectiveRecv, effectiveSend int, ok bool, err error) {
+	conn, ok := pc.(bufferedConn)
+	if !ok {
+		return 0, 0, false, nil
+	}
+
+	if recvBufSize > 0 {
+		if err := conn.SetReadBuffer(recvBufSize); err != nil {
+			return 0, 0, true, err
+		}
+	}
+	if sendBufSize > 0 {
+		if err := conn.SetWriteBuffer(sendBufSize); err != nil {
+			return 0, 0, true, err
+		}
+	}
+
+	effectiveRecv, effectiveSend = socketBufferSizes(pc)
+	return effectiveRecv, effectiveSend, true, nil
+}
+
+// listenConfig returns the config for OS listeners. With reusePort the sockets
+// get SO_REUSEPORT so a new process can bind the port while the old one drains.
+func listenConfig(reusePort bool) *net.ListenConfig {
+	lc := &net.ListenConfig{}
+	if reusePort {
+		lc.Control = setReusePort
+	}
+	return lc
+}
+
+// bindError explains a failure to bind a DNS listener on addr. Permission
+// errors get a hint, since ports below 1024 need privileges.
+func bindError(addr string, err error) error {
+	if errors.Is(err, os.ErrPermission) {
+		return fmt.Errorf("failed to bind DNS server on %s: %w (grant CAP_NET_BIND_SERVICE or use a port above 1023 via --dns-port/TSDNS_DNS_PORT)", addr, err)
+	}
+	return fmt.Errorf("failed to bind DNS server on %s: %w", addr, err)
+}
+
+// listenUDP opens a UDP socket on addr
+func listenUDP(addr string, reusePort bool) (net.PacketConn, error) {
+	return listenConfig(reusePort).ListenPacket(context.Background(), "udp", addr)
+}
+
+// listenTCP opens a TCP listener on addr with the given accept backlog (0 keeps
+// the OS default)
+func listenTCP(addr string, backlog int, reusePort bool) (net.Listener, error) {
+	ln, err := listenConfig(reusePort).Listen(context.Background(), "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if backlog > 0 {
+		if err := setListenBacklog(ln, backlog); err != nil {
+			_ = ln.Close()
+			return nil, err
+		}
+	}
+
+	return ln, nil
+}
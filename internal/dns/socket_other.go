@@ -0,0 +1,15 @@
+//go:build !unix
+
+package dns
+
+import (
+	"net"
+)
+
+func socketBufferSizes(net.PacketConn) (recv, send int) {
+	return 0, 0
+}
+
+func setListenBacklog(net.Listener, int) error {
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package dns
+
+import (
+	"errors"
+	"syscall"
+)
+
+func setReusePort(string, string, syscall.RawConn) error {
+	return errors.New("SO_REUSEPORT is not supported on this platform")
+}
@@ -0,0 +1,46 @@
+//go:build unix
+
+package dns
+
+import (
+	"net"
+	"syscall"
+)
+
+// socketBufferSizes returns the kernel's receive and send buffer sizes for pc.
+// Linux reports double the requested size to account for bookkeeping overhead.
+func socketBufferSizes(pc net.PacketConn) (recv, send int) {
+	sc, ok := pc.(syscall.Conn)
+	if !ok {
+		return 0, 0
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return 0, 0
+	}
+	_ = raw.Control(func(fd uintptr) {
+		recv, _ = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+		send, _ = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF)
+	})
+	return recv, send
+}
+
+// setListenBacklog re-issues listen(2) on an already listening socket, which
+// updates its accept queue length (still capped by net.core.somaxconn on Linux)
+func setListenBacklog(ln net.Listener, backlog int) error {
+	sc, ok := ln.(syscall.Conn)
+	if !ok {
+		return nil
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var listenErr error
+	if err := raw.Control(func(fd uintptr) {
+		listenErr = syscall.Listen(int(fd), backlog)
+	}); err != nil {
+		return err
+	}
+	return listenErr
+}
@@ -0,0 +1,81 @@
+package dns
+
+import (
+	"sync/atomic"
+
+	"github.com/miekg/dns"
+)
+
+// statsPaths are the resolution paths ServeDNS counts queries under, as named
+// in slow query logs and trace queries
+var statsPaths = [...]string{
+	"denied", "trace", "health-probe", "chaos", "special-use", "require-tcp",
+	"cache", "cache-only", "4via6-ptr", "4via6", "magicdns", "blocked",
+	"forward", "reflect-aaaa", "reflect", "4via6-apex",
+}
+
+var statsPathIndex = func() map[string]int {
+	index := make(map[string]int, len(statsPaths))
+	for i, path := range statsPaths {
+		index[path] = i
+	}
+	return index
+}()
+
+// queryStats counts queries for human-facing diagnostics, independently of
+// the Prometheus registry. The zero value is ready to use and every update is
+// a single atomic add.
+type queryStats struct {
+	queries     atomic.Uint64
+	cacheHits   atomic.Uint64
+	cacheMisses atomic.Uint64
+	errors      atomic.Uint64
+	paths       [len(statsPaths)]atomic.Uint64
+}
+
+// Stats is a snapshot of the handler's query counters
+type Stats struct {
+	Queries     uint64            `json:"queries"`
+	CacheHits   uint64            `json:"cacheHits"`
+	CacheMisses uint64            `json:"cacheMisses"`
+	Errors      uint64            `json:"errors"` // Queries answered SERVFAIL or not at all
+	Paths       map[string]uint64 `json:"paths"`  // Queries by resolution path
+}
+
+// record counts a finished query from what its tracking writer saw
+func (s *queryStats) record(w *slowQueryWriter) {
+	s.queries.Add(1)
+	if i, ok := statsPathIndex[w.path]; ok {
+		s.paths[i].Add(1)
+	}
+	if !w.written || w.rcode == dns.RcodeServerFailure {
+		s.errors.Add(1)
+	}
+}
+
+// snapshot reads every counter. Counters are read one by one, so a snapshot
+// taken under load may be off by the queries finishing meanwhile.
+func (s *queryStats) snapshot() Stats {
+	stats := Stats{
+		Queries:     s.queries.Load(),
+		CacheHits:   s.cacheHits.Load(),
+		CacheMisses: s.cacheMisses.Load(),
+		Errors:      s.errors.Load(),
+		Paths:       make(map[string]uint64, len(statsPaths)),
+	}
+	for i, path := range statsPaths {
+		stats.Paths[path] = s.paths[i].Load()
+	}
+	return stats
+}
+
+// Stats returns the query counters since the handler was created
+func (h *TailscaleDNSHandler) Stats() Stats {
+	return h.stats.snapshot()
+}
+
+// Stats returns the query counters since the server was created. They survive
+// configuration reloads.
+func (s *Server) Stats() Stats {
+	return s.handler.Stats()
+}
@@ -0,0 +1,92 @@
+package dns
+
+import (
+	"context"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/rajsingh/tsdnsreflector/internal/tailscale"
+)
+
+// clientTagTTL is how long a client's ACL tags are reused before asking
+// Tailscale again
+const clientTagTTL = time.Minute
+
+// maxClientTagEntries bounds the clients whose tags are remembered at once
+const maxClientTagEntries = 4096
+
+// clientTagLookup returns the ACL tags of the tailnet node at ip
+type clientTagLookup func(ctx context.Context, ip netip.Addr) ([]string, error)
+
+// clientTags caches the ACL tags of tailnet clients so zones with tag
+// backends don't ask Tailscale on every query. A nil clientTags knows no tags.
+type clientTags struct {
+	lookup clientTagLookup
+	ttl    time.Duration
+
+	mu      sync.Mutex
+	entries map[netip.Addr]clientTagEntry
+}
+
+type clientTagEntry struct {
+	tags    []string
+	expires time.Time
+}
+
+func newClientTags(lookup clientTagLookup, ttl time.Duration) *clientTags {
+	return &clientTags{lookup: lookup, ttl: ttl, entries: make(map[netip.Addr]clientTagEntry)}
+}
+
+// whoIsTags looks up tags with a WhoIs query to the TSNet server's LocalAPI
+func whoIsTags(ts *tailscale.TSNetServer) clientTagLookup {
+	return func(ctx context.Context, ip netip.Addr) ([]string, error) {
+		localClient, err := ts.LocalClient()
+		if err != nil {
+			return nil, err
+		}
+		who, err := localClient.WhoIs(ctx, ip.String())
+		if err != nil {
+			return nil, err
+		}
+		if who.Node == nil {
+			return nil, nil
+		}
+		return who.Node.Tags, nil
+	}
+}
+
+// Get returns the tags of the client at ip. Failed lookups aren't cached.
+func (c *clientTags) Get(ctx context.Context, ip netip.Addr) ([]string, error) {
+	if c == nil {
+		return nil, nil
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	entry, ok := c.entries[ip]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.tags, nil
+	}
+
+	tags, err := c.lookup(ctx, ip)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= maxClientTagEntries {
+		for addr, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, addr)
+			}
+		}
+		if len(c.entries) >= maxClientTagEntries {
+			c.entries = make(map[netip.Addr]clientTagEntry)
+		}
+	}
+	c.entries[ip] = clientTagEntry{tags: tags, expires: now.Add(c.ttl)}
+	return tags, nil
+}
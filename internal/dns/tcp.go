@@ -0,0 +1,160 @@
+package dns
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/rajsingh/tsdnsreflector/internal/logger"
+	"github.com/rajsingh/tsdnsreflector/internal/metrics"
+)
+
+var errTCPMessageTooLarge = errors.New("TCP DNS message exceeds maximum size")
+
+// limitTCPMessageSize returns a dns.Server reader decorator that rejects TCP
+// messages whose length prefix exceeds maxSize before reading their body. The
+// client gets FORMERR and, as for any read error, the connection is closed.
+// A maxSize of 0 leaves the reader unchanged.
+func limitTCPMessageSize(maxSize int, log *logger.Logger) dns.DecorateReader {
+	return func(r dns.Reader) dns.Reader {
+		if maxSize <= 0 {
+			return r
+		}
+		return &tcpSizeLimitReader{Reader: r, maxSize: maxSize, logger: log}
+	}
+}
+
+type tcpSizeLimitReader struct {
+	dns.Reader
+	maxSize int
+	logger  *logger.Logger
+}
+
+func (r *tcpSizeLimitReader) ReadTCP(conn net.Conn, timeout time.Duration) ([]byte, error) {
+	lc := &lengthCheckConn{Conn: conn, maxSize: r.maxSize}
+	m, err := r.Reader.ReadTCP(lc, timeout)
+	if !errors.Is(err, errTCPMessageTooLarge) {
+		return m, err
+	}
+
+	metrics.RecordOversizedMessage("tcp")
+	r.logger.Warn("Rejecting oversized TCP DNS message", "client", conn.RemoteAddr().String(), "size", lc.length(), "maxSize", r.maxSize)
+	writeTCPFormErr(conn, timeout)
+	return nil, err
+}
+
+// writeTCPFormErr answers the message being read on conn with FORMERR, using
+// the ID from the first two bytes of its body when they arrive in time
+func writeTCPFormErr(conn net.Conn, timeout time.Duration) {
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	var id [2]byte
+	if _, err := io.ReadFull(conn, id[:]); err != nil {
+		return
+	}
+
+	msg := new(dns.Msg)
+	msg.Id = binary.BigEndian.Uint16(id[:])
+	msg.Response = true
+	msg.Rcode = dns.RcodeFormatError
+	packed, err := msg.Pack()
+	if err != nil {
+		return
+	}
+
+	buf := make([]byte, 2+len(packed))
+	binary.BigEndian.PutUint16(buf, uint16(len(packed)))
+	copy(buf[2:], packed)
+	_, _ = conn.Write(buf)
+}
+
+// lengthCheckConn fails the read of a TCP DNS length prefix larger than maxSize
+type lengthCheckConn struct {
+	net.Conn
+	maxSize int
+	prefix  [2]byte
+	seen    int
+}
+
+func (c *lengthCheckConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if c.seen < 2 && n > 0 {
+		c.seen += copy(c.prefix[c.seen:], b[:n])
+		if c.seen == 2 && c.length() > c.maxSize {
+			// Report no bytes read, as io.ReadFull drops errors once it has enough
+			return 0, errTCPMessageTooLarge
+		}
+	}
+	return n, err
+}
+
+func (c *lengthCheckConn) length() int {
+	return int(binary.BigEndian.Uint16(c.prefix[:]))
+}
+
+// tcpConnLimiter caps the open TCP DNS connections across every listener.
+// Connections accepted over the cap are closed at once rather than left in the
+// accept backlog. A max of 0 only counts connections.
+type tcpConnLimiter struct {
+	max  int64
+	open atomic.Int64
+}
+
+func newTCPConnLimiter(max int) *tcpConnLimiter {
+	return &tcpConnLimiter{max: int64(max)}
+}
+
+// listen returns ln with its connections counted against the limit
+func (l *tcpConnLimiter) listen(ln net.Listener) net.Listener {
+	return &limitedListener{Listener: ln, limiter: l}
+}
+
+func (l *tcpConnLimiter) acquire() bool {
+	if n := l.open.Add(1); l.max > 0 && n > l.max {
+		l.open.Add(-1)
+		return false
+	}
+	metrics.TCPConnections.Inc()
+	return true
+}
+
+func (l *tcpConnLimiter) release() {
+	l.open.Add(-1)
+	metrics.TCPConnections.Dec()
+}
+
+type limitedListener struct {
+	net.Listener
+	limiter *tcpConnLimiter
+}
+
+func (ln *limitedListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := ln.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if ln.limiter.acquire() {
+			return &limitedConn{Conn: conn, release: ln.limiter.release}, nil
+		}
+		metrics.RecordTCPConnectionRejected()
+		_ = conn.Close()
+	}
+}
+
+// limitedConn gives its slot back when closed, however often Close is called
+type limitedConn struct {
+	net.Conn
+	release func()
+	once    sync.Once
+}
+
+func (c *limitedConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
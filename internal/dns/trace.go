@@ -0,0 +1,114 @@
+package dns
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/rajsingh/tsdnsreflector/internal/config"
+)
+
+// tracePrefix marks debug queries: a TXT query for _trace.<name> describes how
+// <name> would be resolved instead of resolving it
+const tracePrefix = "_trace."
+
+// traceTarget returns the name being traced if q is a trace query
+func traceTarget(q dns.Question) (string, bool) {
+	if q.Qtype != dns.TypeTXT || len(q.Name) <= len(tracePrefix) {
+		return "", false
+	}
+	if !strings.EqualFold(q.Name[:len(tracePrefix)], tracePrefix) {
+		return "", false
+	}
+	return q.Name[len(tracePrefix):], true
+}
+
+// isTraceQuery reports whether r is a trace query to be answered
+func (h *TailscaleDNSHandler) isTraceQuery(r *dns.Msg) bool {
+	if !h.runtimeCfg.AllowTraceQueries || len(r.Question) == 0 {
+		return false
+	}
+	_, ok := traceTarget(r.Question[0])
+	return ok
+}
+
+// handleTraceQuery answers a trace query with one TXT string per fact. It
+// mirrors the routing in ServeDNS, including the zone and backend chosen for
+// the client, but never queries a backend, touches the cache or records
+// metrics.
+func (h *TailscaleDNSHandler) handleTraceQuery(w dns.ResponseWriter, r *dns.Msg, target string, isTailscaleClient bool) {
+	clientIP := h.getClientIP(w.RemoteAddr())
+	facts := []string{"name=" + target}
+	if isTailscaleClient {
+		facts = append(facts, "client=tailscale")
+	} else {
+		facts = append(facts, "client=external")
+	}
+
+	// Named as ServeDNS names it for metrics and the zone cache
+	zone := h.config.GetZone(target)
+	zoneName := "default"
+	if zone != nil {
+		zoneName = h.findZoneName(zone)
+	}
+	facts = append(facts, "zone="+zoneName)
+
+	switch policy := h.config.SpecialUsePolicy(target); {
+	case zone != nil && !zone.AllowsClient(clientIP):
+		facts = append(facts, "path=denied")
+	case policy != config.SpecialUseForward:
+		facts = append(facts, "path=special-use", "policy="+policy)
+	case h.via6Trans != nil && h.via6Trans.IsVia6Apex(target):
+		facts = append(facts, "path=4via6-apex", "answer="+cmp.Or(h.runtimeCfg.Via6ApexAnswer, config.Via6ApexNXDomain))
+	case isTailscaleClient && zone != nil && zone.Has4via6():
+		facts = append(facts, "path=4via6",
+			fmt.Sprintf("translateid=%d", *zone.TranslateID),
+			"reflected="+zone.ReflectedDomain)
+		if zone.ReflectsDirectly() {
+			facts = append(facts, "reflectMode="+config.ReflectModeDirect)
+		}
+	case h.isMagicDNSDomain(target):
+		facts = append(facts, "path=magicdns")
+	case !isTailscaleClient && (zone == nil || !zone.AllowExternalClients):
+		facts = append(facts, "path=blocked")
+	case zone != nil:
+		choice := h.chooseZoneBackend(context.Background(), zone, clientIP, isTailscaleClient)
+		if choice.tagChecked {
+			facts = append(facts, "tag="+cmp.Or(choice.tag, "default"))
+		}
+		if choice.regionChecked {
+			facts = append(facts, "region="+cmp.Or(choice.region, "default"))
+		}
+		facts = append(facts, "path=forward", "backends="+strings.Join(choice.backend.DNSServers, ","))
+		if zone.ReflectAAAA {
+			facts = append(facts, "reflected.AAAA="+zone.MapName(target, zone.ReflectedDomain))
+		}
+		if h.servesExternalVia6(zone, isTailscaleClient) {
+			facts = append(facts, "reflected="+zone.MapName(target, zone.ReflectedDomain))
+		}
+	default:
+		facts = append(facts, "path=forward", "backends="+strings.Join(h.forwarder.backends, ","))
+	}
+
+	if zoneCache, ok := h.zoneCaches[zoneName]; ok {
+		for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			status := "miss"
+			if _, found := zoneCache.Peek(h.cacheKey(context.Background(), zone, dns.Question{Name: target, Qtype: qtype, Qclass: dns.ClassINET}, clientIP)); found {
+				status = "hit"
+			}
+			facts = append(facts, fmt.Sprintf("cache.%s=%s", dns.TypeToString[qtype], status))
+		}
+	} else {
+		facts = append(facts, "cache=disabled")
+	}
+
+	msg := new(dns.Msg)
+	msg.SetReply(r)
+	msg.Answer = append(msg.Answer, &dns.TXT{
+		Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 0},
+		Txt: facts,
+	})
+	_ = w.WriteMsg(msg)
+}
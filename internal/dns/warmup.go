@@ -0,0 +1,82 @@
+package dns
+
+import (
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// warmupClient is the client address warmup queries appear to come from.
+// Loopback counts as a Tailscale client, so 4via6 zones are warmed too, and
+// TCP keeps requireTCP zones from answering with an empty truncated reply.
+var warmupClient = &net.TCPAddr{IP: net.IPv6loopback}
+
+// warmupResponseWriter keeps the response to a warmup query so its outcome
+// can be logged
+type warmupResponseWriter struct {
+	dns.ResponseWriter
+	msg *dns.Msg
+}
+
+func (w *warmupResponseWriter) RemoteAddr() net.Addr        { return warmupClient }
+func (w *warmupResponseWriter) WriteMsg(m *dns.Msg) error   { w.msg = m; return nil }
+func (w *warmupResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+
+// warmupNames returns the global and per-zone warmup names, fully qualified
+// and without duplicates
+func (h *TailscaleDNSHandler) warmupNames() []string {
+	seen := make(map[string]bool)
+	var names []string
+	add := func(name string) {
+		name = dns.Fqdn(name)
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	for _, name := range h.config.Global.Warmup {
+		add(name)
+	}
+	for _, zone := range h.config.Zones {
+		for _, name := range zone.Warmup {
+			add(name)
+		}
+	}
+	return names
+}
+
+// warmup resolves the configured warmup names through the normal query path,
+// so their answers are cached before the server takes traffic. Failures are
+// logged and never stop the server.
+func (h *TailscaleDNSHandler) warmup() {
+	names := h.warmupNames()
+	if len(names) == 0 {
+		return
+	}
+	if h.runtimeCfg.CacheOnly {
+		h.logger.Info("Skipping cache warmup in cache-only mode", "names", len(names))
+		return
+	}
+
+	h.logger.Info("Warming up caches", "names", len(names))
+	failed := 0
+	for _, name := range names {
+		for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			req := new(dns.Msg)
+			req.SetQuestion(name, qtype)
+			w := &warmupResponseWriter{}
+			h.ServeDNS(w, req)
+
+			switch {
+			case w.msg == nil:
+				failed++
+				h.logger.Warn("Cache warmup query got no response", "domain", name, "type", dns.TypeToString[qtype])
+			case w.msg.Rcode != dns.RcodeSuccess && w.msg.Rcode != dns.RcodeNameError:
+				failed++
+				h.logger.Warn("Cache warmup query failed", "domain", name, "type", dns.TypeToString[qtype], "rcode", dns.RcodeToString[w.msg.Rcode])
+			}
+		}
+	}
+	h.logger.Info("Cache warmup complete", "names", len(names), "failed", failed)
+}
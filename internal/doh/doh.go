@@ -0,0 +1,61 @@
+package doh
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/miekg/dns"
+)
+
+// ContentType is the media type of DNS messages in wire format (RFC 8484)
+const ContentType = "application/dns-message"
+
+// maxMessageSize bounds the response body read, as no DNS message is larger
+const maxMessageSize = dns.MaxMsgSize
+
+// Exchange sends msg to the DNS over HTTPS server at url as a POST request
+// and returns its answer. The request is cancelled with ctx.
+func Exchange(ctx context.Context, client *http.Client, url string, msg *dns.Msg) (*dns.Msg, error) {
+	packed, err := msg.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("failed to pack query: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(packed))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", ContentType)
+	req.Header.Set("Accept", ContentType)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DNS over HTTPS server returned %s", resp.Status)
+	}
+	if ct, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); ct != ContentType {
+		return nil, fmt.Errorf("DNS over HTTPS server returned content type %q", resp.Header.Get("Content-Type"))
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxMessageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxMessageSize {
+		return nil, fmt.Errorf("DNS over HTTPS response exceeds %d bytes", maxMessageSize)
+	}
+
+	answer := new(dns.Msg)
+	if err := answer.Unpack(body); err != nil {
+		return nil, fmt.Errorf("failed to unpack response: %w", err)
+	}
+	return answer, nil
+}
@@ -0,0 +1,69 @@
+package doh
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestExchange(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != ContentType {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		query := new(dns.Msg)
+		if err := query.Unpack(body); err != nil {
+			http.Error(w, "bad message", http.StatusBadRequest)
+			return
+		}
+
+		switch query.Question[0].Name {
+		case "html.example.com.":
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte("<html></html>"))
+			return
+		case "error.example.com.":
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		msg := new(dns.Msg)
+		msg.SetReply(query)
+		msg.Answer = append(msg.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: query.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.IPv4(10, 0, 0, 1),
+		})
+		packed, _ := msg.Pack()
+		w.Header().Set("Content-Type", ContentType)
+		_, _ = w.Write(packed)
+	}))
+	defer server.Close()
+
+	exchange := func(name string) (*dns.Msg, error) {
+		query := new(dns.Msg)
+		query.SetQuestion(name, dns.TypeA)
+		return Exchange(context.Background(), server.Client(), server.URL+"/dns-query", query)
+	}
+
+	resp, err := exchange("app.example.com.")
+	if err != nil {
+		t.Fatalf("Exchange failed: %v", err)
+	}
+	if len(resp.Answer) != 1 || resp.Answer[0].(*dns.A).A.String() != "10.0.0.1" {
+		t.Errorf("Expected 10.0.0.1, got %v", resp.Answer)
+	}
+
+	if _, err := exchange("html.example.com."); err == nil {
+		t.Error("Expected error for a response that isn't a DNS message")
+	}
+	if _, err := exchange("error.example.com."); err == nil {
+		t.Error("Expected error for a non-200 response")
+	}
+}
@@ -0,0 +1,91 @@
+package geoip
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Resolver looks up client locations in a MaxMind database. A nil Resolver
+// resolves every address to an empty Location.
+type Resolver struct {
+	reader *maxminddb.Reader
+}
+
+// Location is the subset of a MaxMind record used for backend selection
+type Location struct {
+	Country   string
+	Continent string
+	ASN       uint
+}
+
+type record struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Continent struct {
+		Code string `maxminddb:"code"`
+	} `maxminddb:"continent"`
+	ASN uint `maxminddb:"autonomous_system_number"`
+}
+
+// Open loads the MaxMind database at path
+func Open(path string) (*Resolver, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open GeoIP database %s: %w", path, err)
+	}
+	return &Resolver{reader: reader}, nil
+}
+
+// DatabaseType returns the database type from the metadata, e.g. "GeoLite2-City"
+func (r *Resolver) DatabaseType() string {
+	if r == nil {
+		return ""
+	}
+	return r.reader.Metadata.DatabaseType
+}
+
+// Lookup returns the location recorded for ip
+func (r *Resolver) Lookup(ip netip.Addr) (Location, error) {
+	if r == nil || !ip.IsValid() {
+		return Location{}, nil
+	}
+
+	var rec record
+	if err := r.reader.Lookup(net.IP(ip.Unmap().AsSlice()), &rec); err != nil {
+		return Location{}, err
+	}
+
+	return Location{
+		Country:   rec.Country.ISOCode,
+		Continent: rec.Continent.Code,
+		ASN:       rec.ASN,
+	}, nil
+}
+
+// Close releases the database
+func (r *Resolver) Close() error {
+	if r == nil {
+		return nil
+	}
+	return r.reader.Close()
+}
+
+// Regions returns the region keys for the location from most to least
+// specific: "AS<number>", ISO country code, continent code
+func (l Location) Regions() []string {
+	var regions []string
+	if l.ASN != 0 {
+		regions = append(regions, fmt.Sprintf("AS%d", l.ASN))
+	}
+	if l.Country != "" {
+		regions = append(regions, l.Country)
+	}
+	if l.Continent != "" {
+		regions = append(regions, l.Continent)
+	}
+	return regions
+}
@@ -0,0 +1,50 @@
+package geoip
+
+import (
+	"net/netip"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLocationRegions(t *testing.T) {
+	tests := []struct {
+		name     string
+		location Location
+		want     []string
+	}{
+		{"empty", Location{}, nil},
+		{"country only", Location{Country: "DE"}, []string{"DE"}},
+		{"city database", Location{Country: "DE", Continent: "EU"}, []string{"DE", "EU"}},
+		{"asn and city", Location{Country: "US", Continent: "NA", ASN: 13335}, []string{"AS13335", "US", "NA"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.location.Regions(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Regions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenMissingDatabase(t *testing.T) {
+	if _, err := Open(filepath.Join(t.TempDir(), "missing.mmdb")); err == nil {
+		t.Error("Expected error opening missing database")
+	}
+}
+
+func TestNilResolver(t *testing.T) {
+	var r *Resolver
+
+	loc, err := r.Lookup(netip.MustParseAddr("8.8.8.8"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if loc != (Location{}) {
+		t.Errorf("Expected empty location, got %+v", loc)
+	}
+	if err := r.Close(); err != nil {
+		t.Errorf("Unexpected close error: %v", err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"io"
 	"log/slog"
 	"os"
+	"slices"
 	"strings"
 
 	"github.com/rajsingh/tsdnsreflector/internal/config"
@@ -12,11 +13,15 @@ import (
 
 type Logger struct {
 	*slog.Logger
+	level *slog.LevelVar // Shared with loggers derived from this one
+	zone  string         // Zone already attached by WithZone or WithQuery, not repeated by the Zone helpers
 }
 
 func New(cfg config.LoggingConfig) *Logger {
+	level := new(slog.LevelVar)
+	level.Set(parseLevel(cfg.Level))
 	opts := &slog.HandlerOptions{
-		Level: parseLevel(cfg.Level),
+		Level: level,
 		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
 			if !strings.EqualFold(cfg.Format, "json") {
 				if a.Key == slog.TimeKey {
@@ -53,6 +58,15 @@ func New(cfg config.LoggingConfig) *Logger {
 
 	return &Logger{
 		Logger: slog.New(handler),
+		level:  level,
+	}
+}
+
+// SetLevel changes the minimum level logged, by this logger and every logger
+// derived from it, while it is in use
+func (l *Logger) SetLevel(level string) {
+	if l.level != nil {
+		l.level.Set(parseLevel(level))
 	}
 }
 
@@ -74,9 +88,20 @@ func parseLevel(level string) slog.Level {
 func (l *Logger) WithContext(ctx context.Context) *Logger {
 	return &Logger{
 		Logger: l.WithGroup("context"),
+		level:  l.level,
 	}
 }
 
+// NewAudit returns a JSON logger for audit records, writing to file or to
+// stdout if file is empty. Audit records are never filtered by log level.
+func NewAudit(file string) *Logger {
+	return New(config.LoggingConfig{
+		Level:   "info",
+		Format:  "json",
+		LogFile: file,
+	})
+}
+
 func Default() *Logger {
 	return New(config.LoggingConfig{
 		Level:  "info",
@@ -94,28 +119,63 @@ func (l *Logger) UpdateConfig(cfg config.LoggingConfig) {
 func (l *Logger) WithZone(zoneName string) *Logger {
 	return &Logger{
 		Logger: l.With("zone", zoneName),
+		level:  l.level,
+		zone:   zoneName,
 	}
 }
 
+// WithQuery creates a logger for one DNS query, so every line logged while
+// answering it carries the same query ID and client address
+func (l *Logger) WithQuery(qid, clientIP string) *Logger {
+	return &Logger{
+		Logger: l.With("qid", qid, "clientIP", clientIP),
+		level:  l.level,
+	}
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying l
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the logger carried by ctx, or fallback if it has none
+func FromContext(ctx context.Context, fallback *Logger) *Logger {
+	if l, ok := ctx.Value(contextKey{}).(*Logger); ok {
+		return l
+	}
+	return fallback
+}
+
+// zoneArgs prepends zone to args unless the logger already carries it
+func (l *Logger) zoneArgs(zone string, args []any) []any {
+	if zone == l.zone {
+		return args
+	}
+	return append([]any{"zone", zone}, args...)
+}
+
 func (l *Logger) ZoneInfo(zone, msg string, args ...any) {
-	l.Info(msg, append([]any{"zone", zone}, args...)...)
+	l.Info(msg, l.zoneArgs(zone, args)...)
 }
 
 func (l *Logger) ZoneError(zone, msg string, args ...any) {
-	l.Error(msg, append([]any{"zone", zone}, args...)...)
+	l.Error(msg, l.zoneArgs(zone, args)...)
 }
 
 func (l *Logger) ZoneDebug(zone, msg string, args ...any) {
-	l.Debug(msg, append([]any{"zone", zone}, args...)...)
+	l.Debug(msg, l.zoneArgs(zone, args)...)
 }
 
 func (l *Logger) ZoneWarn(zone, msg string, args ...any) {
-	l.Warn(msg, append([]any{"zone", zone}, args...)...)
+	l.Warn(msg, l.zoneArgs(zone, args)...)
 }
 
 type tsnetStyleHandler struct {
 	output io.Writer
 	opts   *slog.HandlerOptions
+	attrs  []slog.Attr // Added by With, written before each record's own
 }
 
 func (h *tsnetStyleHandler) Enabled(ctx context.Context, level slog.Level) bool {
@@ -131,13 +191,17 @@ func (h *tsnetStyleHandler) Handle(ctx context.Context, record slog.Record) erro
 	line.WriteString(" tsdnsreflector: ")
 	line.WriteString(record.Message)
 
-	record.Attrs(func(a slog.Attr) bool {
+	writeAttr := func(a slog.Attr) bool {
 		line.WriteString(" ")
 		line.WriteString(a.Key)
 		line.WriteString("=")
 		line.WriteString(a.Value.String())
 		return true
-	})
+	}
+	for _, a := range h.attrs {
+		writeAttr(a)
+	}
+	record.Attrs(writeAttr)
 
 	line.WriteString("\n")
 
@@ -146,7 +210,11 @@ func (h *tsnetStyleHandler) Handle(ctx context.Context, record slog.Record) erro
 }
 
 func (h *tsnetStyleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
-	return h
+	return &tsnetStyleHandler{
+		output: h.output,
+		opts:   h.opts,
+		attrs:  append(slices.Clip(h.attrs), attrs...),
+	}
 }
 
 func (h *tsnetStyleHandler) WithGroup(name string) slog.Handler {
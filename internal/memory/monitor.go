@@ -11,6 +11,7 @@ import (
 
 type Monitor struct {
 	zones        map[string]*Usage
+	cacheSources map[string]func() int64 // Actual cache usage per zone, for reconciliation
 	mutex        sync.RWMutex
 	logger       *logger.Logger
 	globalLimits Limits
@@ -67,6 +68,14 @@ func (m *Monitor) RegisterZone(zoneName string) error {
 	return nil
 }
 
+// UnregisterZone stops monitoring a zone that is no longer configured, freeing
+// its place under the zone count limit
+func (m *Monitor) UnregisterZone(zoneName string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.zones, zoneName)
+}
+
 func (m *Monitor) UpdateCacheUsage(zoneName string, cacheSize int64) error {
 	if !m.enabled {
 		return nil
@@ -105,6 +114,46 @@ func (m *Monitor) UpdateCacheUsage(zoneName string, cacheSize int64) error {
 	return nil
 }
 
+// SetCacheSources replaces the functions reporting each zone's actual cache
+// memory usage, which periodic checks reconcile against the reported values
+func (m *Monitor) SetCacheSources(sources map[string]func() int64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.cacheSources = sources
+}
+
+// Reconcile pushes every zone's actual cache usage to the monitor, recording
+// how far the last reported value had drifted from it
+func (m *Monitor) Reconcile() {
+	if !m.enabled {
+		return
+	}
+
+	m.mutex.RLock()
+	sources := m.cacheSources
+	reported := make(map[string]int64, len(sources))
+	for zoneName := range sources {
+		if usage, exists := m.zones[zoneName]; exists {
+			reported[zoneName] = usage.CacheSize
+		}
+	}
+	m.mutex.RUnlock()
+
+	for zoneName, source := range sources {
+		last, exists := reported[zoneName]
+		if !exists {
+			continue
+		}
+		actual := source()
+		metrics.UpdateCacheMemoryDrift(zoneName, float64(actual-last))
+		if actual != last {
+			m.logger.ZoneDebug(zoneName, "Reconciling cache memory usage", "reported", last, "actual", actual)
+		}
+		// Limit violations are logged and counted by UpdateCacheUsage
+		_ = m.UpdateCacheUsage(zoneName, actual)
+	}
+}
+
 func (m *Monitor) UpdateQueryBufferUsage(zoneName string, bufferSize int64) error {
 	if !m.enabled {
 		return nil
@@ -202,6 +251,7 @@ func (m *Monitor) StartPeriodicCheck(interval time.Duration) {
 	go func() {
 		defer ticker.Stop()
 		for range ticker.C {
+			m.Reconcile()
 			if err := m.CheckGlobalLimits(); err != nil {
 				m.logger.Error("Global memory check failed", "error", err)
 			}
@@ -5,8 +5,10 @@ import (
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/rajsingh/tsdnsreflector/internal/config"
 	"github.com/rajsingh/tsdnsreflector/internal/logger"
+	"github.com/rajsingh/tsdnsreflector/internal/metrics"
 )
 
 func TestMemoryLimitEnforcement(t *testing.T) {
@@ -292,3 +294,63 @@ func TestMemoryMonitoringDisabledState(t *testing.T) {
 	})
 }
 
+
+func TestMemoryMonitorReconcile(t *testing.T) {
+	log := logger.New(config.LoggingConfig{Level: "debug", Format: "text"})
+	monitor := NewMonitor(log, Limits{
+		MaxZoneCount:     10,
+		MaxTotalMemory:   1024 * 1024,
+		MaxCachePerZone:  512 * 1024,
+		MaxBufferPerZone: 256 * 1024,
+	})
+
+	if err := monitor.RegisterZone("drifted"); err != nil {
+		t.Fatalf("Failed to register zone: %v", err)
+	}
+	if err := monitor.UpdateCacheUsage("drifted", 4096); err != nil {
+		t.Fatalf("Failed to update cache usage: %v", err)
+	}
+
+	// The cache shrank (e.g. evictions) without reporting it
+	actual := int64(1024)
+	monitor.SetCacheSources(map[string]func() int64{
+		"drifted":      func() int64 { return actual },
+		"unregistered": func() int64 { return 1 },
+	})
+	monitor.Reconcile()
+
+	usage, _ := monitor.GetZoneUsage("drifted")
+	if usage.CacheSize != actual {
+		t.Errorf("Expected reconciled cache size %d, got %d", actual, usage.CacheSize)
+	}
+	if got := testutil.ToFloat64(metrics.CacheMemoryDrift.WithLabelValues("drifted")); got != float64(actual-4096) {
+		t.Errorf("Expected drift %d, got %v", actual-4096, got)
+	}
+	if _, exists := monitor.GetZoneUsage("unregistered"); exists {
+		t.Error("Reconcile should not register zones")
+	}
+
+	monitor.Reconcile()
+	if got := testutil.ToFloat64(metrics.CacheMemoryDrift.WithLabelValues("drifted")); got != 0 {
+		t.Errorf("Expected no drift after reconciliation, got %v", got)
+	}
+}
+
+func TestMemoryMonitorUnregisterZone(t *testing.T) {
+	monitor := NewMonitor(logger.Default(), Limits{MaxZoneCount: 1, MaxTotalMemory: 1024, MaxCachePerZone: 1024, MaxBufferPerZone: 1024})
+
+	if err := monitor.RegisterZone("old"); err != nil {
+		t.Fatalf("RegisterZone failed: %v", err)
+	}
+	if err := monitor.RegisterZone("new"); err == nil {
+		t.Fatal("Expected zone count limit error")
+	}
+
+	monitor.UnregisterZone("old")
+	if _, registered := monitor.GetZoneUsage("old"); registered {
+		t.Error("Expected unregistered zone to be gone")
+	}
+	if err := monitor.RegisterZone("new"); err != nil {
+		t.Errorf("Expected unregistering to free a place, got %v", err)
+	}
+}
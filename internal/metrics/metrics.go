@@ -1,6 +1,9 @@
 package metrics
 
 import (
+	"strconv"
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
@@ -58,7 +61,128 @@ var (
 		[]string{"zone", "backend"},
 	)
 
+	BackendQueryDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "tsdnsreflector_backend_query_duration_seconds",
+			Help:    "Backend DNS query latency by zone and backend",
+			Buckets: []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		},
+		[]string{"zone", "backend"},
+	)
+
+	BackendDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "tsdnsreflector_backend_duration_seconds",
+			Help:    "Time until a backend answered, by zone and backend; failed attempts are only in tsdnsreflector_backend_query_duration_seconds",
+			Buckets: []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		},
+		[]string{"zone", "backend"},
+	)
+
+	Via6ResolutionDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "tsdnsreflector_via6_resolution_duration_seconds",
+			Help:    "Time the 4via6 translator took to resolve a reflected domain from its backends, by zone",
+			Buckets: []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		},
+		[]string{"zone"},
+	)
+
+	BackendWins = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tsdnsreflector_backend_wins_total",
+			Help: "Parallel backend queries answered first by each backend",
+		},
+		[]string{"zone", "backend"},
+	)
+
+	BackendIDMismatches = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tsdnsreflector_backend_id_mismatches_total",
+			Help: "Backend responses dropped because their ID did not match the query",
+		},
+		[]string{"zone", "backend"},
+	)
+
+	OversizedMessages = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tsdnsreflector_oversized_messages_total",
+			Help: "Incoming DNS messages rejected for exceeding the maximum size",
+		},
+		[]string{"transport"},
+	)
+
+	SingleflightShared = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tsdnsreflector_singleflight_shared_total",
+			Help: "Queries answered by an identical backend query or 4via6 resolution already in flight",
+		},
+		[]string{"zone", "path"}, // path: forward or via6
+	)
+
+	RateLimitedQueries = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tsdnsreflector_rate_limited_total",
+			Help: "Queries refused because the client exceeded its per-client rate limit",
+		},
+		[]string{"client_class"}, // tailscale or external
+	)
+
+	TCPConnections = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "tsdnsreflector_tcp_connections",
+			Help: "Open TCP DNS client connections",
+		},
+	)
+
+	TCPConnectionsRejected = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "tsdnsreflector_tcp_connections_rejected_total",
+			Help: "TCP DNS client connections closed on accept because the connection limit was reached",
+		},
+	)
+
+	BackendHealthy = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "tsdnsreflector_backend_healthy",
+			Help: "Whether a backend passed its last health check (1) or not (0)",
+		},
+		[]string{"zone", "backend"},
+	)
+
+	BackendCircuitOpen = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "tsdnsreflector_backend_circuit_open",
+			Help: "Whether a backend's circuit breaker is open (1) or closed (0)",
+		},
+		[]string{"backend"},
+	)
+
+	RegionBackendSelections = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tsdnsreflector_region_backend_selections_total",
+			Help: "Backend selections by GeoIP region",
+		},
+		[]string{"zone", "region"}, // region: matched region key or "default"
+	)
+
+	TagBackendSelections = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tsdnsreflector_tag_backend_selections_total",
+			Help: "Backend selections by client ACL tag",
+		},
+		[]string{"zone", "tag"}, // tag: matched ACL tag or "default"
+	)
+
 	// Cache metrics
+	StaleResponses = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tsdnsreflector_stale_responses_total",
+			Help: "Expired cache entries served by zone and reason",
+		},
+		[]string{"zone", "reason"}, // reason: circuit_open, refresh_async, refresh_failed, cache_only
+	)
+
 	CacheOperations = promauto.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "tsdnsreflector_cache_operations_total",
@@ -89,7 +213,7 @@ var (
 			Name: "tsdnsreflector_client_queries_total",
 			Help: "DNS queries by zone, client type and status",
 		},
-		[]string{"zone", "client_type", "status"}, // client_type: tailscale, external; status: allowed, blocked
+		[]string{"zone", "client_type", "status"}, // client_type: tailscale, external; status: allowed, blocked, denied
 	)
 
 	// System status
@@ -100,6 +224,66 @@ var (
 		},
 	)
 
+	TailscalePeersOnline = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "tsdnsreflector_tailscale_peers_online",
+			Help: "Tailnet peers currently online",
+		},
+	)
+
+	TailscalePeersTotal = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "tsdnsreflector_tailscale_peers_total",
+			Help: "Tailnet peers known to this node, online or not",
+		},
+	)
+
+	ExitNodeAdvertised = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "tsdnsreflector_exit_node_advertised",
+			Help: "Whether this node advertises itself as a Tailscale exit node (0=no, 1=yes)",
+		},
+	)
+
+	CacheOnlyMode = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "tsdnsreflector_cache_only_mode",
+			Help: "Whether the server answers only from cache (0=off, 1=on)",
+		},
+	)
+
+	CacheOnlyMisses = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tsdnsreflector_cache_only_misses_total",
+			Help: "Queries not in cache while in cache-only mode by zone and response",
+		},
+		[]string{"zone", "response"}, // response: servfail, refused, stale
+	)
+
+	BuildInfo = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "tsdnsreflector_build_info",
+			Help: "Build information of the running binary (always 1)",
+		},
+		[]string{"version", "commit", "goversion"},
+	)
+
+	RuntimeConfigInfo = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "tsdnsreflector_runtime_config_info",
+			Help: "Non-secret runtime settings of the running server (always 1)",
+		},
+		[]string{"log_level", "dns_port", "metrics_enabled", "magicdns_suffix"},
+	)
+
+	ListenerInfo = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "tsdnsreflector_listener_info",
+			Help: "DNS listeners bound by the server (1=bound)",
+		},
+		[]string{"listener", "protocol", "family", "address"}, // listener: tailscale, local, standalone
+	)
+
 	// Memory monitoring metrics
 	ZoneMemoryUsage = promauto.NewGaugeVec(
 		prometheus.GaugeOpts{
@@ -109,6 +293,14 @@ var (
 		[]string{"zone", "type"},
 	)
 
+	CacheMemoryDrift = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "tsdnsreflector_cache_memory_drift_bytes",
+			Help: "Actual zone cache memory usage minus the value last reported to the memory monitor",
+		},
+		[]string{"zone"},
+	)
+
 	MemoryViolations = promauto.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "tsdnsreflector_memory_violations_total",
@@ -150,6 +342,74 @@ func RecordBackendError(zone, backend string) {
 	BackendErrors.WithLabelValues(zone, backend).Inc()
 }
 
+func RecordBackendLatency(zone, backend string, d time.Duration) {
+	BackendQueryDuration.WithLabelValues(zone, backend).Observe(d.Seconds())
+}
+
+func RecordBackendDuration(zone, backend string, d time.Duration) {
+	BackendDuration.WithLabelValues(zone, backend).Observe(d.Seconds())
+}
+
+func RecordVia6Resolution(zone string, d time.Duration) {
+	Via6ResolutionDuration.WithLabelValues(zone).Observe(d.Seconds())
+}
+
+func RecordBackendWin(zone, backend string) {
+	BackendWins.WithLabelValues(zone, backend).Inc()
+}
+
+func RecordBackendIDMismatch(zone, backend string) {
+	BackendIDMismatches.WithLabelValues(zone, backend).Inc()
+}
+
+func RecordOversizedMessage(transport string) {
+	OversizedMessages.WithLabelValues(transport).Inc()
+}
+
+func RecordSingleflightShared(zone, path string) {
+	SingleflightShared.WithLabelValues(zone, path).Inc()
+}
+
+func RecordRateLimited(tailscaleClient bool) {
+	clientClass := "external"
+	if tailscaleClient {
+		clientClass = "tailscale"
+	}
+	RateLimitedQueries.WithLabelValues(clientClass).Inc()
+}
+
+func RecordTCPConnectionRejected() {
+	TCPConnectionsRejected.Inc()
+}
+
+func UpdateBackendHealth(zone, backend string, healthy bool) {
+	if healthy {
+		BackendHealthy.WithLabelValues(zone, backend).Set(1)
+	} else {
+		BackendHealthy.WithLabelValues(zone, backend).Set(0)
+	}
+}
+
+func UpdateBackendCircuitState(backend string, open bool) {
+	if open {
+		BackendCircuitOpen.WithLabelValues(backend).Set(1)
+	} else {
+		BackendCircuitOpen.WithLabelValues(backend).Set(0)
+	}
+}
+
+func RecordStaleResponse(zone, reason string) {
+	StaleResponses.WithLabelValues(zone, reason).Inc()
+}
+
+func RecordRegionBackendSelection(zone, region string) {
+	RegionBackendSelections.WithLabelValues(zone, region).Inc()
+}
+
+func RecordTagBackendSelection(zone, tag string) {
+	TagBackendSelections.WithLabelValues(zone, tag).Inc()
+}
+
 func RecordCacheHit(zone string) {
 	CacheOperations.WithLabelValues(zone, "hit").Inc()
 }
@@ -174,10 +434,58 @@ func UpdateTailscaleStatus(up bool) {
 	}
 }
 
+func UpdateTailscalePeers(n int) {
+	TailscalePeersOnline.Set(float64(n))
+}
+
+func UpdateTailscalePeersTotal(n int) {
+	TailscalePeersTotal.Set(float64(n))
+}
+
+func UpdateExitNodeAdvertised(advertised bool) {
+	if advertised {
+		ExitNodeAdvertised.Set(1)
+	} else {
+		ExitNodeAdvertised.Set(0)
+	}
+}
+
+func UpdateCacheOnlyMode(enabled bool) {
+	if enabled {
+		CacheOnlyMode.Set(1)
+	} else {
+		CacheOnlyMode.Set(0)
+	}
+}
+
+func RecordCacheOnlyMiss(zone, response string) {
+	CacheOnlyMisses.WithLabelValues(zone, response).Inc()
+}
+
+// RecordBuildInfo sets the build info metric, replacing any previous value
+func RecordBuildInfo(version, commit, goVersion string) {
+	BuildInfo.Reset()
+	BuildInfo.WithLabelValues(version, commit, goVersion).Set(1)
+}
+
+// RecordRuntimeConfigInfo sets the runtime config info metric, replacing any previous value
+func RecordRuntimeConfigInfo(logLevel string, dnsPort int, metricsEnabled bool, magicDNSSuffix string) {
+	RuntimeConfigInfo.Reset()
+	RuntimeConfigInfo.WithLabelValues(logLevel, strconv.Itoa(dnsPort), strconv.FormatBool(metricsEnabled), magicDNSSuffix).Set(1)
+}
+
+func RecordListener(listener, protocol, family, address string) {
+	ListenerInfo.WithLabelValues(listener, protocol, family, address).Set(1)
+}
+
 func UpdateZoneMemoryUsage(zone, memoryType string, bytes float64) {
 	ZoneMemoryUsage.WithLabelValues(zone, memoryType).Set(bytes)
 }
 
+func UpdateCacheMemoryDrift(zone string, bytes float64) {
+	CacheMemoryDrift.WithLabelValues(zone).Set(bytes)
+}
+
 func RecordMemoryViolation(zone, violationType string) {
 	MemoryViolations.WithLabelValues(zone, violationType).Inc()
 }
@@ -195,3 +503,11 @@ func RecordExternalClientQuery(zone, status string) {
 func RecordTailscaleClientQuery(zone string) {
 	ClientQueries.WithLabelValues(zone, "tailscale", "allowed").Inc()
 }
+
+func RecordDeniedClientQuery(zone string, tailscaleClient bool) {
+	clientType := "external"
+	if tailscaleClient {
+		clientType = "tailscale"
+	}
+	ClientQueries.WithLabelValues(zone, clientType, "denied").Inc()
+}
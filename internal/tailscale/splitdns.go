@@ -0,0 +1,128 @@
+package tailscale
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"golang.org/x/oauth2/clientcredentials"
+	"tailscale.com/util/dnsname"
+)
+
+// ConfigureSplitDNS registers domains as split DNS routes to this node's
+// Tailscale addresses, so tailnet clients send queries for them here. Routes
+// for other domains are left alone. Domains that aren't valid DNS names are
+// skipped and reported in the returned error.
+func (ts *TSNetServer) ConfigureSplitDNS(ctx context.Context, domains []string) error {
+	ipv4, ipv6 := ts.TailscaleIPs()
+	var nameservers []string
+	for _, ip := range []net.IP{ipv4, ipv6} {
+		if ip != nil {
+			nameservers = append(nameservers, ip.String())
+		}
+	}
+	if len(nameservers) == 0 {
+		return errors.New("no Tailscale addresses to route split DNS to")
+	}
+
+	payload, invalid := splitDNSPayload(domains, nameservers)
+	if len(payload) == 0 {
+		return invalid
+	}
+
+	clientID, clientSecret, baseURL, err := ts.oauthCredentials()
+	if err != nil {
+		return errors.Join(fmt.Errorf("split DNS needs OAuth client credentials: %w", err), invalid)
+	}
+	credentials := clientcredentials.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		TokenURL:     baseURL + "/api/v2/oauth/token",
+	}
+	if err := patchSplitDNS(ctx, credentials.Client(ctx), baseURL, payload); err != nil {
+		return errors.Join(err, invalid)
+	}
+
+	ts.logger.Info("Split DNS routes configured", "domains", len(payload), "nameservers", nameservers)
+	return invalid
+}
+
+// splitDNSPayload maps each valid, distinct domain to nameservers. Zone
+// wildcards are routed by their parent domain, which covers every name under
+// it. Invalid domains are left out and reported in the error.
+func splitDNSPayload(domains, nameservers []string) (map[string][]string, error) {
+	payload := make(map[string][]string)
+	var invalid []error
+	for _, domain := range domains {
+		name := strings.ToLower(strings.TrimSuffix(strings.TrimPrefix(domain, "*."), "."))
+		if name == "" {
+			invalid = append(invalid, fmt.Errorf("invalid split DNS domain %q", domain))
+			continue
+		}
+		if err := dnsname.ValidHostname(name); err != nil {
+			invalid = append(invalid, fmt.Errorf("invalid split DNS domain %q: %w", domain, err))
+			continue
+		}
+		payload[name] = slices.Clone(nameservers)
+	}
+	return payload, errors.Join(invalid...)
+}
+
+// patchSplitDNS merges payload into the tailnet's split DNS configuration
+func patchSplitDNS(ctx context.Context, client *http.Client, baseURL string, payload map[string][]string) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPatch, baseURL+"/api/v2/tailnet/-/dns/split-dns", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to update split DNS: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("failed to update split DNS: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// oauthCredentials returns the OAuth client credentials and API base URL,
+// from the OAuth configuration or an OAuth client secret used as auth key
+func (ts *TSNetServer) oauthCredentials() (clientID, clientSecret, baseURL string, err error) {
+	if oauth := ts.config.OAuth; oauth != nil {
+		if clientID, err = ts.readCredential(oauth.ClientID, oauth.ClientIDFile, "TS_API_CLIENT_ID"); err != nil {
+			return "", "", "", fmt.Errorf("failed to read OAuth client ID: %w", err)
+		}
+		if clientSecret, err = ts.readCredential(oauth.ClientSecret, oauth.ClientSecretFile, "TS_API_CLIENT_SECRET"); err != nil {
+			return "", "", "", fmt.Errorf("failed to read OAuth client secret: %w", err)
+		}
+		return clientID, clientSecret, oauth.BaseURL, nil
+	}
+
+	authKey := ts.config.AuthKey
+	if authKey == "" {
+		authKey = os.Getenv("TS_AUTHKEY")
+	}
+	if !strings.HasPrefix(authKey, "tskey-client-") {
+		return "", "", "", errors.New("no OAuth client configured")
+	}
+	clientID, oauth, err := parseOAuthSecret(authKey)
+	if err != nil {
+		return "", "", "", err
+	}
+	return clientID, oauth.ClientSecret, oauth.BaseURL, nil
+}
@@ -0,0 +1,68 @@
+package tailscale
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestSplitDNSPayload(t *testing.T) {
+	nameservers := []string{"100.64.0.53", "fd7a:115c:a1e0::53"}
+	payload, err := splitDNSPayload([]string{
+		"*.corp.local",
+		"Corp.Local.", // Same route as the wildcard
+		"app.prod.local",
+		"*",
+		"bad_label!.local",
+	}, nameservers)
+
+	want := map[string][]string{
+		"corp.local":     nameservers,
+		"app.prod.local": nameservers,
+	}
+	if !reflect.DeepEqual(payload, want) {
+		t.Errorf("Expected payload %v, got %v", want, payload)
+	}
+	if err == nil {
+		t.Error("Expected the invalid domains reported")
+	}
+
+	// Each domain gets its own copy of the nameservers
+	payload["corp.local"][0] = "changed"
+	if payload["app.prod.local"][0] != "100.64.0.53" || nameservers[0] != "100.64.0.53" {
+		t.Error("Expected nameserver lists not to be shared")
+	}
+
+	if _, err := splitDNSPayload([]string{"*.corp.local"}, nameservers); err != nil {
+		t.Errorf("Expected no error for valid domains, got %v", err)
+	}
+}
+
+func TestPatchSplitDNS(t *testing.T) {
+	var got map[string][]string
+	status := http.StatusOK
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch || r.URL.Path != "/api/v2/tailnet/-/dns/split-dns" {
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(status)
+	}))
+	defer api.Close()
+
+	payload := map[string][]string{"corp.local": {"100.64.0.53"}}
+	if err := patchSplitDNS(context.Background(), api.Client(), api.URL, payload); err != nil {
+		t.Fatalf("Expected split DNS update to succeed, got %v", err)
+	}
+	if !reflect.DeepEqual(got, payload) {
+		t.Errorf("Expected %v sent, got %v", payload, got)
+	}
+
+	status = http.StatusForbidden
+	if err := patchSplitDNS(context.Background(), api.Client(), api.URL, payload); err == nil {
+		t.Error("Expected an error when the API rejects the update")
+	}
+}
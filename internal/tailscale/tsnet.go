@@ -6,17 +6,21 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"net/netip"
 	"net/url"
 	"os"
+	"slices"
 	"strings"
 
 	"github.com/rajsingh/tsdnsreflector/internal/config"
 	"github.com/rajsingh/tsdnsreflector/internal/kubestore"
 	"github.com/rajsingh/tsdnsreflector/internal/logger"
+	"github.com/rajsingh/tsdnsreflector/internal/metrics"
 	"golang.org/x/oauth2/clientcredentials"
 	"tailscale.com/client/local"
 	"tailscale.com/client/tailscale" //nolint:staticcheck // v2 migration pending
 	"tailscale.com/ipn"
+	"tailscale.com/net/tsaddr"
 	"tailscale.com/tsnet"
 )
 
@@ -69,7 +73,52 @@ func NewTSNetServer(cfg *config.TailscaleConfig, appLogger *logger.Logger) (*TSN
 }
 
 func (ts *TSNetServer) Start(ctx context.Context) error {
-	return ts.server.Start()
+	if err := ts.server.Start(); err != nil {
+		return err
+	}
+	if ts.config.AdvertiseAsExitNode {
+		ts.advertiseExitNode(ctx)
+	}
+	return nil
+}
+
+// advertiseExitNode adds the exit routes to the node's advertised routes.
+// Failure is logged rather than returned, so DNS keeps working without it.
+func (ts *TSNetServer) advertiseExitNode(ctx context.Context) {
+	err := func() error {
+		lc, err := ts.server.LocalClient()
+		if err != nil {
+			return err
+		}
+		prefs, err := lc.GetPrefs(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = lc.EditPrefs(ctx, exitNodePrefs(prefs.AdvertiseRoutes))
+		return err
+	}()
+	if err != nil {
+		ts.logger.Error("Failed to advertise exit node", "error", err)
+		metrics.UpdateExitNodeAdvertised(false)
+		return
+	}
+	ts.logger.Info("Advertising as exit node", "routes", tsaddr.ExitRoutes())
+	metrics.UpdateExitNodeAdvertised(true)
+}
+
+// exitNodePrefs returns the prefs edit that advertises routes plus the exit
+// routes 0.0.0.0/0 and ::/0, keeping routes already advertised
+func exitNodePrefs(routes []netip.Prefix) *ipn.MaskedPrefs {
+	advertised := slices.Clone(routes)
+	for _, route := range tsaddr.ExitRoutes() {
+		if !slices.Contains(advertised, route) {
+			advertised = append(advertised, route)
+		}
+	}
+	return &ipn.MaskedPrefs{
+		Prefs:              ipn.Prefs{AdvertiseRoutes: advertised},
+		AdvertiseRoutesSet: true,
+	}
 }
 
 func (ts *TSNetServer) Close() error {
@@ -213,6 +262,16 @@ func (ts *TSNetServer) generateAuthKeyFromOAuthConfig(ctx context.Context) (stri
 
 // generateAuthKeyFromOAuthSecret parses OAuth client secret with query parameters
 func (ts *TSNetServer) generateAuthKeyFromOAuthSecret(ctx context.Context, clientSecret string) (string, error) {
+	clientID, oauth, err := parseOAuthSecret(clientSecret)
+	if err != nil {
+		return "", err
+	}
+	return ts.generateAuthKeyWithOAuth(ctx, clientID, oauth.ClientSecret, oauth)
+}
+
+// parseOAuthSecret splits an OAuth client secret into the client ID embedded
+// in it and the OAuth settings given as query parameters
+func parseOAuthSecret(clientSecret string) (string, *config.OAuthConfig, error) {
 	// Parse client secret with optional parameters:
 	// tskey-client-xxxx[?ephemeral=false&preauthorized=BOOL&baseURL=...]
 
@@ -244,7 +303,7 @@ func (ts *TSNetServer) generateAuthKeyFromOAuthSecret(ctx context.Context, clien
 	if len(parts) > 1 {
 		values, err := url.ParseQuery(parts[1])
 		if err != nil {
-			return "", fmt.Errorf("failed to parse OAuth parameters: %w", err)
+			return "", nil, fmt.Errorf("failed to parse OAuth parameters: %w", err)
 		}
 
 		if ephemeral := values.Get("ephemeral"); ephemeral != "" {
@@ -261,7 +320,7 @@ func (ts *TSNetServer) generateAuthKeyFromOAuthSecret(ctx context.Context, clien
 		}
 	}
 
-	return ts.generateAuthKeyWithOAuth(ctx, clientID, actualSecret, oauth)
+	return clientID, oauth, nil
 }
 
 // generateAuthKeyWithOAuth generates an authkey using OAuth credentials
@@ -2,8 +2,10 @@ package tailscale
 
 import (
 	"context"
+	"net/netip"
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
 	"time"
 
@@ -241,6 +243,41 @@ func TestTSNetServerConfigDefaults(t *testing.T) {
 	}
 }
 
+func TestExitNodePrefs(t *testing.T) {
+	subnet := netip.MustParsePrefix("10.0.0.0/8")
+	exitV4 := netip.MustParsePrefix("0.0.0.0/0")
+	exitV6 := netip.MustParsePrefix("::/0")
+
+	tests := []struct {
+		name   string
+		routes []netip.Prefix
+		want   []netip.Prefix
+	}{
+		{"no routes", nil, []netip.Prefix{exitV4, exitV6}},
+		{"keeps subnet routes", []netip.Prefix{subnet}, []netip.Prefix{subnet, exitV4, exitV6}},
+		{"already an exit node", []netip.Prefix{exitV6, exitV4}, []netip.Prefix{exitV6, exitV4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mp := exitNodePrefs(tt.routes)
+			if !mp.AdvertiseRoutesSet {
+				t.Error("Expected AdvertiseRoutesSet so the edit applies")
+			}
+			if !slices.Equal(mp.AdvertiseRoutes, tt.want) {
+				t.Errorf("Expected routes %v, got %v", tt.want, mp.AdvertiseRoutes)
+			}
+		})
+	}
+
+	// The caller's routes are left alone
+	routes := make([]netip.Prefix, 1, 4)
+	routes[0] = subnet
+	exitNodePrefs(routes)
+	if routes[:2][1].IsValid() {
+		t.Error("Expected the current routes not to be modified")
+	}
+}
+
 func TestTSNetServerMultipleInstances(t *testing.T) {
 	cfgs := []*config.TailscaleConfig{
 		{